@@ -0,0 +1,318 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// BloomFile is an index over one column of a HeapFile that keeps a Bloom
+// filter of the keys on each heap page (see bloom_filter.go).  It can't find
+// tuples, but it can tell that a page holds no tuple with a given key, so a
+// scan with an equality predicate on the column skips the pages whose filter
+// doesn't contain the key (see [HeapFile.Iterator] and [Filter]).
+//
+// Filters are stored bloomFiltersPerPage to a page, in heap page order, and
+// are read and locked through the BufferPool like the pages of other
+// indexes.  Filters can't forget keys, so deleting a tuple leaves its key in
+// the filter of its page until the index is rebuilt, e.g., by a vacuum.  As
+// an Operator, a BloomFile returns one tuple per heap page, with the page
+// number and the number of keys added to its filter.
+type BloomFile struct {
+	bufPool *BufferPool
+	sync.Mutex
+	name     string // name of the index in the catalog
+	fileName string
+	file     File
+	cipher   *pageCipher // encrypts the pages of the file, or nil
+	keyIndex int         // position of the key in the heap file's tuples
+	desc     *TupleDesc
+}
+
+/*
+Pages of a BloomFile share the layout of the header of other pages:
+
+	reserved (int32)  number of filters (int32)  LSN (int64)  checksum (uint32)
+	reserved (int32)
+
+followed by bloomFiltersPerPage filters, each a count of the keys added to it
+(int32) and bloomFilterBytes bytes of bits.
+*/
+const (
+	bloomPageHeaderSize = 24
+	bloomFilterBytes    = 256
+)
+
+var bloomFiltersPerPage = (PageSize - bloomPageHeaderSize) / (4 + bloomFilterBytes)
+
+type bloomPage struct {
+	dirty   bool
+	file    *BloomFile
+	pageNo  int
+	lsn     int64
+	keys    []int
+	filters [][]byte
+}
+
+func newBloomPage(f *BloomFile, pageNo int) *bloomPage {
+	p := &bloomPage{file: f, pageNo: pageNo, keys: make([]int, bloomFiltersPerPage), filters: make([][]byte, bloomFiltersPerPage)}
+	for i := range p.filters {
+		p.filters[i] = make([]byte, bloomFilterBytes)
+	}
+	return p
+}
+
+func (p *bloomPage) isDirty() bool {
+	return p.dirty
+}
+
+func (p *bloomPage) setDirty(dirty bool) {
+	p.dirty = dirty
+}
+
+func (p *bloomPage) getFile() *DBFile {
+	file := (DBFile)(p.file)
+	return &file
+}
+
+// Serialize the page to a PageSize buffer, filling in its checksum
+func (p *bloomPage) toBuffer() (*bytes.Buffer, error) {
+	b := new(bytes.Buffer)
+	for _, v := range []any{int32(0), int32(len(p.filters)), p.lsn, uint32(0), int32(0)} {
+		err := binary.Write(b, binary.LittleEndian, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, filter := range p.filters {
+		binary.Write(b, binary.LittleEndian, int32(p.keys[i]))
+		b.Write(filter)
+	}
+	b.Write(make([]byte, PageSize-b.Len()))
+	binary.LittleEndian.PutUint32(b.Bytes()[pageChecksumOffset:], pageChecksum(b.Bytes()))
+	return b, nil
+}
+
+// Read the contents of the page from a buffer written by toBuffer
+func (p *bloomPage) initFromBuffer(buf *bytes.Buffer) error {
+	var reserved, count, reserved2 int32
+	var checksum uint32
+	for _, v := range []any{&reserved, &count, &p.lsn, &checksum, &reserved2} {
+		err := binary.Read(buf, binary.LittleEndian, v)
+		if err != nil {
+			return err
+		}
+	}
+	if int(count) != bloomFiltersPerPage {
+		return GoDBError{MalformedDataError, fmt.Sprintf("bad bloom page header: %d filters, expected %d", count, bloomFiltersPerPage)}
+	}
+	for i := range p.filters {
+		var keys int32
+		err := binary.Read(buf, binary.LittleEndian, &keys)
+		if err != nil {
+			return err
+		}
+		p.keys[i] = int(keys)
+		_, err = buf.Read(p.filters[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open a BloomFile, creating an empty index if the file doesn't exist yet.
+// Parameters
+// - fromFile: backing file for the index
+// - keyField: the indexed column of the heap file
+// - keyIndex: the position of keyField in the heap file's TupleDesc
+// - bp: the BufferPool that is used to store pages read from the index
+func NewBloomFile(fromFile string, keyField FieldType, keyIndex int, bp *BufferPool) (*BloomFile, error) {
+	return openBloomFile(fromFile, keyField, keyIndex, nil, bp)
+}
+
+// Open a BloomFile whose pages are encrypted with cipher, unless it is nil
+func openBloomFile(fromFile string, keyField FieldType, keyIndex int, cipher *pageCipher, bp *BufferPool) (*BloomFile, error) {
+	file, err := bp.vfs.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &BloomFile{
+		bufPool:  bp,
+		name:     fromFile,
+		fileName: fromFile,
+		file:     file,
+		cipher:   cipher,
+		keyIndex: keyIndex,
+		desc: &TupleDesc{Fields: []FieldType{
+			{Fname: "heap_page", Ftype: IntType},
+			{Fname: "keys", Ftype: IntType},
+		}},
+	}, nil
+}
+
+// Return the number of pages in the index file
+func (f *BloomFile) NumPages() int {
+	return filePageCount(f.file, f.cipher, PageSize)
+}
+
+func (f *BloomFile) readPage(pageNo int) (*Page, error) {
+	buf, err := readFilePage(f.file, f.cipher, PageSize, pageNo)
+	if e, ok := err.(GoDBError); ok {
+		return nil, f.corruptPageError(pageNo, e.errString)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !verifyPageChecksum(buf) {
+		return nil, f.corruptPageError(pageNo, "checksum mismatch")
+	}
+	page := newBloomPage(f, pageNo)
+	err = page.initFromBuffer(bytes.NewBuffer(buf))
+	if err != nil {
+		return nil, f.corruptPageError(pageNo, err.Error())
+	}
+	observePageLSN(page.lsn)
+	p := Page(page)
+	return &p, nil
+}
+
+func (f *BloomFile) corruptPageError(pageNo int, reason string) error {
+	return GoDBError{CorruptPageError, fmt.Sprintf("page %d of index %s is corrupt: %s", pageNo, f.fileName, reason)}
+}
+
+func (f *BloomFile) flushPage(p *Page) error {
+	page, ok := (*p).(*bloomPage)
+	if !ok {
+		return GoDBError{TypeMismatchError, "cannot cast to bloomPage"}
+	}
+	page.lsn = nextPageLSN()
+	buffer, err := page.toBuffer()
+	if err != nil {
+		return err
+	}
+	return writeFilePage(f.file, f.cipher, page.pageNo, buffer.Bytes())
+}
+
+func (f *BloomFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.fileName, PageNo: pgNo}
+}
+
+// Make every page flushed so far durable
+func (f *BloomFile) sync() error {
+	return f.file.Sync()
+}
+
+// Close the file.  It must not be used again.
+func (f *BloomFile) close() error {
+	return f.file.Close()
+}
+
+func (f *BloomFile) Descriptor() *TupleDesc {
+	return f.desc
+}
+
+func (f *BloomFile) getPage(pageNo int, tid TransactionID, perm RWPerm) (*bloomPage, error) {
+	page, err := f.bufPool.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	return (*page).(*bloomPage), nil
+}
+
+// Append empty pages to the file until it has the page holding the filter
+// of the given heap page
+func (f *BloomFile) extendTo(heapPageNo int) error {
+	f.Lock()
+	defer f.Unlock()
+	for pageNo := f.NumPages(); pageNo <= heapPageNo/bloomFiltersPerPage; pageNo++ {
+		page := Page(newBloomPage(f, pageNo))
+		err := f.flushPage(&page)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add the key of t, a tuple of the indexed heap file, to the filter of the
+// page it is stored on
+func (f *BloomFile) insertTuple(t *Tuple, tid TransactionID) error {
+	e, err := entryForTuple(t, f.keyIndex)
+	if err != nil {
+		return err
+	}
+	err = f.extendTo(e.rid.pageNum)
+	if err != nil {
+		return err
+	}
+	p, err := f.getPage(e.rid.pageNum/bloomFiltersPerPage, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	i := e.rid.pageNum % bloomFiltersPerPage
+	bloomAdd(p.filters[i], e.key)
+	p.keys[i]++
+	p.setDirty(true)
+	return nil
+}
+
+// Keys can't be removed from a Bloom filter, so deleting a tuple leaves the
+// index unchanged
+func (f *BloomFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	_, err := entryForTuple(t, f.keyIndex)
+	return err
+}
+
+// Empty the filter of every heap page
+func (f *BloomFile) clear(tid TransactionID) error {
+	for pageNo := 0; pageNo < f.NumPages(); pageNo++ {
+		p, err := f.getPage(pageNo, tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		for i := range p.filters {
+			p.filters[i] = make([]byte, bloomFilterBytes)
+			p.keys[i] = 0
+		}
+		p.setDirty(true)
+	}
+	return nil
+}
+
+// Return false if no tuple on the given heap page has the given key
+func (f *BloomFile) mayContain(heapPageNo int, key DBValue, tid TransactionID) (bool, error) {
+	pageNo := heapPageNo / bloomFiltersPerPage
+	if pageNo >= f.NumPages() {
+		// no key has been added to the page's filter yet
+		return false, nil
+	}
+	p, err := f.getPage(pageNo, tid, ReadPerm)
+	if err != nil {
+		return false, err
+	}
+	return bloomMayContain(p.filters[heapPageNo%bloomFiltersPerPage], key), nil
+}
+
+// [Operator] iterator method -- return the number of keys added to the
+// filter of each heap page
+func (f *BloomFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	numPages := f.NumPages()
+	heapPageNo := 0
+	return func() (*Tuple, error) {
+		pageNo := heapPageNo / bloomFiltersPerPage
+		if pageNo >= numPages {
+			return nil, nil
+		}
+		p, err := f.getPage(pageNo, tid, ReadPerm)
+		if err != nil {
+			return nil, err
+		}
+		keys := p.keys[heapPageNo%bloomFiltersPerPage]
+		heapPageNo++
+		return &Tuple{*f.desc, []DBValue{IntField{int64(heapPageNo - 1)}, IntField{int64(keys)}}, nil}, nil
+	}, nil
+}
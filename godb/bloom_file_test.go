@@ -0,0 +1,216 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// Return the tuples of op
+func collectTuples(t *testing.T, op Operator, bp *BufferPool) []*Tuple {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	defer bp.CommitTransaction(tid)
+	iter, err := op.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var tuples []*Tuple
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			return tuples
+		}
+		tuples = append(tuples, tup)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		filter.add(IntField{int64(i)})
+	}
+	for i := 0; i < 1000; i++ {
+		if !filter.mayContain(IntField{int64(i)}) {
+			t.Fatalf("filter doesn't contain %d", i)
+		}
+	}
+	falsePositives := 0
+	for i := 1000; i < 11000; i++ {
+		if filter.mayContain(IntField{int64(i)}) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("expected about 1%% false positives, got %d of 10000", falsePositives)
+	}
+}
+
+func TestBloomIndexSkipsPages(t *testing.T) {
+	bp := NewBufferPool(500)
+	hf, _ := makeIndexTestVars(t, bp, BloomIndex)
+	// spread the keys over the pages, so that zone maps can't skip any
+	const n = 3000
+	var ages []int
+	for i := 0; i < n; i++ {
+		ages = append(ages, i*7919%n)
+	}
+	insertAges(t, hf, bp, ages)
+
+	bp2 := NewBufferPool(500)
+	hf2, idx2 := openIndexTestVars(t, bp2, BloomIndex)
+	age := &FieldExpr{hf2.desc.Fields[1]}
+	filt, err := NewIntFilter(&ConstExpr{IntField{1234}, IntType}, OpEq, age, hf2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tuples := collectTuples(t, filt, bp2); len(tuples) != 1 {
+		t.Fatalf("expected one tuple, got %d", len(tuples))
+	}
+	if read := cachedPages(bp2, hf2); read > 2 {
+		t.Errorf("expected the scan to skip all but one page, read %d of %d", read, hf2.NumPages())
+	}
+	pages := collectTuples(t, idx2, bp2)
+	if len(pages) < hf2.NumPages() {
+		t.Fatalf("expected a filter for each of %d pages, got %d", hf2.NumPages(), len(pages))
+	}
+	keys := int64(0)
+	for _, p := range pages {
+		keys += p.Fields[1].(IntField).Value
+	}
+	if keys != n {
+		t.Errorf("expected %d keys in the filters, got %d", n, keys)
+	}
+}
+
+func TestBloomIndexFalsePositives(t *testing.T) {
+	bp := NewBufferPool(500)
+	hf, file := makeIndexTestVars(t, bp, BloomIndex)
+	idx := file.(*BloomFile)
+	// only even keys, spread over the pages, so that zone maps can't skip
+	// the pages for the odd keys in between
+	const n = 3000
+	var ages []int
+	for i := 0; i < n; i++ {
+		ages = append(ages, 2*(i*7919%n))
+	}
+	insertAges(t, hf, bp, ages)
+
+	// the heap pages whose filter may contain a key
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	var filters [][]byte
+	for pageNo := 0; pageNo < idx.NumPages(); pageNo++ {
+		p, err := idx.getPage(pageNo, tid, ReadPerm)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		filters = append(filters, p.filters...)
+	}
+	bp.CommitTransaction(tid)
+	maybe := func(age int) []int {
+		var pages []int
+		for pageNo := 0; pageNo < hf.NumPages(); pageNo++ {
+			if bloomMayContain(filters[pageNo], IntField{int64(age)}) {
+				pages = append(pages, pageNo)
+			}
+		}
+		return pages
+	}
+
+	// find a key that isn't in the table but that some filter claims, and
+	// check that false positives are rare
+	falsePositive, claimed := -1, 0
+	for age := 1; age < 2*n; age += 2 {
+		if pages := maybe(age); len(pages) > 0 {
+			claimed += len(pages)
+			if falsePositive < 0 {
+				falsePositive = age
+			}
+		}
+	}
+	if falsePositive < 0 {
+		t.Fatalf("expected some filter to claim one of %d missing keys", n)
+	}
+	if rate := float64(claimed) / float64(n*hf.NumPages()); rate > 0.01 {
+		t.Errorf("expected under 1%% false positives per page, got %.2f%%", rate*100)
+	}
+
+	// a scan reads exactly the pages whose filter claims the key, and the
+	// false positives among them contribute no tuples
+	for _, age := range []int{falsePositive, 2468} {
+		bp2 := NewBufferPool(500)
+		hf2, _ := openIndexTestVars(t, bp2, BloomIndex)
+		field := &FieldExpr{hf2.desc.Fields[1]}
+		filt, err := NewIntFilter(&ConstExpr{IntField{int64(age)}, IntType}, OpEq, field, hf2)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		expected := 1 - age%2
+		if tuples := collectTuples(t, filt, bp2); len(tuples) != expected {
+			t.Errorf("expected %d tuples with age %d, got %d", expected, age, len(tuples))
+		}
+		var read []int
+		for pageNo := 0; pageNo < hf2.NumPages(); pageNo++ {
+			if bp2.isCached(hf2, pageNo) {
+				read = append(read, pageNo)
+			}
+		}
+		if fmt.Sprint(read) != fmt.Sprint(maybe(age)) {
+			t.Errorf("expected the scan for age %d to read pages %v, read %v", age, maybe(age), read)
+		}
+	}
+}
+
+func TestRuntimeJoinFilter(t *testing.T) {
+	bp := NewBufferPool(500)
+	hf, _ := makeIndexTestVars(t, bp, BloomIndex)
+	var ages []int
+	for i := 0; i < 2000; i++ {
+		ages = append(ages, i)
+	}
+	insertAges(t, hf, bp, ages)
+	td, _, _, _, _, _ := makeTestVars()
+	os.Remove(TestingFile2)
+	small, err := NewHeapFile(TestingFile2, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	insertAges(t, small, bp, []int{5, 500, 5000})
+
+	// the probe side scan drops tuples that aren't in the filter
+	filter := newBloomFilter(3)
+	for _, a := range []int64{5, 500, 5000} {
+		filter.add(IntField{a})
+	}
+	age := &FieldExpr{td.Fields[1]}
+	probe := &runtimeFilterScan{hf, []runtimeFilter{{age, filter}}}
+	if tuples := collectTuples(t, probe, bp); len(tuples) < 2 || len(tuples) > 50 {
+		t.Errorf("expected the runtime filter to drop most tuples, got %d", len(tuples))
+	}
+
+	join, err := NewIntJoin(small, age, hf, age, 100)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tuples := collectTuples(t, join, bp); len(tuples) != 2 {
+		t.Errorf("expected 2 joined tuples, got %d", len(tuples))
+	}
+}
+
+// An operator that scans a heap file with runtime filters
+type runtimeFilterScan struct {
+	file    *HeapFile
+	filters []runtimeFilter
+}
+
+func (s *runtimeFilterScan) Descriptor() *TupleDesc {
+	return s.file.Descriptor()
+}
+
+func (s *runtimeFilterScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return s.file.runtimeFilteredIterator(s.filters, tid)
+}
@@ -0,0 +1,95 @@
+package godb
+
+// Bloom filters.  A Bloom filter is a bit array that represents a set of
+// keys:  adding a key sets a few bits chosen by hashing it, and a key can
+// only be in the set if all of its bits are set.  Lookups may report false
+// positives, but never false negatives, so a filter can be used to throw away
+// work for keys it doesn't contain.
+//
+// GoDB uses Bloom filters in two places:  a [BloomFile] index keeps one filter
+// per heap page, so that scans with an equality predicate can skip pages, and
+// an [EqualityJoin] builds a filter over the keys of its build side and pushes
+// it into the scan of its probe side (see [runtimeFilter]).
+
+// Number of bits set for each key
+const bloomHashes = 7
+
+// Bits per key of filters sized with newBloomFilter, which with bloomHashes
+// hashes gives about 1% false positives
+const bloomBitsPerKey = 10
+
+// Return the positions of the bits of key in a filter of numBits bits.
+// Positions are derived from two halves of the key's hash, so they are the
+// same across restarts.
+func bloomBits(key DBValue, numBits int) [bloomHashes]int {
+	h := hashKey(key)
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	var bits [bloomHashes]int
+	for i := range bits {
+		bits[i] = int((h1 + uint32(i)*h2) % uint32(numBits))
+	}
+	return bits
+}
+
+func bloomAdd(filter []byte, key DBValue) {
+	for _, bit := range bloomBits(key, len(filter)*8) {
+		filter[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func bloomMayContain(filter []byte, key DBValue) bool {
+	for _, bit := range bloomBits(key, len(filter)*8) {
+		if filter[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// An in-memory Bloom filter
+type bloomFilter struct {
+	bits []byte
+}
+
+// Create an empty filter sized for numKeys keys
+func newBloomFilter(numKeys int) *bloomFilter {
+	size := (numKeys*bloomBitsPerKey + 7) / 8
+	if size < 8 {
+		size = 8
+	}
+	return &bloomFilter{make([]byte, size)}
+}
+
+func (b *bloomFilter) add(key DBValue) {
+	bloomAdd(b.bits, key)
+}
+
+func (b *bloomFilter) mayContain(key DBValue) bool {
+	return bloomMayContain(b.bits, key)
+}
+
+// A runtime filter drops the tuples of a scan whose value of expr is not in
+// filter.  Joins build runtime filters from the keys of one input and pass
+// them to the scan of the other, so that tuples with no join partner are
+// dropped before they reach the join.
+type runtimeFilter struct {
+	expr   Expr
+	filter *bloomFilter
+}
+
+// Whether t may pass all of the filters
+func passesRuntimeFilters(filters []runtimeFilter, t *Tuple) bool {
+	for _, f := range filters {
+		v, err := f.expr.EvalExpr(t)
+		if err == nil && !f.filter.mayContain(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// An operator that can apply runtime filters while producing its tuples.
+// The iterator may still return tuples that don't pass the filters.
+type runtimeFilterable interface {
+	runtimeFilteredIterator(filters []runtimeFilter, tid TransactionID) (func() (*Tuple, error), error)
+}
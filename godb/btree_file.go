@@ -0,0 +1,456 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// BTreeFile is a B+tree secondary index over one column of a HeapFile.  It
+// maps each key to the record ids of the heap tuples that have that key;  see
+// [btreePage] for the layout of the tree.
+//
+// Like a HeapFile, its pages are read and locked through the BufferPool.
+// Every user of the tree read locks the meta page and the internal pages on
+// the path from the root to the leaf it needs.  Changes write lock that leaf,
+// and a change that splits pages upgrades to write locks on the parents it
+// adds entries to, and on the meta page only if the root splits.  Lookups
+// take read locks, so the usual two-phase locking of pages also protects the
+// index.  New pages are added to the buffer pool rather than written to the
+// file, so the pages of a split that aborts are never written.
+//
+// A BTreeFile is a DBFile whose insertTuple and deleteTuple methods take
+// tuples of the heap file it indexes, with Rid set;  the HeapFile calls them
+// whenever it stores or removes a tuple.  As an Operator, it returns one
+// tuple per entry, in key order, with the key followed by the page and slot
+// of the heap tuple's record id.
+type BTreeFile struct {
+	bufPool *BufferPool
+	sync.Mutex
+	name     string // name of the index in the catalog
+	fileName string
+	file     File
+	cipher   *pageCipher // encrypts the pages of the file, or nil
+	keyIndex int         // position of the key in the heap file's tuples
+	desc     *TupleDesc  // key, rid page, rid slot
+	nextPage int         // the next page to allocate, unless the file is longer
+}
+
+// Open a BTreeFile, creating an empty tree if the file doesn't exist yet.
+// Parameters
+// - fromFile: backing file for the index
+// - keyField: the indexed column of the heap file
+// - keyIndex: the position of keyField in the heap file's TupleDesc
+// - bp: the BufferPool that is used to store pages read from the index
+func NewBTreeFile(fromFile string, keyField FieldType, keyIndex int, bp *BufferPool) (*BTreeFile, error) {
+	return openBTreeFile(fromFile, keyField, keyIndex, nil, bp)
+}
+
+// Open a BTreeFile whose pages are encrypted with cipher, unless it is nil
+func openBTreeFile(fromFile string, keyField FieldType, keyIndex int, cipher *pageCipher, bp *BufferPool) (*BTreeFile, error) {
+	file, err := bp.vfs.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	f := &BTreeFile{
+		bufPool:  bp,
+		name:     fromFile,
+		fileName: fromFile,
+		file:     file,
+		cipher:   cipher,
+		keyIndex: keyIndex,
+		desc: &TupleDesc{Fields: []FieldType{
+			{Fname: keyField.Fname, Ftype: keyField.Ftype},
+			{Fname: "rid_page", Ftype: IntType},
+			{Fname: "rid_slot", Ftype: IntType},
+		}},
+	}
+	if f.NumPages() == 0 {
+		err = f.initEmptyTree()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Write a meta page and an empty root leaf to the start of the file
+func (f *BTreeFile) initEmptyTree() error {
+	meta := newBTreePage(f, 0, btreeMetaPage)
+	meta.link = 1
+	for _, p := range []*btreePage{meta, newBTreePage(f, 1, btreeLeafPage)} {
+		page := Page(p)
+		err := f.flushPage(&page)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the number of pages in the index file
+func (f *BTreeFile) NumPages() int {
+	return filePageCount(f.file, f.cipher, PageSize)
+}
+
+func (f *BTreeFile) keyType() DBType {
+	return f.desc.Fields[0].Ftype
+}
+
+func (f *BTreeFile) keyDesc() *TupleDesc {
+	return &TupleDesc{Fields: f.desc.Fields[:1]}
+}
+
+func (f *BTreeFile) indexName() string {
+	return f.name
+}
+
+func (f *BTreeFile) keyColumn() int {
+	return f.keyIndex
+}
+
+func (f *BTreeFile) readPage(pageNo int) (*Page, error) {
+	buf, err := readFilePage(f.file, f.cipher, PageSize, pageNo)
+	if e, ok := err.(GoDBError); ok {
+		return nil, f.corruptPageError(pageNo, e.errString)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !verifyPageChecksum(buf) {
+		return nil, f.corruptPageError(pageNo, "checksum mismatch")
+	}
+	page := newBTreePage(f, pageNo, 0)
+	err = page.initFromBuffer(bytes.NewBuffer(buf), f.keyDesc())
+	if err != nil {
+		return nil, f.corruptPageError(pageNo, err.Error())
+	}
+	observePageLSN(page.lsn)
+	p := Page(page)
+	return &p, nil
+}
+
+func (f *BTreeFile) corruptPageError(pageNo int, reason string) error {
+	return GoDBError{CorruptPageError, fmt.Sprintf("page %d of index %s is corrupt: %s", pageNo, f.fileName, reason)}
+}
+
+func (f *BTreeFile) flushPage(p *Page) error {
+	page, ok := (*p).(*btreePage)
+	if !ok {
+		return GoDBError{TypeMismatchError, "cannot cast to btreePage"}
+	}
+	page.lsn = nextPageLSN()
+	buffer, err := page.toBuffer()
+	if err != nil {
+		return err
+	}
+	return writeFilePage(f.file, f.cipher, page.pageNo, buffer.Bytes())
+}
+
+func (f *BTreeFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.fileName, PageNo: pgNo}
+}
+
+// Make every page flushed so far durable
+func (f *BTreeFile) sync() error {
+	return f.file.Sync()
+}
+
+// Close the file.  It must not be used again.
+func (f *BTreeFile) close() error {
+	return f.file.Close()
+}
+
+func (f *BTreeFile) Descriptor() *TupleDesc {
+	return f.desc
+}
+
+func (f *BTreeFile) getPage(pageNo int, tid TransactionID, perm RWPerm) (*btreePage, error) {
+	page, err := f.bufPool.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	return (*page).(*btreePage), nil
+}
+
+// Allocate a new, empty page of the given kind at the end of the file, in
+// the buffer pool, write locked by tid.  If tid aborts while a page allocated
+// after it is committed, its place in the file is left as a hole that no
+// page of the tree refers to.
+func (f *BTreeFile) allocPage(kind btreePageKind, tid TransactionID) (*btreePage, error) {
+	f.Lock()
+	if n := f.NumPages(); f.nextPage < n {
+		f.nextPage = n
+	}
+	pageNo := f.nextPage
+	f.nextPage++
+	f.Unlock()
+	page, err := f.bufPool.addNewPage(f, pageNo, newBTreePage(f, pageNo, kind), tid)
+	if err != nil {
+		return nil, err
+	}
+	return (*page).(*btreePage), nil
+}
+
+// Fetch the meta page and the pages on the path from the root to the leaf
+// that e belongs in, or to the leftmost leaf if e is nil.  The leaf is
+// locked with leafPerm, and the other pages are read locked.
+func (f *BTreeFile) descend(e *indexEntry, tid TransactionID, leafPerm RWPerm) (*btreePage, []*btreePage, error) {
+	meta, err := f.getPage(0, tid, ReadPerm)
+	if err != nil {
+		return nil, nil, err
+	}
+	var path []*btreePage
+	pageNo := meta.link
+	for {
+		p, err := f.getPage(pageNo, tid, ReadPerm)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.kind == btreeLeafPage {
+			if leafPerm == WritePerm {
+				p, err = f.getPage(pageNo, tid, WritePerm)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			return meta, append(path, p), nil
+		}
+		path = append(path, p)
+		if p.kind != btreeInternalPage {
+			return nil, nil, f.corruptPageError(pageNo, "expected a b+tree node")
+		}
+		if e == nil {
+			pageNo = p.children[0]
+		} else {
+			pageNo = p.children[p.childFor(*e)]
+		}
+	}
+}
+
+// Add an entry for t, a tuple of the indexed heap file, to the index
+func (f *BTreeFile) insertTuple(t *Tuple, tid TransactionID) error {
+	e, err := entryForTuple(t, f.keyIndex)
+	if err != nil {
+		return err
+	}
+	meta, path, err := f.descend(&e, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	leaf := path[len(path)-1]
+	i := leaf.lowerBound(e)
+	if i < len(leaf.entries) && compareEntries(leaf.entries[i], e) == 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("index %s already has an entry for %v", f.fileName, e.rid)}
+	}
+	leaf.entries = slices.Insert(leaf.entries, i, e)
+	leaf.setDirty(true)
+	return f.splitFull(meta, path, tid)
+}
+
+// Split the last page of path if it has more entries than fit on a page,
+// then its parent if that overflows in turn, and so on up to the root.  The
+// last page must be write locked;  the read locks on the pages above it are
+// upgraded as they change.
+func (f *BTreeFile) splitFull(meta *btreePage, path []*btreePage, tid TransactionID) error {
+	for level := len(path) - 1; level >= 0; level-- {
+		p := path[level]
+		if len(p.entries) <= btreeCapacity(p.kind, f.keyType()) {
+			return nil
+		}
+		right, err := f.allocPage(p.kind, tid)
+		if err != nil {
+			return err
+		}
+		mid := len(p.entries) / 2
+		var sep indexEntry
+		if p.kind == btreeLeafPage {
+			right.entries = slices.Clone(p.entries[mid:])
+			p.entries = p.entries[:mid]
+			right.link = p.link
+			p.link = right.pageNo
+			sep = right.entries[0]
+		} else {
+			sep = p.entries[mid]
+			right.entries = slices.Clone(p.entries[mid+1:])
+			right.children = slices.Clone(p.children[mid+1:])
+			p.entries = p.entries[:mid]
+			p.children = p.children[:mid+1]
+		}
+		p.setDirty(true)
+		right.setDirty(true)
+
+		if level == 0 {
+			root, err := f.allocPage(btreeInternalPage, tid)
+			if err != nil {
+				return err
+			}
+			root.entries = []indexEntry{sep}
+			root.children = []int{p.pageNo, right.pageNo}
+			root.setDirty(true)
+			meta, err = f.getPage(0, tid, WritePerm)
+			if err != nil {
+				return err
+			}
+			meta.link = root.pageNo
+			meta.setDirty(true)
+			return nil
+		}
+		// the page may have been evicted and read again while it was only
+		// read locked, so the page from the write lock is the one to change
+		parent, err := f.getPage(path[level-1].pageNo, tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		path[level-1] = parent
+		i := parent.childFor(sep)
+		parent.entries = slices.Insert(parent.entries, i, sep)
+		parent.children = slices.Insert(parent.children, i+1, right.pageNo)
+		parent.setDirty(true)
+	}
+	return nil
+}
+
+// Remove the entry for t, a tuple of the indexed heap file, from the index
+func (f *BTreeFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	e, err := entryForTuple(t, f.keyIndex)
+	if err != nil {
+		return err
+	}
+	_, path, err := f.descend(&e, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	leaf := path[len(path)-1]
+	i := leaf.lowerBound(e)
+	if i == len(leaf.entries) || compareEntries(leaf.entries[i], e) != 0 {
+		return GoDBError{TupleNotFoundError, fmt.Sprintf("index %s has no entry for %v", f.fileName, e.rid)}
+	}
+	leaf.entries = slices.Delete(leaf.entries, i, i+1)
+	leaf.setDirty(true)
+	return nil
+}
+
+// Remove every entry from the index.  The file is cut back to an empty tree
+// while holding a write lock on the meta page, which every other user of
+// the index must lock first.
+func (f *BTreeFile) clear(tid TransactionID) error {
+	meta, err := f.getPage(0, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	defer f.Unlock()
+	if n := f.NumPages(); f.nextPage < n {
+		f.nextPage = n
+	}
+	f.bufPool.discardFilePages(f, 1, f.nextPage)
+	f.nextPage = 2
+	err = truncateFilePages(f.file, f.cipher, PageSize, 1)
+	if err != nil {
+		return err
+	}
+	root := Page(newBTreePage(f, 1, btreeLeafPage))
+	err = f.flushPage(&root)
+	if err != nil {
+		return err
+	}
+	meta.link = 1
+	meta.setDirty(true)
+	return nil
+}
+
+// A range of keys to look up in an index.  A nil bound leaves that end of the
+// range open.
+type keyRange struct {
+	lo, hi         DBValue
+	loIncl, hiIncl bool
+}
+
+func pointRange(key DBValue) keyRange {
+	return keyRange{key, key, true, true}
+}
+
+// Whether the range holds exactly one key
+func (r keyRange) isPoint() bool {
+	return r.lo != nil && r.hi != nil && r.loIncl && r.hiIncl && compareKeys(r.lo, r.hi) == 0
+}
+
+// A b+tree index can scan any range
+func (f *BTreeFile) supportsRange(r keyRange) bool {
+	return true
+}
+
+// Return an iterator over the entries with keys in r, in key order.  The
+// iterator returns nil once it is past the end of the range.
+func (f *BTreeFile) scanRange(r keyRange, tid TransactionID) (func() (*indexEntry, error), error) {
+	for _, bound := range []DBValue{r.lo, r.hi} {
+		if bound != nil && !valueHasType(bound, f.keyType()) {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("index %s has %s keys", f.fileName, typeNames[f.keyType()])}
+		}
+	}
+	var start *indexEntry
+	if r.lo != nil {
+		start = &indexEntry{r.lo, heapFileRID{-1, -1}}
+	}
+	_, path, err := f.descend(start, tid, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	leaf := path[len(path)-1]
+	pos := 0
+	if start != nil {
+		pos = leaf.lowerBound(*start)
+	}
+	return func() (*indexEntry, error) {
+		for {
+			if pos == len(leaf.entries) {
+				if leaf.link == btreeNoPage {
+					return nil, nil
+				}
+				leaf, err = f.getPage(leaf.link, tid, ReadPerm)
+				if err != nil {
+					return nil, err
+				}
+				pos = 0
+				continue
+			}
+			e := leaf.entries[pos]
+			pos++
+			if r.lo != nil && !r.loIncl && compareKeys(e.key, r.lo) == 0 {
+				continue
+			}
+			if r.hi != nil {
+				c := compareKeys(e.key, r.hi)
+				if c > 0 || (c == 0 && !r.hiIncl) {
+					return nil, nil
+				}
+			}
+			return &e, nil
+		}
+	}, nil
+}
+
+// Return the record ids of the heap tuples whose key equals key
+func (f *BTreeFile) lookup(key DBValue, tid TransactionID) ([]heapFileRID, error) {
+	return lookupRange(f, key, tid)
+}
+
+// [Operator] iterator method -- return every entry of the index in key order
+func (f *BTreeFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := f.scanRange(keyRange{}, tid)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		e, err := iter()
+		if e == nil || err != nil {
+			return nil, err
+		}
+		return &Tuple{*f.desc, []DBValue{e.key, IntField{int64(e.rid.pageNum)}, IntField{int64(e.rid.slotNum)}}, nil}, nil
+	}, nil
+}
@@ -0,0 +1,678 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const TestingIndexFile string = "test_age.idx"
+
+// A heap file like the one from makeTestVars, with an empty index of the
+// given kind on age, or on name for a full-text index
+func makeIndexTestVars(t *testing.T, bp *BufferPool, kind IndexKind) (*HeapFile, indexFile) {
+	os.Remove(TestingFile)
+	os.Remove(TestingIndexFile)
+	return openIndexTestVars(t, bp, kind)
+}
+
+// Open the heap file and index of makeIndexTestVars again, without emptying
+// them
+func openIndexTestVars(t *testing.T, bp *BufferPool, kind IndexKind) (*HeapFile, indexFile) {
+	// the TupleDesc of makeTestVars, which would empty the heap file
+	td := TupleDesc{Fields: []FieldType{
+		{Fname: "name", Ftype: StringType},
+		{Fname: "age", Ftype: IntType},
+	}}
+	hf, err := NewHeapFile(TestingFile, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var idx indexFile
+	switch kind {
+	case BTreeIndex:
+		idx, err = NewBTreeFile(TestingIndexFile, td.Fields[1], 1, bp)
+	case HashIndex:
+		idx, err = NewHashFile(TestingIndexFile, td.Fields[1], 1, bp)
+	case BloomIndex:
+		idx, err = NewBloomFile(TestingIndexFile, td.Fields[1], 1, bp)
+	case FullTextIndex:
+		idx, err = NewTextFile(TestingIndexFile, td.Fields[0], 0, bp)
+	}
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hf.indexes = append(hf.indexes, idx)
+	return hf, idx
+}
+
+func insertAges(t *testing.T, hf *HeapFile, bp *BufferPool, ages []int) {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for _, age := range ages {
+		tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, IntField{int64(age)}}, nil}
+		err := hf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.CommitTransaction(tid)
+}
+
+// count the entries of idx with keys in r, checking that they are in order
+func countRange(t *testing.T, idx lookupIndex, bp *BufferPool, r keyRange) int {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	defer bp.CommitTransaction(tid)
+	iter, err := idx.scanRange(r, tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var prev *indexEntry
+	cnt := 0
+	for {
+		e, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if e == nil {
+			return cnt
+		}
+		if prev != nil && compareEntries(*prev, *e) >= 0 {
+			t.Fatalf("index entries out of order: %v before %v", *prev, *e)
+		}
+		prev = e
+		cnt++
+	}
+}
+
+// check that every tuple of hf has an entry with the right key in idx, and
+// that idx has no other entries
+func checkIndexMatches(t *testing.T, hf *HeapFile, idx lookupIndex, bp *BufferPool) {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	defer bp.CommitTransaction(tid)
+	tuples := make(map[heapFileRID]DBValue)
+	iter, _ := hf.Iterator(tid)
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		tuples[tup.Rid.(heapFileRID)] = tup.Fields[idx.keyColumn()]
+	}
+	entries, _ := idx.Iterator(tid)
+	cnt := 0
+	for {
+		e, err := entries()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if e == nil {
+			break
+		}
+		cnt++
+		rid := heapFileRID{int(e.Fields[1].(IntField).Value), int(e.Fields[2].(IntField).Value)}
+		if key, ok := tuples[rid]; !ok || key != e.Fields[0] {
+			t.Fatalf("index entry %v doesn't match a tuple", e.Fields)
+		}
+	}
+	if cnt != len(tuples) {
+		t.Fatalf("index has %d entries, heap file has %d tuples", cnt, len(tuples))
+	}
+}
+
+func TestBTreeInsertAndLookup(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, BTreeIndex)
+	idx := file.(*BTreeFile)
+	var ages []int
+	for i := 0; i < 3000; i++ {
+		ages = append(ages, (i*7)%500)
+	}
+	insertAges(t, hf, bp, ages)
+	if idx.NumPages() < 10 {
+		t.Fatalf("expected the tree to have split into several pages, has %d", idx.NumPages())
+	}
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	rids, err := idx.lookup(IntField{42}, tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(rids) != 6 {
+		t.Errorf("expected 6 tuples with age 42, got %d", len(rids))
+	}
+	for _, rid := range rids {
+		page, err := bp.GetPage(hf, rid.pageNum, tid, ReadPerm)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup := (*page).(*heapPage).slots[rid.slotNum]; tup == nil || tup.Fields[1] != (IntField{42}) {
+			t.Errorf("record id %v from index doesn't point at a tuple with age 42", rid)
+		}
+	}
+	bp.CommitTransaction(tid)
+
+	if cnt := countRange(t, idx, bp, keyRange{IntField{100}, IntField{200}, true, false}); cnt != 600 {
+		t.Errorf("expected 600 entries in [100, 200), got %d", cnt)
+	}
+	if cnt := countRange(t, idx, bp, keyRange{IntField{100}, IntField{200}, false, true}); cnt != 600 {
+		t.Errorf("expected 600 entries in (100, 200], got %d", cnt)
+	}
+	if cnt := countRange(t, idx, bp, keyRange{nil, IntField{9}, false, true}); cnt != 60 {
+		t.Errorf("expected 60 entries <= 9, got %d", cnt)
+	}
+	if cnt := countRange(t, idx, bp, keyRange{}); cnt != 3000 {
+		t.Errorf("expected 3000 entries, got %d", cnt)
+	}
+	checkIndexMatches(t, hf, idx, bp)
+
+	// a reopened index sees the same tree
+	idx2, err := NewBTreeFile(TestingIndexFile, hf.Descriptor().Fields[1], 1, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cnt := countRange(t, idx2, bp, pointRange(IntField{499})); cnt != 6 {
+		t.Errorf("expected 6 entries with age 499 after reopening, got %d", cnt)
+	}
+}
+
+func TestBTreeDelete(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, BTreeIndex)
+	idx := file.(*BTreeFile)
+	var ages []int
+	for i := 0; i < 1000; i++ {
+		ages = append(ages, i%100)
+	}
+	insertAges(t, hf, bp, ages)
+
+	deleted := deleteWhere(t, hf, bp, func(n int, rid heapFileRID) bool {
+		return n%2 == 0
+	})
+	if deleted != 500 {
+		t.Fatalf("expected to delete 500 tuples, deleted %d", deleted)
+	}
+	checkIndexMatches(t, hf, idx, bp)
+	if cnt := countRange(t, idx, bp, keyRange{}); cnt != 500 {
+		t.Errorf("expected 500 entries after delete, got %d", cnt)
+	}
+
+	// deleting a tuple that isn't indexed is an error
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, IntField{1000}}, heapFileRID{0, 0}}
+	err := idx.deleteTuple(&tup, tid)
+	if err == nil || err.(GoDBError).code != TupleNotFoundError {
+		t.Errorf("expected TupleNotFoundError deleting a missing entry, got %v", err)
+	}
+	bp.AbortTransaction(tid)
+}
+
+func TestBTreeAbort(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, BTreeIndex)
+	idx := file.(*BTreeFile)
+	insertAges(t, hf, bp, []int{1, 2, 3})
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, IntField{4}}, nil}
+	err := hf.insertTuple(&tup, tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.AbortTransaction(tid)
+
+	if cnt := countRange(t, idx, bp, keyRange{}); cnt != 3 {
+		t.Errorf("expected 3 entries after abort, got %d", cnt)
+	}
+
+	// the pages of splits that abort are never written
+	numPages := idx.NumPages()
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 1000; i++ {
+		tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, IntField{int64(i)}}, heapFileRID{1000, i}}
+		err := idx.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.AbortTransaction(tid)
+	if idx.NumPages() != numPages {
+		t.Errorf("expected the aborted splits to leave %d pages, file has %d", numPages, idx.NumPages())
+	}
+	if cnt := countRange(t, idx, bp, keyRange{}); cnt != 3 {
+		t.Errorf("expected 3 entries after the aborted splits, got %d", cnt)
+	}
+}
+
+func TestBTreeConcurrentWriters(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, BTreeIndex)
+	idx := file.(*BTreeFile)
+	var ages []int
+	for i := 0; i < 1000; i++ {
+		ages = append(ages, i)
+	}
+	insertAges(t, hf, bp, ages)
+
+	// transactions changing different leaves don't wait for each other
+	insert := func(age int) (TransactionID, error) {
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, IntField{int64(age)}}, heapFileRID{1000, age}}
+		return tid, idx.insertTuple(&tup, tid)
+	}
+	tid1, err := insert(0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	done := make(chan error)
+	var tid2 TransactionID
+	go func() {
+		var err error
+		tid2, err = insert(999)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected inserts into different leaves not to block each other")
+	}
+	bp.CommitTransaction(tid1)
+	bp.CommitTransaction(tid2)
+	if cnt := countRange(t, idx, bp, keyRange{}); cnt != 1002 {
+		t.Errorf("expected 1002 entries, got %d", cnt)
+	}
+}
+
+func TestBTreeStringKeys(t *testing.T) {
+	bp := NewBufferPool(100)
+	td, _, _, _, _, _ := makeTestVars()
+	os.Remove(TestingIndexFile)
+	hf, err := NewHeapFile(TestingFile, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	idx, err := NewBTreeFile(TestingIndexFile, td.Fields[0], 0, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hf.indexes = append(hf.indexes, idx)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 600; i++ {
+		name := strings.Repeat(string(rune('a'+i%26)), 1+i%5)
+		tup := Tuple{td, []DBValue{StringField{name}, IntField{int64(i)}}, nil}
+		err := hf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.CommitTransaction(tid)
+
+	if cnt := countRange(t, idx, bp, keyRange{StringField{"b"}, StringField{"c"}, true, false}); cnt != 24 {
+		t.Errorf("expected 24 names in [b, c), got %d", cnt)
+	}
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	_, err = idx.scanRange(pointRange(IntField{1}), tid)
+	if err == nil || err.(GoDBError).code != TypeMismatchError {
+		t.Errorf("expected TypeMismatchError looking up an int in a string index, got %v", err)
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestVacuumUpdatesIndexes(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, BTreeIndex)
+	idx := file.(*BTreeFile)
+	var ages []int
+	for i := 0; i < 300; i++ {
+		ages = append(ages, i)
+	}
+	insertAges(t, hf, bp, ages)
+	deleteWhere(t, hf, bp, func(n int, rid heapFileRID) bool {
+		return rid.slotNum >= 3
+	})
+
+	removed, err := hf.vacuumFile(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if removed == 0 {
+		t.Fatalf("expected vacuum to move tuples and remove pages")
+	}
+	checkIndexMatches(t, hf, idx, bp)
+
+	deleteWhere(t, hf, bp, func(n int, rid heapFileRID) bool {
+		return n%2 == 0
+	})
+	_, err = hf.vacuumFile(true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	checkIndexMatches(t, hf, idx, bp)
+}
+
+func TestParseCreateIndex(t *testing.T) {
+	bp := NewBufferPool(50)
+	err := MakeTestDatabaseEasy(bp)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf("failed load catalog, %s", err.Error())
+	}
+	os.Remove(c.indexNameToFile("t_age"))
+
+	qType, _, err := Parse(c, "create index t_age on t (age)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if qType != CreateIndexQueryType {
+		t.Errorf("expected CreateIndexQueryType")
+	}
+	for _, sql := range []string{"create index t_age on t2 (age)", "create index t_x on t (nosuchcolumn)", "create index t_x on nosuchtable (age)", "alter table t add index t_x (age)"} {
+		_, _, err := Parse(c, sql)
+		if err == nil {
+			t.Errorf("expected error, q=%s", sql)
+		}
+	}
+
+	// the index is saved in the catalog and opened with the table
+	if !strings.Contains(c.CatalogString(), "index t_age on t (age)") {
+		t.Errorf("expected index in catalog, got %s", c.CatalogString())
+	}
+	err = c.SaveToFile("index_catalog.txt", "./")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.Remove("index_catalog.txt")
+	c2, err := NewCatalogFromFile("index_catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	file, err := c2.GetTable("t")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hf := file.(*HeapFile)
+	if len(hf.indexes) != 1 {
+		t.Fatalf("expected table t to have one index, has %d", len(hf.indexes))
+	}
+	idx := hf.indexes[0].(*BTreeFile)
+	checkIndexMatches(t, hf, idx, bp)
+
+	// inserts through SQL maintain the index
+	_, op, err := Parse(c2, "insert into t values ('alice', 42)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ := op.Iterator(tid)
+	if _, err := iter(); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// indexes can't be created or dropped inside an open transaction, which
+	// holds locks on the table
+	for _, sql := range []string{"create index t_name on t (name)", "drop index t_age on t"} {
+		done := make(chan error)
+		go func() {
+			_, _, err := Parse(c2, sql)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if e, ok := err.(GoDBError); !ok || e.code != IllegalOperationError {
+				t.Errorf("expected q=%s inside a transaction to be rejected, got %v", sql, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("q=%s inside a transaction is waiting for the transaction's locks", sql)
+		}
+	}
+	bp.CommitTransaction(tid)
+	if cnt := countRange(t, idx, bp, pointRange(IntField{42})); cnt != 1 {
+		t.Errorf("expected 1 entry with age 42, got %d", cnt)
+	}
+
+	report, err := c2.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 || report.Indexes != 1 {
+		t.Errorf("expected one index and no problems, got %s", report.String())
+	}
+
+	// a tuple deleted behind the index's back is reported by fsck
+	bare, _ := NewHeapFile(c2.tableNameToFile("t"), hf.Descriptor(), bp)
+	deleteWhere(t, bare, bp, func(n int, rid heapFileRID) bool {
+		return n == 0
+	})
+	report, err = c2.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0].Problem, "doesn't match a tuple") {
+		t.Errorf("expected a dangling index entry, got %s", report.String())
+	}
+
+	qType, _, err = Parse(c2, "drop index t_age on t")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if qType != DropIndexQueryType {
+		t.Errorf("expected DropIndexQueryType")
+	}
+	if _, err := os.Stat(c2.indexNameToFile("t_age")); !os.IsNotExist(err) {
+		t.Errorf("expected index file to be removed")
+	}
+	if _, _, err := Parse(c2, "drop index t_age on t"); err == nil {
+		t.Errorf("expected error dropping a missing index")
+	}
+}
+
+// Dropping a table inside a transaction drops its indexes too, even though
+// indexes can't be dropped on their own there
+func TestDropIndexedTableInTransaction(t *testing.T) {
+	vfs := NewMemFS()
+	c := makePartitionCatalog(t, vfs,
+		"create table people (name text, age int)",
+		"create index people_age on people (age)")
+	indexFile := c.indexNameToFile("people_age")
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	if _, _, err := Parse(c, "drop table people"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	c.bp.CommitTransaction(tid)
+	if c.findIndex("people_age") != nil {
+		t.Errorf("expected the index of the dropped table to be dropped")
+	}
+	if _, err := vfs.Stat(indexFile); err == nil {
+		t.Errorf("expected the file of the index to be removed")
+	}
+}
+
+// Create an index of each kind with SQL, and check that it is saved in the
+// catalog, maintained by inserts and deletes, used by the queries it can
+// answer, and consistent with its table
+func TestParseCreateIndexKinds(t *testing.T) {
+	type query struct {
+		sql      string
+		expected []string
+		indexed  bool // whether the plan reads the index
+	}
+	cases := []struct {
+		kind    IndexKind
+		table   string
+		column  string
+		setup   []string // statements run before the index is created
+		invalid []string // statements that must fail
+		changes []string // statements run after the catalog is reloaded
+		queries []query
+	}{
+		{
+			kind: HashIndex, table: "t", column: "age",
+			invalid: []string{"create index t_x on t (age) using bitmap"},
+			changes: []string{"delete from t where name = 'bo'", "insert into t values ('newbie', 7)"},
+			// equality filters and joins look up the index, other predicates
+			// don't
+			queries: []query{
+				{"select name, age from t where age = 22", []string{"[{ang} {22}]", "[{riza} {22}]"}, true},
+				{"select name, age from t where age = 22 and age < 30", []string{"[{ang} {22}]", "[{riza} {22}]"}, true},
+				{"select name from t where age = 7", []string{"[{newbie}]"}, true},
+				{"select name from t where age > 50", []string{"[{sam}]", "[{sarah}]"}, false},
+				{"select t.name, t2.name from t2 join t on t2.age = t.age where t2.name = 'sam'", []string{"[{sam} {sam}]", "[{sam} {sam}]"}, true},
+				{"select count(t.name) from t join t2 on t.age = t2.age", []string{"[{14}]"}, true},
+			},
+		},
+		{
+			kind: BloomIndex, table: "t", column: "age",
+			changes: []string{"delete from t where name = 'bo'", "insert into t values ('newbie', 7)"},
+			// a Bloom filter index can't be scanned, it only lets scans skip
+			// heap pages
+			queries: []query{
+				{"select name, age from t where age = 22", []string{"[{ang} {22}]", "[{riza} {22}]"}, false},
+				{"select name from t where age = 7", []string{"[{newbie}]"}, false},
+				{"select name from t where age = 99", []string{"[{sam}]"}, false},
+				{"select count(t.name) from t join t2 on t.age = t2.age", []string{"[{14}]"}, false},
+			},
+		},
+		{
+			kind: FullTextIndex, table: "docs", column: "body",
+			setup: []string{
+				"create table docs (id int, body text)",
+				"insert into docs values (1, 'The quick brown fox'), (2, 'A lazy dog sleeps, the dog naps'), " +
+					"(3, 'Foxes and dogs'), (4, 'Nothing to see here'), (5, 'fox, fox and more FOX')",
+			},
+			invalid: []string{"create index docs_id on docs (id) using fulltext"},
+			changes: []string{"delete from docs where match(body, 'lazy')", "insert into docs values (6, 'the dog and the cat')"},
+			// MATCH predicates read the postings of their terms;  see
+			// TestTextSearchRanking for the order of the results
+			queries: []query{
+				{"select id from docs where match(body, 'fox')", []string{"[{1}]", "[{5}]"}, true},
+				{"select id from docs where match (body) against ('DOGS')", []string{"[{3}]"}, true},
+				{"select id from docs where match(body, 'dog')", []string{"[{6}]"}, true},
+				{"select id from docs where match(body, 'cat sleeps')", []string{"[{6}]"}, true},
+				{"select id from docs where match(body, 'fox') and id < 5", []string{"[{1}]"}, true},
+				{"select id from docs where id = 4", []string{"[{4}]"}, false},
+			},
+		},
+	}
+	for _, tc := range cases {
+		func() {
+			kind := indexKindNames[tc.kind]
+			bp := NewBufferPool(50)
+			err := MakeTestDatabaseEasy(bp)
+			if err != nil {
+				t.Fatalf("failed to create test database, %s", err.Error())
+			}
+			c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+			if err != nil {
+				t.Fatalf("failed load catalog, %s", err.Error())
+			}
+			if len(tc.setup) > 0 {
+				os.Remove(c.tableNameToFile(tc.table))
+				defer os.Remove(c.tableNameToFile(tc.table))
+			}
+			name := tc.table + "_" + tc.column
+			os.Remove(c.indexNameToFile(name))
+			defer os.Remove(c.indexNameToFile(name))
+			for _, sql := range tc.setup {
+				_, op, err := Parse(c, sql)
+				if err != nil {
+					t.Fatalf("%s: %s", kind, err.Error())
+				}
+				if op != nil {
+					collectTuples(t, op, bp)
+				}
+			}
+
+			create := fmt.Sprintf("create index %s on %s (%s) using %s", name, tc.table, tc.column, kind)
+			qType, _, err := Parse(c, create)
+			if err != nil {
+				t.Fatalf("%s: %s", kind, err.Error())
+			}
+			if qType != CreateIndexQueryType {
+				t.Errorf("%s: expected CreateIndexQueryType", kind)
+			}
+			for _, sql := range tc.invalid {
+				if _, _, err := Parse(c, sql); err == nil {
+					t.Errorf("%s: expected error, q=%s", kind, sql)
+				}
+			}
+			if !strings.Contains(c.CatalogString(), create[len("create "):]) {
+				t.Errorf("%s: expected the index in the catalog, got %s", kind, c.CatalogString())
+			}
+
+			// the index is opened with the table, kept up to date by changes
+			// through SQL, and read by queries once the catalog is reloaded
+			err = c.SaveToFile("index_catalog.txt", "./")
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			defer os.Remove("index_catalog.txt")
+			c, err = NewCatalogFromFile("index_catalog.txt", bp, "./")
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			file, err := c.GetTable(tc.table)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if indexes := file.(*HeapFile).indexes; len(indexes) != 1 {
+				t.Fatalf("%s: expected table %s to have one index, has %d", kind, tc.table, len(indexes))
+			}
+			for _, sql := range tc.changes {
+				runQuery(t, c, sql)
+			}
+			c, err = NewCatalogFromFile("index_catalog.txt", NewBufferPool(50), "./")
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			for _, q := range tc.queries {
+				results, plan := runQuery(t, c, q.sql)
+				if strings.Join(results, ",") != strings.Join(q.expected, ",") {
+					t.Errorf("%s: q=%s: expected %v, got %v", kind, q.sql, q.expected, results)
+				}
+				indexed := false
+				for _, op := range planOperators(plan) {
+					switch op := op.(type) {
+					case *IndexScan, *IndexJoin:
+						indexed = true
+					case *TextSearch:
+						indexed = indexed || op.index != nil
+					}
+				}
+				if indexed != q.indexed {
+					t.Errorf("%s: q=%s: expected the plan to read the index: %v", kind, q.sql, q.indexed)
+				}
+			}
+
+			report, err := c.Fsck(false)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if len(report.Problems) != 0 || report.Indexes != 1 {
+				t.Errorf("%s: expected one index and no problems, got %s", kind, report.String())
+			}
+		}()
+	}
+}
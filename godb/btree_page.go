@@ -0,0 +1,273 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+/* btreePage implements the Page interface for pages of a BTreeFile.
+
+Page 0 of every B+tree file is a meta page that records the page number of
+the root, which moves when the root splits.  All other pages are nodes of the
+tree.
+
+Every entry of the tree is a key together with the record id of the heap
+tuple it came from.  Entries are ordered by key and then by record id, which
+makes every entry unique even when keys are duplicated, so a delete always
+knows exactly which entry to remove.
+
+Leaf pages hold entries in sorted order, and a link to the next leaf so that
+range scans can walk along the leaves.  Internal pages hold n separator
+entries and n+1 children;  every entry reachable from children[i+1] is >=
+entries[i], and every entry reachable from children[i] is < entries[i].
+
+All pages are PageSize bytes and share a header:
+
+	kind (int32)  number of entries (int32)  LSN (int64)  checksum (uint32)
+	next leaf / root page (int32)
+
+The checksum and LSN are at the same offsets as in heap pages, so
+[pageChecksum] and [verifyPageChecksum] apply to both.  The header is
+followed, for leaves, by the entries, each the key (see [Tuple.writeTo])
+followed by the page and slot number of the record id as int32s.  Internal
+pages store the first child as an int32, and then each separator entry
+followed by the child to its right.
+
+Entries are never merged back out of underfull pages;  a leaf whose entries
+have all been deleted stays in the tree and is skipped by scans.
+*/
+
+type btreePageKind int32
+
+const (
+	btreeMetaPage     btreePageKind = iota + 1
+	btreeLeafPage     btreePageKind = iota + 1
+	btreeInternalPage btreePageKind = iota + 1
+)
+
+const (
+	btreePageHeaderSize = 24
+	btreeNoPage         = -1
+)
+
+type indexEntry struct {
+	key DBValue
+	rid heapFileRID
+}
+
+type btreePage struct {
+	dirty    bool
+	file     *BTreeFile
+	pageNo   int
+	lsn      int64
+	kind     btreePageKind
+	entries  []indexEntry
+	children []int // internal pages only
+	link     int   // root for the meta page, next leaf for leaves
+}
+
+func newBTreePage(f *BTreeFile, pageNo int, kind btreePageKind) *btreePage {
+	return &btreePage{file: f, pageNo: pageNo, kind: kind, link: btreeNoPage}
+}
+
+func valueHasType(v DBValue, t DBType) bool {
+	switch v.(type) {
+	case IntField:
+		return t == IntType
+	case StringField:
+		return t == StringType
+	}
+	return false
+}
+
+// Compare two keys of the same type, returning -1, 0 or 1
+func compareKeys(k1 DBValue, k2 DBValue) int {
+	switch v1 := k1.(type) {
+	case IntField:
+		v2 := k2.(IntField).Value
+		if v1.Value < v2 {
+			return -1
+		} else if v1.Value > v2 {
+			return 1
+		}
+	case StringField:
+		v2 := k2.(StringField).Value
+		if v1.Value < v2 {
+			return -1
+		} else if v1.Value > v2 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Compare two entries by key, then by record id
+func compareEntries(e1 indexEntry, e2 indexEntry) int {
+	if c := compareKeys(e1.key, e2.key); c != 0 {
+		return c
+	}
+	if e1.rid.pageNum != e2.rid.pageNum {
+		if e1.rid.pageNum < e2.rid.pageNum {
+			return -1
+		}
+		return 1
+	}
+	if e1.rid.slotNum != e2.rid.slotNum {
+		if e1.rid.slotNum < e2.rid.slotNum {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// Position of the first entry of the page that is >= e
+func (p *btreePage) lowerBound(e indexEntry) int {
+	return sort.Search(len(p.entries), func(i int) bool {
+		return compareEntries(p.entries[i], e) >= 0
+	})
+}
+
+// Index into children of the subtree of an internal page that e belongs in
+func (p *btreePage) childFor(e indexEntry) int {
+	return sort.Search(len(p.entries), func(i int) bool {
+		return compareEntries(p.entries[i], e) > 0
+	})
+}
+
+// Number of entries that fit on a page of the given kind
+func btreeCapacity(kind btreePageKind, keyType DBType) int {
+	keySize := calBytesPerTuple(&TupleDesc{Fields: []FieldType{{Ftype: keyType}}})
+	if kind == btreeInternalPage {
+		return (PageSize - btreePageHeaderSize - 4) / (keySize + 12)
+	}
+	return (PageSize - btreePageHeaderSize) / (keySize + 8)
+}
+
+func (p *btreePage) isDirty() bool {
+	return p.dirty
+}
+
+func (p *btreePage) setDirty(dirty bool) {
+	p.dirty = dirty
+}
+
+func (p *btreePage) getFile() *DBFile {
+	file := (DBFile)(p.file)
+	return &file
+}
+
+func writeIndexEntry(b *bytes.Buffer, e indexEntry) error {
+	err := (&Tuple{Fields: []DBValue{e.key}}).writeTo(b)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(b, binary.LittleEndian, int32(e.rid.pageNum))
+	if err != nil {
+		return err
+	}
+	return binary.Write(b, binary.LittleEndian, int32(e.rid.slotNum))
+}
+
+func readIndexEntry(b *bytes.Buffer, keyDesc *TupleDesc) (indexEntry, error) {
+	t, err := readTupleFrom(b, keyDesc)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	var pageNum, slotNum int32
+	err = binary.Read(b, binary.LittleEndian, &pageNum)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	err = binary.Read(b, binary.LittleEndian, &slotNum)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	return indexEntry{t.Fields[0], heapFileRID{int(pageNum), int(slotNum)}}, nil
+}
+
+// Serialize the page to a PageSize buffer, filling in its checksum
+func (p *btreePage) toBuffer() (*bytes.Buffer, error) {
+	b := new(bytes.Buffer)
+	for _, v := range []any{int32(p.kind), int32(len(p.entries)), p.lsn, uint32(0), int32(p.link)} {
+		err := binary.Write(b, binary.LittleEndian, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.kind == btreeInternalPage {
+		first := btreeNoPage
+		if len(p.children) > 0 {
+			first = p.children[0]
+		}
+		err := binary.Write(b, binary.LittleEndian, int32(first))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, e := range p.entries {
+		err := writeIndexEntry(b, e)
+		if err != nil {
+			return nil, err
+		}
+		if p.kind == btreeInternalPage {
+			err = binary.Write(b, binary.LittleEndian, int32(p.children[i+1]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if b.Len() > PageSize {
+		return nil, GoDBError{PageFullError, "b+tree entries don't fit in page"}
+	}
+	b.Write(make([]byte, PageSize-b.Len()))
+	binary.LittleEndian.PutUint32(b.Bytes()[pageChecksumOffset:], pageChecksum(b.Bytes()))
+	return b, nil
+}
+
+// Read the contents of the page from a buffer written by toBuffer
+func (p *btreePage) initFromBuffer(buf *bytes.Buffer, keyDesc *TupleDesc) error {
+	var kind, numEntries, link int32
+	var checksum uint32
+	for _, v := range []any{&kind, &numEntries, &p.lsn, &checksum, &link} {
+		err := binary.Read(buf, binary.LittleEndian, v)
+		if err != nil {
+			return err
+		}
+	}
+	p.kind = btreePageKind(kind)
+	p.link = int(link)
+	if p.kind != btreeMetaPage && p.kind != btreeLeafPage && p.kind != btreeInternalPage {
+		return GoDBError{MalformedDataError, fmt.Sprintf("unknown b+tree page kind %d", kind)}
+	}
+	if numEntries < 0 || int(numEntries) > btreeCapacity(p.kind, keyDesc.Fields[0].Ftype) {
+		return GoDBError{MalformedDataError, fmt.Sprintf("bad b+tree page header: %d entries", numEntries)}
+	}
+	if p.kind == btreeInternalPage {
+		var child int32
+		err := binary.Read(buf, binary.LittleEndian, &child)
+		if err != nil {
+			return err
+		}
+		p.children = []int{int(child)}
+	}
+	p.entries = make([]indexEntry, 0, numEntries)
+	for i := 0; i < int(numEntries); i++ {
+		e, err := readIndexEntry(buf, keyDesc)
+		if err != nil {
+			return err
+		}
+		p.entries = append(p.entries, e)
+		if p.kind == btreeInternalPage {
+			var child int32
+			err = binary.Read(buf, binary.LittleEndian, &child)
+			if err != nil {
+				return err
+			}
+			p.children = append(p.children, int(child))
+		}
+	}
+	return nil
+}
@@ -58,30 +58,92 @@ func (l *lockInfo) unlockByType(tid TransactionID) {
 
 type BufferPool struct {
 	// TODO: some code goes here
+	// pages, locks and the pages each transaction has fetched are all keyed
+	// by [DBFile.pageKey], since pages of several files (e.g., a table and
+	// its indexes) share the pool
 	mapPage     map[any]*Page
-	dbfile      DBFile
 	numPages    int
 	mu          sync.Mutex
 	abortmu     sync.Mutex
-	tidMap      map[TransactionID][]int
-	lockmap     map[int]*lockInfo
+	tidMap      map[TransactionID][]any
+	lockmap     map[any]*lockInfo
 	waitGraph   map[TransactionID]map[TransactionID]any
-	tidPagesDep map[TransactionID][]int
+	tidPagesDep map[TransactionID][]any
+	vfs         VFS // the file system of the files whose pages are cached
+	durability  DurabilityMode
+	readMode    ReadMode                // how heap files read their pages, see heap_mmap.go
+	failed      map[TransactionID]error // transactions whose commit failed
+	running     map[TransactionID]bool  // transactions begun and not yet committed or aborted
+	readAhead   int                     // pages prefetched ahead of heap file scans, see prefetch.go
+	inFlight    map[any]chan struct{}   // pages being prefetched, closed once they are read
+	generations map[any]int             // times each page was written or dropped, see prefetch.go
 }
 
+// How a BufferPool makes the pages it writes durable
+type DurabilityMode int
+
+const (
+	// Sync every file a transaction wrote before its commit returns
+	SyncOnCommit DurabilityMode = iota
+	// Sync a file after each page written to it
+	SyncEveryWrite
+	// Never sync, leaving it to the operating system to write pages to disk
+	// eventually;  a crash may lose transactions that have committed
+	SyncNever
+)
+
 // Create a new BufferPool with the specified number of pages
 func NewBufferPool(numPages int) *BufferPool {
+	return NewBufferPoolWithVFS(numPages, OSFS{})
+}
+
+// Create a new BufferPool with the specified number of pages, for files of
+// the given file system
+func NewBufferPoolWithVFS(numPages int, vfs VFS) *BufferPool {
 	// TODO: some code goes here
 	return &BufferPool{
 		mapPage:     make(map[any]*Page, numPages),
 		numPages:    numPages,
-		tidMap:      make(map[TransactionID][]int),
-		lockmap:     make(map[int]*lockInfo),
+		tidMap:      make(map[TransactionID][]any),
+		lockmap:     make(map[any]*lockInfo),
 		waitGraph:   make(map[TransactionID]map[TransactionID]any),
-		tidPagesDep: make(map[TransactionID][]int),
+		tidPagesDep: make(map[TransactionID][]any),
+		vfs:         vfs,
+		failed:      make(map[TransactionID]error),
+		running:     make(map[TransactionID]bool),
+		inFlight:    make(map[any]chan struct{}),
+		generations: make(map[any]int),
 	}
 }
 
+// Set how the pages written by the BufferPool are made durable.  The
+// default is [SyncOnCommit].
+func (bp *BufferPool) SetDurability(mode DurabilityMode) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.durability = mode
+}
+
+// Set how heap files read the pages they are asked for.  The default is
+// [ReadAtReads].  The mode should be set before the BufferPool is used.
+func (bp *BufferPool) SetReadMode(mode ReadMode) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.readMode = mode
+}
+
+// The mode set with [BufferPool.SetReadMode]
+func (bp *BufferPool) getReadMode() ReadMode {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.readMode
+}
+
+// Return the file system of the files whose pages are cached
+func (bp *BufferPool) VFS() VFS {
+	return bp.vfs
+}
+
 // Testing method -- iterate through all pages in the buffer pool
 // and flush them using [DBFile.flushPage]. Does not need to be thread/transaction safe
 func (bp *BufferPool) FlushAllPages() {
@@ -89,11 +151,84 @@ func (bp *BufferPool) FlushAllPages() {
 	// 这里是需要实现的， 文档中未告知
 	for _, page := range bp.mapPage {
 		if (*page).isDirty() {
-			bp.dbfile.flushPage(page)
+			(*(*page).getFile()).flushPage(page)
 		}
 	}
 }
 
+// Write the dirty pages that tid has fetched back to disk, without releasing
+// its locks.  Used by operations such as VACUUM that must make their changes
+// durable before they can shrink a file.
+func (bp *BufferPool) flushPages(tid TransactionID) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	for _, pageKey := range bp.tidMap[tid] {
+		page, ok := bp.mapPage[pageKey]
+		if ok && (*page).isDirty() {
+			err := bp.writePage(pageKey, page)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write a dirty page with the given key back to its file, syncing the file if
+// every write must be durable.  The page stays dirty if it couldn't be
+// written.  Must be called with mu held.
+func (bp *BufferPool) writePage(key any, page *Page) error {
+	file := *(*page).getFile()
+	bp.generations[key]++
+	err := file.flushPage(page)
+	if err != nil {
+		return err
+	}
+	(*page).setDirty(false)
+	if bp.durability == SyncEveryWrite {
+		return file.sync()
+	}
+	return nil
+}
+
+// Drop cached pages of file with page numbers in [from, to) from the buffer
+// pool, e.g., because the file has been truncated or rewritten underneath
+// them.  Dirty pages are discarded, so callers must flush them first if they
+// want to keep their contents.
+func (bp *BufferPool) discardFilePages(file DBFile, from int, to int) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	for pageNo := from; pageNo < to; pageNo++ {
+		key := file.pageKey(pageNo)
+		delete(bp.mapPage, key)
+		bp.generations[key]++
+	}
+}
+
+// Add a page that file has just allocated to the pool, dirty and write locked
+// by tid.  The page is only written to the file when tid commits, and is
+// dropped if tid aborts.
+func (bp *BufferPool) addNewPage(file DBFile, pageNo int, page Page, tid TransactionID) (*Page, error) {
+	bp.mu.Lock()
+	err := bp.makeRoom()
+	if err != nil {
+		bp.mu.Unlock()
+		return nil, err
+	}
+	page.setDirty(true)
+	bp.mapPage[file.pageKey(pageNo)] = &page
+	bp.mu.Unlock()
+	return bp.GetPage(file, pageNo, tid, WritePerm)
+}
+
+// Whether a page of file is in the buffer pool
+func (bp *BufferPool) isCached(file DBFile, pageNo int) bool {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	_, ok := bp.mapPage[file.pageKey(pageNo)]
+	return ok
+}
+
 func printMapKeys(mp map[TransactionID]any) {
 	for key := range mp {
 		fmt.Printf("%v ", *key)
@@ -123,14 +258,15 @@ func (bp *BufferPool) AbortTransaction(tid TransactionID) {
 	fmt.Println("abort transaction: ", *tid)
 	//printMap(bp.waitGraph)
 	pages := bp.tidMap[tid]
-	for _, pageId := range pages {
-		pageKey := bp.dbfile.pageKey(pageId)
+	for _, pageKey := range pages {
 		delete(bp.mapPage, pageKey)
-		lInfo := bp.lockmap[pageId]
+		lInfo := bp.lockmap[pageKey]
 		lInfo.unlockByType(tid)
 		delete(lInfo.mp, tid)
 	}
 	delete(bp.tidMap, tid)
+	delete(bp.failed, tid)
+	delete(bp.running, tid)
 	//bp.delEdges(tid)
 	bp.deleteTidToPagesDep(tid)
 	//bp.abortmu.Unlock()
@@ -142,30 +278,83 @@ func (bp *BufferPool) AbortTransaction(tid TransactionID) {
 // should iterate through pages and write them to disk.  In GoDB lab3 we assume
 // that the system will not crash while doing this, allowing us to avoid using a
 // WAL. You do not need to implement this for lab 1.
-func (bp *BufferPool) CommitTransaction(tid TransactionID) {
+//
+// The commit is durable once CommitTransaction returns, unless the durability
+// mode is [SyncNever].  If a page can't be written or a file can't be synced,
+// the error is returned and the transaction is marked failed:  its locks are
+// released, its pages are dropped from the pool since some of them may not
+// have reached the disk, and it can't be used again except to abort it.
+//
+// TODO: forcing pages makes a commit take time in proportion to the number of
+// pages the transaction dirtied.  Once GoDB has a write-ahead log, committed
+// pages should instead be left dirty for a background writer to trickle out
+// at a configurable rate, writing faster when the dirty pages pass a
+// high-water mark and flushing all of them on shutdown.  Until then commits
+// must force, since committed pages that hadn't been written yet couldn't be
+// redone after a crash.
+func (bp *BufferPool) CommitTransaction(tid TransactionID) error {
 	// TODO: some code goes here
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
+	if err, ok := bp.failed[tid]; ok {
+		return err
+	}
 	pages := bp.tidMap[tid]
-	for _, pageId := range pages {
-		pageKey := bp.dbfile.pageKey(pageId)
+	var err error
+	written := make(map[DBFile]bool)
+	for _, pageKey := range pages {
 		page, ok := bp.mapPage[pageKey]
-		if ok && (*page).isDirty() {
-			bp.dbfile.flushPage(page)
-			(*page).setDirty(false)
+		if ok && (*page).isDirty() && err == nil {
+			written[*(*page).getFile()] = true
+			err = bp.writePage(pageKey, page)
+		}
+	}
+	if err == nil && bp.durability == SyncOnCommit {
+		for file := range written {
+			err = file.sync()
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		err = GoDBError{TransactionFailedError, fmt.Sprintf("transaction %d failed to commit: %s", *tid, err.Error())}
+		bp.failed[tid] = err
+	}
+	for _, pageKey := range pages {
+		if err != nil {
+			delete(bp.mapPage, pageKey)
+			bp.generations[pageKey]++
 		}
-		lInfo := bp.lockmap[pageId]
+		lInfo := bp.lockmap[pageKey]
 		lInfo.unlockByType(tid)
 		delete(lInfo.mp, tid)
 	}
 	delete(bp.tidMap, tid)
+	delete(bp.running, tid)
 	//bp.delEdges(tid)
 	bp.deleteTidToPagesDep(tid)
-
+	return err
 }
 
 func (bp *BufferPool) BeginTransaction(tid TransactionID) error {
 	// TODO: some code goes here
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.running[tid] = true
+	return nil
+}
+
+// Return an error if a transaction is running.  Statements that run in
+// transactions of their own, such as VACUUM, are rejected while one is:  if
+// the caller holds its locks, the statement's transaction would wait for them
+// forever.
+func (bp *BufferPool) checkNoTransactions(statement string) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if len(bp.running) > 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("%s can't run while a transaction is open", statement)}
+	}
 	return nil
 }
 
@@ -182,22 +371,85 @@ func (bp *BufferPool) BeginTransaction(tid TransactionID) error {
 // of pages in the BufferPool in a map keyed by the [DBFile.pageKey].
 func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm RWPerm) (*Page, error) {
 	// TODO: some code goes here
-	for !bp.mu.TryLock() {
-		time.Sleep(time.Millisecond * 100)
-		//fmt.Printf("tid:%v try to get buffer pool mu\n", *tid)
+	// prefetches hold the lock only briefly, so wait for it rather than
+	// polling
+	bp.mu.Lock()
+	err := bp.acquirePageLock(file, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
 	}
+	key := file.pageKey(pageNo)
+	page, ok := bp.mapPage[key]
+	done := bp.inFlight[key]
+	bp.mu.Unlock()
+
+	if ok {
+		return page, nil
+	}
+	if done != nil {
+		// a prefetch is already reading the page
+		<-done
+		bp.mu.Lock()
+		page, ok = bp.mapPage[key]
+		bp.mu.Unlock()
+		if ok {
+			return page, nil
+		}
+	}
+	// page not in cache
+	page, err = file.readPage(pageNo)
+	if err != nil {
+		return nil, err
+	}
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if cached, ok := bp.mapPage[key]; ok {
+		// prefetched while it was being read
+		return cached, nil
+	}
+	err = bp.makeRoom()
+	if err != nil {
+		return nil, err
+	}
+	bp.mapPage[key] = page
+	return page, nil
+}
+
+// Lock page pageNo of file on behalf of tid, as [BufferPool.GetPage] does,
+// without reading the page.  Scans lock the pages they skip this way, since
+// another transaction could otherwise add tuples they are looking for to
+// them before tid completes.
+func (bp *BufferPool) lockPage(file DBFile, pageNo int, tid TransactionID, perm RWPerm) error {
+	bp.mu.Lock()
+	err := bp.acquirePageLock(file, pageNo, tid, perm)
+	if err != nil {
+		return err
+	}
+	bp.mu.Unlock()
+	return nil
+}
+
+// Take a lock on page pageNo of file for tid, waiting for it if another
+// transaction holds a conflicting lock.  Must be called with mu held, which is
+// still held when the lock has been taken, but not if an error is returned.
+func (bp *BufferPool) acquirePageLock(file DBFile, pageNo int, tid TransactionID, perm RWPerm) error {
 	//fmt.Printf("tid:%v success get buffer pool mu perm is %s\n", *tid, permMap[perm])
+	if err, ok := bp.failed[tid]; ok {
+		bp.mu.Unlock()
+		return err
+	}
+	key := file.pageKey(pageNo)
 	pages, ok := bp.tidMap[tid]
 	if !ok {
-		pages = []int{pageNo}
+		pages = []any{key}
 	} else {
-		pages = append(pages, pageNo)
+		pages = append(pages, key)
 	}
 	bp.tidMap[tid] = pages
-	lInfo, ok := bp.lockmap[pageNo]
+	lInfo, ok := bp.lockmap[key]
 	if !ok {
-		bp.lockmap[pageNo] = &lockInfo{lockState: InitState, mp: make(map[TransactionID]any)}
-		lInfo = bp.lockmap[pageNo]
+		bp.lockmap[key] = &lockInfo{lockState: InitState, mp: make(map[TransactionID]any)}
+		lInfo = bp.lockmap[key]
 	}
 
 	switch perm {
@@ -213,7 +465,7 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 				for !lInfo.mu.TryRLock() {
 					if cnt == 0 {
 						//bp.addEdges(tid, lInfo.mp)
-						bp.addTidToPagesDep(tid, pageNo)
+						bp.addTidToPagesDep(tid, key)
 						flag = true
 						bp.mu.Unlock()
 					}
@@ -223,7 +475,7 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 					if bp.deadLockDetection(tid) {
 						//bp.AbortTransaction(tid)
 						fmt.Printf("find deadlock in tid:%v", *tid)
-						return nil, GoDBError{DeadlockError, "dead lock occur"}
+						return GoDBError{DeadlockError, "dead lock occur"}
 					}
 				}
 
@@ -272,7 +524,7 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 				for !lInfo.mu.TryLock() {
 					if cnt == 0 {
 						//bp.addEdges(tid, lInfo.mp)
-						bp.addTidToPagesDep(tid, pageNo)
+						bp.addTidToPagesDep(tid, key)
 						flag = true
 						bp.mu.Unlock()
 					}
@@ -286,7 +538,7 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 						//bp.AbortTransaction(tid)
 						fmt.Printf("find deadlock in tid:%v", *tid)
 
-						return nil, GoDBError{DeadlockError, "deadlock occur"}
+						return GoDBError{DeadlockError, "deadlock occur"}
 					}
 				}
 
@@ -305,7 +557,7 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 			for !lInfo.mu.TryLock() {
 				if cnt == 0 {
 					//bp.addEdges(tid, lInfo.mp)
-					bp.addTidToPagesDep(tid, pageNo)
+					bp.addTidToPagesDep(tid, key)
 					flag = true
 					bp.mu.Unlock()
 				}
@@ -316,7 +568,7 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 					//bp.AbortTransaction(tid)
 					fmt.Printf("find deadlock in tid:%v", *tid)
 
-					return nil, GoDBError{DeadlockError, "dead lock occur"}
+					return GoDBError{DeadlockError, "dead lock occur"}
 				}
 			}
 
@@ -330,37 +582,23 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 			}
 		}
 	}
-	bp.mu.Unlock()
-	key := file.pageKey(pageNo)
-	page, ok := bp.mapPage[key]
+	return nil
+}
 
-	if ok {
-		return page, nil
+// Make room for another page, evicting a page that is not dirty if the pool
+// is full.  Must be called with bp.mu held.
+func (bp *BufferPool) makeRoom() error {
+	if len(bp.mapPage) < bp.numPages {
+		return nil
 	}
-	// page not in cache
-	page, err := file.readPage(pageNo)
-	if err != nil {
-		return nil, err
-	}
-	cnt := len(bp.mapPage)
-	// delete a page if it is not dirty
-	if cnt == bp.numPages {
-		flag := true
-		for key, p := range bp.mapPage {
-			if !(*p).isDirty() {
-				delete(bp.mapPage, key)
-				flag = false
-				break
-			}
-		}
-		if flag {
-			return nil, GoDBError{BufferPoolFullError, "buffer pool is full"}
+	for key, p := range bp.mapPage {
+		if !(*p).isDirty() {
+			delete(bp.mapPage, key)
+			bp.generations[key]++
+			return nil
 		}
 	}
-	bp.mu.Lock()
-	bp.mapPage[key] = page
-	bp.mu.Unlock()
-	return page, err
+	return GoDBError{BufferPoolFullError, "buffer pool is full"}
 }
 
 func (bp *BufferPool) buildWaitGraph() {
@@ -420,10 +658,10 @@ func getValues(mp map[TransactionID]any) (arr []TransactionID) {
 	return
 }
 
-func (bp *BufferPool) addTidToPagesDep(from TransactionID, page int) {
+func (bp *BufferPool) addTidToPagesDep(from TransactionID, page any) {
 	pages, ok := bp.tidPagesDep[from]
 	if !ok {
-		pages = []int{page}
+		pages = []any{page}
 	}
 	pages = append(pages, page)
 	bp.tidPagesDep[from] = pages
@@ -46,3 +46,118 @@ func TestGetPage(t *testing.T) {
 		t.Fatalf("No error when getting page 7 from a file with 6 pages.")
 	}
 }
+
+// Count the tuples of a heap file in a MemFS, as read by a new buffer pool
+func countDurableTuples(t *testing.T, vfs VFS, td *TupleDesc) int {
+	bp := NewBufferPoolWithVFS(10, vfs)
+	hf, err := NewHeapFile("durable.dat", td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return len(collectTuples(t, hf, bp))
+}
+
+func TestCommitDurability(t *testing.T) {
+	td, t1, _, _, _, _ := makeTestVars()
+	mem := NewMemFS()
+	bp := NewBufferPoolWithVFS(10, mem)
+	hf, err := NewHeapFile("durable.dat", &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	insert := func() error {
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		err := hf.insertTuple(&t1, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		return bp.CommitTransaction(tid)
+	}
+
+	// by default a commit survives a crash
+	if err := insert(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	mem.Crash()
+	if n := countDurableTuples(t, mem, &td); n != 1 {
+		t.Fatalf("expected the committed tuple to survive a crash, got %d tuples", n)
+	}
+
+	// without syncing, it may not
+	bp.SetDurability(SyncNever)
+	if err := insert(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n := countDurableTuples(t, mem, &td); n != 2 {
+		t.Fatalf("expected 2 tuples before the crash, got %d", n)
+	}
+	mem.Crash()
+	if n := countDurableTuples(t, mem, &td); n != 1 {
+		t.Errorf("expected the unsynced commit to be lost in a crash, got %d tuples", n)
+	}
+
+	// when every write is synced, flushed pages survive even before commit
+	bp = NewBufferPoolWithVFS(10, mem)
+	bp.SetDurability(SyncEveryWrite)
+	hf, err = NewHeapFile("durable.dat", &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	hf.insertTuple(&t1, tid)
+	err = bp.flushPages(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	mem.Crash()
+	if n := countDurableTuples(t, mem, &td); n != 2 {
+		t.Errorf("expected the flushed page to survive a crash, got %d tuples", n)
+	}
+}
+
+func TestCommitFailure(t *testing.T) {
+	td, t1, _, _, _, _ := makeTestVars()
+	vfs := NewFaultFS(NewMemFS())
+	bp := NewBufferPoolWithVFS(10, vfs)
+	hf, err := NewHeapFile("durable.dat", &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	hf.insertTuple(&t1, tid)
+	if err := bp.CommitTransaction(tid); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, kind := range []FaultKind{SyncFailure, TornWrite} {
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		hf.insertTuple(&t1, tid)
+		vfs.InjectFault(kind, "durable.dat", 0)
+		err := bp.CommitTransaction(tid)
+		if e, ok := err.(GoDBError); !ok || e.code != TransactionFailedError {
+			t.Fatalf("expected the commit to fail, got %v", err)
+		}
+		if bp.CommitTransaction(tid) == nil {
+			t.Errorf("expected a failed transaction not to commit")
+		}
+		if _, err := bp.GetPage(hf, 0, tid, ReadPerm); err == nil {
+			t.Errorf("expected a failed transaction not to read pages")
+		}
+		bp.AbortTransaction(tid)
+		if bp.isCached(hf, 0) {
+			t.Errorf("expected the failed transaction's pages to be dropped")
+		}
+	}
+
+	// the failed transactions released their locks
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	defer bp.CommitTransaction(tid)
+	if _, err = bp.GetPage(hf, 0, tid, WritePerm); err != nil {
+		t.Errorf("expected to lock the page after the failures, got %v", err)
+	}
+}
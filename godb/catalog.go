@@ -4,12 +4,19 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
 type Table struct {
-	name string
-	desc TupleDesc
+	name       string
+	desc       TupleDesc
+	clusterKey string // column the table is clustered on, or ""
+	columnar   bool   // whether the table is stored in a ColumnarFile
+	compressed string // codec the pages of a heap table are compressed with, or ""
+	// partitioning of the table, or nil if it is stored in a single heap file
+	partitioning *partitioning
+	temporary    bool // whether the table only lasts for the session, see temp_table.go
 }
 
 type Catalog struct {
@@ -18,15 +25,22 @@ type Catalog struct {
 	columnMap map[string][]*Table
 	bp        *BufferPool
 	rootPath  string
+	indexes   []*Index
+	cipher    *pageCipher       // encrypts the pages of every table and index, or nil
+	pageSize  int               // of the pages of heap tables
+	tempDir   string            // scratch directory of the session's temporary tables, or "" if none was made yet
+	files     map[string]DBFile // files of the tables opened by GetTable, by table name
 }
 
+// Write the catalog to a file.  Temporary tables and their indexes are left
+// out, since they don't outlive the session.
 func (c *Catalog) SaveToFile(catalogFile string, rootPath string) error {
-	catalogString := c.CatalogString()
-	f, err := os.OpenFile(rootPath+"/"+catalogFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	catalogString := c.catalogString(false)
+	f, err := c.bp.vfs.OpenFile(rootPath+"/"+catalogFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
-	f.WriteString(catalogString)
+	f.Write([]byte(catalogString))
 	f.Close()
 	return nil
 }
@@ -34,16 +48,32 @@ func (c *Catalog) SaveToFile(catalogFile string, rootPath string) error {
 func (c *Catalog) dropTable(table string) error {
 	for i, t := range c.tables {
 		if t.name == table {
+			c.closeTable(table)
+			// the names of the files of a temporary table depend on it
+			// still being in the catalog
+			for _, fileName := range c.tableFileNames(t) {
+				removeHeapFile(c.bp.vfs, fileName)
+			}
+			for _, idx := range c.tableIndexes(table) {
+				c.removeIndex(idx)
+			}
 			c.tableMap[table] = nil
 			c.columnMap[table] = nil
 			c.tables = append(c.tables[:i], c.tables[i+1:]...)
-			os.Remove(c.tableNameToFile(table))
 			return nil
 		}
 	}
 	return GoDBError{NoSuchTableError, "couldn't find table to drop"}
 }
 
+// Remove a heap file along with its side files
+func removeHeapFile(vfs VFS, fileName string) {
+	vfs.Remove(fileName)
+	vfs.Remove(fsmFileName(fileName))
+	vfs.Remove(zoneMapFileName(fileName))
+	vfs.Remove(pageMapFileName(fileName))
+}
+
 func ImportCatalogFromCSVs(catalogFile string, bp *BufferPool, rootPath string, tableSuffix string, separator string) error {
 	c, err := NewCatalogFromFile(catalogFile, bp, rootPath)
 	if err != nil {
@@ -51,16 +81,20 @@ func ImportCatalogFromCSVs(catalogFile string, bp *BufferPool, rootPath string,
 	}
 	for _, t := range c.tables {
 		fmt.Printf("Doing %s\n", t.name)
+		if t.clusterKey != "" || t.columnar || t.partitioning != nil {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("can't load table %s from a CSV file, it isn't a heap file", t.name)}
+		}
 		fileName := rootPath + "/" + t.name + "." + tableSuffix
-		hf, err := NewHeapFile(c.tableNameToFile(t.name), t.desc.copy(), c.bp)
+		hf, err := openHeapFile(c.tableNameToFile(t.name), t.desc.copy(), c.pageSize, t.compressed, c.cipher, c.bp)
 		if err != nil {
 			return err
 		}
-		f, err := os.Open(fileName)
+		f, err := c.bp.vfs.OpenFile(fileName, os.O_RDONLY, 0)
 		if err != nil {
 			return err
 		}
 		err = hf.LoadFromCSV(f, false, separator, true)
+		f.Close()
 		if err != nil {
 			return err
 		}
@@ -69,21 +103,89 @@ func ImportCatalogFromCSVs(catalogFile string, bp *BufferPool, rootPath string,
 	return nil
 }
 
-func parseCatalogFile(catalogFile string, rootPath string) ([]TupleDesc, []string, error) {
-	var tables []TupleDesc
-	var names []string
-	f, err := os.Open(rootPath + "/" + catalogFile)
+// Parse an index entry of a catalog file, "index name on table (column)",
+// followed by "using" and the kind of index for indexes other than b+trees
+func parseCatalogIndex(line string) (*Index, error) {
+	words := strings.Fields(strings.NewReplacer("(", " ", ")", " ").Replace(line))
+	if (len(words) != 5 && len(words) != 7) || words[0] != "index" || words[2] != "on" || (len(words) == 7 && words[5] != "using") {
+		return nil, GoDBError{ParseError, fmt.Sprintf("malformed index entry in catalog (line %s)", line)}
+	}
+	kind := BTreeIndex
+	if len(words) == 7 {
+		var err error
+		kind, err = parseIndexKind(words[6])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Index{words[1], words[3], words[4], kind}, nil
+}
+
+// Split the primary key clause off a table entry of the catalog, which has
+// the form "name (field type, ...) [primary key (column)]"
+func parseCatalogClusterKey(line string) (string, string, error) {
+	const clause = " primary key ("
+	i := strings.LastIndex(line, clause)
+	if i < 0 {
+		return line, "", nil
+	}
+	key := strings.TrimSpace(line[i+len(clause):])
+	if !strings.HasSuffix(key, ")") {
+		return "", "", GoDBError{ParseError, fmt.Sprintf("malformed primary key in catalog entry (%s)", line)}
+	}
+	return line[:i], strings.TrimSpace(strings.TrimSuffix(key, ")")), nil
+}
+
+// Parse a catalog file, returning its tables and indexes and the page size of
+// its heap tables
+func parseCatalogFile(vfs VFS, catalogFile string, rootPath string) ([]*Table, []*Index, int, error) {
+	var tables []*Table
+	var indexes []*Index
+	pageSize := PageSize
+	f, err := vfs.OpenFile(rootPath+"/"+catalogFile, os.O_RDONLY, 0)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, 0, err
 	}
+	defer f.Close()
 	scanner := bufio.NewScanner(f)
 
 	for scanner.Scan() {
-		// code to read each line
-		line := strings.ToLower(scanner.Text())
+		// code to read each line;  the partitioning clause is split off first,
+		// since the strings it lists keep their case
+		line, partitionClause := cutPartitionClause(scanner.Text())
+		line = strings.ToLower(line)
+		if strings.HasPrefix(line, "index ") {
+			idx, err := parseCatalogIndex(line)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			indexes = append(indexes, idx)
+			continue
+		}
+		if strings.HasPrefix(line, "page size ") {
+			pageSize, err = strconv.Atoi(strings.TrimSpace(line[len("page size "):]))
+			if err == nil {
+				err = checkPageSize(pageSize)
+			}
+			if err != nil {
+				return nil, nil, 0, GoDBError{ParseError, fmt.Sprintf("bad page size in catalog (line %s)", line)}
+			}
+			continue
+		}
+		compressed := ""
+		if i := strings.LastIndex(line, " compression "); i > strings.LastIndex(line, ")") {
+			compressed = strings.TrimSpace(line[i+len(" compression "):])
+			line = line[:i]
+		}
+		columnar := strings.HasSuffix(line, " using columnar")
+		line = strings.TrimSuffix(line, " using columnar")
+		line, clusterKey, err := parseCatalogClusterKey(line)
+		if err != nil {
+			return nil, nil, 0, err
+		}
 		sep := strings.Split(line, "(")
 		if len(sep) != 2 {
-			return nil, nil, GoDBError{ParseError, fmt.Sprintf("expected one paren in catalog entry, got %d (%s)", len(sep), line)}
+			return nil, nil, 0, GoDBError{ParseError, fmt.Sprintf("expected one paren in catalog entry, got %d (%s)", len(sep), line)}
 		}
 		tableName := strings.TrimSpace(sep[0])
 		rest := strings.Trim(sep[1], "()")
@@ -93,7 +195,7 @@ func parseCatalogFile(catalogFile string, rootPath string) ([]TupleDesc, []strin
 			f := strings.TrimSpace(f)
 			nameType := strings.Split(f, " ")
 			if len(nameType) != 2 {
-				return nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s (line %s)", nameType, line)}
+				return nil, nil, 0, GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s (line %s)", nameType, line)}
 			}
 			switch nameType[1] {
 			case "int":
@@ -107,34 +209,120 @@ func parseCatalogFile(catalogFile string, rootPath string) ([]TupleDesc, []strin
 			case "text":
 				fieldArray = append(fieldArray, FieldType{nameType[0], "", StringType})
 			default:
-				return nil, nil, GoDBError{ParseError, fmt.Sprintf("unknown type %s (line %s)", nameType[1], line)}
+				return nil, nil, 0, GoDBError{ParseError, fmt.Sprintf("unknown type %s (line %s)", nameType[1], line)}
 			}
 		}
-		tables = append(tables, TupleDesc{fieldArray})
-		names = append(names, tableName)
+		var partitions *partitioning
+		if partitionClause != "" {
+			partitions, err = parsePartitioning(partitionClause, &TupleDesc{fieldArray})
+			if err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		tables = append(tables, &Table{tableName, TupleDesc{fieldArray}, clusterKey, columnar, compressed, partitions, false})
 	}
-	return tables, names, nil
+	return tables, indexes, pageSize, nil
 
 }
 
 func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*Catalog, error) {
-	tabs, names, err := parseCatalogFile(catalogFile, rootPath)
+	return openCatalog(catalogFile, bp, rootPath, nil)
+}
+
+// Create a new, empty catalog file whose heap tables have pages of pageSize
+// bytes, a power of two between MinPageSize and MaxPageSize, and open it.
+// The page size is recorded at the top of the catalog file, so it can't
+// change once the catalog has tables;  catalog files without it use
+// PageSize.  Larger pages suit tables with wide rows, which otherwise only
+// fit a few to a page.
+func CreateCatalog(catalogFile string, bp *BufferPool, rootPath string, pageSize int) (*Catalog, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	f, err := bp.vfs.OpenFile(rootPath+"/"+catalogFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c := &Catalog{make([]*Table, 0), make(map[string]*Table), make(map[string][]*Table), bp, rootPath, nil, nil, pageSize, "", make(map[string]DBFile)}
+	_, err = f.Write([]byte(c.CatalogString()))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Open a catalog whose tables and indexes are encrypted at rest with key, an
+// AES key of 16, 24 or 32 bytes (see encryption.go).  Every page the catalog
+// writes is encrypted, so its files can only be read again with the same key.
+func NewEncryptedCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string, key []byte) (*Catalog, error) {
+	cipher, err := newPageCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	c := &Catalog{make([]*Table, 0), make(map[string]*Table), make(map[string][]*Table), bp, rootPath}
-	for i, t := range tabs {
-		c.addTable(names[i], t)
+	return openCatalog(catalogFile, bp, rootPath, cipher)
+}
+
+func openCatalog(catalogFile string, bp *BufferPool, rootPath string, cipher *pageCipher) (*Catalog, error) {
+	tabs, indexes, pageSize, err := parseCatalogFile(bp.vfs, catalogFile, rootPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{make([]*Table, 0), make(map[string]*Table), make(map[string][]*Table), bp, rootPath, nil, cipher, pageSize, "", make(map[string]DBFile)}
+	for _, t := range tabs {
+		err := c.addTable(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, idx := range indexes {
+		if c.tableMap[idx.table] == nil {
+			return nil, GoDBError{NoSuchTableError, fmt.Sprintf("index %s is on unknown table %s", idx.name, idx.table)}
+		}
+		c.indexes = append(c.indexes, idx)
 	}
 
 	return c, nil
 
 }
 
-func (c *Catalog) addTable(named string, desc TupleDesc) error {
+// Add a table to the catalog.  If t has a clusterKey, the table is stored in
+// a [ClusteredFile] ordered by that column.
+func (c *Catalog) addTable(t *Table) error {
+	named, desc := t.name, t.desc
+	if t.compressed != "" {
+		if t.clusterKey != "" || t.columnar {
+			return GoDBError{ParseError, fmt.Sprintf("only heap tables can be compressed, not %s", named)}
+		}
+		_, err := findPageCodec(t.compressed)
+		if err != nil {
+			return err
+		}
+	}
+	if t.clusterKey != "" {
+		if t.columnar {
+			return GoDBError{ParseError, fmt.Sprintf("columnar table %s can't have a primary key", named)}
+		}
+		if t.partitioning != nil {
+			return GoDBError{ParseError, fmt.Sprintf("partitioned table %s can't have a primary key", named)}
+		}
+		_, err := findFieldInTd(FieldType{t.clusterKey, "", UnknownType}, &desc)
+		if err != nil {
+			return GoDBError{ParseError, fmt.Sprintf("primary key %s of table %s is not one of its columns", t.clusterKey, named)}
+		}
+	}
+	if t.columnar && t.partitioning != nil {
+		return GoDBError{ParseError, fmt.Sprintf("columnar table %s can't be partitioned", named)}
+	}
 	_, err := c.GetTable(named)
 	if err != nil {
-		t := &Table{named, desc}
+		if t.temporary {
+			err = c.makeTempDir()
+			if err != nil {
+				return err
+			}
+		}
 		c.tables = append(c.tables, t)
 		c.tableMap[named] = t
 		for _, f := range desc.Fields {
@@ -152,16 +340,103 @@ func (c *Catalog) addTable(named string, desc TupleDesc) error {
 }
 
 func (c *Catalog) tableNameToFile(tableName string) string {
-	return c.rootPath + "/" + tableName + ".dat"
+	return c.tableDir(tableName) + "/" + tableName + ".dat"
 
 }
+
+// Return the file of the named table:  a ClusteredFile if the table has a
+// primary key, a ColumnarFile if it was created USING COLUMNAR, a
+// PartitionedFile if it was created with a PARTITION BY clause, and otherwise
+// a heap file, compressed if it was created with a COMPRESSION option, along
+// with its indexes.  The file is opened the first time it is asked for, and
+// the same file is returned until the table is dropped or its files are
+// replaced, or the catalog is closed.
 func (c *Catalog) GetTable(named string) (DBFile, error) {
+	if file := c.files[named]; file != nil {
+		return file, nil
+	}
+	file, err := c.openTable(named)
+	if err != nil {
+		return nil, err
+	}
+	c.files[named] = file
+	return file, nil
+}
+
+// Open the file of the named table, see GetTable
+func (c *Catalog) openTable(named string) (DBFile, error) {
 	t := c.tableMap[named]
 	if t == nil {
 		return nil, GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", named)}
 	}
-	return NewHeapFile(c.tableNameToFile(named), t.desc.copy(), c.bp)
+	if t.clusterKey != "" {
+		keyIndex, err := findFieldInTd(FieldType{t.clusterKey, "", UnknownType}, &t.desc)
+		if err != nil {
+			return nil, err
+		}
+		return openClusteredFile(c.tableNameToFile(named), t.desc.copy(), keyIndex, c.cipher, c.bp)
+	}
+	if t.columnar {
+		return openColumnarFile(c.tableNameToFile(named), t.desc.copy(), c.cipher, c.bp)
+	}
+	if t.partitioning != nil {
+		return c.openPartitionedFile(t)
+	}
+	hf, err := openHeapFile(c.tableNameToFile(named), t.desc.copy(), c.pageSize, t.compressed, c.cipher, c.bp)
+	if err != nil {
+		return nil, err
+	}
+	hf.temporary = t.temporary
+	for _, idx := range c.tableIndexes(named) {
+		index, err := c.openIndex(idx, hf.desc)
+		if err != nil {
+			closeFiles(c.bp, hf)
+			return nil, err
+		}
+		hf.indexes = append(hf.indexes, index)
+	}
+	return hf, nil
+
+}
 
+// A file that the catalog opens, and closes once it is no longer used
+type closableFile interface {
+	DBFile
+	NumPages() int
+	close() error
+}
+
+// Close the file GetTable opened for the named table, if any, so that the
+// next GetTable opens it again.  This must be done before the files of the
+// table are removed or replaced.
+func (c *Catalog) closeTable(named string) {
+	if file := c.files[named]; file != nil {
+		delete(c.files, named)
+		closeFiles(c.bp, file)
+	}
+}
+
+// Close files, along with the partitions and indexes of the heap files among
+// them.  Their pages are dropped from the buffer pool first, since it writes
+// dirty pages back through the file that read them.
+func closeFiles(bp *BufferPool, files ...DBFile) {
+	for _, file := range files {
+		switch file := file.(type) {
+		case *PartitionedFile:
+			for _, hf := range file.partitions {
+				closeFiles(bp, hf)
+			}
+			continue
+		case *HeapFile:
+			for _, index := range file.indexes {
+				closeFiles(bp, index)
+			}
+		}
+		if f, ok := file.(closableFile); ok {
+			bp.discardFilePages(f, 0, f.NumPages())
+			f.close()
+		}
+	}
 }
 
 func (c *Catalog) findTablesWithColumn(named string) []*Table {
@@ -179,9 +454,24 @@ func (c *Catalog) GetTableIdx(t int) (DBFile, error) {
 	return c.GetTable(tab.name)
 }
 
+// Describe the tables and indexes of the catalog, in the format of catalog
+// files, with temporary tables marked as such
 func (c *Catalog) CatalogString() string {
+	return c.catalogString(true)
+}
+
+func (c *Catalog) catalogString(temporary bool) string {
 	outStr := ""
+	if c.pageSize != PageSize {
+		outStr = fmt.Sprintf("page size %d\n", c.pageSize)
+	}
 	for _, t := range c.tables {
+		if t.temporary {
+			if !temporary {
+				continue
+			}
+			outStr = outStr + "temporary "
+		}
 		fieldStr := "("
 		for i, f := range t.desc.Fields {
 			if i != 0 {
@@ -189,7 +479,30 @@ func (c *Catalog) CatalogString() string {
 			}
 			fieldStr = fieldStr + f.Fname + " " + typeNames[f.Ftype]
 		}
-		outStr = outStr + t.name + " " + fieldStr + ")\n"
+		outStr = outStr + t.name + " " + fieldStr + ")"
+		if t.clusterKey != "" {
+			outStr = outStr + " primary key (" + t.clusterKey + ")"
+		}
+		if t.columnar {
+			outStr = outStr + " using columnar"
+		}
+		if t.compressed != "" {
+			outStr = outStr + " compression " + t.compressed
+		}
+		if t.partitioning != nil {
+			outStr = outStr + " " + t.partitioning.String()
+		}
+		outStr = outStr + "\n"
+	}
+	for _, idx := range c.indexes {
+		if t := c.tableMap[idx.table]; !temporary && t != nil && t.temporary {
+			continue
+		}
+		outStr = outStr + "index " + idx.name + " on " + idx.table + " (" + idx.column + ")"
+		if idx.kind != BTreeIndex {
+			outStr = outStr + " using " + indexKindNames[idx.kind]
+		}
+		outStr = outStr + "\n"
 	}
 	return outStr
 }
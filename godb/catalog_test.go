@@ -0,0 +1,94 @@
+package godb
+
+import (
+	"strings"
+	"testing"
+)
+
+// Whether the data file of a heap file opened in a MemFS has been closed
+func heapFileClosed(hf *HeapFile) bool {
+	return hf.file.(*memHandle).closed
+}
+
+func TestCatalogCachesFiles(t *testing.T) {
+	vfs := NewMemFS()
+	c := makePartitionCatalog(t, vfs,
+		"create table people (name text, age int)",
+		"create index people_age on people (age)")
+	runQuery(t, c, "insert into people values ('sam', 25), ('kathy', 45), ('bill', 30)")
+	file, err := c.GetTable("people")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hf := file.(*HeapFile)
+	if again, _ := c.GetTable("people"); again != file {
+		t.Errorf("expected GetTable to return the same file every time")
+	}
+
+	// VACUUM FULL replaces the files of the table underneath the cached file
+	runQuery(t, c, "delete from people where name = 'sam'")
+	if _, err := processVacuum(c, "vacuum full people"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if again, _ := c.GetTable("people"); again != file {
+		t.Errorf("expected VACUUM FULL to keep the cached file")
+	}
+	if results, _ := runQuery(t, c, "select name from people where age = 45"); strings.Join(results, ",") != "[{kathy}]" {
+		t.Errorf("expected kathy after VACUUM FULL, got %v", results)
+	}
+
+	// dropping an index opens the table again without it, and creating one
+	// adds it to the cached file
+	index := hf.indexes[0].(*BTreeFile)
+	if err := c.DropIndex("people_age", "people"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !heapFileClosed(hf) || !index.file.(*memHandle).closed {
+		t.Errorf("expected the files of the table and its index to be closed when the index is dropped")
+	}
+	file, _ = c.GetTable("people")
+	hf = file.(*HeapFile)
+	if len(hf.indexes) != 0 {
+		t.Errorf("expected the table to be opened again without the index")
+	}
+	if err := c.CreateIndex("people_name", "people", "name", HashIndex); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if again, _ := c.GetTable("people"); again != file || len(hf.indexes) != 1 {
+		t.Errorf("expected the new index to be added to the cached file")
+	}
+	runQuery(t, c, "insert into people values ('ang', 22)")
+	if results, _ := runQuery(t, c, "select age from people where name = 'ang'"); strings.Join(results, ",") != "[{22}]" {
+		t.Errorf("expected the new index to find ang, got %v", results)
+	}
+
+	// closing the catalog closes its files, which are opened again as needed
+	err = c.Close()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !heapFileClosed(hf) {
+		t.Errorf("expected the file of people to be closed with the catalog")
+	}
+	if results, _ := runQuery(t, c, "select name from people"); len(results) != 3 {
+		t.Errorf("expected 3 people after closing the catalog, got %v", results)
+	}
+	file, _ = c.GetTable("people")
+	if file == DBFile(hf) {
+		t.Errorf("expected the table to be opened again after closing the catalog")
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	if err := c.Close(); err == nil || err.(GoDBError).code != IllegalOperationError {
+		t.Errorf("expected closing the catalog while a transaction is open to be rejected, got %v", err)
+	}
+	c.bp.CommitTransaction(tid)
+
+	hf = file.(*HeapFile)
+	if _, _, err := Parse(c, "drop table people"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !heapFileClosed(hf) || len(c.files) != 0 {
+		t.Errorf("expected the file of a dropped table to be closed")
+	}
+}
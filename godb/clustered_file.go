@@ -0,0 +1,425 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// ClusteredFile stores the tuples of a table in the leaves of a B+tree,
+// ordered by a unique clustering key, rather than in a heap.  Scans return
+// tuples in key order, and a range of keys can be scanned without reading
+// the rest of the table;  see [clusteredPage] for the layout of the tree.
+//
+// Tables are stored as ClusteredFiles when they are created with a PRIMARY
+// KEY, which becomes the clustering key.  Pages are read and locked through
+// the BufferPool as for a [BTreeFile]:  changes write lock the meta page and
+// the path from the root to the leaf they touch, and scans take read locks.
+//
+// Tuples move between pages as leaves split, so they have no stable record
+// id;  deleteTuple finds a tuple by its key instead.
+type ClusteredFile struct {
+	bufPool *BufferPool
+	sync.Mutex
+	fileName string
+	file     File
+	cipher   *pageCipher // encrypts the pages of the file, or nil
+	desc     *TupleDesc
+	keyIndex int // position of the clustering key in desc
+}
+
+// Open a ClusteredFile, creating an empty tree if the file doesn't exist yet.
+// Parameters
+// - fromFile: backing file for the table
+// - td: the TupleDesc of the table
+// - keyIndex: the position of the clustering key in td
+// - bp: the BufferPool that is used to store pages read from the file
+func NewClusteredFile(fromFile string, td *TupleDesc, keyIndex int, bp *BufferPool) (*ClusteredFile, error) {
+	return openClusteredFile(fromFile, td, keyIndex, nil, bp)
+}
+
+// Open a ClusteredFile whose pages are encrypted with cipher, unless it is nil
+func openClusteredFile(fromFile string, td *TupleDesc, keyIndex int, cipher *pageCipher, bp *BufferPool) (*ClusteredFile, error) {
+	if keyIndex < 0 || keyIndex >= len(td.Fields) {
+		return nil, GoDBError{IllegalIdxError, fmt.Sprintf("clustering key %d out of range", keyIndex)}
+	}
+	file, err := bp.vfs.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	f := &ClusteredFile{
+		bufPool:  bp,
+		fileName: fromFile,
+		file:     file,
+		cipher:   cipher,
+		desc:     td,
+		keyIndex: keyIndex,
+	}
+	if f.NumPages() == 0 {
+		err = f.initEmptyTree()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Write a meta page and an empty root leaf to the start of the file
+func (f *ClusteredFile) initEmptyTree() error {
+	meta := newClusteredPage(f, 0, btreeMetaPage)
+	meta.link = 1
+	for _, p := range []*clusteredPage{meta, newClusteredPage(f, 1, btreeLeafPage)} {
+		page := Page(p)
+		err := f.flushPage(&page)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the number of pages in the file
+func (f *ClusteredFile) NumPages() int {
+	return filePageCount(f.file, f.cipher, PageSize)
+}
+
+// Position of the clustering key in the tuples of the file
+func (f *ClusteredFile) keyColumn() int {
+	return f.keyIndex
+}
+
+func (f *ClusteredFile) keyType() DBType {
+	return f.desc.Fields[f.keyIndex].Ftype
+}
+
+func (f *ClusteredFile) readPage(pageNo int) (*Page, error) {
+	buf, err := readFilePage(f.file, f.cipher, PageSize, pageNo)
+	if e, ok := err.(GoDBError); ok {
+		return nil, f.corruptPageError(pageNo, e.errString)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !verifyPageChecksum(buf) {
+		return nil, f.corruptPageError(pageNo, "checksum mismatch")
+	}
+	page := newClusteredPage(f, pageNo, 0)
+	err = page.initFromBuffer(bytes.NewBuffer(buf), f.desc, f.keyIndex)
+	if err != nil {
+		return nil, f.corruptPageError(pageNo, err.Error())
+	}
+	observePageLSN(page.lsn)
+	p := Page(page)
+	return &p, nil
+}
+
+func (f *ClusteredFile) corruptPageError(pageNo int, reason string) error {
+	return GoDBError{CorruptPageError, fmt.Sprintf("page %d of clustered file %s is corrupt: %s", pageNo, f.fileName, reason)}
+}
+
+func (f *ClusteredFile) flushPage(p *Page) error {
+	page, ok := (*p).(*clusteredPage)
+	if !ok {
+		return GoDBError{TypeMismatchError, "cannot cast to clusteredPage"}
+	}
+	page.lsn = nextPageLSN()
+	buffer, err := page.toBuffer()
+	if err != nil {
+		return err
+	}
+	return writeFilePage(f.file, f.cipher, page.pageNo, buffer.Bytes())
+}
+
+func (f *ClusteredFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.fileName, PageNo: pgNo}
+}
+
+// Make every page flushed so far durable
+func (f *ClusteredFile) sync() error {
+	return f.file.Sync()
+}
+
+// Close the file.  It must not be used again.
+func (f *ClusteredFile) close() error {
+	return f.file.Close()
+}
+
+// [Operator] descriptor method -- return the TupleDesc of the table
+func (f *ClusteredFile) Descriptor() *TupleDesc {
+	return f.desc
+}
+
+func (f *ClusteredFile) getPage(pageNo int, tid TransactionID, perm RWPerm) (*clusteredPage, error) {
+	page, err := f.bufPool.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	return (*page).(*clusteredPage), nil
+}
+
+// Append a new, empty page of the given kind to the file and write lock it
+func (f *ClusteredFile) allocPage(kind btreePageKind, tid TransactionID) (*clusteredPage, error) {
+	f.Lock()
+	pageNo := f.NumPages()
+	page := Page(newClusteredPage(f, pageNo, kind))
+	err := f.flushPage(&page)
+	f.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return f.getPage(pageNo, tid, WritePerm)
+}
+
+// Fetch the meta page and the pages on the path from the root to the leaf
+// that key belongs in, or to the leftmost leaf if key is nil.
+func (f *ClusteredFile) descend(key DBValue, tid TransactionID, perm RWPerm) (*clusteredPage, []*clusteredPage, error) {
+	meta, err := f.getPage(0, tid, perm)
+	if err != nil {
+		return nil, nil, err
+	}
+	var path []*clusteredPage
+	pageNo := meta.link
+	for {
+		p, err := f.getPage(pageNo, tid, perm)
+		if err != nil {
+			return nil, nil, err
+		}
+		path = append(path, p)
+		if p.kind == btreeLeafPage {
+			return meta, path, nil
+		}
+		if p.kind != btreeInternalPage {
+			return nil, nil, f.corruptPageError(pageNo, "expected a b+tree node")
+		}
+		if key == nil {
+			pageNo = p.children[0]
+		} else {
+			pageNo = p.children[p.childFor(key)]
+		}
+	}
+}
+
+// Return the clustering key of t, checking that t fits the file
+func (f *ClusteredFile) keyOf(t *Tuple) (DBValue, error) {
+	if len(t.Fields) != len(f.desc.Fields) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("tuple has %d fields, table %s has %d", len(t.Fields), f.fileName, len(f.desc.Fields))}
+	}
+	key := t.Fields[f.keyIndex]
+	if !valueHasType(key, f.keyType()) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("clustering key of %s is a %s", f.fileName, typeNames[f.keyType()])}
+	}
+	return key, nil
+}
+
+// Add t to the file, in key order.  Returns an IllegalOperationError if the
+// file already has a tuple with the same key.
+func (f *ClusteredFile) insertTuple(t *Tuple, tid TransactionID) error {
+	key, err := f.keyOf(t)
+	if err != nil {
+		return err
+	}
+	meta, path, err := f.descend(key, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	leaf := path[len(path)-1]
+	i := leaf.searchTuples(key, f.keyIndex, false)
+	if i < len(leaf.tuples) && compareKeys(leaf.tuples[i].Fields[f.keyIndex], key) == 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("table %s already has a tuple with key %v", f.fileName, key)}
+	}
+	stored := &Tuple{*f.desc, slices.Clone(t.Fields), nil}
+	leaf.tuples = slices.Insert(leaf.tuples, i, stored)
+	leaf.setDirty(true)
+	return f.splitFull(meta, path, tid)
+}
+
+// Split the last page of path if it has more tuples or keys than fit on a
+// page, then its parent if that overflows in turn, and so on up to the root.
+func (f *ClusteredFile) splitFull(meta *clusteredPage, path []*clusteredPage, tid TransactionID) error {
+	for level := len(path) - 1; level >= 0; level-- {
+		p := path[level]
+		n := len(p.tuples)
+		if p.kind == btreeInternalPage {
+			n = len(p.keys)
+		}
+		if n <= clusteredCapacity(p.kind, f.desc, f.keyIndex) {
+			return nil
+		}
+		right, err := f.allocPage(p.kind, tid)
+		if err != nil {
+			return err
+		}
+		mid := n / 2
+		var sep DBValue
+		if p.kind == btreeLeafPage {
+			right.tuples = slices.Clone(p.tuples[mid:])
+			p.tuples = p.tuples[:mid]
+			right.link = p.link
+			p.link = right.pageNo
+			sep = right.tuples[0].Fields[f.keyIndex]
+		} else {
+			sep = p.keys[mid]
+			right.keys = slices.Clone(p.keys[mid+1:])
+			right.children = slices.Clone(p.children[mid+1:])
+			p.keys = p.keys[:mid]
+			p.children = p.children[:mid+1]
+		}
+		p.setDirty(true)
+		right.setDirty(true)
+
+		if level == 0 {
+			root, err := f.allocPage(btreeInternalPage, tid)
+			if err != nil {
+				return err
+			}
+			root.keys = []DBValue{sep}
+			root.children = []int{p.pageNo, right.pageNo}
+			root.setDirty(true)
+			meta.link = root.pageNo
+			meta.setDirty(true)
+			return nil
+		}
+		parent := path[level-1]
+		i := parent.childFor(sep)
+		parent.keys = slices.Insert(parent.keys, i, sep)
+		parent.children = slices.Insert(parent.children, i+1, right.pageNo)
+		parent.setDirty(true)
+	}
+	return nil
+}
+
+// Remove the tuple with the key of t from the file.  The stored tuple must
+// match t.
+func (f *ClusteredFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	key, err := f.keyOf(t)
+	if err != nil {
+		return err
+	}
+	_, path, err := f.descend(key, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	leaf := path[len(path)-1]
+	i := leaf.searchTuples(key, f.keyIndex, false)
+	if i == len(leaf.tuples) || !sameFields(leaf.tuples[i], t) {
+		return GoDBError{TupleNotFoundError, fmt.Sprintf("table %s has no tuple %v", f.fileName, t.Fields)}
+	}
+	leaf.tuples = slices.Delete(leaf.tuples, i, i+1)
+	leaf.setDirty(true)
+	return nil
+}
+
+func sameFields(t1 *Tuple, t2 *Tuple) bool {
+	for i, v := range t1.Fields {
+		if v != t2.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Return an iterator over the tuples with keys in r, in key order.  The
+// iterator remembers the last key it returned rather than a position in a
+// leaf, so that it can be used to delete the tuples it returns.
+func (f *ClusteredFile) scanRange(r keyRange, tid TransactionID) (func() (*Tuple, error), error) {
+	for _, bound := range []DBValue{r.lo, r.hi} {
+		if bound != nil && !valueHasType(bound, f.keyType()) {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("clustering key of %s is a %s", f.fileName, typeNames[f.keyType()])}
+		}
+	}
+	_, path, err := f.descend(r.lo, tid, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	leaf := path[len(path)-1]
+	last, after := r.lo, !r.loIncl
+	done := false
+	return func() (*Tuple, error) {
+		for !done {
+			pos := 0
+			if last != nil {
+				pos = leaf.searchTuples(last, f.keyIndex, after)
+			}
+			if pos == len(leaf.tuples) {
+				if leaf.link == btreeNoPage {
+					done = true
+					break
+				}
+				leaf, err = f.getPage(leaf.link, tid, ReadPerm)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			t := leaf.tuples[pos]
+			key := t.Fields[f.keyIndex]
+			if r.hi != nil {
+				c := compareKeys(key, r.hi)
+				if c > 0 || (c == 0 && !r.hiIncl) {
+					done = true
+					break
+				}
+			}
+			last, after = key, true
+			return &Tuple{*f.desc, t.Fields, key}, nil
+		}
+		return nil, nil
+	}, nil
+}
+
+// [Operator] iterator method -- return every tuple of the file in key order.
+// The Rid of each tuple is its key.
+func (f *ClusteredFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return f.scanRange(keyRange{}, tid)
+}
+
+// ClusteredScan returns the tuples of a ClusteredFile whose keys fall in a
+// range, in key order.  The planner uses it in place of a Filter over a scan
+// of the whole file (see [planIndexScan]).
+type ClusteredScan struct {
+	file *ClusteredFile
+	keys keyRange
+}
+
+func NewClusteredScan(file *ClusteredFile, keys keyRange) *ClusteredScan {
+	return &ClusteredScan{file, keys}
+}
+
+func (s *ClusteredScan) Descriptor() *TupleDesc {
+	return s.file.Descriptor()
+}
+
+func (s *ClusteredScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return s.file.scanRange(s.keys, tid)
+}
+
+// Whether op returns its tuples in ascending order of field, because they
+// come from a ClusteredFile clustered on field through operators that keep
+// their order.  The planner leaves out an ORDER BY that op already satisfies.
+func keyOrdered(op Operator, field FieldType) bool {
+	switch op := op.(type) {
+	case *ClusteredFile:
+		key := op.desc.Fields[op.keyIndex]
+		return key.Fname == field.Fname && (field.TableQualifier == "" || field.TableQualifier == key.TableQualifier)
+	case *ClusteredScan:
+		return keyOrdered(op.file, field)
+	case *Filter[int64]:
+		return keyOrdered(op.child, field)
+	case *Filter[string]:
+		return keyOrdered(op.child, field)
+	case *LimitOp:
+		return keyOrdered(op.child, field)
+	case *Project:
+		i, err := findFieldInTd(field, op.Descriptor())
+		if err != nil {
+			return false
+		}
+		selected, ok := op.selectFields[i].(*FieldExpr)
+		return ok && keyOrdered(op.child, selected.selectField)
+	}
+	return false
+}
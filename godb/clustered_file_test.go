@@ -0,0 +1,286 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+const TestingClusteredFile string = "test_clustered.dat"
+
+// A clustered file with the TupleDesc from makeTestVars, clustered on age
+func makeClusteredTestVars(t *testing.T, bp *BufferPool) *ClusteredFile {
+	td, _, _, _, _, _ := makeTestVars()
+	os.Remove(TestingClusteredFile)
+	cf, err := NewClusteredFile(TestingClusteredFile, &td, 1, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return cf
+}
+
+// Return the ages of the tuples returned by iter, in order
+func clusteredAges(t *testing.T, iter func() (*Tuple, error)) []int {
+	var ages []int
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			return ages
+		}
+		ages = append(ages, int(tup.Fields[1].(IntField).Value))
+	}
+}
+
+func TestClusteredFileInsertAndScan(t *testing.T) {
+	bp := NewBufferPool(100)
+	cf := makeClusteredTestVars(t, bp)
+	const n = 2000
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < n; i++ {
+		age := (i * 7919) % n
+		tup := Tuple{*cf.Descriptor(), []DBValue{StringField{fmt.Sprintf("n%d", age)}, IntField{int64(age)}}, nil}
+		err := cf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	dup := Tuple{*cf.Descriptor(), []DBValue{StringField{"sam"}, IntField{5}}, nil}
+	if err := cf.insertTuple(&dup, tid); err == nil {
+		t.Errorf("expected inserting a duplicate key to fail")
+	}
+	bp.CommitTransaction(tid)
+	if cf.NumPages() < 20 {
+		t.Fatalf("expected the tree to have split into many pages, has %d", cf.NumPages())
+	}
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ := cf.Iterator(tid)
+	ages := clusteredAges(t, iter)
+	if len(ages) != n {
+		t.Fatalf("expected %d tuples, got %d", n, len(ages))
+	}
+	for i, age := range ages {
+		if age != i {
+			t.Fatalf("expected tuples in key order, got age %d at position %d", age, i)
+		}
+	}
+	iter, _ = cf.scanRange(keyRange{IntField{100}, IntField{200}, false, true}, tid)
+	if ages := clusteredAges(t, iter); len(ages) != 100 || ages[0] != 101 || ages[99] != 200 {
+		t.Errorf("expected ages 101 to 200, got %d ages", len(ages))
+	}
+	if _, err := cf.scanRange(pointRange(StringField{"sam"}), tid); err == nil {
+		t.Errorf("expected a scan with a string key to fail")
+	}
+	bp.CommitTransaction(tid)
+
+	// delete the tuples with odd ages as they are scanned
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	del := NewDeleteOp(cf, &oddAges{cf})
+	iter, _ = del.Iterator(tid)
+	cnt, err := iter()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cnt.Fields[0].(IntField).Value != n/2 {
+		t.Errorf("expected %d tuples to be deleted, got %v", n/2, cnt.Fields[0])
+	}
+	missing := Tuple{*cf.Descriptor(), []DBValue{StringField{"n3"}, IntField{3}}, nil}
+	if err := cf.deleteTuple(&missing, tid); err == nil {
+		t.Errorf("expected deleting a missing tuple to fail")
+	}
+	bp.CommitTransaction(tid)
+
+	// the remaining tuples are found again when the file is reopened
+	td, _, _, _, _, _ := makeTestVars()
+	bp2 := NewBufferPool(100)
+	cf2, err := NewClusteredFile(TestingClusteredFile, &td, 1, bp2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid = NewTID()
+	bp2.BeginTransaction(tid)
+	iter, _ = cf2.Iterator(tid)
+	ages = clusteredAges(t, iter)
+	bp2.CommitTransaction(tid)
+	if len(ages) != n/2 {
+		t.Fatalf("expected %d tuples after deleting, got %d", n/2, len(ages))
+	}
+	for i, age := range ages {
+		if age != 2*i {
+			t.Fatalf("expected age %d at position %d, got %d", 2*i, i, age)
+		}
+	}
+}
+
+// Scans the tuples of a clustered file with odd ages
+type oddAges struct {
+	cf *ClusteredFile
+}
+
+func (f *oddAges) Descriptor() *TupleDesc {
+	return f.cf.Descriptor()
+}
+
+func (f *oddAges) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := f.cf.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		for {
+			tup, err := iter()
+			if tup == nil || err != nil {
+				return tup, err
+			}
+			if tup.Fields[1].(IntField).Value%2 == 1 {
+				return tup, nil
+			}
+		}
+	}, nil
+}
+
+func TestParseCreateClusteredTable(t *testing.T) {
+	bp := NewBufferPool(50)
+	err := MakeTestDatabaseEasy(bp)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf("failed load catalog, %s", err.Error())
+	}
+	os.Remove(c.tableNameToFile("people"))
+	defer os.Remove(c.tableNameToFile("people"))
+
+	if _, _, err := Parse(c, "create table bad (a int, b int, primary key (c))"); err == nil {
+		t.Errorf("expected a primary key on an unknown column to fail")
+	}
+	if _, _, err := Parse(c, "create table bad (a int, b int, primary key (a, b))"); err == nil {
+		t.Errorf("expected a primary key on two columns to fail")
+	}
+	qType, _, err := Parse(c, "create table people (name text, age int primary key)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if qType != CreateTableQueryType {
+		t.Errorf("expected CreateTableQueryType")
+	}
+	if !strings.Contains(c.CatalogString(), "people (name string, age int) primary key (age)") {
+		t.Errorf("expected clustered table in catalog, got %s", c.CatalogString())
+	}
+	runQuery(t, c, "insert into people values ('sam', 25), ('kathy', 45), ('bill', 30), ('ang', 22), ('joe', 40), "+
+		"('mark', 50), ('sarah', 60), ('riza', 43), ('bo', 99), ('pat', 38)")
+
+	err = c.SaveToFile("clustered_catalog.txt", "./")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.Remove("clustered_catalog.txt")
+	c2, err := NewCatalogFromFile("clustered_catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	file, err := c2.GetTable("people")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := file.(*ClusteredFile); !ok {
+		t.Fatalf("expected people to be a clustered file, got %T", file)
+	}
+
+	// ordering by the key needs no sort, and range filters on the key scan
+	// just the range
+	queries := []struct {
+		sql       string
+		orderBy   bool
+		rangeScan bool
+		expected  []string
+	}{
+		{"select name, age from people order by age", false, false, nil},
+		{"select age, name from people where age >= 40 and age < 60 order by age, name", false, true,
+			[]string{"[{40} {joe}]", "[{43} {riza}]", "[{45} {kathy}]", "[{50} {mark}]"}},
+		{"select name from people where age = 22", false, true, []string{"[{ang}]"}},
+		{"select name, age from people order by age desc", true, false, nil},
+		{"select name, age from people order by name", true, false, nil},
+	}
+	for _, q := range queries {
+		_, op, err := Parse(c2, q.sql)
+		if err != nil {
+			t.Fatalf("q=%s, %s", q.sql, err.Error())
+		}
+		var orderBy, rangeScan bool
+		for _, op := range planOperators(op) {
+			switch op.(type) {
+			case *OrderBy:
+				orderBy = true
+			case *ClusteredScan:
+				rangeScan = true
+			}
+		}
+		if orderBy != q.orderBy || rangeScan != q.rangeScan {
+			t.Errorf("q=%s: expected order by %v and range scan %v, got %v and %v", q.sql, q.orderBy, q.rangeScan, orderBy, rangeScan)
+		}
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		iter, err := op.Iterator(tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		var results []string
+		var ages []int64
+		for {
+			tup, err := iter()
+			if err != nil {
+				t.Fatalf("q=%s, %s", q.sql, err.Error())
+			}
+			if tup == nil {
+				break
+			}
+			results = append(results, fmt.Sprintf("%v", tup.Fields))
+			if age, ok := tup.Fields[0].(IntField); ok {
+				ages = append(ages, age.Value)
+			} else if len(tup.Fields) > 1 {
+				ages = append(ages, tup.Fields[1].(IntField).Value)
+			}
+		}
+		bp.CommitTransaction(tid)
+		if q.expected != nil && strings.Join(results, ",") != strings.Join(q.expected, ",") {
+			t.Errorf("q=%s: expected %v, got %v", q.sql, q.expected, results)
+		}
+		if !q.orderBy {
+			for i := 1; i < len(ages); i++ {
+				if ages[i-1] >= ages[i] {
+					t.Errorf("q=%s: results out of key order: %v", q.sql, results)
+					break
+				}
+			}
+		}
+	}
+
+	_, op, err := Parse(c2, "insert into people values ('sam', 25)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ := op.Iterator(tid)
+	if _, err := iter(); err == nil {
+		t.Errorf("expected inserting a duplicate key to fail")
+	}
+	bp.AbortTransaction(tid)
+
+	report, err := c2.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("expected no problems, got %s", report.String())
+	}
+}
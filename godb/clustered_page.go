@@ -0,0 +1,183 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+/* clusteredPage implements the Page interface for pages of a ClusteredFile.
+
+A clustered file is a B+tree laid out like a [btreePage] tree, except that its
+leaves hold the tuples of the table themselves, ordered by the clustering
+key, rather than entries pointing into a heap file.  Clustering keys are
+unique, so internal pages hold bare keys:  n separator keys and n+1
+children, where every tuple reachable from children[i+1] has a key >=
+keys[i], and every tuple reachable from children[i] has a key < keys[i].
+
+All pages are PageSize bytes and share the b+tree page header:
+
+	kind (int32)  number of tuples or keys (int32)  LSN (int64)
+	checksum (uint32)  next leaf / root page (int32)
+
+For leaves, the header is followed by the tuples (see [Tuple.writeTo]).
+Internal pages store the first child as an int32, and then each separator key
+followed by the child to its right.
+
+As in b+tree indexes, leaves emptied by deletes stay in the tree.
+*/
+
+type clusteredPage struct {
+	dirty    bool
+	file     *ClusteredFile
+	pageNo   int
+	lsn      int64
+	kind     btreePageKind
+	tuples   []*Tuple  // leaves only
+	keys     []DBValue // internal pages only
+	children []int     // internal pages only
+	link     int       // root for the meta page, next leaf for leaves
+}
+
+func newClusteredPage(f *ClusteredFile, pageNo int, kind btreePageKind) *clusteredPage {
+	return &clusteredPage{file: f, pageNo: pageNo, kind: kind, link: btreeNoPage}
+}
+
+// Number of tuples or separator keys that fit on a page of the given kind
+func clusteredCapacity(kind btreePageKind, desc *TupleDesc, keyIndex int) int {
+	if kind == btreeInternalPage {
+		keySize := calBytesPerTuple(&TupleDesc{Fields: []FieldType{desc.Fields[keyIndex]}})
+		return (PageSize - btreePageHeaderSize - 4) / (keySize + 4)
+	}
+	return (PageSize - btreePageHeaderSize) / calBytesPerTuple(desc)
+}
+
+// Position of the first tuple of a leaf whose key is >= key, or > key if
+// after is set
+func (p *clusteredPage) searchTuples(key DBValue, keyIndex int, after bool) int {
+	return sort.Search(len(p.tuples), func(i int) bool {
+		c := compareKeys(p.tuples[i].Fields[keyIndex], key)
+		return c > 0 || (c == 0 && !after)
+	})
+}
+
+// Index into children of the subtree of an internal page that key belongs in
+func (p *clusteredPage) childFor(key DBValue) int {
+	return sort.Search(len(p.keys), func(i int) bool {
+		return compareKeys(p.keys[i], key) > 0
+	})
+}
+
+func (p *clusteredPage) isDirty() bool {
+	return p.dirty
+}
+
+func (p *clusteredPage) setDirty(dirty bool) {
+	p.dirty = dirty
+}
+
+func (p *clusteredPage) getFile() *DBFile {
+	file := (DBFile)(p.file)
+	return &file
+}
+
+// Serialize the page to a PageSize buffer, filling in its checksum
+func (p *clusteredPage) toBuffer() (*bytes.Buffer, error) {
+	b := new(bytes.Buffer)
+	count := len(p.tuples)
+	if p.kind == btreeInternalPage {
+		count = len(p.keys)
+	}
+	for _, v := range []any{int32(p.kind), int32(count), p.lsn, uint32(0), int32(p.link)} {
+		err := binary.Write(b, binary.LittleEndian, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.kind == btreeInternalPage {
+		first := btreeNoPage
+		if len(p.children) > 0 {
+			first = p.children[0]
+		}
+		err := binary.Write(b, binary.LittleEndian, int32(first))
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range p.keys {
+			err := (&Tuple{Fields: []DBValue{key}}).writeTo(b)
+			if err != nil {
+				return nil, err
+			}
+			err = binary.Write(b, binary.LittleEndian, int32(p.children[i+1]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, t := range p.tuples {
+		err := t.writeTo(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b.Len() > PageSize {
+		return nil, GoDBError{PageFullError, "clustered tuples don't fit in page"}
+	}
+	b.Write(make([]byte, PageSize-b.Len()))
+	binary.LittleEndian.PutUint32(b.Bytes()[pageChecksumOffset:], pageChecksum(b.Bytes()))
+	return b, nil
+}
+
+// Read the contents of the page from a buffer written by toBuffer.  desc is
+// the TupleDesc of the table and keyIndex the position of its key.
+func (p *clusteredPage) initFromBuffer(buf *bytes.Buffer, desc *TupleDesc, keyIndex int) error {
+	var kind, count, link int32
+	var checksum uint32
+	for _, v := range []any{&kind, &count, &p.lsn, &checksum, &link} {
+		err := binary.Read(buf, binary.LittleEndian, v)
+		if err != nil {
+			return err
+		}
+	}
+	p.kind = btreePageKind(kind)
+	p.link = int(link)
+	if p.kind != btreeMetaPage && p.kind != btreeLeafPage && p.kind != btreeInternalPage {
+		return GoDBError{MalformedDataError, fmt.Sprintf("unknown clustered page kind %d", kind)}
+	}
+	if count < 0 || int(count) > clusteredCapacity(p.kind, desc, keyIndex) {
+		return GoDBError{MalformedDataError, fmt.Sprintf("bad clustered page header: %d entries", count)}
+	}
+	switch p.kind {
+	case btreeInternalPage:
+		keyDesc := &TupleDesc{Fields: []FieldType{desc.Fields[keyIndex]}}
+		var child int32
+		err := binary.Read(buf, binary.LittleEndian, &child)
+		if err != nil {
+			return err
+		}
+		p.children = []int{int(child)}
+		for i := 0; i < int(count); i++ {
+			t, err := readTupleFrom(buf, keyDesc)
+			if err != nil {
+				return err
+			}
+			err = binary.Read(buf, binary.LittleEndian, &child)
+			if err != nil {
+				return err
+			}
+			p.keys = append(p.keys, t.Fields[0])
+			p.children = append(p.children, int(child))
+		}
+	case btreeLeafPage:
+		p.tuples = make([]*Tuple, 0, count)
+		for i := 0; i < int(count); i++ {
+			t, err := readTupleFrom(buf, desc)
+			if err != nil {
+				return err
+			}
+			p.tuples = append(p.tuples, t)
+		}
+	}
+	return nil
+}
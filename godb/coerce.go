@@ -0,0 +1,111 @@
+package godb
+
+import "fmt"
+
+// Implicit type coercion rules used by the planner when the operands of a
+// filter, join, or function call do not have the same type.
+
+type coercionRule int
+
+const (
+	coerceNever coercionRule = iota
+	// the conversion is lossless and may be applied to any expression
+	coerceAlways coercionRule = iota
+	// the conversion can fail, so it is only applied to constants, which are
+	// converted (and checked) when the plan is built
+	coerceConst coercionRule = iota
+)
+
+// coercionMatrix[from][to] says whether a value of type from may be
+// implicitly converted to type to.
+var coercionMatrix = map[DBType]map[DBType]coercionRule{
+	IntType:    {IntType: coerceAlways, StringType: coerceAlways},
+	StringType: {StringType: coerceAlways, IntType: coerceConst},
+}
+
+func describeExpr(e Expr) string {
+	kind := "expression"
+	switch e.(type) {
+	case *ConstExpr:
+		kind = "constant"
+	case *FieldExpr:
+		kind = "field"
+	}
+	return fmt.Sprintf("%s %s %s", typeNames[e.GetExprType().Ftype], kind, exprToStr(e))
+}
+
+// Return an expression that produces the value of e as type to, or a
+// TypeMismatchError if the coercion matrix does not allow the conversion.
+// Constants are converted immediately rather than wrapped in a [CastExpr].
+func coerceExpr(e Expr, to DBType) (Expr, error) {
+	from := e.GetExprType().Ftype
+	if from == to {
+		return e, nil
+	}
+	rule := coercionMatrix[from][to]
+	if c, ok := e.(*ConstExpr); ok && rule != coerceNever {
+		val, err := castValue(c.val, to)
+		if err != nil {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot convert %s to %s", describeExpr(e), typeNames[to])}
+		}
+		return &ConstExpr{val, to}, nil
+	}
+	if rule != coerceAlways {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot convert %s to %s, use CAST", describeExpr(e), typeNames[to])}
+	}
+	return &CastExpr{e, to}, nil
+}
+
+// Coerce the two sides of a comparison to a common type.  If exactly one side
+// is a constant it is converted to the type of the other side; LIKE always
+// compares strings.
+func coerceComparison(left Expr, op BoolOp, right Expr) (Expr, Expr, error) {
+	lType := left.GetExprType().Ftype
+	rType := right.GetExprType().Ftype
+	if op == OpLike || op == OpMatch {
+		l, err := coerceExpr(left, StringType)
+		if err != nil {
+			return nil, nil, err
+		}
+		r, err := coerceExpr(right, StringType)
+		if err != nil {
+			return nil, nil, err
+		}
+		return l, r, nil
+	}
+	if lType == rType {
+		return left, right, nil
+	}
+	mismatch := GoDBError{TypeMismatchError, fmt.Sprintf("cannot compare %s with %s", describeExpr(left), describeExpr(right))}
+	_, lConst := left.(*ConstExpr)
+	_, rConst := right.(*ConstExpr)
+	if !lConst {
+		if r, err := coerceExpr(right, lType); err == nil {
+			return left, r, nil
+		} else if rConst {
+			return nil, nil, mismatch
+		}
+	}
+	if l, err := coerceExpr(left, rType); err == nil {
+		return l, right, nil
+	}
+	return nil, nil, mismatch
+}
+
+// Coerce the arguments of a call to the named function to the argument types
+// it declares.  Unknown functions and calls with the wrong number of
+// arguments are left alone; [FuncExpr.EvalExpr] reports those.
+func coerceFuncArgs(op string, args []*Expr) error {
+	fType, exists := funcs[op]
+	if !exists || len(args) != len(fType.argTypes) {
+		return nil
+	}
+	for i, argType := range fType.argTypes {
+		arg, err := coerceExpr(*args[i], argType)
+		if err != nil {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("function %s argument %d: %s", op, i+1, err.(GoDBError).errString)}
+		}
+		args[i] = &arg
+	}
+	return nil
+}
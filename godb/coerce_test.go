@@ -0,0 +1,134 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestCastExpr(t *testing.T) {
+	_, t1, _, _, _, _ := makeTestVars()
+	ageStr := &CastExpr{&FieldExpr{FieldType{"age", "", IntType}}, StringType}
+	if ageStr.GetExprType().Ftype != StringType {
+		t.Fatalf("expected cast to have string type")
+	}
+	v, err := ageStr.EvalExpr(&t1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v != (StringField{"25"}) {
+		t.Errorf("expected '25', got %v", v)
+	}
+
+	nameInt := &CastExpr{&FieldExpr{FieldType{"name", "", StringType}}, IntType}
+	_, err = nameInt.EvalExpr(&t1)
+	if err == nil {
+		t.Fatalf("expected error casting 'sam' to int")
+	}
+	if err.(GoDBError).code != TypeMismatchError {
+		t.Errorf("expected TypeMismatchError, got %v", err)
+	}
+
+	v, err = (&CastExpr{&ConstExpr{StringField{" 42 "}, StringType}, IntType}).EvalExpr(nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v != (IntField{42}) {
+		t.Errorf("expected 42, got %v", v)
+	}
+}
+
+func TestCoerceComparison(t *testing.T) {
+	age := &FieldExpr{FieldType{"age", "", IntType}}
+	name := &FieldExpr{FieldType{"name", "", StringType}}
+
+	// string constant compared to an int field is converted to an int
+	_, r, err := coerceComparison(age, OpEq, &ConstExpr{StringField{"30"}, StringType})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if c, ok := r.(*ConstExpr); !ok || c.val != (IntField{30}) {
+		t.Errorf("expected constant to be folded to int 30, got %v", exprToStr(r))
+	}
+
+	// non numeric constant can't be compared to an int field
+	_, _, err = coerceComparison(age, OpEq, &ConstExpr{StringField{"abc"}, StringType})
+	if err == nil || err.(GoDBError).code != TypeMismatchError {
+		t.Errorf("expected TypeMismatchError, got %v", err)
+	}
+
+	// int field and string field are compared as strings
+	l, r, err := coerceComparison(age, OpEq, name)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if l.GetExprType().Ftype != StringType || r.GetExprType().Ftype != StringType {
+		t.Errorf("expected both sides to be strings")
+	}
+
+	// like always compares strings
+	l, _, err = coerceComparison(age, OpLike, &ConstExpr{StringField{"2%"}, StringType})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := l.(*CastExpr); !ok {
+		t.Errorf("expected int field to be cast to string for LIKE")
+	}
+}
+
+func TestParseCastAndCoercion(t *testing.T) {
+	bp := NewBufferPool(10)
+	err := MakeTestDatabaseEasy(bp)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf("failed load catalog, %s", err.Error())
+	}
+
+	counts := map[string]int{
+		"select name from t where age = '25'":                          1,
+		"select name from t where cast(age as char) like '2%'":         3,
+		"select getsubstr(age, 0, 1) from t where name = 'sam'":        2,
+		"select name from t where age = cast('30' as signed)":          1,
+		"select t.name from t join t2 on cast(t.age as char) = t2.age": 16,
+	}
+	for sql, expected := range counts {
+		_, plan, err := Parse(c, sql)
+		if err != nil {
+			t.Fatalf("failed to parse, q=%s, %s", sql, err.Error())
+		}
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		iter, err := plan.Iterator(tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		cnt := 0
+		for {
+			tup, err := iter()
+			if err != nil {
+				t.Fatalf("q=%s, %s", sql, err.Error())
+			}
+			if tup == nil {
+				break
+			}
+			cnt++
+		}
+		bp.CommitTransaction(tid)
+		if cnt != expected {
+			t.Errorf("q=%s, expected %d results, got %d", sql, expected, cnt)
+		}
+	}
+
+	for _, sql := range []string{
+		"select name from t where age = 'abc'",
+		"select sq(name) from t",
+	} {
+		_, _, err := Parse(c, sql)
+		if err == nil {
+			t.Errorf("expected type mismatch error, q=%s", sql)
+		} else if err.(GoDBError).code != TypeMismatchError {
+			t.Errorf("expected TypeMismatchError, q=%s, got %v", sql, err)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// ColumnarFile stores the tuples of a table column by column, in row groups
+// of per-column pages (see [columnarPage]).  Each column page is compressed
+// with a lightweight encoding chosen for its values, and a scan only reads
+// the pages of the columns a query refers to (see [ColumnarScan]), which
+// suits queries that read a few columns of a wide table.
+//
+// Tables are stored as ColumnarFiles when they are created with USING
+// COLUMNAR.  Tuples are appended to the last row group, and deleted by
+// marking them in the bitmap of their row group;  the space of deleted
+// tuples is not reused.  The record id of a tuple is a heapFileRID whose page
+// number is that of its row group and whose slot is its row in the group.
+type ColumnarFile struct {
+	bufPool *BufferPool
+	sync.Mutex
+	fileName string
+	file     File
+	cipher   *pageCipher // encrypts the pages of the file, or nil
+	desc     *TupleDesc
+}
+
+// Open a ColumnarFile, creating the file if it doesn't exist yet.
+// Parameters
+// - fromFile: backing file for the table
+// - td: the TupleDesc of the table
+// - bp: the BufferPool that is used to store pages read from the file
+func NewColumnarFile(fromFile string, td *TupleDesc, bp *BufferPool) (*ColumnarFile, error) {
+	return openColumnarFile(fromFile, td, nil, bp)
+}
+
+// Open a ColumnarFile whose pages are encrypted with cipher, unless it is nil
+func openColumnarFile(fromFile string, td *TupleDesc, cipher *pageCipher, bp *BufferPool) (*ColumnarFile, error) {
+	file, err := bp.vfs.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &ColumnarFile{bufPool: bp, fileName: fromFile, file: file, cipher: cipher, desc: td}, nil
+}
+
+// Return the number of pages in the file
+func (f *ColumnarFile) NumPages() int {
+	return filePageCount(f.file, f.cipher, PageSize)
+}
+
+// Return the number of row groups in the file
+func (f *ColumnarFile) numGroups() int {
+	return f.NumPages() / f.groupSize()
+}
+
+// Number of pages in a row group
+func (f *ColumnarFile) groupSize() int {
+	return len(f.desc.Fields) + 1
+}
+
+// Page number of the group page of row group g, or of its page for column
+// col if col >= 0
+func (f *ColumnarFile) groupPageNo(g int, col int) int {
+	return g*f.groupSize() + col + 1
+}
+
+func (f *ColumnarFile) readPage(pageNo int) (*Page, error) {
+	buf, err := readFilePage(f.file, f.cipher, PageSize, pageNo)
+	if e, ok := err.(GoDBError); ok {
+		return nil, f.corruptPageError(pageNo, e.errString)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !verifyPageChecksum(buf) {
+		return nil, f.corruptPageError(pageNo, "checksum mismatch")
+	}
+	col := pageNo%f.groupSize() - 1
+	var page *columnarPage
+	var ftype DBType
+	if col < 0 {
+		page = newColumnarGroupPage(f, pageNo)
+	} else {
+		ftype = f.desc.Fields[col].Ftype
+		page = newColumnarColumnPage(f, pageNo, ftype)
+	}
+	err = page.initFromBuffer(bytes.NewBuffer(buf), ftype)
+	if err != nil {
+		return nil, f.corruptPageError(pageNo, err.Error())
+	}
+	if (col < 0) != (page.kind == columnarGroupPage) {
+		return nil, f.corruptPageError(pageNo, fmt.Sprintf("unexpected page kind %d", page.kind))
+	}
+	observePageLSN(page.lsn)
+	p := Page(page)
+	return &p, nil
+}
+
+func (f *ColumnarFile) corruptPageError(pageNo int, reason string) error {
+	return GoDBError{CorruptPageError, fmt.Sprintf("page %d of columnar file %s is corrupt: %s", pageNo, f.fileName, reason)}
+}
+
+func (f *ColumnarFile) flushPage(p *Page) error {
+	page, ok := (*p).(*columnarPage)
+	if !ok {
+		return GoDBError{TypeMismatchError, "cannot cast to columnarPage"}
+	}
+	page.lsn = nextPageLSN()
+	buffer, err := page.toBuffer()
+	if err != nil {
+		return err
+	}
+	return writeFilePage(f.file, f.cipher, page.pageNo, buffer.Bytes())
+}
+
+func (f *ColumnarFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.fileName, PageNo: pgNo}
+}
+
+// Make every page flushed so far durable
+func (f *ColumnarFile) sync() error {
+	return f.file.Sync()
+}
+
+// Close the file.  It must not be used again.
+func (f *ColumnarFile) close() error {
+	return f.file.Close()
+}
+
+// [Operator] descriptor method -- return the TupleDesc of the table
+func (f *ColumnarFile) Descriptor() *TupleDesc {
+	return f.desc
+}
+
+func (f *ColumnarFile) getPage(pageNo int, tid TransactionID, perm RWPerm) (*columnarPage, error) {
+	page, err := f.bufPool.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	return (*page).(*columnarPage), nil
+}
+
+// Fetch the group page of row group g, and the pages of the given columns
+func (f *ColumnarFile) getGroup(g int, columns []int, tid TransactionID, perm RWPerm) (*columnarPage, []*columnarPage, error) {
+	group, err := f.getPage(f.groupPageNo(g, -1), tid, perm)
+	if err != nil {
+		return nil, nil, err
+	}
+	pages := make([]*columnarPage, len(columns))
+	for i, col := range columns {
+		pages[i], err = f.getPage(f.groupPageNo(g, col), tid, perm)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(pages[i].values) != group.rows {
+			return nil, nil, f.corruptPageError(pages[i].pageNo, fmt.Sprintf("column has %d values, row group has %d rows", len(pages[i].values), group.rows))
+		}
+	}
+	return group, pages, nil
+}
+
+// Append an empty row group to the file
+func (f *ColumnarFile) allocGroup() (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	g := f.numGroups()
+	pages := []Page{newColumnarGroupPage(f, f.groupPageNo(g, -1))}
+	for col, field := range f.desc.Fields {
+		pages = append(pages, newColumnarColumnPage(f, f.groupPageNo(g, col), field.Ftype))
+	}
+	for _, p := range pages {
+		err := f.flushPage(&p)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return g, nil
+}
+
+func (f *ColumnarFile) allColumns() []int {
+	columns := make([]int, len(f.desc.Fields))
+	for i := range columns {
+		columns[i] = i
+	}
+	return columns
+}
+
+// Append t to the last row group, starting a new one if t doesn't fit
+func (f *ColumnarFile) insertTuple(t *Tuple, tid TransactionID) error {
+	if len(t.Fields) != len(f.desc.Fields) {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("tuple has %d fields, table %s has %d", len(t.Fields), f.fileName, len(f.desc.Fields))}
+	}
+	for i, v := range t.Fields {
+		if !valueHasType(v, f.desc.Fields[i].Ftype) {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("field %d of tuple is not a %s", i, typeNames[f.desc.Fields[i].Ftype])}
+		}
+	}
+	g := f.numGroups() - 1
+	for {
+		if g < 0 {
+			var err error
+			g, err = f.allocGroup()
+			if err != nil {
+				return err
+			}
+		}
+		group, pages, err := f.getGroup(g, f.allColumns(), tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		fits := group.rows < columnarMaxRows
+		for i, p := range pages {
+			fits = fits && p.stats.fits(t.Fields[i])
+		}
+		if !fits {
+			if group.rows == 0 {
+				return GoDBError{PageFullError, "tuple doesn't fit in an empty row group"}
+			}
+			g = -1
+			continue
+		}
+		for i, p := range pages {
+			p.appendValue(t.Fields[i])
+			p.setDirty(true)
+		}
+		group.deleted = append(group.deleted, false)
+		group.rows++
+		group.setDirty(true)
+		t.Rid = heapFileRID{g, group.rows - 1}
+		return nil
+	}
+}
+
+// Mark the row of t, found by its Rid, as deleted
+func (f *ColumnarFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	rid, ok := t.Rid.(heapFileRID)
+	if !ok {
+		return GoDBError{TupleNotFoundError, "tuple has no record id"}
+	}
+	if rid.pageNum < 0 || rid.pageNum >= f.numGroups() {
+		return GoDBError{TupleNotFoundError, fmt.Sprintf("no row group %d in %s", rid.pageNum, f.fileName)}
+	}
+	group, err := f.getPage(f.groupPageNo(rid.pageNum, -1), tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	if rid.slotNum < 0 || rid.slotNum >= group.rows || group.deleted[rid.slotNum] {
+		return GoDBError{TupleNotFoundError, fmt.Sprintf("no tuple at %v in %s", rid, f.fileName)}
+	}
+	group.deleted[rid.slotNum] = true
+	group.setDirty(true)
+	return nil
+}
+
+// Return an iterator over the rows of the file that reads only the given
+// columns.  The other fields of the tuples it returns are zero values.
+func (f *ColumnarFile) scanColumns(columns []int, tid TransactionID) (func() (*Tuple, error), error) {
+	numGroups := f.numGroups()
+	g, row := -1, 0
+	var group *columnarPage
+	var pages []*columnarPage
+	return func() (*Tuple, error) {
+		for {
+			if group == nil || row == group.rows {
+				g++
+				if g >= numGroups {
+					return nil, nil
+				}
+				var err error
+				group, pages, err = f.getGroup(g, columns, tid, ReadPerm)
+				if err != nil {
+					return nil, err
+				}
+				row = 0
+				continue
+			}
+			r := row
+			row++
+			if group.deleted[r] {
+				continue
+			}
+			fields := make([]DBValue, len(f.desc.Fields))
+			for i, field := range f.desc.Fields {
+				if field.Ftype == IntType {
+					fields[i] = IntField{}
+				} else {
+					fields[i] = StringField{}
+				}
+			}
+			for i, col := range columns {
+				fields[col] = pages[i].values[r]
+			}
+			return &Tuple{*f.desc, fields, heapFileRID{g, r}}, nil
+		}
+	}, nil
+}
+
+// [Operator] iterator method -- return every tuple of the file, in the order
+// they were inserted
+func (f *ColumnarFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return f.scanColumns(f.allColumns(), tid)
+}
+
+// ColumnarScan scans a ColumnarFile reading only some of its columns.  Its
+// tuples have all the fields of the table, but those of the other columns
+// are zero values.  The planner scans columnar tables with a ColumnarScan of
+// the columns the query refers to.
+type ColumnarScan struct {
+	file    *ColumnarFile
+	columns []int
+}
+
+func NewColumnarScan(file *ColumnarFile, columns []int) *ColumnarScan {
+	return &ColumnarScan{file, columns}
+}
+
+func (s *ColumnarScan) Descriptor() *TupleDesc {
+	return s.file.Descriptor()
+}
+
+func (s *ColumnarScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return s.file.scanColumns(s.columns, tid)
+}
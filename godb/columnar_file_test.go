@@ -0,0 +1,218 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+const TestingColumnarFile string = "test_columnar.dat"
+
+func TestColumnarPageEncodings(t *testing.T) {
+	cases := []struct {
+		name     string
+		ftype    DBType
+		value    func(i int) DBValue
+		encoding columnEncoding
+	}{
+		{"small ints", IntType, func(i int) DBValue { return IntField{int64(1000 + i%50)} }, bitPackEncoding},
+		{"runs of ints", IntType, func(i int) DBValue { return IntField{int64(i / 100 * 1000000007)} }, rleEncoding},
+		{"wide ints", IntType, func(i int) DBValue { return IntField{int64(uint64(i) * 0x9e3779b97f4a7c15)} }, plainEncoding},
+		{"few strings", StringType, func(i int) DBValue { return StringField{fmt.Sprintf("state-%d", i*7%13)} }, dictEncoding},
+		{"runs of strings", StringType, func(i int) DBValue { return StringField{fmt.Sprintf("s%d", i/200)} }, rleEncoding},
+		{"distinct strings", StringType, func(i int) DBValue { return StringField{fmt.Sprintf("%031d", i)} }, plainEncoding},
+	}
+	for _, c := range cases {
+		p := newColumnarColumnPage(nil, 1, c.ftype)
+		for i := 0; i < 1000 && p.stats.fits(c.value(i)); i++ {
+			p.appendValue(c.value(i))
+		}
+		buf, err := p.toBuffer()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err.Error())
+		}
+		if p.encoding != c.encoding {
+			t.Errorf("%s: expected %s encoding, got %s", c.name, columnEncodingNames[c.encoding], columnEncodingNames[p.encoding])
+		}
+		if !verifyPageChecksum(buf.Bytes()) {
+			t.Errorf("%s: bad checksum", c.name)
+		}
+		p2 := newColumnarColumnPage(nil, 1, c.ftype)
+		err = p2.initFromBuffer(bytes.NewBuffer(buf.Bytes()), c.ftype)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err.Error())
+		}
+		if len(p2.values) != len(p.values) {
+			t.Fatalf("%s: expected %d values, got %d", c.name, len(p.values), len(p2.values))
+		}
+		for i, v := range p.values {
+			if p2.values[i] != v {
+				t.Fatalf("%s: expected %v at %d, got %v", c.name, v, i, p2.values[i])
+			}
+		}
+	}
+}
+
+func TestColumnarFileInsertAndScan(t *testing.T) {
+	bp := NewBufferPool(100)
+	td, _, _, _, _, _ := makeTestVars()
+	os.Remove(TestingColumnarFile)
+	cf, err := NewColumnarFile(TestingColumnarFile, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	const n = 5000
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < n; i++ {
+		tup := Tuple{td, []DBValue{StringField{fmt.Sprintf("name%d", i%10)}, IntField{int64(i)}}, nil}
+		err := cf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.CommitTransaction(tid)
+	if cf.numGroups() < 2 {
+		t.Fatalf("expected several row groups, got %d", cf.numGroups())
+	}
+
+	// delete every third tuple
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ := cf.Iterator(tid)
+	for i := 0; ; i++ {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		if i%3 == 0 {
+			err := cf.deleteTuple(tup, tid)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if err := cf.deleteTuple(tup, tid); err == nil {
+				t.Fatalf("expected deleting a tuple twice to fail")
+			}
+		}
+	}
+	bp.CommitTransaction(tid)
+
+	// reopen the file, and scan just the age column
+	bp2 := NewBufferPool(100)
+	cf2, err := NewColumnarFile(TestingColumnarFile, &td, bp2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid = NewTID()
+	bp2.BeginTransaction(tid)
+	defer bp2.CommitTransaction(tid)
+	iter, _ = NewColumnarScan(cf2, []int{1}).Iterator(tid)
+	cnt := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		age := tup.Fields[1].(IntField).Value
+		if age%3 == 0 {
+			t.Fatalf("found deleted tuple with age %d", age)
+		}
+		if tup.Fields[0] != (StringField{}) {
+			t.Fatalf("expected the unread name column to be empty, got %v", tup.Fields[0])
+		}
+		cnt++
+	}
+	if cnt != n-(n+2)/3 {
+		t.Errorf("expected %d tuples, got %d", n-(n+2)/3, cnt)
+	}
+}
+
+func TestParseCreateColumnarTable(t *testing.T) {
+	bp := NewBufferPool(50)
+	err := MakeTestDatabaseEasy(bp)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf("failed load catalog, %s", err.Error())
+	}
+	os.Remove(c.tableNameToFile("wide"))
+	defer os.Remove(c.tableNameToFile("wide"))
+
+	if _, _, err := Parse(c, "create table bad (a int, b int) using bitmap"); err == nil {
+		t.Errorf("expected an unknown storage to fail")
+	}
+	if _, _, err := Parse(c, "create table bad (a int, b int, primary key (a)) using columnar"); err == nil {
+		t.Errorf("expected a columnar table with a primary key to fail")
+	}
+	qType, _, err := Parse(c, "create table wide (name text, age int, city text, score int) using columnar")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if qType != CreateTableQueryType {
+		t.Errorf("expected CreateTableQueryType")
+	}
+	if !strings.Contains(c.CatalogString(), "wide (name string, age int, city string, score int) using columnar") {
+		t.Errorf("expected columnar table in catalog, got %s", c.CatalogString())
+	}
+	runQuery(t, c, "insert into wide values ('sam', 25, 'boston', 1), ('kathy', 45, 'boston', 2), ('bill', 30, 'nyc', 3), "+
+		"('ang', 22, 'sf', 4), ('joe', 40, 'nyc', 5)")
+
+	err = c.SaveToFile("columnar_catalog.txt", "./")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.Remove("columnar_catalog.txt")
+	c2, err := NewCatalogFromFile("columnar_catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	runQuery(t, c2, "delete from wide where name = 'joe'")
+
+	queries := []struct {
+		sql      string
+		columns  string
+		expected []string
+	}{
+		{"select name from wide where age > 24", "name,age", []string{"[{bill}]", "[{kathy}]", "[{sam}]"}},
+		{"select city, sum(score) from wide group by city", "city,score", []string{"[{boston} {3}]", "[{nyc} {3}]", "[{sf} {4}]"}},
+		{"select count(*) from wide", "", []string{"[{4}]"}},
+		{"select * from wide where city = 'sf'", "name,age,city,score", []string{"[{ang} {22} {sf} {4}]"}},
+	}
+	for _, q := range queries {
+		results, plan := runQuery(t, c2, q.sql)
+		if strings.Join(results, ",") != strings.Join(q.expected, ",") {
+			t.Errorf("q=%s: expected %v, got %v", q.sql, q.expected, results)
+		}
+		var columns []string
+		found := false
+		for _, op := range planOperators(plan) {
+			if scan, ok := op.(*ColumnarScan); ok {
+				found = true
+				for _, col := range scan.columns {
+					columns = append(columns, scan.file.desc.Fields[col].Fname)
+				}
+			}
+		}
+		if !found || strings.Join(columns, ",") != q.columns {
+			t.Errorf("q=%s: expected a columnar scan of %s, got %v", q.sql, q.columns, columns)
+		}
+	}
+
+	report, err := c2.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("expected no problems, got %s", report.String())
+	}
+}
@@ -0,0 +1,507 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+/* columnarPage implements the Page interface for pages of a ColumnarFile.
+
+A columnar file is a sequence of row groups.  Each row group of a table with
+n columns takes n+1 consecutive pages:  a group page, followed by one column
+page per column holding that column's values for the rows of the group, in
+row order.  The group page records the number of rows in the group and a
+bitmap of the rows that have been deleted.  Rows are only ever appended to
+the last row group, which is closed once the values of any column no longer
+fit on its page.
+
+All pages are PageSize bytes and share a header:
+
+	kind (int32)  number of rows or values (int32)  LSN (int64)
+	checksum (uint32)  encoding (int32)
+
+The checksum and LSN are at the same offsets as in heap pages.  The group
+page's header is followed by the deleted bitmap.  A column page's values are
+written with whichever of the encodings below is smallest for them; see
+[columnStats]:
+
+  - plain: each value as in a tuple (see [Tuple.writeTo])
+  - run length: the number of runs (int32), then each run as a plain value
+    followed by its length (int32)
+  - bit-packed (int columns): the smallest value (int64) and a bit width
+    (uint8), followed by each value minus the smallest in that many bits
+  - dictionary (string columns): the number of distinct values (int32), each
+    as a length (uint8) and its bytes, then a bit width (uint8) and the
+    position of each value in the dictionary, bit-packed
+*/
+
+type columnarPageKind int32
+
+const (
+	columnarGroupPage  columnarPageKind = iota + 1
+	columnarColumnPage columnarPageKind = iota + 1
+)
+
+type columnEncoding int32
+
+const (
+	plainEncoding columnEncoding = iota
+	rleEncoding
+	bitPackEncoding
+	dictEncoding
+	numEncodings
+)
+
+var columnEncodingNames = map[columnEncoding]string{
+	plainEncoding:   "plain",
+	rleEncoding:     "rle",
+	bitPackEncoding: "bitpack",
+	dictEncoding:    "dictionary",
+}
+
+const columnarPageHeaderSize = 24
+
+// The most rows a row group can have:  one bit each in the group page
+var columnarMaxRows = (PageSize - columnarPageHeaderSize) * 8
+
+// Running statistics of the values of a column page, from which the size of
+// each encoding of the values can be worked out without encoding them
+type columnStats struct {
+	ftype     DBType
+	n         int
+	min, max  int64          // int columns
+	runs      int            // number of runs of equal values
+	last      DBValue        // the last value added
+	dict      map[string]int // string columns: position of each distinct value
+	dictBytes int            // size of the dictionary entries
+}
+
+func newColumnStats(ftype DBType) *columnStats {
+	return &columnStats{ftype: ftype, dict: make(map[string]int)}
+}
+
+// Number of bits needed to write every value from 0 to x
+func bitWidth(x uint64) int {
+	return bits.Len64(x)
+}
+
+// Number of bytes that n values of width bits each take when packed
+func packedSize(n int, width int) int {
+	return (n*width + 7) / 8
+}
+
+func plainValueSize(ftype DBType) int {
+	return calBytesPerTuple(&TupleDesc{Fields: []FieldType{{Ftype: ftype}}})
+}
+
+// The size of each encoding of the values, plus v if it isn't nil.
+// Encodings that don't apply to the column's type have size -1.
+func (s *columnStats) encodedSizes(v DBValue) [numEncodings]int {
+	n, runs := s.n, s.runs
+	if v != nil {
+		n++
+		if s.n == 0 || v != s.last {
+			runs++
+		}
+	}
+	var sizes [numEncodings]int
+	sizes[plainEncoding] = n * plainValueSize(s.ftype)
+	sizes[rleEncoding] = 4 + runs*(plainValueSize(s.ftype)+4)
+	sizes[bitPackEncoding] = -1
+	sizes[dictEncoding] = -1
+	switch s.ftype {
+	case IntType:
+		lo, hi := s.min, s.max
+		if v != nil {
+			x := v.(IntField).Value
+			if s.n == 0 || x < lo {
+				lo = x
+			}
+			if s.n == 0 || x > hi {
+				hi = x
+			}
+		}
+		width := 0
+		if n > 0 {
+			width = bitWidth(uint64(hi) - uint64(lo))
+		}
+		sizes[bitPackEncoding] = 9 + packedSize(n, width)
+	case StringType:
+		distinct, dictBytes := len(s.dict), s.dictBytes
+		if v != nil {
+			if _, ok := s.dict[v.(StringField).Value]; !ok {
+				distinct++
+				dictBytes += 1 + len(v.(StringField).Value)
+			}
+		}
+		width := 0
+		if distinct > 1 {
+			width = bitWidth(uint64(distinct - 1))
+		}
+		sizes[dictEncoding] = 4 + dictBytes + 1 + packedSize(n, width)
+	}
+	return sizes
+}
+
+// The smallest encoding of the values, and its size
+func (s *columnStats) bestEncoding() (columnEncoding, int) {
+	sizes := s.encodedSizes(nil)
+	best := plainEncoding
+	for enc, size := range sizes {
+		if size >= 0 && size < sizes[best] {
+			best = columnEncoding(enc)
+		}
+	}
+	return best, sizes[best]
+}
+
+// Whether v can be added while some encoding still fits on a page
+func (s *columnStats) fits(v DBValue) bool {
+	for _, size := range s.encodedSizes(v) {
+		if size >= 0 && size <= PageSize-columnarPageHeaderSize {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *columnStats) add(v DBValue) {
+	if s.n == 0 || v != s.last {
+		s.runs++
+	}
+	switch v := v.(type) {
+	case IntField:
+		if s.n == 0 || v.Value < s.min {
+			s.min = v.Value
+		}
+		if s.n == 0 || v.Value > s.max {
+			s.max = v.Value
+		}
+	case StringField:
+		if _, ok := s.dict[v.Value]; !ok {
+			s.dict[v.Value] = len(s.dict)
+			s.dictBytes += 1 + len(v.Value)
+		}
+	}
+	s.last = v
+	s.n++
+}
+
+type columnarPage struct {
+	dirty    bool
+	file     *ColumnarFile
+	pageNo   int
+	lsn      int64
+	kind     columnarPageKind
+	rows     int    // group pages only
+	deleted  []bool // group pages only
+	values   []DBValue
+	stats    *columnStats   // column pages only
+	encoding columnEncoding // of the column page as last read or written
+}
+
+func newColumnarGroupPage(f *ColumnarFile, pageNo int) *columnarPage {
+	return &columnarPage{file: f, pageNo: pageNo, kind: columnarGroupPage}
+}
+
+func newColumnarColumnPage(f *ColumnarFile, pageNo int, ftype DBType) *columnarPage {
+	return &columnarPage{file: f, pageNo: pageNo, kind: columnarColumnPage, stats: newColumnStats(ftype)}
+}
+
+func (p *columnarPage) isDirty() bool {
+	return p.dirty
+}
+
+func (p *columnarPage) setDirty(dirty bool) {
+	p.dirty = dirty
+}
+
+func (p *columnarPage) getFile() *DBFile {
+	file := (DBFile)(p.file)
+	return &file
+}
+
+// Append a value to a column page
+func (p *columnarPage) appendValue(v DBValue) {
+	p.values = append(p.values, v)
+	p.stats.add(v)
+}
+
+// Write n values of width bits each, least significant bits first
+func writePacked(b *bytes.Buffer, values []uint64, width int) {
+	packed := make([]byte, packedSize(len(values), width))
+	bit := 0
+	for _, v := range values {
+		for i := 0; i < width; i++ {
+			if v&(1<<i) != 0 {
+				packed[bit/8] |= 1 << (bit % 8)
+			}
+			bit++
+		}
+	}
+	b.Write(packed)
+}
+
+func readPacked(b *bytes.Buffer, n int, width int) ([]uint64, error) {
+	packed := b.Next(packedSize(n, width))
+	if len(packed) < packedSize(n, width) {
+		return nil, GoDBError{MalformedDataError, "bit-packed values run past the end of the page"}
+	}
+	values := make([]uint64, n)
+	bit := 0
+	for j := range values {
+		for i := 0; i < width; i++ {
+			if packed[bit/8]&(1<<(bit%8)) != 0 {
+				values[j] |= 1 << i
+			}
+			bit++
+		}
+	}
+	return values, nil
+}
+
+func writePlainValue(b *bytes.Buffer, v DBValue) error {
+	return (&Tuple{Fields: []DBValue{v}}).writeTo(b)
+}
+
+func readPlainValue(b *bytes.Buffer, ftype DBType) (DBValue, error) {
+	t, err := readTupleFrom(b, &TupleDesc{Fields: []FieldType{{Ftype: ftype}}})
+	if err != nil {
+		return nil, err
+	}
+	return t.Fields[0], nil
+}
+
+// Write the values of a column page with the given encoding
+func (p *columnarPage) encodeValues(b *bytes.Buffer, enc columnEncoding) error {
+	switch enc {
+	case plainEncoding:
+		for _, v := range p.values {
+			err := writePlainValue(b, v)
+			if err != nil {
+				return err
+			}
+		}
+	case rleEncoding:
+		binary.Write(b, binary.LittleEndian, int32(p.stats.runs))
+		for i := 0; i < len(p.values); {
+			j := i + 1
+			for j < len(p.values) && p.values[j] == p.values[i] {
+				j++
+			}
+			err := writePlainValue(b, p.values[i])
+			if err != nil {
+				return err
+			}
+			binary.Write(b, binary.LittleEndian, int32(j-i))
+			i = j
+		}
+	case bitPackEncoding:
+		width := bitWidth(uint64(p.stats.max) - uint64(p.stats.min))
+		binary.Write(b, binary.LittleEndian, p.stats.min)
+		b.WriteByte(byte(width))
+		offsets := make([]uint64, len(p.values))
+		for i, v := range p.values {
+			offsets[i] = uint64(v.(IntField).Value) - uint64(p.stats.min)
+		}
+		writePacked(b, offsets, width)
+	case dictEncoding:
+		dict := make([]string, len(p.stats.dict))
+		for s, code := range p.stats.dict {
+			dict[code] = s
+		}
+		binary.Write(b, binary.LittleEndian, int32(len(dict)))
+		for _, s := range dict {
+			b.WriteByte(byte(len(s)))
+			b.WriteString(s)
+		}
+		width := 0
+		if len(dict) > 1 {
+			width = bitWidth(uint64(len(dict) - 1))
+		}
+		b.WriteByte(byte(width))
+		codes := make([]uint64, len(p.values))
+		for i, v := range p.values {
+			codes[i] = uint64(p.stats.dict[v.(StringField).Value])
+		}
+		writePacked(b, codes, width)
+	default:
+		return GoDBError{MalformedDataError, fmt.Sprintf("unknown column encoding %d", enc)}
+	}
+	return nil
+}
+
+// Read n values of a column page written by encodeValues
+func (p *columnarPage) decodeValues(b *bytes.Buffer, enc columnEncoding, n int) error {
+	ftype := p.stats.ftype
+	switch enc {
+	case plainEncoding:
+		for i := 0; i < n; i++ {
+			v, err := readPlainValue(b, ftype)
+			if err != nil {
+				return err
+			}
+			p.appendValue(v)
+		}
+	case rleEncoding:
+		var runs int32
+		err := binary.Read(b, binary.LittleEndian, &runs)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < int(runs); i++ {
+			v, err := readPlainValue(b, ftype)
+			if err != nil {
+				return err
+			}
+			var length int32
+			err = binary.Read(b, binary.LittleEndian, &length)
+			if err != nil {
+				return err
+			}
+			if length <= 0 || len(p.values)+int(length) > n {
+				return GoDBError{MalformedDataError, fmt.Sprintf("bad run length %d", length)}
+			}
+			for j := 0; j < int(length); j++ {
+				p.appendValue(v)
+			}
+		}
+	case bitPackEncoding:
+		if ftype != IntType {
+			return GoDBError{MalformedDataError, "bit-packed string column"}
+		}
+		var min int64
+		err := binary.Read(b, binary.LittleEndian, &min)
+		if err != nil {
+			return err
+		}
+		width, err := b.ReadByte()
+		if err != nil {
+			return err
+		}
+		if width > 64 {
+			return GoDBError{MalformedDataError, fmt.Sprintf("bad bit width %d", width)}
+		}
+		offsets, err := readPacked(b, n, int(width))
+		if err != nil {
+			return err
+		}
+		for _, off := range offsets {
+			p.appendValue(IntField{int64(uint64(min) + off)})
+		}
+	case dictEncoding:
+		if ftype != StringType {
+			return GoDBError{MalformedDataError, "dictionary encoded int column"}
+		}
+		var size int32
+		err := binary.Read(b, binary.LittleEndian, &size)
+		if err != nil {
+			return err
+		}
+		if size < 0 || int(size) > n {
+			return GoDBError{MalformedDataError, fmt.Sprintf("bad dictionary size %d", size)}
+		}
+		dict := make([]string, size)
+		for i := range dict {
+			length, err := b.ReadByte()
+			if err != nil {
+				return err
+			}
+			s := b.Next(int(length))
+			if len(s) < int(length) {
+				return GoDBError{MalformedDataError, "dictionary runs past the end of the page"}
+			}
+			dict[i] = string(s)
+		}
+		width, err := b.ReadByte()
+		if err != nil {
+			return err
+		}
+		codes, err := readPacked(b, n, int(width))
+		if err != nil {
+			return err
+		}
+		for _, code := range codes {
+			if code >= uint64(len(dict)) {
+				return GoDBError{MalformedDataError, fmt.Sprintf("dictionary code %d out of range", code)}
+			}
+			p.appendValue(StringField{dict[code]})
+		}
+	default:
+		return GoDBError{MalformedDataError, fmt.Sprintf("unknown column encoding %d", enc)}
+	}
+	if len(p.values) != n {
+		return GoDBError{MalformedDataError, fmt.Sprintf("expected %d values, found %d", n, len(p.values))}
+	}
+	return nil
+}
+
+// Serialize the page to a PageSize buffer, filling in its checksum
+func (p *columnarPage) toBuffer() (*bytes.Buffer, error) {
+	b := new(bytes.Buffer)
+	count := p.rows
+	if p.kind == columnarColumnPage {
+		count = len(p.values)
+		p.encoding, _ = p.stats.bestEncoding()
+	}
+	for _, v := range []any{int32(p.kind), int32(count), p.lsn, uint32(0), int32(p.encoding)} {
+		err := binary.Write(b, binary.LittleEndian, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.kind == columnarGroupPage {
+		bitmap := make([]byte, (p.rows+7)/8)
+		for i, deleted := range p.deleted {
+			if deleted {
+				bitmap[i/8] |= 1 << (i % 8)
+			}
+		}
+		b.Write(bitmap)
+	} else {
+		err := p.encodeValues(b, p.encoding)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b.Len() > PageSize {
+		return nil, GoDBError{PageFullError, "column values don't fit in page"}
+	}
+	b.Write(make([]byte, PageSize-b.Len()))
+	binary.LittleEndian.PutUint32(b.Bytes()[pageChecksumOffset:], pageChecksum(b.Bytes()))
+	return b, nil
+}
+
+// Read the contents of the page from a buffer written by toBuffer.  ftype is
+// the type of the column, for column pages.
+func (p *columnarPage) initFromBuffer(buf *bytes.Buffer, ftype DBType) error {
+	var kind, count, encoding int32
+	var checksum uint32
+	for _, v := range []any{&kind, &count, &p.lsn, &checksum, &encoding} {
+		err := binary.Read(buf, binary.LittleEndian, v)
+		if err != nil {
+			return err
+		}
+	}
+	p.kind = columnarPageKind(kind)
+	p.encoding = columnEncoding(encoding)
+	if count < 0 || int(count) > columnarMaxRows {
+		return GoDBError{MalformedDataError, fmt.Sprintf("bad columnar page header: %d rows", count)}
+	}
+	switch p.kind {
+	case columnarGroupPage:
+		p.rows = int(count)
+		bitmap := buf.Next((p.rows + 7) / 8)
+		p.deleted = make([]bool, p.rows)
+		for i := range p.deleted {
+			p.deleted[i] = bitmap[i/8]&(1<<(i%8)) != 0
+		}
+		return nil
+	case columnarColumnPage:
+		p.stats = newColumnStats(ftype)
+		return p.decodeValues(buf, p.encoding, int(count))
+	}
+	return GoDBError{MalformedDataError, fmt.Sprintf("unknown columnar page kind %d", kind)}
+}
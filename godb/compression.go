@@ -0,0 +1,168 @@
+package godb
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Page compression.  A heap table created with a COMPRESSION option stores
+// each page compressed with one of the pageCodecs below, so that the padding
+// of fixed length strings and empty slots costs next to nothing on disk.
+// Pages are compressed in [HeapFile.flushPage] and decompressed in
+// [HeapFile.readPage];  in the buffer pool they are ordinary pages.  Since
+// compressed pages vary in size, a [pageMap] records where each one is
+// stored.
+
+// A pageCodec compresses serialized pages.  decompress returns an error if
+// data is not the compressed form of a page of pageSize bytes.
+type pageCodec struct {
+	name       string
+	compress   func(page []byte) ([]byte, error)
+	decompress func(data []byte, pageSize int) ([]byte, error)
+}
+
+var pageCodecs = map[string]*pageCodec{
+	"lz":      {"lz", lzCompress, lzDecompress},
+	"deflate": {"deflate", deflateCompress, deflateDecompress},
+}
+
+// Return the codec with the given name
+func findPageCodec(name string) (*pageCodec, error) {
+	codec, ok := pageCodecs[name]
+	if !ok {
+		return nil, GoDBError{ParseError, fmt.Sprintf("unknown compression %s", name)}
+	}
+	return codec, nil
+}
+
+/*
+lz is a byte oriented LZ77 codec in the style of LZ4 and Snappy:  fast, and
+good at the long runs of zeros in our pages.  The compressed data is a
+sequence of elements, each starting with a tag byte:
+
+	0xxxxxxx  a literal run of x+1 bytes, which follow the tag
+	1xxxxxxx  a copy of x+lzMinMatch bytes from offset bytes back in the
+	          output, where offset is the uint16 that follows the tag
+
+Copies may overlap the bytes they produce, so a run of n equal bytes takes a
+literal byte and a copy.
+*/
+const (
+	lzMinMatch   = 4
+	lzMaxMatch   = 0x7f + lzMinMatch
+	lzMaxLiteral = 0x80
+	lzMaxOffset  = 0xffff
+	lzHashBits   = 12
+)
+
+func lzHash(b []byte) uint32 {
+	return (binary.LittleEndian.Uint32(b) * 2654435761) >> (32 - lzHashBits)
+}
+
+func lzCompress(page []byte) ([]byte, error) {
+	out := make([]byte, 0, len(page)/4)
+	var table [1 << lzHashBits]int32
+	for i := range table {
+		table[i] = -1
+	}
+	literals := 0 // start of the pending literal run
+	emitLiterals := func(end int) {
+		for literals < end {
+			n := end - literals
+			if n > lzMaxLiteral {
+				n = lzMaxLiteral
+			}
+			out = append(out, byte(n-1))
+			out = append(out, page[literals:literals+n]...)
+			literals += n
+		}
+	}
+	for i := 0; i+lzMinMatch <= len(page); {
+		h := lzHash(page[i:])
+		candidate := int(table[h])
+		table[h] = int32(i)
+		if candidate < 0 || i-candidate > lzMaxOffset || !bytes.Equal(page[candidate:candidate+lzMinMatch], page[i:i+lzMinMatch]) {
+			i++
+			continue
+		}
+		length := lzMinMatch
+		for i+length < len(page) && length < lzMaxMatch && page[candidate+length] == page[i+length] {
+			length++
+		}
+		emitLiterals(i)
+		out = append(out, 0x80|byte(length-lzMinMatch), 0, 0)
+		binary.LittleEndian.PutUint16(out[len(out)-2:], uint16(i-candidate))
+		i += length
+		literals = i
+	}
+	emitLiterals(len(page))
+	return out, nil
+}
+
+func lzDecompress(data []byte, pageSize int) ([]byte, error) {
+	page := make([]byte, 0, pageSize)
+	for i := 0; i < len(data); {
+		tag := data[i]
+		i++
+		if tag&0x80 == 0 {
+			n := int(tag) + 1
+			if i+n > len(data) {
+				return nil, GoDBError{MalformedDataError, "lz literal run past the end of the data"}
+			}
+			page = append(page, data[i:i+n]...)
+			i += n
+			continue
+		}
+		if i+2 > len(data) {
+			return nil, GoDBError{MalformedDataError, "lz copy past the end of the data"}
+		}
+		length := int(tag&0x7f) + lzMinMatch
+		offset := int(binary.LittleEndian.Uint16(data[i:]))
+		i += 2
+		if offset == 0 || offset > len(page) {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("lz copy from offset %d before the start of the page", offset)}
+		}
+		for j := 0; j < length; j++ {
+			page = append(page, page[len(page)-offset])
+		}
+	}
+	if len(page) != pageSize {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("decompressed page has %d bytes, expected %d", len(page), pageSize)}
+	}
+	return page, nil
+}
+
+func deflateCompress(page []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := flate.NewWriter(&b, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(page)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func deflateDecompress(data []byte, pageSize int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	page := make([]byte, pageSize)
+	_, err := io.ReadFull(r, page)
+	if err != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("can't inflate page: %s", err.Error())}
+	}
+	n, _ := r.Read(make([]byte, 1))
+	if n != 0 {
+		return nil, GoDBError{MalformedDataError, "inflated page is too long"}
+	}
+	return page, nil
+}
@@ -0,0 +1,204 @@
+package godb
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+const TestingCompressedFile string = "test_compressed.dat"
+
+func TestPageCodecs(t *testing.T) {
+	td, t1, t2, _, _, _ := makeTestVars()
+	empty, err := newHeapPage(&td, 0, nil).toBuffer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	full := newHeapPage(&td, 0, nil)
+	for i := 0; full.usedSlots < full.numSlots; i++ {
+		tup := t1
+		if i%3 == 0 {
+			tup = t2
+		}
+		full.insertTuple(&Tuple{tup.Desc, tup.Fields, nil})
+	}
+	fullBuf, err := full.toBuffer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	random := make([]byte, PageSize)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for name, codec := range pageCodecs {
+		for _, page := range [][]byte{empty.Bytes(), fullBuf.Bytes(), random} {
+			compressed, err := codec.compress(page)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err.Error())
+			}
+			decompressed, err := codec.decompress(compressed, PageSize)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err.Error())
+			}
+			if !bytes.Equal(page, decompressed) {
+				t.Errorf("%s: page changed by compression", name)
+			}
+		}
+		compressed, _ := codec.compress(empty.Bytes())
+		if len(compressed) > PageSize/20 {
+			t.Errorf("%s: expected an empty page to compress well, got %d bytes", name, len(compressed))
+		}
+		if _, err := codec.decompress(compressed[:len(compressed)/2], PageSize); err == nil {
+			t.Errorf("%s: expected truncated data not to decompress", name)
+		}
+	}
+}
+
+func TestCompressedHeapFile(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars()
+	os.Remove(TestingCompressedFile)
+	defer os.Remove(TestingCompressedFile)
+	os.Remove(pageMapFileName(TestingCompressedFile))
+	defer os.Remove(pageMapFileName(TestingCompressedFile))
+	bp := NewBufferPool(500)
+	hf, err := NewCompressedHeapFile(TestingCompressedFile, &td, "lz", bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var ages []int
+	for i := 0; i < 2000; i++ {
+		ages = append(ages, i)
+	}
+	insertAges(t, hf, bp, ages)
+	numPages := hf.NumPages()
+	stat, err := os.Stat(TestingCompressedFile)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if numPages < 10 || stat.Size()-int64(PageSize) > int64(numPages*PageSize/4) {
+		t.Errorf("expected %d pages to compress to well under %d bytes, got %d", numPages, numPages*PageSize, stat.Size())
+	}
+
+	// a plain NewHeapFile finds the page map, and the file can't be opened
+	// with another codec
+	if _, err := NewCompressedHeapFile(TestingCompressedFile, &td, "deflate", bp); err == nil {
+		t.Errorf("expected opening the file with another codec to fail")
+	}
+	bp = NewBufferPool(500)
+	hf, err = NewHeapFile(TestingCompressedFile, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if hf.NumPages() != numPages {
+		t.Fatalf("expected %d pages after reopening, got %d", numPages, hf.NumPages())
+	}
+	tuples := collectTuples(t, hf, bp)
+	if len(tuples) != len(ages) {
+		t.Fatalf("expected %d tuples, got %d", len(ages), len(tuples))
+	}
+
+	// deleting most tuples and vacuuming shrinks the file and the page map
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for _, tup := range tuples {
+		if tup.Fields[1].(IntField).Value >= 100 {
+			err := hf.deleteTuple(tup, tid)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+	}
+	bp.CommitTransaction(tid)
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	_, err = hf.vacuumFull(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.CommitTransaction(tid)
+	if hf.NumPages() >= numPages {
+		t.Errorf("expected vacuum to remove pages, still has %d", hf.NumPages())
+	}
+	shrunk, err := os.Stat(TestingCompressedFile)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if shrunk.Size() >= stat.Size() {
+		t.Errorf("expected vacuum to shrink the file from %d bytes, got %d", stat.Size(), shrunk.Size())
+	}
+	bp = NewBufferPool(500)
+	hf, err = NewHeapFile(TestingCompressedFile, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n := len(collectTuples(t, hf, bp)); n != 100 {
+		t.Errorf("expected 100 tuples after vacuum, got %d", n)
+	}
+}
+
+func TestParseCreateCompressedTable(t *testing.T) {
+	bp := NewBufferPool(50)
+	err := MakeTestDatabaseEasy(bp)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf("failed load catalog, %s", err.Error())
+	}
+	os.Remove(c.tableNameToFile("people"))
+	defer os.Remove(c.tableNameToFile("people"))
+	os.Remove(pageMapFileName(c.tableNameToFile("people")))
+	defer os.Remove(pageMapFileName(c.tableNameToFile("people")))
+
+	for _, sql := range []string{
+		"create table bad (a int) compression = 'zip'",
+		"create table bad (a int) using columnar compression = 'lz'",
+		"create table bad (a int primary key) compression = 'lz'",
+		"create table bad (a int) compression",
+	} {
+		if _, _, err := Parse(c, sql); err == nil {
+			t.Errorf("expected %s to fail", sql)
+		}
+	}
+	_, _, err = Parse(c, "create table people (name text, age int) compression = 'deflate'")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !strings.Contains(c.CatalogString(), "people (name string, age int) compression deflate") {
+		t.Errorf("expected compressed table in catalog, got %s", c.CatalogString())
+	}
+	runQuery(t, c, "insert into people values ('sam', 25), ('kathy', 45), ('bill', 30), ('ang', 22), ('joe', 40)")
+	err = c.SaveToFile("compressed_catalog.txt", "./")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.Remove("compressed_catalog.txt")
+	c2, err := NewCatalogFromFile("compressed_catalog.txt", NewBufferPool(50), "./")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	results, _ := runQuery(t, c2, "select name from people where age > 30")
+	if strings.Join(results, ",") != "[{joe}],[{kathy}]" {
+		t.Errorf("expected joe and kathy, got %v", results)
+	}
+	if _, err := os.Stat(pageMapFileName(c.tableNameToFile("people"))); err != nil {
+		t.Errorf("expected people to have a page map")
+	}
+
+	report, err := c2.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 || report.Tables != 3 || report.Tuples != 29 {
+		t.Errorf("expected 3 tables with 29 tuples and no problems, got %s", report.String())
+	}
+	_, _, err = Parse(c2, "drop table people")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := os.Stat(pageMapFileName(c.tableNameToFile("people"))); err == nil {
+		t.Errorf("expected dropping people to remove its page map")
+	}
+}
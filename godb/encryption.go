@@ -0,0 +1,125 @@
+package godb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+)
+
+// Encryption at rest.  A catalog opened with a key (see
+// [NewEncryptedCatalogFromFile]) encrypts every page of its tables and
+// indexes with AES-GCM as the page is written, and decrypts and
+// authenticates it as it is read, so pages in the buffer pool are plain
+// while the files on disk never are.  Each write of a page uses a fresh
+// random nonce, stored with the page, and the page number is authenticated
+// along with the contents, so that a page can't be moved within its file.  A
+// page that fails authentication, whether it was damaged or tampered with or
+// the key is wrong, is reported as corrupt.
+//
+// An encrypted page takes pageCipherOverhead more bytes on disk than a plain
+// one:  the nonce, followed by the encrypted page and the authentication tag.
+
+// The error returned for a page that fails authentication
+var errPageAuthentication = GoDBError{MalformedDataError, "page failed authentication"}
+
+// A pageCipher encrypts and decrypts the pages of a file.  A nil pageCipher
+// leaves pages as they are.
+type pageCipher struct {
+	aead cipher.AEAD
+}
+
+const (
+	pageNonceSize      = 12
+	pageCipherOverhead = pageNonceSize + 16
+)
+
+// Create a pageCipher from an AES key, which must be 16, 24 or 32 bytes long
+func newPageCipher(key []byte) (*pageCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("bad encryption key: %s", err.Error())}
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &pageCipher{aead}, nil
+}
+
+// Return the number of bytes a page of size bytes takes on disk
+func (c *pageCipher) storedSize(size int) int {
+	if c == nil {
+		return size
+	}
+	return size + pageCipherOverhead
+}
+
+func pageAdditionalData(pageNo int) []byte {
+	ad := make([]byte, 8)
+	binary.LittleEndian.PutUint64(ad, uint64(pageNo))
+	return ad
+}
+
+// Encrypt page pageNo of a file
+func (c *pageCipher) seal(pageNo int, page []byte) ([]byte, error) {
+	if c == nil {
+		return page, nil
+	}
+	nonce := make([]byte, pageNonceSize, pageNonceSize+len(page)+c.aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, page, pageAdditionalData(pageNo)), nil
+}
+
+// Decrypt page pageNo of a file, returning errPageAuthentication if it fails
+// authentication
+func (c *pageCipher) open(pageNo int, data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	if len(data) < pageCipherOverhead {
+		return nil, errPageAuthentication
+	}
+	page, err := c.aead.Open(nil, data[:pageNonceSize], data[pageNonceSize:], pageAdditionalData(pageNo))
+	if err != nil {
+		return nil, errPageAuthentication
+	}
+	return page, nil
+}
+
+// Read page pageNo of a file of pageSize pages, decrypting it if c is not
+// nil.  Returns errPageAuthentication if the page fails authentication.
+func readFilePage(file File, c *pageCipher, pageSize int, pageNo int) ([]byte, error) {
+	buf := make([]byte, c.storedSize(pageSize))
+	_, err := file.ReadAt(buf, int64(pageNo*len(buf)))
+	if err != nil {
+		return nil, err
+	}
+	return c.open(pageNo, buf)
+}
+
+// Write page pageNo of a file whose pages are the size of page, encrypting it
+// if c is not nil
+func writeFilePage(file File, c *pageCipher, pageNo int, page []byte) error {
+	data, err := c.seal(pageNo, page)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, int64(pageNo*len(data)))
+	return err
+}
+
+// Return the number of pages in a file of pageSize pages
+func filePageCount(file File, c *pageCipher, pageSize int) int {
+	stat, _ := file.Stat()
+	return int(stat.Size()) / c.storedSize(pageSize)
+}
+
+// Cut a file of pageSize pages down to numPages pages
+func truncateFilePages(file File, c *pageCipher, pageSize int, numPages int) error {
+	return file.Truncate(int64(numPages * c.storedSize(pageSize)))
+}
@@ -0,0 +1,196 @@
+package godb
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+const TestingEncryptedFile string = "test_encrypted.dat"
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestPageCipher(t *testing.T) {
+	if _, err := newPageCipher([]byte("short")); err == nil {
+		t.Errorf("expected a 5 byte key to be rejected")
+	}
+	c, err := newPageCipher(testEncryptionKey)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	page := bytes.Repeat([]byte("godb"), PageSize/4)
+	sealed, err := c.seal(3, page)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(sealed) != c.storedSize(PageSize) || bytes.Contains(sealed, []byte("godb")) {
+		t.Fatalf("expected %d encrypted bytes", c.storedSize(PageSize))
+	}
+	again, _ := c.seal(3, page)
+	if bytes.Equal(sealed, again) {
+		t.Errorf("expected each write of a page to use a new nonce")
+	}
+	opened, err := c.open(3, sealed)
+	if err != nil || !bytes.Equal(opened, page) {
+		t.Fatalf("expected the page to decrypt")
+	}
+	if _, err := c.open(4, sealed); err != errPageAuthentication {
+		t.Errorf("expected a page moved to another page number to fail authentication")
+	}
+	sealed[100] ^= 1
+	if _, err := c.open(3, sealed); err != errPageAuthentication {
+		t.Errorf("expected a modified page to fail authentication")
+	}
+	other, _ := newPageCipher([]byte("fedcba9876543210"))
+	if _, err := other.open(3, again); err != errPageAuthentication {
+		t.Errorf("expected the wrong key to fail authentication")
+	}
+}
+
+func TestEncryptedHeapFile(t *testing.T) {
+	td, t1, t2, _, _, _ := makeTestVars()
+	os.Remove(TestingEncryptedFile)
+	defer os.Remove(TestingEncryptedFile)
+	defer os.Remove(fsmFileName(TestingEncryptedFile))
+	defer os.Remove(zoneMapFileName(TestingEncryptedFile))
+	c, err := newPageCipher(testEncryptionKey)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp := NewBufferPool(10)
+	hf, err := openHeapFile(TestingEncryptedFile, &td, PageSize, "", c, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 200; i++ {
+		hf.insertTuple(&t1, tid)
+		hf.insertTuple(&t2, tid)
+	}
+	bp.CommitTransaction(tid)
+
+	data, err := os.ReadFile(TestingEncryptedFile)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(data)%c.storedSize(PageSize) != 0 || bytes.Contains(data, []byte("sam")) {
+		t.Fatalf("expected the file to hold only encrypted pages")
+	}
+
+	bp = NewBufferPool(10)
+	hf, err = openHeapFile(TestingEncryptedFile, &td, PageSize, "", c, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n := len(collectTuples(t, hf, bp)); n != 400 {
+		t.Fatalf("expected 400 tuples after reopening, got %d", n)
+	}
+
+	// a modified page is reported as corrupt
+	f, err := os.OpenFile(TestingEncryptedFile, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	offset := c.storedSize(PageSize) + 50 // in page 0, after the header
+	f.WriteAt([]byte{data[offset] ^ 1}, int64(offset))
+	f.Close()
+	bp = NewBufferPool(10)
+	hf, err = openHeapFile(TestingEncryptedFile, &td, PageSize, "", c, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	defer bp.CommitTransaction(tid)
+	_, err = bp.GetPage(hf, 0, tid, ReadPerm)
+	if e, ok := err.(GoDBError); !ok || e.code != CorruptPageError {
+		t.Errorf("expected a corrupt page error, got %v", err)
+	}
+}
+
+func TestEncryptedCatalog(t *testing.T) {
+	err := os.WriteFile("encrypted_catalog.txt", []byte("secrets (name string, age int)\n"), 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.Remove("encrypted_catalog.txt")
+	if _, err := NewEncryptedCatalogFromFile("encrypted_catalog.txt", NewBufferPool(50), "./", []byte("short")); err == nil {
+		t.Errorf("expected a bad key to be rejected")
+	}
+	c, err := NewEncryptedCatalogFromFile("encrypted_catalog.txt", NewBufferPool(50), "./", testEncryptionKey)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tableFile := c.tableNameToFile("secrets")
+	indexFile := c.indexNameToFile("secrets_age")
+	for _, name := range []string{tableFile, fsmFileName(tableFile), zoneMapFileName(tableFile), quarantineFileName(tableFile), indexFile} {
+		os.Remove(name)
+		defer os.Remove(name)
+	}
+	_, _, err = Parse(c, "create index secrets_age on secrets (age)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	runQuery(t, c, "insert into secrets values ('sam', 25), ('kathy', 45), ('bill', 30), ('ang', 22), ('joe', 40)")
+	err = c.SaveToFile("encrypted_catalog.txt", "./")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, name := range []string{tableFile, indexFile} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if bytes.Contains(data, []byte("kathy")) || len(data)%(PageSize+pageCipherOverhead) != 0 {
+			t.Errorf("expected %s to hold only encrypted pages", name)
+		}
+	}
+
+	c, err = NewEncryptedCatalogFromFile("encrypted_catalog.txt", NewBufferPool(50), "./", testEncryptionKey)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	results, _ := runQuery(t, c, "select name from secrets where age > 30")
+	if strings.Join(results, ",") != "[{joe}],[{kathy}]" {
+		t.Errorf("expected joe and kathy, got %v", results)
+	}
+	report, err := c.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 || report.Tuples != 5 {
+		t.Errorf("expected 5 tuples and no problems, got %s", report.String())
+	}
+
+	// with the wrong key every page is corrupt, and fsck leaves them alone
+	c, err = NewEncryptedCatalogFromFile("encrypted_catalog.txt", NewBufferPool(50), "./", []byte("fedcba9876543210"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, op, err := Parse(c, "select name from secrets")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	iter, err := op.Iterator(tid)
+	if err == nil {
+		_, err = iter()
+	}
+	c.bp.CommitTransaction(tid)
+	if e, ok := err.(GoDBError); !ok || e.code != CorruptPageError {
+		t.Errorf("expected a corrupt page error, got %v", err)
+	}
+	report, err = c.Fsck(true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) == 0 || report.Quarantined != 0 {
+		t.Errorf("expected problems but nothing quarantined, got %s", report.String())
+	}
+	if _, err := os.Stat(quarantineFileName(tableFile)); err == nil {
+		t.Errorf("expected no quarantine file")
+	}
+}
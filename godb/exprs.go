@@ -3,6 +3,8 @@ package godb
 import (
 	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -48,6 +50,54 @@ func (c *ConstExpr) EvalExpr(_ *Tuple) (DBValue, error) {
 	return c.val, nil
 }
 
+// CastExpr converts the value of a child expression to another type, either
+// because the query asked for it with CAST(expr AS type) or because the
+// planner inserted an implicit coercion (see [coerceExpr]).
+type CastExpr struct {
+	expr     Expr
+	castType DBType
+}
+
+func (c *CastExpr) GetExprType() FieldType {
+	ft := c.expr.GetExprType()
+	return FieldType{ft.Fname, ft.TableQualifier, c.castType}
+}
+
+func (c *CastExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	val, err := c.expr.EvalExpr(t)
+	if err != nil {
+		return nil, err
+	}
+	return castValue(val, c.castType)
+}
+
+// Convert a single value to the specified type.  Ints always convert to their
+// decimal string representation; strings convert to ints only if they contain
+// a (possibly space padded) decimal integer.
+func castValue(val DBValue, to DBType) (DBValue, error) {
+	switch v := val.(type) {
+	case IntField:
+		switch to {
+		case IntType:
+			return v, nil
+		case StringType:
+			return StringField{strconv.FormatInt(v.Value, 10)}, nil
+		}
+	case StringField:
+		switch to {
+		case StringType:
+			return v, nil
+		case IntType:
+			i, err := strconv.ParseInt(strings.TrimSpace(v.Value), 10, 64)
+			if err != nil {
+				return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot cast string '%s' to int", v.Value)}
+			}
+			return IntField{i}, nil
+		}
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot cast %v to %s", val, typeNames[to])}
+}
+
 type FuncExpr struct {
 	op   string
 	args []*Expr
@@ -221,13 +271,8 @@ func (f *FuncExpr) EvalExpr(t *Tuple) (DBValue, error) {
 	argvals := make([]any, len(fType.argTypes))
 	for i, argType := range fType.argTypes {
 		arg := *f.args[i]
-		if arg.GetExprType().Ftype != argType {
-			typeName := "string"
-			switch argType {
-			case IntType:
-				typeName = "int"
-			}
-			return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected arg of type %s", f.op, typeName)}
+		if argTy := arg.GetExprType().Ftype; argTy != argType {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("function %s expected arg %d of type %s, got %s", f.op, i+1, typeNames[argType], typeNames[argTy])}
 		}
 		val, err := arg.EvalExpr(t)
 		if err != nil {
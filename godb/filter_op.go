@@ -58,7 +58,13 @@ func (f *Filter[T]) Descriptor() *TupleDesc {
 // HINT: you can use the evalPred function defined in types.go to compare two values
 func (f *Filter[T]) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
-	iter, err := f.child.Iterator(tid)
+	return f.runtimeFilteredIterator(nil, tid)
+}
+
+// Return an iterator over the tuples of the filter, passing the runtime
+// filters on to its child
+func (f *Filter[T]) runtimeFilteredIterator(filters []runtimeFilter, tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := f.childIterator(filters, tid)
 	if err != nil {
 		return nil, err
 	}
@@ -82,3 +88,43 @@ func (f *Filter[T]) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 		}
 	}, nil
 }
+
+// Return an iterator over the child of the filter, passing runtime filters on
+// to it if it can apply them.  If the filter compares a column of a heap file
+// to a constant, the predicate is pushed into the scan of the file so it can
+// skip pages that have no matching tuples.
+func (f *Filter[T]) childIterator(filters []runtimeFilter, tid TransactionID) (func() (*Tuple, error), error) {
+	if hf, ok := f.child.(*HeapFile); ok {
+		var preds []zonePredicate
+		if pred, ok := f.zonePredicate(hf.desc); ok {
+			preds = append(preds, pred)
+		}
+		return hf.iteratorWhere(preds, filters, tid)
+	}
+	if child, ok := f.child.(runtimeFilterable); ok && len(filters) > 0 {
+		return child.runtimeFilteredIterator(filters, tid)
+	}
+	return f.child.Iterator(tid)
+}
+
+// Return the predicate of the filter as a zonePredicate on tuples of desc, if
+// it compares a field of desc to a constant
+func (f *Filter[T]) zonePredicate(desc *TupleDesc) (zonePredicate, bool) {
+	field, ok := f.left.(*FieldExpr)
+	if !ok {
+		return zonePredicate{}, false
+	}
+	constant, ok := f.right.(*ConstExpr)
+	if !ok {
+		return zonePredicate{}, false
+	}
+	idx, err := findFieldInTd(field.selectField, desc)
+	if err != nil {
+		return zonePredicate{}, false
+	}
+	value, ok := constant.val.(DBValue)
+	if !ok || !valueHasType(value, desc.Fields[idx].Ftype) {
+		return zonePredicate{}, false
+	}
+	return zonePredicate{idx, f.op, value}, true
+}
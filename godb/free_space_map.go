@@ -0,0 +1,118 @@
+package godb
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// A freeSpaceMap records, for every page of a heap file, how many free tuple
+// slots the page has, so that inserts can go straight to a page with room
+// instead of scanning (and locking) every page of the file.
+//
+// The map is stored in a side file next to the heap file (fileName + ".fsm")
+// with one little endian uint16 per page.  Entries are written when a page is
+// flushed, so the persisted map describes what is on disk; the in-memory copy
+// is also updated as transactions insert and delete tuples.  Either way an
+// entry is only a hint: callers must check the page itself (under a lock)
+// before relying on it.
+type freeSpaceMap struct {
+	sync.Mutex
+	file File
+	free []int
+}
+
+// Marks a page whose free space is not known, e.g., because it was added to the
+// heap file by another HeapFile instance, or because the map was lost.  Such
+// pages are treated as possibly having room.
+const fsmUnknown = 0xFFFF
+
+const fsmEntrySize = 2
+
+func fsmFileName(heapFileName string) string {
+	return heapFileName + ".fsm"
+}
+
+// Open (or create) the free space map for a heap file with numPages pages.
+// Entries past the end of the heap file are discarded, and missing entries are
+// marked unknown.
+func openFreeSpaceMap(vfs VFS, heapFileName string, numPages int) (*freeSpaceMap, error) {
+	file, err := vfs.OpenFile(fsmFileName(heapFileName), os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entries := int(stat.Size()) / fsmEntrySize
+	if entries > numPages {
+		entries = numPages
+		err = file.Truncate(int64(numPages * fsmEntrySize))
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf := make([]byte, entries*fsmEntrySize)
+	_, err = file.ReadAt(buf, 0)
+	if err != nil && entries > 0 {
+		return nil, err
+	}
+	m := &freeSpaceMap{file: file, free: make([]int, numPages)}
+	for i := range m.free {
+		m.free[i] = fsmUnknown
+		if i < entries {
+			m.free[i] = int(binary.LittleEndian.Uint16(buf[i*fsmEntrySize:]))
+		}
+	}
+	return m, nil
+}
+
+// Return the first page at or after start that may have a free slot, or -1
+// if there is none.  numPages is the current length of the heap file, which
+// may have grown since the map was loaded.
+func (m *freeSpaceMap) findPage(start int, numPages int) int {
+	m.Lock()
+	defer m.Unlock()
+	for len(m.free) < numPages {
+		m.free = append(m.free, fsmUnknown)
+	}
+	for pageNo := start; pageNo < numPages; pageNo++ {
+		if m.free[pageNo] != 0 {
+			return pageNo
+		}
+	}
+	return -1
+}
+
+// Record the number of free slots on a page in memory only
+func (m *freeSpaceMap) update(pageNo int, free int) {
+	m.Lock()
+	defer m.Unlock()
+	for len(m.free) <= pageNo {
+		m.free = append(m.free, fsmUnknown)
+	}
+	m.free[pageNo] = free
+}
+
+// Record the number of free slots on a page, and write the entry to the map
+// file.  Called when the page itself is written to disk.
+func (m *freeSpaceMap) persist(pageNo int, free int) error {
+	m.update(pageNo, free)
+	buf := make([]byte, fsmEntrySize)
+	binary.LittleEndian.PutUint16(buf, uint16(free))
+	_, err := m.file.WriteAt(buf, int64(pageNo*fsmEntrySize))
+	return err
+}
+
+// Forget all pages at or after numPages, e.g., after the heap file has been
+// truncated
+func (m *freeSpaceMap) truncate(numPages int) error {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.free) > numPages {
+		m.free = m.free[:numPages]
+	}
+	return m.file.Truncate(int64(numPages * fsmEntrySize))
+}
@@ -0,0 +1,84 @@
+package godb
+
+import (
+	"testing"
+)
+
+// fill the first three pages of the test heap file and commit them
+func fillThreePages(t *testing.T, hf *HeapFile, bp *BufferPool, tup *Tuple) {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for hf.NumPages() < 3 || hf.fsm.findPage(2, 3) != -1 {
+		err := hf.insertTuple(&Tuple{tup.Desc, tup.Fields, nil}, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestFreeSpaceMapInsertLocksOnePage(t *testing.T) {
+	_, t1, _, hf, bp, _ := makeTestVars()
+	fillThreePages(t, hf, bp, &t1)
+
+	// delete a tuple from the middle page, then insert into the file;  the
+	// insert should go to that page without touching the others
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ := hf.Iterator(tid)
+	var victim *Tuple
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		if tup.Rid.(heapFileRID).pageNum == 1 {
+			victim = tup
+			break
+		}
+	}
+	if victim == nil {
+		t.Fatalf("no tuple found on page 1")
+	}
+	err := hf.deleteTuple(victim, tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.CommitTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	err = hf.insertTuple(&t1, tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if rid := t1.Rid.(heapFileRID); rid.pageNum != 1 {
+		t.Errorf("expected insert into page 1, got page %d", rid.pageNum)
+	}
+	if len(bp.tidMap[tid]) != 1 {
+		t.Errorf("expected insert to fetch 1 page, fetched %v", bp.tidMap[tid])
+	}
+	bp.CommitTransaction(tid)
+	if hf.NumPages() != 3 {
+		t.Errorf("expected 3 pages, got %d", hf.NumPages())
+	}
+}
+
+func TestFreeSpaceMapPersists(t *testing.T) {
+	td, t1, _, hf, bp, _ := makeTestVars()
+	fillThreePages(t, hf, bp, &t1)
+
+	hf2, err := NewHeapFile(TestingFile, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(hf2.fsm.free) != 3 {
+		t.Fatalf("expected 3 entries in reopened free space map, got %d", len(hf2.fsm.free))
+	}
+	if p := hf2.fsm.findPage(0, hf2.NumPages()); p != -1 {
+		t.Errorf("expected no free pages, got page %d (%v)", p, hf2.fsm.free)
+	}
+}
@@ -0,0 +1,956 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"golang.org/x/exp/slices"
+)
+
+// Offline integrity checking of the heap files and indexes named in a
+// catalog.  The checker reads files directly rather than through the buffer
+// pool, so it should only be run when no transactions are using the database.
+
+// A problem found by [Catalog.Fsck].  PageNo is -1 for problems that concern
+// a file as a whole.
+type FsckProblem struct {
+	Table   string
+	File    string
+	PageNo  int
+	Problem string
+}
+
+func (p FsckProblem) String() string {
+	if p.PageNo < 0 {
+		return fmt.Sprintf("%s (%s): %s", p.Table, p.File, p.Problem)
+	}
+	return fmt.Sprintf("%s (%s) page %d: %s", p.Table, p.File, p.PageNo, p.Problem)
+}
+
+type FsckReport struct {
+	Tables      int
+	Indexes     int
+	Pages       int
+	Tuples      int
+	Problems    []FsckProblem
+	Quarantined int //number of bad pages moved to quarantine files
+}
+
+func (r *FsckReport) String() string {
+	out := fmt.Sprintf("checked %d tables, %d indexes, %d pages, %d tuples\n", r.Tables, r.Indexes, r.Pages, r.Tuples)
+	for _, p := range r.Problems {
+		out += "  " + p.String() + "\n"
+	}
+	if len(r.Problems) == 0 {
+		out += "no problems found\n"
+	} else {
+		out += fmt.Sprintf("%d problems found", len(r.Problems))
+		if r.Quarantined > 0 {
+			out += fmt.Sprintf(", %d pages quarantined", r.Quarantined)
+		}
+		out += "\n"
+	}
+	return out
+}
+
+// Name of the file that bad pages of a heap file are copied to when they are
+// quarantined.  Each entry is the page number as an int64 followed by the
+// page as it was found, which is the table's page size.  A page of a
+// compressed file that can't be decompressed is saved as it was stored, cut
+// off or padded with zeros to the page size.  If the file is encrypted, so are the pages
+// saved from it, and pages that fail authentication are never quarantined,
+// since that is also what reading them with the wrong key looks like.
+func quarantineFileName(heapFileName string) string {
+	return heapFileName + ".quarantine"
+}
+
+// Check every heap file and index named in the catalog.  If quarantine is
+// true, each heap page that fails its checks is appended to the table's
+// quarantine file and replaced by an empty page, so that the rest of the
+// table remains readable.  Indexes are never repaired in place;  a damaged
+// index, or one left pointing at quarantined tuples, can be dropped and
+// created again.
+func (c *Catalog) Fsck(quarantine bool) (*FsckReport, error) {
+	report := &FsckReport{}
+	for _, t := range c.tables {
+		indexes := c.tableIndexes(t.name)
+		var tuples map[heapFileRID]*Tuple
+		if len(indexes) > 0 {
+			tuples = make(map[heapFileRID]*Tuple)
+		}
+		var err error
+		if t.clusterKey != "" {
+			err = fsckClusteredFile(c.bp.vfs, t, c.tableNameToFile(t.name), c.cipher, report)
+		} else if t.columnar {
+			err = fsckColumnarFile(c.bp.vfs, t, c.tableNameToFile(t.name), c.cipher, report)
+		} else {
+			for _, fileName := range c.tableFileNames(t) {
+				err = fsckHeapFile(c.bp.vfs, t.name, fileName, &t.desc, c.pageSize, c.cipher, quarantine, report, tuples)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Tables++
+		for _, idx := range indexes {
+			check := fsckBTreeFile
+			if idx.kind == HashIndex {
+				check = fsckHashFile
+			} else if idx.kind == BloomIndex {
+				check = fsckBloomFile
+			} else if idx.kind == FullTextIndex {
+				check = fsckTextFile
+			}
+			err := check(c.bp.vfs, idx, c.indexFile(idx), &t.desc, c.cipher, tuples, report)
+			if err != nil {
+				return report, err
+			}
+			report.Indexes++
+		}
+	}
+	return report, nil
+}
+
+// Check a heap file.  If tuples is not nil, the tuples of the file's good
+// pages are added to it.
+func fsckHeapFile(vfs VFS, table string, fileName string, desc *TupleDesc, pageSize int, cipher *pageCipher, quarantine bool, report *FsckReport, tuples map[heapFileRID]*Tuple) error {
+	problem := func(pageNo int, format string, args ...any) {
+		report.Problems = append(report.Problems, FsckProblem{table, fileName, pageNo, fmt.Sprintf(format, args...)})
+	}
+	file, err := vfs.OpenFile(fileName, os.O_RDWR, fs.ModePerm)
+	if os.IsNotExist(err) {
+		// tables that have never had a tuple inserted have no file
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header, err := readHeapFileHeader(file, fileName)
+	if err != nil {
+		problem(-1, "%s", err.Error())
+		return nil
+	}
+	if header == nil {
+		// a file in format version 0 isn't damaged, so its pages are left
+		// alone
+		if stat, err := file.Stat(); err == nil && stat.Size() > 0 {
+			problem(-1, "file is in format version 0, and must be upgraded with UpgradeHeapFile")
+		}
+		return nil
+	}
+	flags := uint32(0)
+	if cipher != nil {
+		flags |= heapFileEncrypted
+	}
+	if _, err := vfs.Stat(pageMapFileName(fileName)); err == nil {
+		flags |= heapFileCompressed
+	}
+	err = header.check(fileName, desc, pageSize, flags)
+	if err != nil {
+		problem(-1, "%s", err.Error())
+		return nil
+	}
+	firstSlot := 1 // page 0 follows the header
+	pages, err := openPageMap(vfs, fileName, "", pageSize, cipher)
+	if err != nil {
+		problem(-1, "bad page map: %s", err.Error())
+		return nil
+	}
+	var numPages int
+	if pages != nil {
+		numPages = pages.numPages()
+	} else {
+		stat, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		size := cipher.storedSize(pageSize)
+		if stat.Size()%int64(size) != 0 {
+			problem(-1, "file size %d is not a multiple of the page size %d", stat.Size(), size)
+		}
+		numPages = int(stat.Size())/size - firstSlot
+	}
+	fsm := readFsckFreeSpaceMap(vfs, fileName)
+	if len(fsm) > numPages {
+		problem(-1, "free space map has %d entries, file has %d pages", len(fsm), numPages)
+	}
+
+	for pageNo := 0; pageNo < numPages; pageNo++ {
+		report.Pages++
+		buf, reason, err := fsckReadHeapPage(file, pages, cipher, pageSize, firstSlot, pageNo)
+		if err != nil {
+			return err
+		}
+		var slots []*Tuple
+		if reason == "" {
+			slots, reason = checkHeapPage(buf, desc)
+		}
+		if reason == "" {
+			usedSlots := 0
+			for slot, t := range slots {
+				if t == nil {
+					continue
+				}
+				usedSlots++
+				if tuples != nil {
+					tuples[heapFileRID{pageNo, slot}] = t
+				}
+			}
+			report.Tuples += usedSlots
+			free := calNumSlot(desc, pageSize) - usedSlots
+			if pageNo < len(fsm) && fsm[pageNo] != fsmUnknown && fsm[pageNo] != free {
+				problem(pageNo, "free space map records %d free slots, page has %d", fsm[pageNo], free)
+			}
+			continue
+		}
+		problem(pageNo, "%s", reason)
+		if quarantine && buf != nil {
+			err = quarantinePage(vfs, file, pages, cipher, fileName, firstSlot, pageNo, buf, desc)
+			if err != nil {
+				return err
+			}
+			report.Quarantined++
+		}
+	}
+	return nil
+}
+
+// Read a page of a heap file, decrypting it if cipher is not nil and
+// decompressing it if pages is not nil.  Uncompressed pages are stored from
+// slot firstSlot of the file on.  If the page can't be read, returns the
+// reason, along with the page as it was found if it may be quarantined.
+func fsckReadHeapPage(file File, pages *pageMap, cipher *pageCipher, pageSize int, firstSlot int, pageNo int) ([]byte, string, error) {
+	buf := make([]byte, pageSize)
+	var compressed []byte
+	var err error
+	if pages == nil {
+		buf, err = readFilePage(file, cipher, pageSize, pageNo+firstSlot)
+	} else {
+		compressed, err = pages.readCompressed(file, pageNo)
+	}
+	if err == errPageAuthentication {
+		return nil, err.(GoDBError).errString, nil
+	}
+	if pages == nil {
+		return buf, "", err
+	}
+	if e, ok := err.(GoDBError); ok {
+		return buf, e.errString, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	page, err := pages.codec.decompress(compressed, pageSize)
+	if e, ok := err.(GoDBError); ok {
+		copy(buf, compressed)
+		return buf, e.errString, nil
+	}
+	return page, "", err
+}
+
+// Check a single serialized heap page, returning its slots (nil for unused
+// slots), or a description of what is wrong with it.
+func checkHeapPage(buf []byte, desc *TupleDesc) ([]*Tuple, string) {
+	if !verifyPageChecksum(buf) {
+		return nil, "checksum mismatch"
+	}
+	numSlots := int(int32(binary.LittleEndian.Uint32(buf[0:])))
+	usedSlots := int(int32(binary.LittleEndian.Uint32(buf[4:])))
+	if expected := calNumSlot(desc, len(buf)); numSlots != expected {
+		return nil, fmt.Sprintf("header has %d slots, expected %d for the table's schema", numSlots, expected)
+	}
+	if usedSlots < 0 || usedSlots > numSlots {
+		return nil, fmt.Sprintf("header has %d used slots out of %d", usedSlots, numSlots)
+	}
+	bitmap := buf[heapPageHeaderSize : heapPageHeaderSize+slotBitmapSize(numSlots)]
+	b := bytes.NewBuffer(buf[heapPageHeaderSize+slotBitmapSize(numSlots):])
+	bytesPerTuple := calBytesPerTuple(desc)
+	slots := make([]*Tuple, numSlots)
+	marked := 0
+	for i := 0; i < len(bitmap)*8; i++ {
+		inUse := bitmap[i/8]&(1<<(i%8)) != 0
+		if i >= numSlots {
+			if inUse {
+				return nil, fmt.Sprintf("slot bitmap marks slot %d in use, page only has %d slots", i, numSlots)
+			}
+			continue
+		}
+		if !inUse {
+			if !allZero(b.Next(bytesPerTuple)) {
+				return nil, fmt.Sprintf("unused slot %d is not empty", i)
+			}
+			continue
+		}
+		t, err := readTupleFrom(b, desc)
+		if err != nil {
+			return nil, fmt.Sprintf("tuple %d can't be decoded: %s", i, err.Error())
+		}
+		slots[i] = t
+		marked++
+	}
+	if marked != usedSlots {
+		return nil, fmt.Sprintf("header has %d used slots, slot bitmap has %d", usedSlots, marked)
+	}
+	if !allZero(b.Bytes()) {
+		return nil, "unused space after the last slot is not empty"
+	}
+	return slots, ""
+}
+
+func allZero(buf []byte) bool {
+	for _, c := range buf {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy a bad page to the quarantine file and overwrite it with an empty page.
+// pages is the page map of the file if it is compressed, or nil, and cipher
+// encrypts its pages, unless it is nil.  Uncompressed pages are stored from
+// slot firstSlot of the file on.
+func quarantinePage(vfs VFS, file File, pages *pageMap, cipher *pageCipher, fileName string, firstSlot int, pageNo int, buf []byte, desc *TupleDesc) error {
+	q, err := vfs.OpenFile(quarantineFileName(fileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	err = binary.Write(q, binary.LittleEndian, int64(pageNo))
+	if err != nil {
+		return err
+	}
+	saved, err := cipher.seal(pageNo, buf)
+	if err != nil {
+		return err
+	}
+	_, err = q.Write(saved)
+	if err != nil {
+		return err
+	}
+	empty, err := newSizedHeapPage(desc, pageNo, len(buf), nil).toBuffer()
+	if err != nil {
+		return err
+	}
+	if pages != nil {
+		err = pages.writePage(file, pageNo, empty.Bytes())
+	} else {
+		err = writeFilePage(file, cipher, pageNo+firstSlot, empty.Bytes())
+	}
+	if err != nil {
+		return err
+	}
+	fsm, err := vfs.OpenFile(fsmFileName(fileName), os.O_WRONLY, fs.ModePerm)
+	if err != nil {
+		return nil // no free space map to fix up
+	}
+	defer fsm.Close()
+	entry := make([]byte, fsmEntrySize)
+	binary.LittleEndian.PutUint16(entry, uint16(calNumSlot(desc, len(buf))))
+	_, err = fsm.WriteAt(entry, int64(pageNo*fsmEntrySize))
+	return err
+}
+
+// Read the raw entries of a heap file's free space map, or nil if it has none
+func readFsckFreeSpaceMap(vfs VFS, fileName string) []int {
+	data, err := readVFSFile(vfs, fsmFileName(fileName))
+	if err != nil {
+		return nil
+	}
+	entries := make([]int, len(data)/fsmEntrySize)
+	for i := range entries {
+		entries[i] = int(binary.LittleEndian.Uint16(data[i*fsmEntrySize:]))
+	}
+	return entries
+}
+
+// Read the pages of a file of PageSize pages, decrypting them if cipher is
+// not nil.  Pages that fail authentication are reported as problems and left
+// nil.
+func fsckReadPages(vfs VFS, fileName string, cipher *pageCipher, problem func(int, string, ...any)) ([][]byte, error) {
+	data, err := readVFSFile(vfs, fileName)
+	if err != nil {
+		return nil, err
+	}
+	size := cipher.storedSize(PageSize)
+	if len(data)%size != 0 {
+		problem(-1, "file size %d is not a multiple of the page size %d", len(data), size)
+	}
+	pages := make([][]byte, len(data)/size)
+	for pageNo := range pages {
+		page, err := cipher.open(pageNo, data[pageNo*size:(pageNo+1)*size])
+		if err != nil {
+			problem(pageNo, "%s", err.(GoDBError).errString)
+			continue
+		}
+		pages[pageNo] = page
+	}
+	return pages, nil
+}
+
+// Check the structure of a B+tree index, and that it has exactly one entry,
+// with the right key, for each of the tuples of the table it indexes.
+func fsckBTreeFile(vfs VFS, idx *Index, fileName string, desc *TupleDesc, cipher *pageCipher, tuples map[heapFileRID]*Tuple, report *FsckReport) error {
+	problem := func(pageNo int, format string, args ...any) {
+		report.Problems = append(report.Problems, FsckProblem{idx.table, fileName, pageNo, fmt.Sprintf(format, args...)})
+	}
+	keyIndex, err := findFieldInTd(FieldType{idx.column, "", UnknownType}, desc)
+	if err != nil {
+		problem(-1, "index %s is on unknown column %s", idx.name, idx.column)
+		return nil
+	}
+	pageData, err := fsckReadPages(vfs, fileName, cipher, problem)
+	if os.IsNotExist(err) {
+		problem(-1, "index %s has no file", idx.name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	numPages := len(pageData)
+	keyDesc := &TupleDesc{Fields: []FieldType{desc.Fields[keyIndex]}}
+	readPage := func(pageNo int) *btreePage {
+		if pageNo < 0 || pageNo >= numPages {
+			problem(-1, "reference to page %d, file has %d pages", pageNo, numPages)
+			return nil
+		}
+		report.Pages++
+		buf := pageData[pageNo]
+		if buf == nil {
+			return nil
+		}
+		if !verifyPageChecksum(buf) {
+			problem(pageNo, "checksum mismatch")
+			return nil
+		}
+		p := newBTreePage(nil, pageNo, 0)
+		err := p.initFromBuffer(bytes.NewBuffer(buf), keyDesc)
+		if err != nil {
+			problem(pageNo, "%s", err.Error())
+			return nil
+		}
+		return p
+	}
+
+	meta := readPage(0)
+	if meta == nil {
+		return nil
+	}
+	if meta.kind != btreeMetaPage {
+		problem(0, "expected the meta page, found page kind %d", meta.kind)
+		return nil
+	}
+
+	// walk the tree, checking that entries are sorted and fall within the
+	// range their parents give them, and that all leaves are at one depth
+	var leaves []*btreePage
+	visited := make(map[int]bool)
+	leafDepth := -1
+	var walk func(pageNo int, lo *indexEntry, hi *indexEntry, depth int)
+	walk = func(pageNo int, lo *indexEntry, hi *indexEntry, depth int) {
+		if visited[pageNo] {
+			problem(pageNo, "page is reachable more than once")
+			return
+		}
+		visited[pageNo] = true
+		p := readPage(pageNo)
+		if p == nil {
+			return
+		}
+		for i, e := range p.entries {
+			if i > 0 && compareEntries(p.entries[i-1], e) >= 0 {
+				problem(pageNo, "entries %d and %d are out of order", i-1, i)
+				return
+			}
+			if (lo != nil && compareEntries(e, *lo) < 0) || (hi != nil && compareEntries(e, *hi) >= 0) {
+				problem(pageNo, "entry %d is outside the key range of its parent", i)
+				return
+			}
+		}
+		switch p.kind {
+		case btreeLeafPage:
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				problem(pageNo, "leaf at depth %d, other leaves are at depth %d", depth, leafDepth)
+			}
+			leaves = append(leaves, p)
+		case btreeInternalPage:
+			for i, child := range p.children {
+				childLo, childHi := lo, hi
+				if i > 0 {
+					childLo = &p.entries[i-1]
+				}
+				if i < len(p.entries) {
+					childHi = &p.entries[i]
+				}
+				walk(child, childLo, childHi, depth+1)
+			}
+		default:
+			problem(pageNo, "meta page found inside the tree")
+		}
+	}
+	walk(meta.link, nil, nil, 0)
+
+	indexed := make(map[heapFileRID]bool)
+	for i, leaf := range leaves {
+		next := btreeNoPage
+		if i+1 < len(leaves) {
+			next = leaves[i+1].pageNo
+		}
+		if leaf.link != next {
+			problem(leaf.pageNo, "next leaf is page %d, expected %d", leaf.link, next)
+		}
+		checkIndexEntries(leaf.pageNo, leaf.entries, keyIndex, tuples, indexed, problem)
+	}
+	checkAllIndexed(idx, tuples, indexed, problem)
+	return nil
+}
+
+// Check the tree of a clustered table:  that the tuples are in strictly
+// increasing key order, both within leaves and along the chain of leaves,
+// that they fall within the key ranges of their parents, and that all leaves
+// are at one depth.  Clustered files are never quarantined.
+func fsckClusteredFile(vfs VFS, t *Table, fileName string, cipher *pageCipher, report *FsckReport) error {
+	problem := func(pageNo int, format string, args ...any) {
+		report.Problems = append(report.Problems, FsckProblem{t.name, fileName, pageNo, fmt.Sprintf(format, args...)})
+	}
+	keyIndex, err := findFieldInTd(FieldType{t.clusterKey, "", UnknownType}, &t.desc)
+	if err != nil {
+		problem(-1, "table is clustered on unknown column %s", t.clusterKey)
+		return nil
+	}
+	pageData, err := fsckReadPages(vfs, fileName, cipher, problem)
+	if os.IsNotExist(err) {
+		// tables that have never been opened have no file
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	numPages := len(pageData)
+	readPage := func(pageNo int) *clusteredPage {
+		if pageNo < 0 || pageNo >= numPages {
+			problem(-1, "reference to page %d, file has %d pages", pageNo, numPages)
+			return nil
+		}
+		report.Pages++
+		buf := pageData[pageNo]
+		if buf == nil {
+			return nil
+		}
+		if !verifyPageChecksum(buf) {
+			problem(pageNo, "checksum mismatch")
+			return nil
+		}
+		p := newClusteredPage(nil, pageNo, 0)
+		err := p.initFromBuffer(bytes.NewBuffer(buf), &t.desc, keyIndex)
+		if err != nil {
+			problem(pageNo, "%s", err.Error())
+			return nil
+		}
+		return p
+	}
+
+	meta := readPage(0)
+	if meta == nil {
+		return nil
+	}
+	if meta.kind != btreeMetaPage {
+		problem(0, "expected the meta page, found page kind %d", meta.kind)
+		return nil
+	}
+
+	var leaves []*clusteredPage
+	visited := make(map[int]bool)
+	leafDepth := -1
+	var walk func(pageNo int, lo DBValue, hi DBValue, depth int)
+	walk = func(pageNo int, lo DBValue, hi DBValue, depth int) {
+		if visited[pageNo] {
+			problem(pageNo, "page is reachable more than once")
+			return
+		}
+		visited[pageNo] = true
+		p := readPage(pageNo)
+		if p == nil {
+			return
+		}
+		keys := p.keys
+		if p.kind == btreeLeafPage {
+			keys = nil
+			for _, t := range p.tuples {
+				keys = append(keys, t.Fields[keyIndex])
+			}
+		}
+		for i, key := range keys {
+			if i > 0 && compareKeys(keys[i-1], key) >= 0 {
+				problem(pageNo, "keys %d and %d are out of order", i-1, i)
+				return
+			}
+			if (lo != nil && compareKeys(key, lo) < 0) || (hi != nil && compareKeys(key, hi) >= 0) {
+				problem(pageNo, "key %d is outside the key range of its parent", i)
+				return
+			}
+		}
+		switch p.kind {
+		case btreeLeafPage:
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				problem(pageNo, "leaf at depth %d, other leaves are at depth %d", depth, leafDepth)
+			}
+			leaves = append(leaves, p)
+			report.Tuples += len(p.tuples)
+		case btreeInternalPage:
+			for i, child := range p.children {
+				childLo, childHi := lo, hi
+				if i > 0 {
+					childLo = p.keys[i-1]
+				}
+				if i < len(p.keys) {
+					childHi = p.keys[i]
+				}
+				walk(child, childLo, childHi, depth+1)
+			}
+		default:
+			problem(pageNo, "meta page found inside the tree")
+		}
+	}
+	walk(meta.link, nil, nil, 0)
+
+	for i, leaf := range leaves {
+		next := btreeNoPage
+		if i+1 < len(leaves) {
+			next = leaves[i+1].pageNo
+		}
+		if leaf.link != next {
+			problem(leaf.pageNo, "next leaf is page %d, expected %d", leaf.link, next)
+		}
+	}
+	return nil
+}
+
+// Check a columnar table:  that every page decodes, that each row group has
+// a group page followed by a page per column, and that every column of a
+// row group has a value for each of its rows.
+func fsckColumnarFile(vfs VFS, t *Table, fileName string, cipher *pageCipher, report *FsckReport) error {
+	problem := func(pageNo int, format string, args ...any) {
+		report.Problems = append(report.Problems, FsckProblem{t.name, fileName, pageNo, fmt.Sprintf(format, args...)})
+	}
+	pageData, err := fsckReadPages(vfs, fileName, cipher, problem)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	groupSize := len(t.desc.Fields) + 1
+	if len(pageData)%groupSize != 0 {
+		problem(-1, "file has %d pages, not a multiple of the row group size %d", len(pageData), groupSize)
+	}
+	numPages := len(pageData)
+	for g := 0; g+groupSize <= numPages; g += groupSize {
+		rows := -1
+		for col := -1; col < len(t.desc.Fields); col++ {
+			pageNo := g + col + 1
+			report.Pages++
+			buf := pageData[pageNo]
+			if buf == nil {
+				continue
+			}
+			if !verifyPageChecksum(buf) {
+				problem(pageNo, "checksum mismatch")
+				continue
+			}
+			var ftype DBType
+			if col >= 0 {
+				ftype = t.desc.Fields[col].Ftype
+			}
+			p := newColumnarColumnPage(nil, pageNo, ftype)
+			err := p.initFromBuffer(bytes.NewBuffer(buf), ftype)
+			if err != nil {
+				problem(pageNo, "%s", err.Error())
+				continue
+			}
+			if col < 0 {
+				if p.kind != columnarGroupPage {
+					problem(pageNo, "expected a group page, found page kind %d", p.kind)
+					break
+				}
+				rows = p.rows
+				for _, deleted := range p.deleted {
+					if !deleted {
+						report.Tuples++
+					}
+				}
+				continue
+			}
+			if p.kind != columnarColumnPage {
+				problem(pageNo, "expected a page of column %s, found page kind %d", t.desc.Fields[col].Fname, p.kind)
+			} else if rows >= 0 && len(p.values) != rows {
+				problem(pageNo, "column %s has %d values, row group has %d rows", t.desc.Fields[col].Fname, len(p.values), rows)
+			}
+		}
+	}
+	return nil
+}
+
+// Check that each entry of an index page matches a tuple with the same key,
+// recording the tuples that have entries in indexed
+func checkIndexEntries(pageNo int, entries []indexEntry, keyIndex int, tuples map[heapFileRID]*Tuple, indexed map[heapFileRID]bool, problem func(int, string, ...any)) {
+	for _, e := range entries {
+		if indexed[e.rid] {
+			problem(pageNo, "second entry for page %d slot %d", e.rid.pageNum, e.rid.slotNum)
+		}
+		indexed[e.rid] = true
+		t, ok := tuples[e.rid]
+		if !ok {
+			problem(pageNo, "entry for page %d slot %d doesn't match a tuple", e.rid.pageNum, e.rid.slotNum)
+			continue
+		}
+		if compareKeys(t.Fields[keyIndex], e.key) != 0 {
+			problem(pageNo, "entry for page %d slot %d has a different key than the tuple", e.rid.pageNum, e.rid.slotNum)
+		}
+	}
+}
+
+// Check that every tuple has an entry in the index
+func checkAllIndexed(idx *Index, tuples map[heapFileRID]*Tuple, indexed map[heapFileRID]bool, problem func(int, string, ...any)) {
+	missing := 0
+	for rid := range tuples {
+		if !indexed[rid] {
+			missing++
+		}
+	}
+	if missing > 0 {
+		problem(-1, "%d tuples have no entry in index %s", missing, idx.name)
+	}
+}
+
+// Check the structure of a hash index:  that the directory is consistent
+// with the local depths of the buckets, and every key is in the bucket its
+// hash belongs in.  As for b+tree indexes, also check that the index has
+// exactly one entry, with the right key, for each tuple of the table.
+func fsckHashFile(vfs VFS, idx *Index, fileName string, desc *TupleDesc, cipher *pageCipher, tuples map[heapFileRID]*Tuple, report *FsckReport) error {
+	problem := func(pageNo int, format string, args ...any) {
+		report.Problems = append(report.Problems, FsckProblem{idx.table, fileName, pageNo, fmt.Sprintf(format, args...)})
+	}
+	keyIndex, err := findFieldInTd(FieldType{idx.column, "", UnknownType}, desc)
+	if err != nil {
+		problem(-1, "index %s is on unknown column %s", idx.name, idx.column)
+		return nil
+	}
+	indexed := make(map[heapFileRID]bool)
+	keyDesc := &TupleDesc{Fields: []FieldType{desc.Fields[keyIndex]}}
+	complete, err := fsckHashPages(vfs, fileName, keyDesc, cipher, report, problem, func(pageNo int, entries []indexEntry) {
+		checkIndexEntries(pageNo, entries, keyIndex, tuples, indexed, problem)
+	})
+	if err != nil || !complete {
+		return err
+	}
+	checkAllIndexed(idx, tuples, indexed, problem)
+	return nil
+}
+
+// Check the structure of a hash file, passing the entries of each page of
+// each bucket to checkEntries.  Returns false if the directory couldn't be
+// read.
+func fsckHashPages(vfs VFS, fileName string, keyDesc *TupleDesc, cipher *pageCipher, report *FsckReport, problem func(int, string, ...any), checkEntries func(int, []indexEntry)) (bool, error) {
+	pageData, err := fsckReadPages(vfs, fileName, cipher, problem)
+	if os.IsNotExist(err) {
+		problem(-1, "index has no file")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	numPages := len(pageData)
+	visited := make(map[int]bool)
+	readPage := func(pageNo int) *hashPage {
+		if pageNo < 0 || pageNo >= numPages {
+			problem(-1, "reference to page %d, file has %d pages", pageNo, numPages)
+			return nil
+		}
+		if visited[pageNo] {
+			problem(pageNo, "page is reachable more than once")
+			return nil
+		}
+		visited[pageNo] = true
+		report.Pages++
+		buf := pageData[pageNo]
+		if buf == nil {
+			return nil
+		}
+		if !verifyPageChecksum(buf) {
+			problem(pageNo, "checksum mismatch")
+			return nil
+		}
+		p := newHashPage(nil, pageNo, 0, 0)
+		err := p.initFromBuffer(bytes.NewBuffer(buf), keyDesc)
+		if err != nil {
+			problem(pageNo, "%s", err.Error())
+			return nil
+		}
+		return p
+	}
+
+	meta := readPage(0)
+	if meta == nil {
+		return false, nil
+	}
+	if meta.kind != hashMetaPage {
+		problem(0, "expected the meta page, found page kind %d", meta.kind)
+		return false, nil
+	}
+
+	// check each bucket at the first directory entry that points to it, whose
+	// number is the low bits of the hash that all of the bucket's keys share
+	for i, bucketNo := range meta.dir {
+		if visited[bucketNo] {
+			continue
+		}
+		p := readPage(bucketNo)
+		if p == nil {
+			continue
+		}
+		if p.kind != hashBucketPage || p.depth > meta.depth {
+			problem(bucketNo, "directory entry %d points to a page of kind %d with depth %d", i, p.kind, p.depth)
+			continue
+		}
+		mask := 1<<p.depth - 1
+		for j, other := range meta.dir {
+			if (j&mask == i&mask) != (other == bucketNo) {
+				problem(0, "directory entry %d doesn't agree with the depth %d of bucket %d", j, p.depth, bucketNo)
+				break
+			}
+		}
+		for page := p; page != nil; {
+			for _, e := range page.entries {
+				if int(hashKey(e.key))&mask != i&mask {
+					problem(page.pageNo, "entry for page %d slot %d is in the wrong bucket", e.rid.pageNum, e.rid.slotNum)
+				}
+			}
+			checkEntries(page.pageNo, page.entries)
+			if page.next == hashNoPage {
+				break
+			}
+			page = readPage(page.next)
+		}
+	}
+	return true, nil
+}
+
+// Check a full-text index:  the structure of its hash file, and that it has
+// exactly the postings of the distinct terms of each tuple of the table
+func fsckTextFile(vfs VFS, idx *Index, fileName string, desc *TupleDesc, cipher *pageCipher, tuples map[heapFileRID]*Tuple, report *FsckReport) error {
+	problem := func(pageNo int, format string, args ...any) {
+		report.Problems = append(report.Problems, FsckProblem{idx.table, fileName, pageNo, fmt.Sprintf(format, args...)})
+	}
+	keyIndex, err := findFieldInTd(FieldType{idx.column, "", UnknownType}, desc)
+	if err != nil || desc.Fields[keyIndex].Ftype != StringType {
+		problem(-1, "index %s is on unknown or non-string column %s", idx.name, idx.column)
+		return nil
+	}
+	type posting struct {
+		term string
+		rid  heapFileRID
+	}
+	found := make(map[posting]bool)
+	keyDesc := &TupleDesc{Fields: []FieldType{{Fname: "term", Ftype: StringType}}}
+	complete, err := fsckHashPages(vfs, fileName, keyDesc, cipher, report, problem, func(pageNo int, entries []indexEntry) {
+		for _, e := range entries {
+			p := posting{e.key.(StringField).Value, e.rid}
+			if found[p] {
+				problem(pageNo, "second posting of %s for page %d slot %d", p.term, e.rid.pageNum, e.rid.slotNum)
+			}
+			found[p] = true
+			t, ok := tuples[e.rid]
+			if !ok {
+				problem(pageNo, "posting of %s for page %d slot %d doesn't match a tuple", p.term, e.rid.pageNum, e.rid.slotNum)
+				continue
+			}
+			if !slices.Contains(tokenize(t.Fields[keyIndex].(StringField).Value), p.term) {
+				problem(pageNo, "posting of %s for page %d slot %d, whose text doesn't contain it", p.term, e.rid.pageNum, e.rid.slotNum)
+			}
+		}
+	})
+	if err != nil || !complete {
+		return err
+	}
+	missing := 0
+	for rid, t := range tuples {
+		for _, term := range distinctTerms(t.Fields[keyIndex].(StringField).Value) {
+			if !found[posting{term, rid}] {
+				missing++
+			}
+		}
+	}
+	if missing > 0 {
+		problem(-1, "%d terms of tuples have no posting in index %s", missing, idx.name)
+	}
+	return nil
+}
+
+// Check a Bloom filter index:  that its pages are readable, and that the
+// filter of each heap page contains the keys of the page's tuples.  Filters
+// may contain keys of deleted tuples, so extra keys are not problems.
+func fsckBloomFile(vfs VFS, idx *Index, fileName string, desc *TupleDesc, cipher *pageCipher, tuples map[heapFileRID]*Tuple, report *FsckReport) error {
+	problem := func(pageNo int, format string, args ...any) {
+		report.Problems = append(report.Problems, FsckProblem{idx.table, fileName, pageNo, fmt.Sprintf(format, args...)})
+	}
+	keyIndex, err := findFieldInTd(FieldType{idx.column, "", UnknownType}, desc)
+	if err != nil {
+		problem(-1, "index %s is on unknown column %s", idx.name, idx.column)
+		return nil
+	}
+	pageData, err := fsckReadPages(vfs, fileName, cipher, problem)
+	if os.IsNotExist(err) {
+		problem(-1, "index %s has no file", idx.name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	numPages := len(pageData)
+	pages := make([]*bloomPage, numPages)
+	for pageNo := range pages {
+		report.Pages++
+		buf := pageData[pageNo]
+		if buf == nil {
+			continue
+		}
+		if !verifyPageChecksum(buf) {
+			problem(pageNo, "checksum mismatch")
+			continue
+		}
+		p := newBloomPage(nil, pageNo)
+		err := p.initFromBuffer(bytes.NewBuffer(buf))
+		if err != nil {
+			problem(pageNo, "%s", err.Error())
+			continue
+		}
+		pages[pageNo] = p
+	}
+	missing := 0
+	for rid, t := range tuples {
+		pageNo := rid.pageNum / bloomFiltersPerPage
+		if pageNo >= numPages {
+			missing++
+			continue
+		}
+		if pages[pageNo] != nil && !bloomMayContain(pages[pageNo].filters[rid.pageNum%bloomFiltersPerPage], t.Fields[keyIndex]) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		problem(-1, "%d tuples are missing from the filters of index %s", missing, idx.name)
+	}
+	return nil
+}
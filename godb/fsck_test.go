@@ -0,0 +1,62 @@
+package godb
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	bp := NewBufferPool(10)
+	err := MakeTestDatabaseEasy(bp)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf("failed load catalog, %s", err.Error())
+	}
+	os.Remove(quarantineFileName(c.tableNameToFile("t")))
+
+	report, err := c.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("expected no problems, got %s", report.String())
+	}
+	if report.Tables != 2 || report.Tuples != 24 {
+		t.Errorf("expected 2 tables with 24 tuples, got %s", report.String())
+	}
+
+	// corrupt the used slot count of t's first page
+	f, err := os.OpenFile(c.tableNameToFile("t"), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	f.WriteAt([]byte{0xff}, int64(PageSize+4))
+	f.Close()
+
+	report, err = c.Fsck(true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Table != "t" || report.Problems[0].PageNo != 0 {
+		t.Fatalf("expected one problem on page 0 of t, got %s", report.String())
+	}
+	if report.Quarantined != 1 {
+		t.Errorf("expected one quarantined page, got %d", report.Quarantined)
+	}
+	stat, err := os.Stat(quarantineFileName(c.tableNameToFile("t")))
+	if err != nil || stat.Size() != int64(PageSize+8) {
+		t.Errorf("expected quarantine file with one page")
+	}
+
+	// after quarantine the table is readable again, without the bad page's tuples
+	report, err = c.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 || report.Tuples != 12 {
+		t.Errorf("expected no problems and 12 tuples after quarantine, got %s", report.String())
+	}
+}
@@ -0,0 +1,503 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// HashFile is an extendible hash index over one column of a HeapFile.  Like
+// a [BTreeFile] it maps each key to the record ids of the heap tuples that
+// have that key, but it can only look up single keys, not ranges;  see
+// [hashPage] for its layout.
+//
+// Pages are read and locked through the BufferPool.  Every user of the index
+// read locks the meta page, which holds the directory.  Changes write lock
+// the pages of the bucket they touch, and upgrade to a write lock on the meta
+// page only if they split the bucket, which changes the directory.  Lookups
+// take read locks.  New pages are added to the buffer pool rather than
+// written to the file, so the pages of a change that aborts are never
+// written.
+//
+// As with a BTreeFile, the HeapFile passes each tuple it stores or removes to
+// insertTuple or deleteTuple, with Rid set.  As an Operator, a HashFile
+// returns one tuple per entry, bucket by bucket, with the key followed by the
+// page and slot of the heap tuple's record id.
+type HashFile struct {
+	bufPool *BufferPool
+	sync.Mutex
+	name     string // name of the index in the catalog
+	fileName string
+	file     File
+	cipher   *pageCipher // encrypts the pages of the file, or nil
+	keyIndex int         // position of the key in the heap file's tuples
+	desc     *TupleDesc  // key, rid page, rid slot
+	nextPage int         // the next page to allocate, unless the file is longer
+}
+
+// Open a HashFile, creating an empty index if the file doesn't exist yet.
+// Parameters
+// - fromFile: backing file for the index
+// - keyField: the indexed column of the heap file
+// - keyIndex: the position of keyField in the heap file's TupleDesc
+// - bp: the BufferPool that is used to store pages read from the index
+func NewHashFile(fromFile string, keyField FieldType, keyIndex int, bp *BufferPool) (*HashFile, error) {
+	return openHashFile(fromFile, keyField, keyIndex, nil, bp)
+}
+
+// Open a HashFile whose pages are encrypted with cipher, unless it is nil
+func openHashFile(fromFile string, keyField FieldType, keyIndex int, cipher *pageCipher, bp *BufferPool) (*HashFile, error) {
+	file, err := bp.vfs.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	f := &HashFile{
+		bufPool:  bp,
+		name:     fromFile,
+		fileName: fromFile,
+		file:     file,
+		cipher:   cipher,
+		keyIndex: keyIndex,
+		desc: &TupleDesc{Fields: []FieldType{
+			{Fname: keyField.Fname, Ftype: keyField.Ftype},
+			{Fname: "rid_page", Ftype: IntType},
+			{Fname: "rid_slot", Ftype: IntType},
+		}},
+	}
+	if f.NumPages() == 0 {
+		err = f.initEmptyIndex()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Write a meta page with a directory of one empty bucket to the start of the
+// file
+func (f *HashFile) initEmptyIndex() error {
+	meta := newHashPage(f, 0, hashMetaPage, 0)
+	meta.dir = []int{1}
+	for _, p := range []*hashPage{meta, newHashPage(f, 1, hashBucketPage, 0)} {
+		page := Page(p)
+		err := f.flushPage(&page)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the number of pages in the index file
+func (f *HashFile) NumPages() int {
+	return filePageCount(f.file, f.cipher, PageSize)
+}
+
+func (f *HashFile) keyType() DBType {
+	return f.desc.Fields[0].Ftype
+}
+
+func (f *HashFile) keyDesc() *TupleDesc {
+	return &TupleDesc{Fields: f.desc.Fields[:1]}
+}
+
+func (f *HashFile) indexName() string {
+	return f.name
+}
+
+func (f *HashFile) keyColumn() int {
+	return f.keyIndex
+}
+
+func (f *HashFile) readPage(pageNo int) (*Page, error) {
+	buf, err := readFilePage(f.file, f.cipher, PageSize, pageNo)
+	if e, ok := err.(GoDBError); ok {
+		return nil, f.corruptPageError(pageNo, e.errString)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !verifyPageChecksum(buf) {
+		return nil, f.corruptPageError(pageNo, "checksum mismatch")
+	}
+	page := newHashPage(f, pageNo, 0, 0)
+	err = page.initFromBuffer(bytes.NewBuffer(buf), f.keyDesc())
+	if err != nil {
+		return nil, f.corruptPageError(pageNo, err.Error())
+	}
+	observePageLSN(page.lsn)
+	p := Page(page)
+	return &p, nil
+}
+
+func (f *HashFile) corruptPageError(pageNo int, reason string) error {
+	return GoDBError{CorruptPageError, fmt.Sprintf("page %d of index %s is corrupt: %s", pageNo, f.fileName, reason)}
+}
+
+func (f *HashFile) flushPage(p *Page) error {
+	page, ok := (*p).(*hashPage)
+	if !ok {
+		return GoDBError{TypeMismatchError, "cannot cast to hashPage"}
+	}
+	page.lsn = nextPageLSN()
+	buffer, err := page.toBuffer()
+	if err != nil {
+		return err
+	}
+	return writeFilePage(f.file, f.cipher, page.pageNo, buffer.Bytes())
+}
+
+func (f *HashFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.fileName, PageNo: pgNo}
+}
+
+// Make every page flushed so far durable
+func (f *HashFile) sync() error {
+	return f.file.Sync()
+}
+
+// Close the file.  It must not be used again.
+func (f *HashFile) close() error {
+	return f.file.Close()
+}
+
+func (f *HashFile) Descriptor() *TupleDesc {
+	return f.desc
+}
+
+func (f *HashFile) getPage(pageNo int, tid TransactionID, perm RWPerm) (*hashPage, error) {
+	page, err := f.bufPool.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	p := (*page).(*hashPage)
+	if (pageNo == 0) != (p.kind == hashMetaPage) {
+		return nil, f.corruptPageError(pageNo, fmt.Sprintf("unexpected page kind %d", p.kind))
+	}
+	return p, nil
+}
+
+// Allocate a new, empty bucket page with the given local depth at the end of
+// the file, in the buffer pool, write locked by tid.  As in a [BTreeFile], a
+// page allocated by a transaction that aborts leaves a hole that no bucket
+// refers to.
+func (f *HashFile) allocPage(depth int, tid TransactionID) (*hashPage, error) {
+	f.Lock()
+	if n := f.NumPages(); f.nextPage < n {
+		f.nextPage = n
+	}
+	pageNo := f.nextPage
+	f.nextPage++
+	f.Unlock()
+	page, err := f.bufPool.addNewPage(f, pageNo, newHashPage(f, pageNo, hashBucketPage, depth), tid)
+	if err != nil {
+		return nil, err
+	}
+	return (*page).(*hashPage), nil
+}
+
+// Fetch the bucket page that the directory on the meta page assigns a key
+// with hash h to, followed by its overflow pages
+func (f *HashFile) bucket(meta *hashPage, h uint64, tid TransactionID, perm RWPerm) ([]*hashPage, error) {
+	var chain []*hashPage
+	pageNo := meta.dir[h&(1<<meta.depth-1)]
+	for pageNo != hashNoPage {
+		p, err := f.getPage(pageNo, tid, perm)
+		if err != nil {
+			return nil, err
+		}
+		if len(chain) > 0 && pageNo <= chain[len(chain)-1].pageNo {
+			// overflow pages are always allocated after the page they follow
+			return nil, f.corruptPageError(pageNo, "overflow chain loops")
+		}
+		chain = append(chain, p)
+		pageNo = p.next
+	}
+	return chain, nil
+}
+
+// Add an entry for t, a tuple of the indexed heap file, to the index
+func (f *HashFile) insertTuple(t *Tuple, tid TransactionID) error {
+	e, err := entryForTuple(t, f.keyIndex)
+	if err != nil {
+		return err
+	}
+	return f.insertEntry(e, tid)
+}
+
+// Add an entry to the index.  An index can hold only one entry for a key and
+// record id.
+func (f *HashFile) insertEntry(e indexEntry, tid TransactionID) error {
+	h := hashKey(e.key)
+	meta, err := f.getPage(0, tid, ReadPerm)
+	if err != nil {
+		return err
+	}
+	for {
+		chain, err := f.bucket(meta, h, tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		for _, p := range chain {
+			if slices.ContainsFunc(p.entries, func(e2 indexEntry) bool { return compareEntries(e, e2) == 0 }) {
+				return GoDBError{IllegalOperationError, fmt.Sprintf("index %s already has an entry for %v", f.fileName, e.rid)}
+			}
+		}
+		for _, p := range chain {
+			if len(p.entries) < hashBucketCapacity(f.keyType()) {
+				p.entries = append(p.entries, e)
+				p.setDirty(true)
+				return nil
+			}
+		}
+		if f.canSplit(chain, h) {
+			meta, err = f.getPage(0, tid, WritePerm)
+			if err != nil {
+				return err
+			}
+			err = f.split(meta, chain, tid)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		last := chain[len(chain)-1]
+		overflow, err := f.allocPage(last.depth, tid)
+		if err != nil {
+			return err
+		}
+		overflow.entries = []indexEntry{e}
+		overflow.setDirty(true)
+		last.next = overflow.pageNo
+		last.setDirty(true)
+		return nil
+	}
+}
+
+// Whether splitting a full bucket would make room for a key with hash h,
+// which it does unless every key in it agrees with h on all the bits the
+// directory can use
+func (f *HashFile) canSplit(chain []*hashPage, h uint64) bool {
+	if chain[0].depth >= hashMaxDepth {
+		return false
+	}
+	mask := uint64(1)<<hashMaxDepth - 1
+	for _, p := range chain {
+		for _, e := range p.entries {
+			if hashKey(e.key)&mask != h&mask {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Split the bucket whose pages are chain on the next bit of the hash,
+// doubling the directory if the bucket's local depth is the global depth.
+// meta must be write locked.  The entries that stay are packed into the bucket's existing pages, and the
+// rest are moved to a new bucket.
+func (f *HashFile) split(meta *hashPage, chain []*hashPage, tid TransactionID) error {
+	depth := chain[0].depth
+	if depth == meta.depth {
+		meta.dir = append(meta.dir, meta.dir...)
+		meta.depth++
+	}
+	bit := uint64(1) << depth
+	var stay, move []indexEntry
+	for _, p := range chain {
+		for _, e := range p.entries {
+			if hashKey(e.key)&bit == 0 {
+				stay = append(stay, e)
+			} else {
+				move = append(move, e)
+			}
+		}
+		p.entries = nil
+		p.depth = depth + 1
+		p.setDirty(true)
+	}
+	newBucket, err := f.allocPage(depth+1, tid)
+	if err != nil {
+		return err
+	}
+	for i, pageNo := range meta.dir {
+		if pageNo == chain[0].pageNo && uint64(i)&bit != 0 {
+			meta.dir[i] = newBucket.pageNo
+		}
+	}
+	meta.setDirty(true)
+
+	capacity := hashBucketCapacity(f.keyType())
+	take := func(entries *[]indexEntry) []indexEntry {
+		n := len(*entries)
+		if n > capacity {
+			n = capacity
+		}
+		taken := (*entries)[:n:n]
+		*entries = (*entries)[n:]
+		return taken
+	}
+	for _, p := range chain {
+		p.entries = take(&stay)
+	}
+	for p := newBucket; ; {
+		p.entries = take(&move)
+		p.setDirty(true)
+		if len(move) == 0 {
+			return nil
+		}
+		overflow, err := f.allocPage(depth+1, tid)
+		if err != nil {
+			return err
+		}
+		p.next = overflow.pageNo
+		p = overflow
+	}
+}
+
+// Remove the entry for t, a tuple of the indexed heap file, from the index
+func (f *HashFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	e, err := entryForTuple(t, f.keyIndex)
+	if err != nil {
+		return err
+	}
+	return f.deleteEntry(e, tid)
+}
+
+// Remove an entry from the index
+func (f *HashFile) deleteEntry(e indexEntry, tid TransactionID) error {
+	meta, err := f.getPage(0, tid, ReadPerm)
+	if err != nil {
+		return err
+	}
+	chain, err := f.bucket(meta, hashKey(e.key), tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	for _, p := range chain {
+		for i, e2 := range p.entries {
+			if compareEntries(e, e2) == 0 {
+				p.entries = slices.Delete(p.entries, i, i+1)
+				p.setDirty(true)
+				return nil
+			}
+		}
+	}
+	return GoDBError{TupleNotFoundError, fmt.Sprintf("index %s has no entry for %v", f.fileName, e.rid)}
+}
+
+// Remove every entry from the index.  The file is cut back to an empty index
+// while holding a write lock on the meta page, which every other user of the
+// index must lock first.
+func (f *HashFile) clear(tid TransactionID) error {
+	meta, err := f.getPage(0, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	defer f.Unlock()
+	if n := f.NumPages(); f.nextPage < n {
+		f.nextPage = n
+	}
+	f.bufPool.discardFilePages(f, 1, f.nextPage)
+	f.nextPage = 2
+	err = truncateFilePages(f.file, f.cipher, PageSize, 1)
+	if err != nil {
+		return err
+	}
+	bucket := Page(newHashPage(f, 1, hashBucketPage, 0))
+	err = f.flushPage(&bucket)
+	if err != nil {
+		return err
+	}
+	meta.depth = 0
+	meta.dir = []int{1}
+	meta.setDirty(true)
+	return nil
+}
+
+// A hash index can only scan ranges of a single key
+func (f *HashFile) supportsRange(r keyRange) bool {
+	return r.isPoint()
+}
+
+// Return an iterator over the entries with keys in r, which must hold a
+// single key, in record id order
+func (f *HashFile) scanRange(r keyRange, tid TransactionID) (func() (*indexEntry, error), error) {
+	if !r.isPoint() {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("hash index %s can only look up single keys", f.fileName)}
+	}
+	if !valueHasType(r.lo, f.keyType()) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("index %s has %s keys", f.fileName, typeNames[f.keyType()])}
+	}
+	meta, err := f.getPage(0, tid, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := f.bucket(meta, hashKey(r.lo), tid, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	var entries []indexEntry
+	for _, p := range chain {
+		for _, e := range p.entries {
+			if compareKeys(e.key, r.lo) == 0 {
+				entries = append(entries, e)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return compareEntries(entries[i], entries[j]) < 0 })
+	return func() (*indexEntry, error) {
+		if len(entries) == 0 {
+			return nil, nil
+		}
+		e := entries[0]
+		entries = entries[1:]
+		return &e, nil
+	}, nil
+}
+
+// Return the record ids of the heap tuples whose key equals key
+func (f *HashFile) lookup(key DBValue, tid TransactionID) ([]heapFileRID, error) {
+	return lookupRange(f, key, tid)
+}
+
+// [Operator] iterator method -- return every entry of the index, one bucket
+// at a time
+func (f *HashFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	meta, err := f.getPage(0, tid, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	var buckets []int
+	for _, pageNo := range meta.dir {
+		if !slices.Contains(buckets, pageNo) {
+			buckets = append(buckets, pageNo)
+		}
+	}
+	var entries []indexEntry
+	pageNo := hashNoPage
+	return func() (*Tuple, error) {
+		for len(entries) == 0 {
+			if pageNo == hashNoPage {
+				if len(buckets) == 0 {
+					return nil, nil
+				}
+				pageNo, buckets = buckets[0], buckets[1:]
+			}
+			p, err := f.getPage(pageNo, tid, ReadPerm)
+			if err != nil {
+				return nil, err
+			}
+			entries, pageNo = p.entries, p.next
+		}
+		e := entries[0]
+		entries = entries[1:]
+		return &Tuple{*f.desc, []DBValue{e.key, IntField{int64(e.rid.pageNum)}, IntField{int64(e.rid.slotNum)}}, nil}, nil
+	}, nil
+}
@@ -0,0 +1,330 @@
+package godb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHashIndexInsertAndLookup(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, HashIndex)
+	idx := file.(*HashFile)
+	var ages []int
+	for i := 0; i < 3000; i++ {
+		ages = append(ages, (i*7)%500)
+	}
+	// more copies of one key than fit in a bucket
+	for i := 0; i < 2*hashBucketCapacity(IntType); i++ {
+		ages = append(ages, 1000)
+	}
+	insertAges(t, hf, bp, ages)
+	if idx.NumPages() < 10 {
+		t.Fatalf("expected the index to have split into several buckets, has %d pages", idx.NumPages())
+	}
+
+	for _, age := range []int{0, 7, 250, 499} {
+		if cnt := countRange(t, idx, bp, pointRange(IntField{int64(age)})); cnt != 6 {
+			t.Errorf("expected 6 entries with age %d, got %d", age, cnt)
+		}
+	}
+	if cnt := countRange(t, idx, bp, pointRange(IntField{1000})); cnt != 2*hashBucketCapacity(IntType) {
+		t.Errorf("expected %d entries with age 1000, got %d", 2*hashBucketCapacity(IntType), cnt)
+	}
+	if cnt := countRange(t, idx, bp, pointRange(IntField{500})); cnt != 0 {
+		t.Errorf("expected no entries with age 500, got %d", cnt)
+	}
+	checkIndexMatches(t, hf, idx, bp)
+
+	// only single keys can be looked up
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	_, err := idx.scanRange(keyRange{lo: IntField{10}}, tid)
+	if err == nil {
+		t.Errorf("expected a range scan of a hash index to fail")
+	}
+	_, err = idx.scanRange(pointRange(StringField{"sam"}), tid)
+	if err == nil {
+		t.Errorf("expected a lookup of a string key in an int index to fail")
+	}
+	bp.CommitTransaction(tid)
+
+	// the index is found again when the file is reopened
+	idx2, err := NewHashFile(TestingIndexFile, hf.Descriptor().Fields[1], 1, NewBufferPool(10))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cnt := countRange(t, idx2, idx2.bufPool, pointRange(IntField{7})); cnt != 6 {
+		t.Errorf("expected 6 entries with age 7 after reopening, got %d", cnt)
+	}
+}
+
+func TestHashIndexDelete(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, HashIndex)
+	idx := file.(*HashFile)
+	var ages []int
+	for i := 0; i < 2000; i++ {
+		ages = append(ages, i%100)
+	}
+	insertAges(t, hf, bp, ages)
+	deleteWhere(t, hf, bp, func(n int, rid heapFileRID) bool {
+		return n%2 == 0
+	})
+	checkIndexMatches(t, hf, idx, bp)
+	if cnt := countRange(t, idx, bp, pointRange(IntField{43})); cnt != 20 {
+		t.Errorf("expected 20 entries with age 43, got %d", cnt)
+	}
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, IntField{42}}, heapFileRID{1000, 0}}
+	err := idx.deleteTuple(&tup, tid)
+	if err == nil {
+		t.Errorf("expected deleting a missing entry to fail")
+	}
+	err = idx.clear(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.CommitTransaction(tid)
+	if cnt := countRange(t, idx, bp, pointRange(IntField{43})); cnt != 0 {
+		t.Errorf("expected no entries after clearing the index, got %d", cnt)
+	}
+}
+
+// Return the global depth of a hash index and the pages of the bucket that
+// key belongs in
+func hashBucketOf(t *testing.T, idx *HashFile, bp *BufferPool, key DBValue) (int, []*hashPage) {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	defer bp.CommitTransaction(tid)
+	meta, err := idx.getPage(0, tid, ReadPerm)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(meta.dir) != 1<<meta.depth {
+		t.Fatalf("expected a directory of %d entries at depth %d, has %d", 1<<meta.depth, meta.depth, len(meta.dir))
+	}
+	chain, err := idx.bucket(meta, hashKey(key), tid, ReadPerm)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return meta.depth, chain
+}
+
+// Check the structure of the pages of a hash index, and that every page of
+// its file is part of it
+func checkHashPages(t *testing.T, idx *HashFile, bp *BufferPool) {
+	t.Helper()
+	var report FsckReport
+	problem := func(pageNo int, format string, args ...any) {
+		t.Errorf("page %d: "+format, append([]any{pageNo}, args...)...)
+	}
+	_, err := fsckHashPages(bp.vfs, idx.fileName, idx.keyDesc(), nil, &report, problem, func(int, []indexEntry) {})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if report.Pages != idx.NumPages() {
+		t.Errorf("expected every one of %d pages to be reachable, %d are", idx.NumPages(), report.Pages)
+	}
+}
+
+func TestHashIndexDirectoryDoubling(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, HashIndex)
+	idx := file.(*HashFile)
+	capacity := hashBucketCapacity(IntType)
+
+	// a full bucket doesn't split until another key arrives
+	var ages []int
+	for i := 0; i < capacity; i++ {
+		ages = append(ages, i)
+	}
+	insertAges(t, hf, bp, ages)
+	if depth, chain := hashBucketOf(t, idx, bp, IntField{0}); depth != 0 || len(chain) != 1 || idx.NumPages() != 2 {
+		t.Fatalf("expected one full bucket at depth 0, got depth %d with %d pages", depth, idx.NumPages())
+	}
+
+	// splitting the only bucket doubles the directory, and each half of it
+	// points to one of the two buckets
+	insertAges(t, hf, bp, []int{capacity})
+	depth, _ := hashBucketOf(t, idx, bp, IntField{0})
+	if depth == 0 {
+		t.Fatalf("expected the directory to double")
+	}
+	for age := 0; age <= capacity; age++ {
+		_, chain := hashBucketOf(t, idx, bp, IntField{int64(age)})
+		if len(chain) != 1 || chain[0].depth > depth {
+			t.Errorf("expected age %d in a bucket of one page with depth at most %d, got %d pages with depth %d", age, depth, len(chain), chain[0].depth)
+		}
+	}
+	checkHashPages(t, idx, bp)
+
+	// more keys deepen the directory further, without overflow pages
+	ages = nil
+	for i := capacity + 1; i < 20*capacity; i++ {
+		ages = append(ages, i)
+	}
+	insertAges(t, hf, bp, ages)
+	if deeper, _ := hashBucketOf(t, idx, bp, IntField{0}); deeper <= depth {
+		t.Errorf("expected the directory to grow beyond depth %d, has depth %d", depth, deeper)
+	}
+	for _, age := range []int{0, capacity, 10 * capacity, 20*capacity - 1} {
+		if _, chain := hashBucketOf(t, idx, bp, IntField{int64(age)}); len(chain) != 1 {
+			t.Errorf("expected the bucket of age %d to have no overflow pages, has %d pages", age, len(chain))
+		}
+	}
+	checkHashPages(t, idx, bp)
+	checkIndexMatches(t, hf, idx, bp)
+}
+
+func TestHashIndexOverflowChains(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, HashIndex)
+	idx := file.(*HashFile)
+	capacity := hashBucketCapacity(IntType)
+
+	// copies of one key can't be split up, so they fill a chain of overflow
+	// pages instead
+	var ages []int
+	for i := 0; i < 2*capacity+1; i++ {
+		ages = append(ages, 1000)
+	}
+	insertAges(t, hf, bp, ages)
+	depth, chain := hashBucketOf(t, idx, bp, IntField{1000})
+	if depth != 0 || len(chain) != 3 {
+		t.Fatalf("expected a bucket of 3 pages at depth 0, got %d pages at depth %d", len(chain), depth)
+	}
+	for i := 1; i < len(chain); i++ {
+		if chain[i-1].next != chain[i].pageNo || chain[i].pageNo <= chain[i-1].pageNo {
+			t.Errorf("expected overflow page %d to follow page %d", chain[i].pageNo, chain[i-1].pageNo)
+		}
+	}
+	checkHashPages(t, idx, bp)
+
+	// other keys split the bucket, and the copies move with their chain
+	ages = nil
+	for i := 0; i < 2*capacity; i++ {
+		ages = append(ages, i)
+	}
+	insertAges(t, hf, bp, ages)
+	if depth, chain := hashBucketOf(t, idx, bp, IntField{1000}); depth == 0 || len(chain) < 3 {
+		t.Errorf("expected the copies to keep a chain of at least 3 pages after a split, got %d pages at depth %d", len(chain), depth)
+	}
+	if cnt := countRange(t, idx, bp, pointRange(IntField{1000})); cnt != 2*capacity+1 {
+		t.Errorf("expected %d entries with age 1000, got %d", 2*capacity+1, cnt)
+	}
+	checkHashPages(t, idx, bp)
+
+	// entries are found and removed anywhere in the chain
+	deleteWhere(t, hf, bp, func(n int, rid heapFileRID) bool {
+		return n%2 == 0
+	})
+	if cnt := countRange(t, idx, bp, pointRange(IntField{1000})); cnt != capacity {
+		t.Errorf("expected %d entries with age 1000 after deleting, got %d", capacity, cnt)
+	}
+	checkIndexMatches(t, hf, idx, bp)
+}
+
+func TestHashIndexAbort(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, HashIndex)
+	idx := file.(*HashFile)
+	insertAges(t, hf, bp, []int{1, 2, 3})
+
+	// the pages of splits and overflow pages of changes that abort are never
+	// written, and the directory is left as it was
+	numPages := idx.NumPages()
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 2000; i++ {
+		tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, IntField{int64(i % 700)}}, heapFileRID{1000, i}}
+		err := idx.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.AbortTransaction(tid)
+	if idx.NumPages() != numPages {
+		t.Errorf("expected the aborted splits to leave %d pages, file has %d", numPages, idx.NumPages())
+	}
+	for _, age := range []int{1, 2, 3} {
+		if cnt := countRange(t, idx, bp, pointRange(IntField{int64(age)})); cnt != 1 {
+			t.Errorf("expected 1 entry with age %d after the aborted splits, got %d", age, cnt)
+		}
+	}
+	checkIndexMatches(t, hf, idx, bp)
+	insertAges(t, hf, bp, []int{4, 5})
+	checkIndexMatches(t, hf, idx, bp)
+}
+
+func TestHashIndexConcurrentWriters(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, HashIndex)
+	idx := file.(*HashFile)
+	var ages []int
+	for i := 0; i < 1000; i++ {
+		ages = append(ages, i)
+	}
+	insertAges(t, hf, bp, ages)
+
+	// find two keys in different buckets that both have room for another
+	// entry
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	meta, err := idx.getPage(0, tid, ReadPerm)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var keys []int
+	buckets := make(map[int]bool)
+	for age := 1000; age < 2000 && len(keys) < 2; age++ {
+		chain, err := idx.bucket(meta, hashKey(IntField{int64(age)}), tid, ReadPerm)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if buckets[chain[0].pageNo] || len(chain[len(chain)-1].entries) >= hashBucketCapacity(IntType) {
+			continue
+		}
+		buckets[chain[0].pageNo] = true
+		keys = append(keys, age)
+	}
+	bp.CommitTransaction(tid)
+	if len(keys) < 2 {
+		t.Fatalf("expected two buckets with room, found %d", len(keys))
+	}
+
+	// transactions changing different buckets don't wait for each other
+	insert := func(age int) (TransactionID, error) {
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, IntField{int64(age)}}, heapFileRID{1000, age}}
+		return tid, idx.insertTuple(&tup, tid)
+	}
+	tid1, err := insert(keys[0])
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	done := make(chan error)
+	var tid2 TransactionID
+	go func() {
+		var err error
+		tid2, err = insert(keys[1])
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected inserts into different buckets not to block each other")
+	}
+	bp.CommitTransaction(tid1)
+	bp.CommitTransaction(tid2)
+	for _, age := range keys {
+		if cnt := countRange(t, idx, bp, pointRange(IntField{int64(age)})); cnt != 1 {
+			t.Errorf("expected 1 entry with age %d, got %d", age, cnt)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+/* hashPage implements the Page interface for pages of a HashFile, an
+extendible hash index.
+
+Page 0 of every hash file is a meta page holding the directory:  2^d page
+numbers of buckets, where d is the global depth of the index.  A key belongs
+in the bucket that the directory entry numbered by the low d bits of its hash
+points to.  Each bucket has a local depth l <= d, and all the keys in it share
+the low l bits of their hash, so 2^(d-l) directory entries point to it.
+
+When a bucket fills up it is split in two on bit l of the hash, doubling the
+directory first if l = d.  Keys whose hashes agree on every bit the directory
+can use (including duplicates of one key) can't be separated by splitting,
+so instead the bucket grows a chain of overflow pages, linked from the bucket
+page.
+
+All pages are PageSize bytes and share a header:
+
+	kind (int32)  number of entries (int32)  LSN (int64)  checksum (uint32)
+	next overflow page (int32)  depth (int32)
+
+As in b+tree pages, the checksum and LSN are at the same offsets as in heap
+pages.  The meta page's header holds the global depth and is followed by the
+directory, as int32s;  bucket and overflow pages hold their local depth and
+are followed by their entries, written as in b+tree leaves.
+*/
+
+type hashPageKind int32
+
+const (
+	hashMetaPage   hashPageKind = iota + 1
+	hashBucketPage hashPageKind = iota + 1
+)
+
+const (
+	hashPageHeaderSize = 28
+	hashNoPage         = -1
+)
+
+// The largest global depth whose directory fits on the meta page
+var hashMaxDepth = func() int {
+	d := 0
+	for 4<<(d+1) <= PageSize-hashPageHeaderSize {
+		d++
+	}
+	return d
+}()
+
+type hashPage struct {
+	dirty   bool
+	file    *HashFile
+	pageNo  int
+	lsn     int64
+	kind    hashPageKind
+	depth   int          // global depth for the meta page, local for buckets
+	dir     []int        // meta page only
+	entries []indexEntry // bucket and overflow pages only
+	next    int          // next overflow page of a bucket
+}
+
+func newHashPage(f *HashFile, pageNo int, kind hashPageKind, depth int) *hashPage {
+	return &hashPage{file: f, pageNo: pageNo, kind: kind, depth: depth, next: hashNoPage}
+}
+
+// Hash a key.  Keys are hashed in their serialized form, so that a key hashes
+// the same across restarts.
+func hashKey(key DBValue) uint64 {
+	var b bytes.Buffer
+	(&Tuple{Fields: []DBValue{key}}).writeTo(&b)
+	h := fnv.New64a()
+	h.Write(b.Bytes())
+	return h.Sum64()
+}
+
+// Number of entries that fit on a bucket or overflow page
+func hashBucketCapacity(keyType DBType) int {
+	keySize := calBytesPerTuple(&TupleDesc{Fields: []FieldType{{Ftype: keyType}}})
+	return (PageSize - hashPageHeaderSize) / (keySize + 8)
+}
+
+func (p *hashPage) isDirty() bool {
+	return p.dirty
+}
+
+func (p *hashPage) setDirty(dirty bool) {
+	p.dirty = dirty
+}
+
+func (p *hashPage) getFile() *DBFile {
+	file := (DBFile)(p.file)
+	return &file
+}
+
+// Serialize the page to a PageSize buffer, filling in its checksum
+func (p *hashPage) toBuffer() (*bytes.Buffer, error) {
+	b := new(bytes.Buffer)
+	count := len(p.entries)
+	if p.kind == hashMetaPage {
+		count = len(p.dir)
+	}
+	for _, v := range []any{int32(p.kind), int32(count), p.lsn, uint32(0), int32(p.next), int32(p.depth)} {
+		err := binary.Write(b, binary.LittleEndian, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, pageNo := range p.dir {
+		err := binary.Write(b, binary.LittleEndian, int32(pageNo))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range p.entries {
+		err := writeIndexEntry(b, e)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b.Len() > PageSize {
+		return nil, GoDBError{PageFullError, "hash index entries don't fit in page"}
+	}
+	b.Write(make([]byte, PageSize-b.Len()))
+	binary.LittleEndian.PutUint32(b.Bytes()[pageChecksumOffset:], pageChecksum(b.Bytes()))
+	return b, nil
+}
+
+// Read the contents of the page from a buffer written by toBuffer
+func (p *hashPage) initFromBuffer(buf *bytes.Buffer, keyDesc *TupleDesc) error {
+	var kind, count, next, depth int32
+	var checksum uint32
+	for _, v := range []any{&kind, &count, &p.lsn, &checksum, &next, &depth} {
+		err := binary.Read(buf, binary.LittleEndian, v)
+		if err != nil {
+			return err
+		}
+	}
+	p.kind = hashPageKind(kind)
+	p.next = int(next)
+	p.depth = int(depth)
+	if depth < 0 || int(depth) > hashMaxDepth {
+		return GoDBError{MalformedDataError, fmt.Sprintf("bad hash page header: depth %d", depth)}
+	}
+	switch p.kind {
+	case hashMetaPage:
+		if int(count) != 1<<depth {
+			return GoDBError{MalformedDataError, fmt.Sprintf("bad hash page header: %d directory entries for depth %d", count, depth)}
+		}
+		p.dir = make([]int, count)
+		for i := range p.dir {
+			var pageNo int32
+			err := binary.Read(buf, binary.LittleEndian, &pageNo)
+			if err != nil {
+				return err
+			}
+			p.dir[i] = int(pageNo)
+		}
+	case hashBucketPage:
+		if count < 0 || int(count) > hashBucketCapacity(keyDesc.Fields[0].Ftype) {
+			return GoDBError{MalformedDataError, fmt.Sprintf("bad hash page header: %d entries", count)}
+		}
+		p.entries = make([]indexEntry, 0, count)
+		for i := 0; i < int(count); i++ {
+			e, err := readIndexEntry(buf, keyDesc)
+			if err != nil {
+				return err
+			}
+			p.entries = append(p.entries, e)
+		}
+	default:
+		return GoDBError{MalformedDataError, fmt.Sprintf("unknown hash page kind %d", kind)}
+	}
+	return nil
+}
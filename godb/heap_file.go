@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"strconv"
@@ -22,9 +23,18 @@ type HeapFile struct {
 	// additional fields
 	bufPool *BufferPool
 	sync.Mutex
-	desc     *TupleDesc
-	fileName string
-	file     *os.File
+	desc      *TupleDesc
+	fileName  string
+	file      File
+	fsm       *freeSpaceMap
+	zones     *zoneMap
+	pages     *pageMap    // where compressed pages are stored, or nil if pages aren't compressed
+	cipher    *pageCipher // encrypts the pages of the file, or nil
+	pageSize  int
+	version   int          // format version of the file, see heap_header.go
+	indexes   []indexFile  // kept in sync with the tuples of the file
+	temporary bool         // whether the file is of a temporary table
+	mapping   *heapMapping // of the data file, if pages are read with MmapReads
 }
 
 // Create a HeapFile.
@@ -32,30 +42,164 @@ type HeapFile struct {
 // - fromFile: backing file for the HeapFile.  May be empty or a previously created heap file.
 // - td: the TupleDesc for the HeapFile.
 // - bp: the BufferPool that is used to store pages read from the HeapFile
-// May return an error if the file cannot be opened or created.
+// May return an error if the file cannot be opened or created, or if its
+// header shows that it was created for another schema (see heap_header.go).
+// An existing file is opened with the page size in its header.
 func NewHeapFile(fromFile string, td *TupleDesc, bp *BufferPool) (*HeapFile, error) {
 	// TODO: some code goes here
-	file, err := os.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	return openHeapFile(fromFile, td, 0, "", nil, bp)
+}
+
+// Create a HeapFile with pages of pageSize bytes, a power of two between
+// MinPageSize and MaxPageSize.  fromFile must be empty or a heap file
+// previously created with the same page size.
+func NewHeapFileWithPageSize(fromFile string, td *TupleDesc, pageSize int, bp *BufferPool) (*HeapFile, error) {
+	return openHeapFile(fromFile, td, pageSize, "", nil, bp)
+}
+
+// Create a HeapFile whose pages are compressed with the named codec (see
+// compression.go).  fromFile must be empty or a heap file previously created
+// with the same compression.
+func NewCompressedHeapFile(fromFile string, td *TupleDesc, compression string, bp *BufferPool) (*HeapFile, error) {
+	return openHeapFile(fromFile, td, 0, compression, nil, bp)
+}
+
+// Open a heap file of pageSize pages, which are encrypted with cipher, unless
+// it is nil.  If pageSize is 0, an existing file is opened with the page size
+// in its header, and a new one gets PageSize pages.  If compression is "",
+// the file is compressed only if it already has a page map.  A file in format
+// version 0 is rejected;  see [UpgradeHeapFile].
+func openHeapFile(fromFile string, td *TupleDesc, pageSize int, compression string, cipher *pageCipher, bp *BufferPool) (*HeapFile, error) {
+	f, err := openHeapFileOfAnyVersion(fromFile, td, pageSize, compression, cipher, bp)
+	if err != nil {
+		return nil, err
+	}
+	if f.version == 0 {
+		f.close()
+		return nil, GoDBError{UnsupportedFormatError, fmt.Sprintf("%s is in format version 0, of the original version of GoDB, and must be upgraded with UpgradeHeapFile first", fromFile)}
+	}
+	return f, nil
+}
+
+// Open a heap file like openHeapFile, but in any format version.  Files in
+// format version 0 are always opened with PageSize pages, and without
+// compression or encryption, which they never had.
+func openHeapFileOfAnyVersion(fromFile string, td *TupleDesc, pageSize int, compression string, cipher *pageCipher, bp *BufferPool) (*HeapFile, error) {
+	err := finishReplacement(bp.vfs, fromFile)
+	if err != nil {
+		return nil, err
+	}
+	file, err := bp.vfs.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header, err := readHeapFileHeader(file, fromFile)
+	if err != nil {
+		return nil, err
+	}
+	version := currentHeapFileVersion
+	if header != nil {
+		version = header.version
+		if pageSize == 0 {
+			pageSize = header.pageSize
+		}
+	} else if stat.Size() > 0 {
+		version, pageSize, compression, cipher = 0, PageSize, "", nil
+	}
+	if pageSize == 0 {
+		pageSize = PageSize
+	}
+	err = checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := openPageMap(bp.vfs, fromFile, compression, pageSize, cipher)
 	if err != nil {
 		return nil, err
 	}
+	if version == 0 && pages != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("%s has a page map, but no header", fromFile)}
+	}
+	header = &heapFileHeader{version, pageSize, 0, schemaHash(td)}
+	if cipher != nil {
+		header.flags |= heapFileEncrypted
+	}
+	if pages != nil {
+		header.flags |= heapFileCompressed
+	}
+	if stat.Size() == 0 {
+		_, err = file.WriteAt(header.toBytes(cipher.storedSize(pageSize)), 0)
+		if err != nil {
+			return nil, err
+		}
+	} else if version > 0 {
+		existing, _ := readHeapFileHeader(file, fromFile)
+		err = existing.check(fromFile, td, pageSize, header.flags)
+		if err != nil {
+			return nil, err
+		}
+	}
 	heapFile := &HeapFile{
 		bufPool:  bp,
 		desc:     td,
 		fileName: fromFile,
 		file:     file,
+		pages:    pages,
+		cipher:   cipher,
+		pageSize: pageSize,
+		version:  version,
+		mapping:  &heapMapping{},
+	}
+	if pages != nil {
+		pages.dataStart = int64(heapFile.firstSlot() * cipher.storedSize(pageSize))
+	}
+	heapFile.fsm, err = openFreeSpaceMap(bp.vfs, fromFile, heapFile.NumPages())
+	if err != nil {
+		return nil, err
+	}
+	heapFile.zones, err = openZoneMap(bp.vfs, fromFile, td, heapFile.NumPages(), cipher)
+	if err != nil {
+		return nil, err
 	}
-	//  这里绑定是否有问题
-	bp.dbfile = DBFile(heapFile)
 	return heapFile, nil //replace me
 }
 
 // Return the number of pages in the heap file
 func (f *HeapFile) NumPages() int {
 	// TODO: some code goes here
-	stat, _ := f.file.Stat()
-	fileSize := int(stat.Size())
-	return fileSize / PageSize //replace me
+	if f.pages != nil {
+		return f.pages.numPages()
+	}
+	numPages := filePageCount(f.file, f.cipher, f.pageSize) - f.firstSlot()
+	if numPages < 0 {
+		return 0
+	}
+	return numPages //replace me
+}
+
+// Return the slot of the file that page 0 is stored in, which follows the
+// header in every format but version 0
+func (f *HeapFile) firstSlot() int {
+	if f.version == 0 {
+		return 0
+	}
+	return 1
+}
+
+// Close the heap file and its side files.  The file must not be used again.
+func (f *HeapFile) close() error {
+	f.mapping.release()
+	err := f.file.Close()
+	f.fsm.file.Close()
+	f.zones.file.Close()
+	if f.pages != nil {
+		f.pages.file.Close()
+	}
+	return err
 }
 
 // Load the contents of a heap file from a specified CSV file.  Parameters are as follows:
@@ -65,7 +209,7 @@ func (f *HeapFile) NumPages() int {
 // Returns an error if the field cannot be opened or if a line is malformed
 // We provide the implementation of this method, but it won't work until
 // [HeapFile.insertTuple] is implemented
-func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLastField bool) error {
+func (f *HeapFile) LoadFromCSV(file io.Reader, hasHeader bool, sep string, skipLastField bool) error {
 	scanner := bufio.NewScanner(file)
 	cnt := 0
 	for scanner.Scan() {
@@ -128,7 +272,10 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 
 		//commit frequently, to avoid all pages in BP being full
 		//todo fix
-		bp.CommitTransaction(tid)
+		err := bp.CommitTransaction(tid)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -142,24 +289,71 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 // the [heapPage.initFromBuffer] method.
 func (f *HeapFile) readPage(pageNo int) (*Page, error) {
 	// TODO: some code goes here
-	file := f.file
-	offset := pageNo * PageSize
-	byteArr := make([]byte, PageSize)
-	_, err := file.ReadAt(byteArr, int64(offset))
+	if f.mapped() {
+		page, ok, err := f.readMappedPage(pageNo)
+		if ok {
+			return page, err
+		}
+	}
+	byteArr, err := f.readPageData(pageNo)
 	if err != nil {
 		return nil, err
 	}
-	buffer := bytes.NewBuffer(byteArr)
+	return f.decodePage(pageNo, byteArr)
+}
+
+// Check and deserialize page pageNo of the file from data, which isn't kept
+func (f *HeapFile) decodePage(pageNo int, data []byte) (*Page, error) {
+	if !verifyPageChecksum(data) {
+		return nil, f.corruptPageError(pageNo, "checksum mismatch")
+	}
+	buffer := bytes.NewBuffer(data)
 	page := newHeapPage(f.desc, pageNo, f)
-	page.initFromBuffer(buffer)
+	err := page.initFromBuffer(buffer)
+	if err != nil {
+		return nil, f.corruptPageError(pageNo, err.Error())
+	}
+	observePageLSN(page.lsn)
 	p := Page(page)
 	return &p, nil
 }
 
+// Read the serialized page pageNo, decrypting and decompressing it as needed
+func (f *HeapFile) readPageData(pageNo int) ([]byte, error) {
+	var data []byte
+	var err error
+	if f.pages != nil {
+		data, err = f.pages.readPage(f.file, pageNo)
+	} else {
+		data, err = readFilePage(f.file, f.cipher, f.pageSize, pageNo+f.firstSlot())
+	}
+	if e, ok := err.(GoDBError); ok {
+		return nil, f.corruptPageError(pageNo, e.errString)
+	}
+	return data, err
+}
+
+// Write the serialized page pageNo, compressing and encrypting it as needed
+func (f *HeapFile) writePageData(pageNo int, data []byte) error {
+	if f.pages != nil {
+		return f.pages.writePage(f.file, pageNo, data)
+	}
+	return writeFilePage(f.file, f.cipher, pageNo+f.firstSlot(), data)
+}
+
+// Return a CorruptPageError identifying a damaged page of this file
+func (f *HeapFile) corruptPageError(pageNo int, reason string) error {
+	return GoDBError{CorruptPageError, fmt.Sprintf("page %d of %s is corrupt: %s", pageNo, f.fileName, reason)}
+}
+
 // Add the tuple to the HeapFile.  This method should search through pages in
 // the heap file, looking for empty slots and adding the tuple in the first
 // empty slot if finds.
 //
+// Candidate pages come from the free space map, so only pages that (probably)
+// have room are fetched and write locked.  A stale entry is corrected and the
+// search moves on to the next candidate.
+//
 // If none are found, it should create a new [heapPage] and insert the tuple
 // there, and write the heapPage to the end of the HeapFile (e.g., using the
 // [flushPage] method.)
@@ -168,33 +362,30 @@ func (f *HeapFile) readPage(pageNo int) (*Page, error) {
 // rather than directly reading pages itself. For lab 1, you do not need to
 // worry about concurrent transactions modifying the Page or HeapFile.  We will
 // add support for concurrent modifications in lab 3.
+//
+// Once the tuple is stored, an entry for it is added to each of the file's
+// indexes.
 func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 	// TODO: some code goes here
-	numPages := f.NumPages()
+	err := f.storeTuple(t, tid)
+	if err != nil {
+		return err
+	}
+	return f.addToIndexes(t, tid)
+}
+
+// Store t in a free slot of the file, appending a new page if there is none
+func (f *HeapFile) storeTuple(t *Tuple, tid TransactionID) error {
 	bf := f.bufPool
-	for pageId := 0; pageId < numPages; pageId++ {
-		page, err := bf.GetPage(f, pageId, tid, WritePerm)
-		if err != nil {
-			return err
-		}
-		heapPage := (*page).(*heapPage)
-		if heapPage.usedSlots != heapPage.numSlots {
-			f.Lock()
-			_, err := heapPage.insertTuple(t)
-			f.Unlock()
-			heapPage.setDirty(true)
-			if err != nil {
-				return err
-			} else {
-				return nil
-			}
-		}
+	inserted, err := f.insertIntoFreePage(t, f.NumPages(), tid)
+	if err != nil || inserted {
+		return err
 	}
 	pageNum := f.NumPages()
 	newPage := newHeapPage(&t.Desc, pageNum, f)
 	writePage := Page(newPage)
 	// need to flush page
-	err := f.flushPage(&writePage)
+	err = f.flushPage(&writePage)
 	if err != nil {
 		return err
 	}
@@ -207,6 +398,7 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 	_, err = heapPage.insertTuple(t)
 	f.Unlock()
 	heapPage.setDirty(true)
+	f.fsm.update(pageNum, heapPage.numSlots-heapPage.usedSlots)
 	if err != nil {
 		return err
 	} else {
@@ -215,6 +407,28 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 	return nil //replace me
 }
 
+// Insert t into the first page before limit that has a free slot, according
+// to the free space map.  Returns false if there is no such page.
+func (f *HeapFile) insertIntoFreePage(t *Tuple, limit int, tid TransactionID) (bool, error) {
+	for pageId := f.fsm.findPage(0, limit); pageId != -1; pageId = f.fsm.findPage(pageId+1, limit) {
+		page, err := f.bufPool.GetPage(f, pageId, tid, WritePerm)
+		if err != nil {
+			return false, err
+		}
+		heapPage := (*page).(*heapPage)
+		if heapPage.usedSlots != heapPage.numSlots {
+			f.Lock()
+			_, err := heapPage.insertTuple(t)
+			f.Unlock()
+			heapPage.setDirty(true)
+			f.fsm.update(pageId, heapPage.numSlots-heapPage.usedSlots)
+			return err == nil, err
+		}
+		f.fsm.update(pageId, 0)
+	}
+	return false, nil
+}
+
 // Remove the provided tuple from the HeapFile.  This method should use the
 // [Tuple.Rid] field of t to determine which tuple to remove.
 // This method is only called with tuples that are read from storage via the
@@ -234,13 +448,40 @@ func (f *HeapFile) deleteTuple(t *Tuple, tid TransactionID) error {
 	heappage := (*page).(*heapPage)
 	//此处之前位置错误
 	f.Lock()
+	var stored *Tuple
+	if rid.slotNum >= 0 && rid.slotNum < len(heappage.slots) {
+		stored = heappage.slots[rid.slotNum]
+	}
 	err = heappage.deleteTuple(rid)
 	f.Unlock()
 	heappage.setDirty(true)
+	f.fsm.update(rid.pageNum, heappage.numSlots-heappage.usedSlots)
 	if err != nil {
 		return err
 	}
-	return nil //replace me
+	return f.removeFromIndexes(stored, rid, tid)
+}
+
+// Add an entry for t, which has just been stored at t.Rid, to each index
+func (f *HeapFile) addToIndexes(t *Tuple, tid TransactionID) error {
+	for _, index := range f.indexes {
+		err := index.insertTuple(t, tid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove the entries for t, which was stored at rid, from each index
+func (f *HeapFile) removeFromIndexes(t *Tuple, rid heapFileRID, tid TransactionID) error {
+	for _, index := range f.indexes {
+		err := index.deleteTuple(&Tuple{t.Desc, t.Fields, rid}, tid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Method to force the specified page back to the backing file at the appropriate
@@ -254,17 +495,20 @@ func (f *HeapFile) flushPage(p *Page) error {
 	if !ok {
 		return GoDBError{TypeMismatchError, "cannot cast to heappage"}
 	}
-	file := f.file
+	page.lsn = nextPageLSN()
 	buffer, err := page.toBuffer()
 	if err != nil {
 		return err
 	}
-	byteArr := buffer.Bytes()
-	writeArr := make([]byte, PageSize)
-	copy(writeArr, byteArr)
-	offset := page.pageNo * PageSize
-	file.WriteAt(writeArr, int64(offset))
-	return nil //replace me
+	err = f.writePageData(page.pageNo, buffer.Bytes())
+	if err != nil {
+		return err
+	}
+	err = f.zones.persist(page)
+	if err != nil {
+		return err
+	}
+	return f.fsm.persist(page.pageNo, page.numSlots-page.usedSlots)
 }
 
 // [Operator] descriptor method -- return the TupleDesc for this HeapFile
@@ -284,8 +528,21 @@ func (f *HeapFile) Descriptor() *TupleDesc {
 // set appropriate so that [deleteTuple] will work (see additional comments there).
 func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
+	return f.iteratorWhere(nil, nil, tid)
+}
+
+// Return an iterator over the records in the heap file that skips pages whose
+// zone map entry or Bloom filter shows that none of their tuples satisfy all
+// of preds, and drops tuples that don't pass the runtime filters.  The pages
+// ahead of the scan are prefetched if the buffer pool has read-ahead on.  The
+// iterator may still return tuples that don't satisfy preds or pass the
+// filters, so callers must check them.  Skipped pages are still read
+// locked, so that no concurrent transaction can add tuples satisfying preds
+// to them before tid completes.
+func (f *HeapFile) iteratorWhere(preds []zonePredicate, filters []runtimeFilter, tid TransactionID) (func() (*Tuple, error), error) {
 	numPages := f.NumPages()
 	pageId := -1
+	prefetched := 0 // the first page that hasn't been prefetched
 	var iter func() (*Tuple, error)
 	initNewPage := true
 	return func() (*Tuple, error) {
@@ -295,6 +552,14 @@ func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 				if pageId == numPages {
 					return nil, nil
 				}
+				f.readAhead(pageId, numPages, preds, &prefetched)
+				skip, err := f.canSkipPage(pageId, preds, tid)
+				if err != nil {
+					return nil, err
+				}
+				if skip {
+					continue
+				}
 				page, err := f.bufPool.GetPage(f, pageId, tid, ReadPerm)
 				if err != nil {
 					// todo 这里返回err会导致app_op_test.go通过失败，
@@ -309,6 +574,9 @@ func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 				return nil, err
 			}
 			if tuple != nil {
+				if !passesRuntimeFilters(filters, tuple) {
+					continue
+				}
 				return tuple, nil
 			} else {
 				initNewPage = true
@@ -318,6 +586,50 @@ func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	}, nil
 }
 
+// Whether the tuples on a page can't satisfy all of preds, according to the
+// Bloom filter indexes on the columns of equality predicates, or to its zone
+// map entry.  The zone map entry is only used if the page isn't in the buffer
+// pool, since a cached page may differ from what is on disk.  The page is read
+// locked by tid first, so that the answer holds until tid completes.
+func (f *HeapFile) canSkipPage(pageNo int, preds []zonePredicate, tid TransactionID) (bool, error) {
+	if len(preds) == 0 {
+		return false, nil
+	}
+	err := f.bufPool.lockPage(f, pageNo, tid, ReadPerm)
+	if err != nil {
+		return false, err
+	}
+	for _, index := range f.indexes {
+		bloom, ok := index.(*BloomFile)
+		if !ok {
+			continue
+		}
+		for _, p := range preds {
+			if p.op != OpEq || p.field != bloom.keyIndex {
+				continue
+			}
+			found, err := bloom.mayContain(pageNo, p.value, tid)
+			if err != nil {
+				return false, err
+			}
+			if !found {
+				return true, nil
+			}
+		}
+	}
+	if f.bufPool.isCached(f, pageNo) {
+		return false, nil
+	}
+	z := f.zones.read(pageNo)
+	return z != nil && !z.mayMatch(preds), nil
+}
+
+// Return an iterator over the records in the heap file that drops the tuples
+// that don't pass the runtime filters
+func (f *HeapFile) runtimeFilteredIterator(filters []runtimeFilter, tid TransactionID) (func() (*Tuple, error), error) {
+	return f.iteratorWhere(nil, filters, tid)
+}
+
 // internal strucuture to use as key for a heap page
 type heapHash struct {
 	FileName string
@@ -333,3 +645,18 @@ func (f *HeapFile) pageKey(pgNo int) any {
 	key := heapHash{FileName: f.fileName, PageNo: pgNo}
 	return key
 }
+
+// Make every page flushed so far durable, along with the page map of a
+// compressed file.  The free space and zone maps are only hints, so they are
+// left to the operating system.  The file of a temporary table is never
+// synced.
+func (f *HeapFile) sync() error {
+	if f.temporary {
+		return nil
+	}
+	err := f.file.Sync()
+	if err != nil || f.pages == nil {
+		return err
+	}
+	return f.pages.file.Sync()
+}
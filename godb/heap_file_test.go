@@ -2,6 +2,7 @@ package godb
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -206,3 +207,46 @@ func TestHeapFilePageKey(t *testing.T) {
 		}
 	}
 }
+
+func TestHeapFileCorruptPage(t *testing.T) {
+	td, t1, t2, hf, bp, tid := makeTestVars()
+	hf.insertTuple(&t1, tid)
+	hf.insertTuple(&t2, tid)
+	bp.CommitTransaction(tid)
+
+	pg, err := hf.readPage(0)
+	if err != nil {
+		t.Fatalf("failed to read valid page: %s", err)
+	}
+	if (*pg).(*heapPage).lsn == 0 {
+		t.Errorf("expected flushed page to have an LSN")
+	}
+
+	// flip a bit in the first tuple
+	f, err := os.OpenFile(TestingFile, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	b := make([]byte, 1)
+	firstTuple := int64(PageSize + heapPageHeaderSize + slotBitmapSize(calNumSlot(&td, PageSize)))
+	f.ReadAt(b, firstTuple)
+	b[0] ^= 1
+	f.WriteAt(b, firstTuple)
+	f.Close()
+
+	hf2, err := NewHeapFile(TestingFile, &td, NewBufferPool(3))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = hf2.readPage(0)
+	if err == nil {
+		t.Fatalf("expected error reading corrupt page")
+	}
+	gerr, ok := err.(GoDBError)
+	if !ok || gerr.code != CorruptPageError {
+		t.Fatalf("expected CorruptPageError, got %v", err)
+	}
+	if !strings.Contains(gerr.errString, TestingFile) || !strings.Contains(gerr.errString, "page 0") {
+		t.Errorf("expected error to name file and page, got %s", gerr.errString)
+	}
+}
@@ -0,0 +1,319 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"os"
+)
+
+// The heap file header.  The first page of a heap file describes the rest of
+// it, so that a file can't be opened with the wrong schema or page size, or
+// without its key, and so that its format can change:
+//
+//	magic         8 bytes, "GODBHEAP"
+//	version       uint32, the format version, currentHeapFileVersion
+//	page size     uint32
+//	flags         uint32, heapFileEncrypted and heapFileCompressed
+//	schema hash   uint64, see [schemaHash]
+//	checksum      uint32, CRC-32C of the bytes before it
+//
+// all little endian and padded with zeros to the size of a page slot.  The
+// header is never encrypted or compressed;  it holds nothing from the tuples.
+// Page n of the file is stored in slot n+1, or in the extents of a
+// compressed file, which follow the header.
+//
+// Files written by the original version of GoDB, before there was a header,
+// are format version 0.  Their pages are PageSize bytes, and aren't
+// compressed or encrypted;  each holds the number of slots and of used slots,
+// followed by the used slots packed together, without a slot bitmap, LSN or
+// checksum.  A version 0 file can't be opened until [UpgradeHeapFile] has
+// rewritten it in the current format.  UpgradeHeapFile is also the upgrade
+// path from every later version once the format changes again.
+
+const (
+	heapFileMagic           = "GODBHEAP"
+	currentHeapFileVersion  = 1
+	heapFileHeaderFieldSize = 28 // followed by the checksum
+)
+
+// Flags of the heap file header
+const (
+	heapFileEncrypted = 1 << iota
+	heapFileCompressed
+)
+
+type heapFileHeader struct {
+	version    int
+	pageSize   int
+	flags      uint32
+	schemaHash uint64
+}
+
+// Hash the layout of the tuples of a schema:  the number and types of its
+// fields.  Field names are left out, since a file can be read under any names
+// without producing garbage.
+func schemaHash(desc *TupleDesc) uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, int32(StringLength))
+	for _, field := range desc.Fields {
+		binary.Write(h, binary.LittleEndian, int32(field.Ftype))
+	}
+	return h.Sum64()
+}
+
+// Serialize the header into size bytes
+func (h *heapFileHeader) toBytes(size int) []byte {
+	b := new(bytes.Buffer)
+	b.WriteString(heapFileMagic)
+	binary.Write(b, binary.LittleEndian, uint32(h.version))
+	binary.Write(b, binary.LittleEndian, uint32(h.pageSize))
+	binary.Write(b, binary.LittleEndian, h.flags)
+	binary.Write(b, binary.LittleEndian, h.schemaHash)
+	binary.Write(b, binary.LittleEndian, crc32.Checksum(b.Bytes(), pageChecksumTable))
+	b.Write(make([]byte, size-b.Len()))
+	return b.Bytes()
+}
+
+// Read the header of a heap file.  Returns nil if the file has none, i.e., it
+// is empty or in format version 0.
+func readHeapFileHeader(file File, fileName string) (*heapFileHeader, error) {
+	buf := make([]byte, heapFileHeaderFieldSize+4)
+	n, err := file.ReadAt(buf, 0)
+	if n < len(heapFileMagic) || string(buf[:len(heapFileMagic)]) != heapFileMagic {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("header of %s is cut off", fileName)}
+	}
+	if crc32.Checksum(buf[:heapFileHeaderFieldSize], pageChecksumTable) != binary.LittleEndian.Uint32(buf[heapFileHeaderFieldSize:]) {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("header of %s is corrupt", fileName)}
+	}
+	return &heapFileHeader{
+		version:    int(binary.LittleEndian.Uint32(buf[8:])),
+		pageSize:   int(binary.LittleEndian.Uint32(buf[12:])),
+		flags:      binary.LittleEndian.Uint32(buf[16:]),
+		schemaHash: binary.LittleEndian.Uint64(buf[20:]),
+	}, nil
+}
+
+// Check that a heap file's header matches how it is being opened.  pageSize
+// is 0 if any page size will do.
+func (h *heapFileHeader) check(fileName string, desc *TupleDesc, pageSize int, flags uint32) error {
+	if h.version > currentHeapFileVersion {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("%s has format version %d, this version of GoDB only reads up to %d", fileName, h.version, currentHeapFileVersion)}
+	}
+	if err := checkPageSize(h.pageSize); err != nil {
+		return GoDBError{MalformedDataError, fmt.Sprintf("header of %s is corrupt: %s", fileName, err.Error())}
+	}
+	if pageSize != 0 && h.pageSize != pageSize {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("%s has %d byte pages, not %d", fileName, h.pageSize, pageSize)}
+	}
+	if h.schemaHash != schemaHash(desc) {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("%s was created for another schema", fileName)}
+	}
+	if h.flags&heapFileEncrypted != flags&heapFileEncrypted {
+		if h.flags&heapFileEncrypted != 0 {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("%s is encrypted", fileName)}
+		}
+		return GoDBError{IllegalOperationError, fmt.Sprintf("%s is not encrypted", fileName)}
+	}
+	if h.flags&heapFileCompressed != flags&heapFileCompressed {
+		return GoDBError{MalformedDataError, fmt.Sprintf("header of %s doesn't agree with its page map", fileName)}
+	}
+	return nil
+}
+
+// Rewrite a heap file in the current format, with pages of pageSize bytes,
+// or PageSize if it is 0.  The new file is written next to the old one and
+// then put in its place by [replaceHeapFile].  The file must not be open.
+// Upgrading a file that is already in the current format does nothing.
+//
+// The tuples of a file in format version 0 are packed into new pages, so they
+// get new record ids, and indexes on the file must be rebuilt.  A page that
+// can't be decoded fails the upgrade.
+func UpgradeHeapFile(fileName string, td *TupleDesc, pageSize int, bp *BufferPool) error {
+	return upgradeHeapFile(fileName, td, pageSize, nil, bp)
+}
+
+// Upgrade a heap file like UpgradeHeapFile, encrypting the new file with
+// cipher unless it is nil
+func upgradeHeapFile(fileName string, td *TupleDesc, pageSize int, cipher *pageCipher, bp *BufferPool) error {
+	old, err := openHeapFileOfAnyVersion(fileName, td, pageSize, "", cipher, bp)
+	if err != nil {
+		return err
+	}
+	defer old.close()
+	if old.version == currentHeapFileVersion {
+		return nil
+	}
+	err = replaceHeapFile(bp.vfs, fileName, func(newName string) error {
+		upgraded, err := openHeapFile(newName, td, pageSize, "", cipher, bp)
+		if err != nil {
+			return err
+		}
+		defer upgraded.close()
+		err = old.repackInto(upgraded)
+		if err != nil {
+			return err
+		}
+		return upgraded.sync()
+	})
+	return err
+}
+
+// Decode the tuples of every page of a heap file in format version 0, and
+// pack them into the pages of another, empty one
+func (f *HeapFile) repackInto(to *HeapFile) error {
+	packer := newHeapFilePacker(to)
+	for pageNo := 0; pageNo < f.NumPages(); pageNo++ {
+		data, err := f.readPageData(pageNo)
+		if err != nil {
+			return err
+		}
+		tuples, err := decodePackedPage(data, f.desc)
+		if err != nil {
+			return f.corruptPageError(pageNo, err.Error())
+		}
+		for _, t := range tuples {
+			err = packer.add(t)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err := packer.finish()
+	return err
+}
+
+// Names a replacement of a heap file is written under, while it is being
+// written and once it is complete
+func partialReplacementFileName(heapFileName string) string {
+	return heapFileName + ".new.tmp"
+}
+
+func replacementFileName(heapFileName string) string {
+	return heapFileName + ".new"
+}
+
+// The side files of a heap file that are replaced along with it, if they
+// exist
+func heapSideFileNames(heapFileName string) []string {
+	return []string{pageMapFileName(heapFileName), fsmFileName(heapFileName), zoneMapFileName(heapFileName)}
+}
+
+// Replace a heap file and its side files all at once.  write must write and
+// sync the new heap file under the name it is given, and close it.  The new
+// files are then renamed to their replacement names, the data file last, so
+// the replacement is complete once a file with the data file's replacement
+// name exists.  [finishReplacement] then renames them over the originals;  if
+// that is interrupted, it is done again the next time the file is opened,
+// while an interrupted write is thrown away.
+func replaceHeapFile(vfs VFS, fileName string, write func(newName string) error) error {
+	partial := partialReplacementFileName(fileName)
+	removeHeapFile(vfs, partial)
+	removeHeapFile(vfs, replacementFileName(fileName))
+	err := write(partial)
+	if err != nil {
+		removeHeapFile(vfs, partial)
+		return err
+	}
+	replacements := heapSideFileNames(replacementFileName(fileName))
+	for i, name := range heapSideFileNames(partial) {
+		if _, err := vfs.Stat(name); err != nil {
+			continue
+		}
+		err = vfs.Rename(name, replacements[i])
+		if err != nil {
+			return err
+		}
+	}
+	err = vfs.Rename(partial, replacementFileName(fileName))
+	if err != nil {
+		return err
+	}
+	return finishReplacement(vfs, fileName)
+}
+
+// Complete a replacement of a heap file by [replaceHeapFile] that was
+// interrupted, or throw it away if it wasn't complete.
+func finishReplacement(vfs VFS, fileName string) error {
+	newName := replacementFileName(fileName)
+	if _, err := vfs.Stat(newName); err != nil {
+		removeHeapFile(vfs, partialReplacementFileName(fileName))
+		for _, name := range heapSideFileNames(newName) {
+			vfs.Remove(name)
+		}
+		return nil
+	}
+	originals := heapSideFileNames(fileName)
+	for i, name := range heapSideFileNames(newName) {
+		if _, err := vfs.Stat(name); err != nil {
+			continue
+		}
+		if err := vfs.Rename(name, originals[i]); err != nil {
+			return err
+		}
+	}
+	return vfs.Rename(newName, fileName)
+}
+
+// Upgrade the files of every heap table in the catalog to the current
+// format, and rebuild the indexes of tables whose files were rewritten.
+// Returns the number of tables whose files were rewritten.  Files can't be
+// upgraded while a transaction is open.
+func (c *Catalog) Upgrade() (int, error) {
+	err := c.bp.checkNoTransactions("an upgrade")
+	if err != nil {
+		return 0, err
+	}
+	upgraded := 0
+	for _, t := range c.tables {
+		if t.clusterKey != "" || t.columnar {
+			continue
+		}
+		rewritten := false
+		for _, fileName := range c.tableFileNames(t) {
+			if _, err := c.bp.vfs.Stat(fileName); err != nil {
+				continue
+			}
+			file, err := c.bp.vfs.OpenFile(fileName, os.O_RDONLY, 0)
+			if err != nil {
+				return upgraded, err
+			}
+			header, err := readHeapFileHeader(file, fileName)
+			file.Close()
+			if err != nil {
+				return upgraded, err
+			}
+			if header != nil && header.version == currentHeapFileVersion {
+				continue
+			}
+			c.closeTable(t.name)
+			err = upgradeHeapFile(fileName, t.desc.copy(), c.pageSize, c.cipher, c.bp)
+			if err != nil {
+				return upgraded, err
+			}
+			rewritten = true
+		}
+		if !rewritten {
+			continue
+		}
+		upgraded++
+		if len(c.tableIndexes(t.name)) > 0 {
+			file, err := c.GetTable(t.name)
+			if err != nil {
+				return upgraded, err
+			}
+			_, err = c.bp.runTransaction(func(tid TransactionID) (int, error) {
+				return 0, file.(*HeapFile).rebuildIndexes(tid)
+			})
+			if err != nil {
+				return upgraded, err
+			}
+		}
+	}
+	return upgraded, nil
+}
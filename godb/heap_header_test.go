@@ -0,0 +1,315 @@
+package godb
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Write tuples to a new heap file in vfs, and return the number of its pages
+func makeHeaderTestFile(t *testing.T, vfs VFS, fileName string, td *TupleDesc, pageSize int, compression string) int {
+	bp := NewBufferPoolWithVFS(50, vfs)
+	hf, err := openHeapFile(fileName, td, pageSize, compression, nil, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, t1, t2, _, _, _ := makeTestVars()
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 300; i++ {
+		hf.insertTuple(&t1, tid)
+		hf.insertTuple(&t2, tid)
+	}
+	err = bp.CommitTransaction(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return hf.NumPages()
+}
+
+// Rewrite a heap file as a file in format version 0
+func downgradeHeapFile(t *testing.T, vfs VFS, fileName string, td *TupleDesc) {
+	bp := NewBufferPoolWithVFS(50, vfs)
+	hf, err := NewHeapFile(fileName, td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tuples := collectTuples(t, hf, bp)
+	hf.close()
+	perPage := (PageSize - packedPageHeaderSize) / calBytesPerTuple(td)
+	f, err := vfs.OpenFile(fileName, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for len(tuples) > 0 {
+		n := perPage
+		if n > len(tuples) {
+			n = len(tuples)
+		}
+		f.Write(encodePackedPage(td, tuples[:n]))
+		tuples = tuples[n:]
+	}
+	f.Close()
+	vfs.Remove(fsmFileName(fileName))
+	vfs.Remove(zoneMapFileName(fileName))
+}
+
+func TestHeapFileHeader(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars()
+	vfs := NewMemFS()
+	numPages := makeHeaderTestFile(t, vfs, "header.dat", &td, 8192, "")
+
+	// the page size is taken from the header
+	bp := NewBufferPoolWithVFS(50, vfs)
+	hf, err := NewHeapFile("header.dat", &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if hf.pageSize != 8192 || hf.NumPages() != numPages || hf.version != currentHeapFileVersion {
+		t.Errorf("expected %d pages of 8192 bytes, got %d of %d", numPages, hf.NumPages(), hf.pageSize)
+	}
+	if n := len(collectTuples(t, hf, bp)); n != 600 {
+		t.Errorf("expected 600 tuples, got %d", n)
+	}
+
+	expectError := func(code GoDBErrorCode, err error, format string) {
+		t.Helper()
+		if e, ok := err.(GoDBError); !ok || e.code != code {
+			t.Errorf(format+", got %v", err)
+		}
+	}
+	_, err = NewHeapFileWithPageSize("header.dat", &td, PageSize, NewBufferPoolWithVFS(50, vfs))
+	expectError(IllegalOperationError, err, "expected another page size to be rejected")
+	other := TupleDesc{Fields: []FieldType{{Fname: "age", Ftype: IntType}, {Fname: "name", Ftype: StringType}}}
+	_, err = NewHeapFile("header.dat", &other, NewBufferPoolWithVFS(50, vfs))
+	expectError(TypeMismatchError, err, "expected another schema to be rejected")
+	c, err := newPageCipher(testEncryptionKey)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = openHeapFile("header.dat", &td, 0, "", c, NewBufferPoolWithVFS(50, vfs))
+	expectError(IllegalOperationError, err, "expected a plain file opened with a key to be rejected")
+
+	// field names aren't part of the schema
+	renamed := TupleDesc{Fields: []FieldType{{Fname: "n", Ftype: StringType}, {Fname: "a", Ftype: IntType}}}
+	if _, err := NewHeapFile("header.dat", &renamed, NewBufferPoolWithVFS(50, vfs)); err != nil {
+		t.Errorf("expected renamed fields to be accepted, got %v", err)
+	}
+
+	f, _ := vfs.OpenFile("header.dat", os.O_RDWR, 0)
+	f.WriteAt([]byte{0xff}, 13)
+	f.Close()
+	_, err = NewHeapFile("header.dat", &td, NewBufferPoolWithVFS(50, vfs))
+	expectError(MalformedDataError, err, "expected a corrupt header to be rejected")
+}
+
+func TestUpgradeHeapFile(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars()
+	expectError := func(code GoDBErrorCode, err error, format string) {
+		t.Helper()
+		if e, ok := err.(GoDBError); !ok || e.code != code {
+			t.Errorf(format+", got %v", err)
+		}
+	}
+	vfs := NewMemFS()
+	makeHeaderTestFile(t, vfs, "legacy.dat", &td, PageSize, "")
+	downgradeHeapFile(t, vfs, "legacy.dat", &td)
+
+	// the file can't be opened, but it can be upgraded, to another page size
+	_, err := NewHeapFile("legacy.dat", &td, NewBufferPoolWithVFS(50, vfs))
+	expectError(UnsupportedFormatError, err, "expected a file in format version 0 to be rejected")
+	err = UpgradeHeapFile("legacy.dat", &td, 8192, NewBufferPoolWithVFS(50, vfs))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, name := range vfs.Names() {
+		if strings.HasPrefix(name, replacementFileName("legacy.dat")) {
+			t.Errorf("expected %s to be gone after the upgrade", name)
+		}
+	}
+	bp := NewBufferPoolWithVFS(50, vfs)
+	hf, err := NewHeapFile("legacy.dat", &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if hf.version != currentHeapFileVersion || hf.pageSize != 8192 {
+		t.Errorf("expected a version %d file of 8192 byte pages, got version %d of %d byte pages", currentHeapFileVersion, hf.version, hf.pageSize)
+	}
+	names := make(map[string]int)
+	for _, tup := range collectTuples(t, hf, bp) {
+		names[tup.Fields[0].(StringField).Value]++
+	}
+	if names["sam"] != 300 || names["george jones"] != 300 || len(names) != 2 {
+		t.Errorf("expected 300 of each tuple after the upgrade, got %v", names)
+	}
+	hf.close()
+	report := &FsckReport{}
+	err = fsckHeapFile(vfs, "legacy", "legacy.dat", &td, 8192, nil, false, report, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 || report.Tuples != 600 {
+		t.Errorf("expected 600 tuples and no problems after the upgrade, got %s", report.String())
+	}
+
+	// upgrading a current file does nothing
+	before, _ := readVFSFile(vfs, "legacy.dat")
+	err = UpgradeHeapFile("legacy.dat", &td, 0, NewBufferPoolWithVFS(50, vfs))
+	if err != nil {
+		t.Errorf("expected upgrading a current file to succeed, got %v", err)
+	}
+	if after, _ := readVFSFile(vfs, "legacy.dat"); !bytes.Equal(before, after) {
+		t.Errorf("expected upgrading a current file to leave it alone")
+	}
+
+	// a page that can't be decoded fails the upgrade, which leaves the file
+	// alone
+	makeHeaderTestFile(t, vfs, "damaged.dat", &td, PageSize, "")
+	downgradeHeapFile(t, vfs, "damaged.dat", &td)
+	f, _ := vfs.OpenFile("damaged.dat", os.O_RDWR, 0)
+	f.WriteAt(bytes.Repeat([]byte{0xab}, PageSize), int64(PageSize))
+	f.Close()
+	damaged, _ := readVFSFile(vfs, "damaged.dat")
+	err = UpgradeHeapFile("damaged.dat", &td, PageSize, NewBufferPoolWithVFS(50, vfs))
+	expectError(CorruptPageError, err, "expected a damaged page to fail the upgrade")
+	if data, _ := readVFSFile(vfs, "damaged.dat"); !bytes.Equal(data, damaged) {
+		t.Errorf("expected a failed upgrade to leave the file alone")
+	}
+	for _, name := range vfs.Names() {
+		if strings.HasPrefix(name, replacementFileName("damaged.dat")) {
+			t.Errorf("expected no %s after a failed upgrade", name)
+		}
+	}
+}
+
+func TestInterruptedReplacement(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars()
+	vfs := NewMemFS()
+	makeHeaderTestFile(t, vfs, "new.dat", &td, PageSize, "lz")
+	makeHeaderTestFile(t, vfs, "old.dat", &td, PageSize, "lz")
+	newData, _ := readVFSFile(vfs, "new.dat")
+	newMap, _ := readVFSFile(vfs, pageMapFileName("new.dat"))
+	oldData, _ := readVFSFile(vfs, "old.dat")
+	oldMap, _ := readVFSFile(vfs, pageMapFileName("old.dat"))
+	writeFile := func(name string, data []byte) {
+		f, _ := vfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		f.Write(data)
+		f.Close()
+	}
+	expectFile := func(name string, contents []byte, what string) {
+		data, _ := readVFSFile(vfs, name)
+		if string(data) != string(contents) {
+			t.Errorf("expected %s to be %s", name, what)
+		}
+	}
+
+	// interrupted while the new files were written:  they are thrown away
+	partial := partialReplacementFileName("old.dat")
+	writeFile(partial, newData)
+	writeFile(pageMapFileName(partial), newMap)
+	err := finishReplacement(vfs, "old.dat")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expectFile("old.dat", oldData, "left alone")
+	expectFile(pageMapFileName("old.dat"), oldMap, "left alone")
+	for _, name := range vfs.Names() {
+		if strings.HasPrefix(name, replacementFileName("old.dat")) {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+
+	// interrupted while moving the side files into place, before the data
+	// file:  the replacement is finished
+	writeFile(replacementFileName("old.dat"), newData)
+	writeFile(pageMapFileName("old.dat"), newMap)
+	err = finishReplacement(vfs, "old.dat")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expectFile("old.dat", newData, "replaced")
+	expectFile(pageMapFileName("old.dat"), newMap, "replaced")
+
+	// a complete replacement is renamed into place when the file is opened
+	writeFile(replacementFileName("new.dat"), oldData)
+	writeFile(pageMapFileName(replacementFileName("new.dat")), oldMap)
+	hf, err := openHeapFile("new.dat", &td, 0, "", nil, NewBufferPoolWithVFS(10, vfs))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hf.close()
+	expectFile("new.dat", oldData, "replaced")
+	expectFile(pageMapFileName("new.dat"), oldMap, "replaced")
+	if _, err := vfs.Stat(replacementFileName("new.dat")); err == nil {
+		t.Errorf("expected the replacement to be renamed into place")
+	}
+}
+
+func TestCatalogUpgrade(t *testing.T) {
+	vfs := NewMemFS()
+	c, err := CreateCatalog("catalog.txt", NewBufferPoolWithVFS(50, vfs), "db", 8192)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, sql := range []string{
+		"create table people (name text, age int)",
+		"create table pets (name text, age int)",
+	} {
+		if _, _, err := Parse(c, sql); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	runQuery(t, c, "insert into people values ('sam', 25), ('kathy', 45), ('bill', 30)")
+	runQuery(t, c, "insert into pets values ('rex', 3)")
+	runQuery(t, c, "delete from people where name = 'sam'")
+	if _, _, err := Parse(c, "create index people_age on people (age)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = c.SaveToFile("catalog.txt", "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// packing the tuples of people together moves them to other slots, so
+	// its index has to be rebuilt
+	err = c.Close()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	downgradeHeapFile(t, vfs, c.tableNameToFile("people"), c.tableMap["people"].desc.copy())
+
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPoolWithVFS(50, vfs), "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	if _, err := c.Upgrade(); err == nil || err.(GoDBError).code != IllegalOperationError {
+		t.Errorf("expected an upgrade while a transaction is open to be rejected, got %v", err)
+	}
+	c.bp.CommitTransaction(tid)
+	upgraded, err := c.Upgrade()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if upgraded != 1 {
+		t.Errorf("expected only people to be upgraded, got %d tables", upgraded)
+	}
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPoolWithVFS(50, vfs), "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if results, _ := runQuery(t, c, "select name from people"); len(results) != 2 {
+		t.Errorf("expected 2 people after the upgrade, got %v", results)
+	}
+	if results, _ := runQuery(t, c, "select name from people where age = 45"); strings.Join(results, ",") != "[{kathy}]" {
+		t.Errorf("expected the index to find kathy after the upgrade, got %v", results)
+	}
+	report, err := c.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 || report.Tuples != 3 || report.Indexes != 1 {
+		t.Errorf("expected 3 tuples, one index and no problems, got %s", report.String())
+	}
+}
@@ -0,0 +1,114 @@
+package godb
+
+import (
+	"errors"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// The memory-mapped read path of heap files.  With [BufferPool.SetReadMode]
+// set to [MmapReads], a heap file whose pages are neither compressed nor
+// encrypted maps its data file read-only and decodes pages straight out of
+// the mapping, instead of allocating a page sized buffer and copying each
+// page into it with ReadAt.  Pages are still written with WriteAt;  the
+// operating system keeps the mapping coherent with those writes.
+//
+// A mapping covers the file as it was when it was made, and is remade when a
+// page past its end is read.  Pages that can't be read from a mapping, e.g.
+// because the VFS doesn't map files or the file was truncated underneath it,
+// are read with ReadAt as usual.
+
+// How heap files read their pages
+type ReadMode int
+
+const (
+	// Read each page into a new buffer with ReadAt
+	ReadAtReads ReadMode = iota
+	// Decode the pages of plain heap files from a memory mapping of the file
+	MmapReads
+)
+
+// The error returned when a file can't be memory mapped
+var errMmapUnsupported = errors.New("file can't be memory mapped")
+
+// A read-only memory mapping of the data file of a heap file
+type heapMapping struct {
+	sync.RWMutex
+	data   []byte
+	failed bool // the file can't be mapped, so it is read with ReadAt
+}
+
+// Make sure the mapping covers at least size bytes of file, remapping the
+// whole file if it doesn't.  Returns false if the file can't be mapped or
+// is shorter than size.
+func (m *heapMapping) extend(file File, size int) bool {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.data) >= size {
+		return true
+	}
+	if m.failed {
+		return false
+	}
+	stat, err := file.Stat()
+	if err != nil || int(stat.Size()) < size {
+		return false
+	}
+	data, err := mmapFile(file, int(stat.Size()))
+	if err != nil {
+		m.failed = true
+		return false
+	}
+	if m.data == nil {
+		// heap files opened for a query are never closed, so the mapping
+		// goes away with the HeapFile
+		runtime.SetFinalizer(m, (*heapMapping).release)
+	} else {
+		munmap(m.data)
+	}
+	m.data = data
+	return true
+}
+
+// Unmap the file.  It is mapped again by the next read that needs it.
+func (m *heapMapping) release() {
+	m.Lock()
+	defer m.Unlock()
+	if m.data != nil {
+		munmap(m.data)
+		m.data = nil
+	}
+}
+
+// Whether pages of the file are read from a mapping of it
+func (f *HeapFile) mapped() bool {
+	return f.bufPool.getReadMode() == MmapReads && f.pages == nil && f.cipher == nil
+}
+
+// Decode page pageNo from the mapping of the file.  Returns false if it
+// can't be read that way, and must be read with ReadAt.
+func (f *HeapFile) readMappedPage(pageNo int) (page *Page, ok bool, err error) {
+	m := f.mapping
+	start := (pageNo + f.firstSlot()) * f.pageSize
+	end := start + f.pageSize
+	if !m.extend(f.file, end) {
+		return nil, false, nil
+	}
+	m.RLock()
+	defer m.RUnlock()
+	if end > len(m.data) {
+		// unmapped since it was extended
+		return nil, false, nil
+	}
+	// a file truncated by another HeapFile leaves part of the mapping past
+	// its end, where reads fault
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if recover() != nil {
+			page, ok, err = nil, false, nil
+		}
+	}()
+	page, err = f.decodePage(pageNo, m.data[start:end])
+	return page, true, err
+}
@@ -0,0 +1,178 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write numPages full pages of tuples to a new heap file, without going
+// through the buffer pool.  Returns the number of tuples written.
+func makeScanTestFile(tb testing.TB, fileName string, td *TupleDesc, numPages int) int {
+	hf, err := NewHeapFile(fileName, td, NewBufferPool(10))
+	if err != nil {
+		tb.Fatalf(err.Error())
+	}
+	n := 0
+	for pageNo := 0; pageNo < numPages; pageNo++ {
+		page := newHeapPage(td, pageNo, hf)
+		for page.usedSlots < page.numSlots {
+			_, err := page.insertTuple(&Tuple{*td, []DBValue{StringField{fmt.Sprintf("name%d", n)}, IntField{int64(n)}}, nil})
+			if err != nil {
+				tb.Fatalf(err.Error())
+			}
+			n++
+		}
+		p := Page(page)
+		err := hf.flushPage(&p)
+		if err != nil {
+			tb.Fatalf(err.Error())
+		}
+	}
+	hf.close()
+	return n
+}
+
+func TestMmapReads(t *testing.T) {
+	td, t1, _, _, _, _ := makeTestVars()
+	fileName := filepath.Join(t.TempDir(), "mmap.dat")
+	n := makeScanTestFile(t, fileName, &td, 20)
+
+	bp := NewBufferPool(100)
+	bp.SetReadMode(MmapReads)
+	hf, err := NewHeapFile(fileName, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if got := len(collectTuples(t, hf, bp)); got != n {
+		t.Fatalf("expected %d tuples, got %d", n, got)
+	}
+	if len(hf.mapping.data) != 21*PageSize {
+		t.Fatalf("expected the header and 20 pages to be mapped, got %d bytes", len(hf.mapping.data))
+	}
+
+	// pages appended after the file was mapped are read from a new mapping
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 300; i++ {
+		hf.insertTuple(&t1, tid)
+	}
+	err = bp.CommitTransaction(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp = NewBufferPool(100)
+	bp.SetReadMode(MmapReads)
+	hf.bufPool = bp
+	if got := len(collectTuples(t, hf, bp)); got != n+300 {
+		t.Errorf("expected %d tuples after the inserts, got %d", n+300, got)
+	}
+	if len(hf.mapping.data) != (hf.NumPages()+1)*PageSize {
+		t.Errorf("expected the mapping to cover all %d pages", hf.NumPages())
+	}
+
+	// a page truncated off by another heap file is read with ReadAt, which
+	// fails, rather than faulting
+	other, err := NewHeapFile(fileName, &td, NewBufferPool(10))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = truncateFilePages(other.file, nil, PageSize, 3)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := hf.readPage(10); err == nil {
+		t.Errorf("expected reading a truncated page to fail")
+	}
+	if _, err := hf.readPage(1); err != nil {
+		t.Errorf("expected page 1 to be readable, got %v", err)
+	}
+
+	// a corrupt page is reported as such
+	f, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	f.WriteAt([]byte{0xff}, int64(PageSize+100))
+	f.Close()
+	if _, err := hf.readPage(0); err == nil || err.(GoDBError).code != CorruptPageError {
+		t.Errorf("expected a corrupt page error, got %v", err)
+	}
+	hf.close()
+}
+
+func TestMmapReadsFallBack(t *testing.T) {
+	td, t1, t2, _, _, _ := makeTestVars()
+	bp := NewBufferPoolWithVFS(10, NewMemFS())
+	bp.SetReadMode(MmapReads)
+	hf, err := NewHeapFile("mem.dat", &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 100; i++ {
+		hf.insertTuple(&t1, tid)
+		hf.insertTuple(&t2, tid)
+	}
+	bp.CommitTransaction(tid)
+	hf.bufPool = NewBufferPoolWithVFS(10, bp.vfs)
+	hf.bufPool.SetReadMode(MmapReads)
+	if got := len(collectTuples(t, hf, hf.bufPool)); got != 200 {
+		t.Errorf("expected 200 tuples, got %d", got)
+	}
+	if !hf.mapping.failed {
+		t.Errorf("expected the files of a MemFS not to be mapped")
+	}
+}
+
+// Scan a heap file of 2000 pages with each read mode, with and without
+// read-ahead, through a buffer pool too small to cache it
+func BenchmarkHeapFileScan(b *testing.B) {
+	td, _, _, _, _, _ := makeTestVars()
+	fileName := filepath.Join(b.TempDir(), "scan.dat")
+	n := makeScanTestFile(b, fileName, &td, 2000)
+	for _, mode := range []struct {
+		name      string
+		mode      ReadMode
+		readAhead int
+	}{{"ReadAt", ReadAtReads, 0}, {"Mmap", MmapReads, 0}, {"ReadAtReadAhead", ReadAtReads, 32}, {"MmapReadAhead", MmapReads, 32}} {
+		b.Run(mode.name, func(b *testing.B) {
+			bp := NewBufferPool(100)
+			bp.SetReadMode(mode.mode)
+			bp.SetReadAhead(mode.readAhead)
+			hf, err := NewHeapFile(fileName, &td, bp)
+			if err != nil {
+				b.Fatalf(err.Error())
+			}
+			defer hf.close()
+			b.ReportAllocs()
+			b.SetBytes(int64(hf.NumPages() * PageSize))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tid := NewTID()
+				bp.BeginTransaction(tid)
+				iter, err := hf.Iterator(tid)
+				if err != nil {
+					b.Fatalf(err.Error())
+				}
+				count := 0
+				for {
+					tup, err := iter()
+					if err != nil {
+						b.Fatalf(err.Error())
+					}
+					if tup == nil {
+						break
+					}
+					count++
+				}
+				bp.CommitTransaction(tid)
+				if count != n {
+					b.Fatalf("expected %d tuples, got %d", n, count)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,9 @@ package godb
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -16,9 +19,12 @@ tuples.
 In GoDB all tuples are fixed length, which means that given a TupleDesc it is
 possible to figure out how many tuple "slots" fit on a given page.
 
-In addition, all pages are PageSize bytes.  They begin with a header with a 32
+In addition, all pages of a file are the same size, PageSize bytes unless the
+file was created with another page size (see [CreateCatalog]).  They begin with a header with a 32
 bit integer with the number of slots (tuples), and a second 32 bit integer with
-the number of used slots.
+the number of used slots, followed by the 64 bit LSN of the page, a 32 bit
+CRC-32C checksum of the whole page (computed with the checksum field set to 0),
+and a bitmap with one bit per slot that is set if the slot is in use.
 
 Each tuple occupies the same number of bytes.  You can use the go function
 unsafe.Sizeof() to determine the size in bytes of an object.  So, a GoDB integer
@@ -30,21 +36,23 @@ tuple is just the sum of the size in bytes of its fields.
 Once you have figured out how big a record is, you can determine the number of
 slots on on the page as:
 
-remPageSize = PageSize - 8 // bytes after header
-numSlots = remPageSize / bytesPerTuple //integer division will round down
+remPageSize = pageSize - heapPageHeaderSize // bytes after header
+numSlots = remPageSize * 8 / (bytesPerTuple * 8 + 1) // each slot also needs a bit
 
 To serialize a page to a buffer, you can then:
 
 write the number of slots as an int32
 write the number of used slots as an int32
-write the tuples themselves to the buffer
+write the LSN as an int64 and a placeholder for the checksum as a uint32
+write the slot bitmap
+write every slot, with unused slots filled with zeros
+pad the buffer to the page size and fill in the checksum
 
 You will follow the inverse process to read pages from a buffer.
 
-Note that to process deletions you will likely delete tuples at a specific
-position (slot) in the heap page.  This means that after a page is read from
-disk, tuples should retain the same slot number. Because GoDB will never evict a
-dirty page, it's OK if tuples are renumbered when they are written back to disk.
+Tuples are always written back to the slot they occupy, so a tuple keeps its
+record id for as long as it lives.  Indexes rely on this, since they refer to
+tuples by record id.
 
 */
 
@@ -57,16 +65,97 @@ type heapPage struct {
 	pageNo    int
 	numSlots  int
 	usedSlots int
+	lsn       int64
+	pageSize  int
+}
+
+const (
+	pageLSNOffset      = 8
+	pageChecksumOffset = 16
+	heapPageHeaderSize = 20 // followed by the slot bitmap
+)
+
+// Size in bytes of the slot bitmap of a page with numSlots slots
+func slotBitmapSize(numSlots int) int {
+	return (numSlots + 7) / 8
+}
+
+// LSN assigned to the most recently written page.  There is no log yet, so
+// the LSN is simply a counter that orders page writes;  it is advanced past
+// the LSN of every page that is read so that it keeps increasing across
+// restarts.
+var lastPageLSN atomic.Int64
+
+func nextPageLSN() int64 {
+	return lastPageLSN.Add(1)
+}
+
+func observePageLSN(lsn int64) {
+	for {
+		cur := lastPageLSN.Load()
+		if lsn <= cur || lastPageLSN.CompareAndSwap(cur, lsn) {
+			return
+		}
+	}
+}
+
+var pageChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Compute the checksum of a serialized page, treating the checksum field as 0
+func pageChecksum(page []byte) uint32 {
+	crc := crc32.Update(0, pageChecksumTable, page[:pageChecksumOffset])
+	crc = crc32.Update(crc, pageChecksumTable, make([]byte, 4))
+	return crc32.Update(crc, pageChecksumTable, page[pageChecksumOffset+4:])
+}
+
+// Check the checksum stored in a serialized page
+func verifyPageChecksum(page []byte) bool {
+	stored := binary.LittleEndian.Uint32(page[pageChecksumOffset:])
+	return stored == pageChecksum(page)
+}
+
+// Size of the header of pages in format version 0 (see heap_header.go),
+// which is just the number of slots and of used slots.  The used slots follow
+// it, packed together, without a slot bitmap, LSN or checksum.
+const packedPageHeaderSize = 8
+
+// Read the tuples of a serialized page of a file in format version 0
+func decodePackedPage(page []byte, desc *TupleDesc) ([]*Tuple, error) {
+	numSlots := int(int32(binary.LittleEndian.Uint32(page[0:])))
+	usedSlots := int(int32(binary.LittleEndian.Uint32(page[4:])))
+	expected := (len(page) - packedPageHeaderSize) / calBytesPerTuple(desc)
+	if numSlots != expected || usedSlots < 0 || usedSlots > numSlots {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("bad page header: %d slots, %d used, expected %d slots", numSlots, usedSlots, expected)}
+	}
+	buf := bytes.NewBuffer(page[packedPageHeaderSize:])
+	tuples := make([]*Tuple, 0, usedSlots)
+	for i := 0; i < usedSlots; i++ {
+		t, err := readTupleFrom(buf, desc)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, t)
+	}
+	return tuples, nil
 }
 
 type heapFileRID struct {
 	pageNum, slotNum int
 }
 
-// Construct a new heap page
+// Construct a new heap page, of the page size of f if it isn't nil
 func newHeapPage(desc *TupleDesc, pageNo int, f *HeapFile) *heapPage {
 	// TODO: some code goes here
-	numSlots := calNumSlot(desc)
+	pageSize := PageSize
+	if f != nil {
+		pageSize = f.pageSize
+	}
+	return newSizedHeapPage(desc, pageNo, pageSize, f)
+}
+
+// Construct a new heap page of pageSize bytes
+func newSizedHeapPage(desc *TupleDesc, pageNo int, pageSize int, f *HeapFile) *heapPage {
+	numSlots := calNumSlot(desc, pageSize)
 	return &heapPage{
 		dirty:     false,
 		desc:      desc,
@@ -75,6 +164,7 @@ func newHeapPage(desc *TupleDesc, pageNo int, f *HeapFile) *heapPage {
 		pageNo:    pageNo,
 		numSlots:  numSlots,
 		usedSlots: 0,
+		pageSize:  pageSize,
 	} //replace me
 }
 
@@ -92,12 +182,20 @@ func calBytesPerTuple(desc *TupleDesc) int {
 	return cnt
 }
 
-func calNumSlot(desc *TupleDesc) int {
-	remPageSize := PageSize - 8 // bytes after header
-	numSlots := remPageSize / calBytesPerTuple(desc)
+func calNumSlot(desc *TupleDesc, pageSize int) int {
+	remPageSize := pageSize - heapPageHeaderSize // bytes after header
+	numSlots := remPageSize * 8 / (calBytesPerTuple(desc)*8 + 1)
 	return numSlots
 }
 
+// Check that pageSize is a power of two between MinPageSize and MaxPageSize
+func checkPageSize(pageSize int) error {
+	if pageSize < MinPageSize || pageSize > MaxPageSize || pageSize&(pageSize-1) != 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("page size %d is not a power of two between %d and %d", pageSize, MinPageSize, MaxPageSize)}
+	}
+	return nil
+}
+
 func (h *heapPage) getNumSlots() int {
 	// TODO: some code goes here
 	return h.numSlots //replace me
@@ -163,7 +261,8 @@ func (p *heapPage) getFile() *DBFile {
 // if the write to the the buffer fails. You will likely want to call this from
 // your [HeapFile.flushPage] method.  You should write the page header, using
 // the binary.Write method in LittleEndian order, followed by the tuples of the
-// page, written using the Tuple.writeTo method.
+// page, written using the Tuple.writeTo method.  The buffer is padded to the
+// page size and checksummed.
 func (h *heapPage) toBuffer() (*bytes.Buffer, error) {
 	// TODO: some code goes here
 	b := new(bytes.Buffer)
@@ -175,14 +274,37 @@ func (h *heapPage) toBuffer() (*bytes.Buffer, error) {
 	if err != nil {
 		return nil, err
 	}
-	for _, tuple := range h.slots {
+	err = binary.Write(b, binary.LittleEndian, h.lsn)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(b, binary.LittleEndian, uint32(0))
+	if err != nil {
+		return nil, err
+	}
+	bitmap := make([]byte, slotBitmapSize(h.numSlots))
+	for i, tuple := range h.slots {
 		if tuple != nil {
-			err = tuple.writeTo(b)
-			if err != nil {
-				return nil, err
-			}
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+	}
+	b.Write(bitmap)
+	emptySlot := make([]byte, calBytesPerTuple(h.desc))
+	for _, tuple := range h.slots {
+		if tuple == nil {
+			b.Write(emptySlot)
+			continue
+		}
+		err = tuple.writeTo(b)
+		if err != nil {
+			return nil, err
 		}
 	}
+	if b.Len() > h.pageSize {
+		return nil, GoDBError{PageFullError, "tuples don't fit in page"}
+	}
+	b.Write(make([]byte, h.pageSize-b.Len()))
+	binary.LittleEndian.PutUint32(b.Bytes()[pageChecksumOffset:], pageChecksum(b.Bytes()))
 	return b, nil //replace me
 }
 
@@ -190,17 +312,48 @@ func (h *heapPage) toBuffer() (*bytes.Buffer, error) {
 func (h *heapPage) initFromBuffer(buf *bytes.Buffer) error {
 	// TODO: some code goes here
 	var numSlots, usedSlots int32
-	binary.Read(buf, binary.LittleEndian, &numSlots)
-	binary.Read(buf, binary.LittleEndian, &usedSlots)
+	var checksum uint32
+	err := binary.Read(buf, binary.LittleEndian, &numSlots)
+	if err != nil {
+		return err
+	}
+	err = binary.Read(buf, binary.LittleEndian, &usedSlots)
+	if err != nil {
+		return err
+	}
+	err = binary.Read(buf, binary.LittleEndian, &h.lsn)
+	if err != nil {
+		return err
+	}
+	err = binary.Read(buf, binary.LittleEndian, &checksum)
+	if err != nil {
+		return err
+	}
+	if int(numSlots) != len(h.slots) || usedSlots < 0 || usedSlots > numSlots {
+		return GoDBError{MalformedDataError, fmt.Sprintf("bad page header: %d slots, %d used, expected %d slots", numSlots, usedSlots, len(h.slots))}
+	}
+	bitmap := buf.Next(slotBitmapSize(int(numSlots)))
+	if len(bitmap) != slotBitmapSize(int(numSlots)) {
+		return GoDBError{MalformedDataError, "page too short for slot bitmap"}
+	}
 	h.numSlots = int(numSlots)
-	h.usedSlots = int(usedSlots)
-	//h.slots = make([]*Tuple, h.numSlots)
-	for i := 0; i < h.usedSlots; i++ {
+	h.usedSlots = 0
+	bytesPerTuple := calBytesPerTuple(h.desc)
+	for i := 0; i < h.numSlots; i++ {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			h.slots[i] = nil
+			buf.Next(bytesPerTuple)
+			continue
+		}
 		tuple, err := readTupleFrom(buf, h.desc)
 		if err != nil {
 			return err
 		}
 		h.slots[i] = tuple
+		h.usedSlots++
+	}
+	if h.usedSlots != int(usedSlots) {
+		return GoDBError{MalformedDataError, fmt.Sprintf("bad page header: %d used slots, but %d slots are marked in use", usedSlots, h.usedSlots)}
 	}
 	return nil //replace me
 }
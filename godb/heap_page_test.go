@@ -1,6 +1,9 @@
 package godb
 
 import (
+	"bytes"
+	"encoding/binary"
+	"os"
 	"testing"
 	"unsafe"
 )
@@ -8,7 +11,7 @@ import (
 func TestInsertHeapPage(t *testing.T) {
 	td, t1, t2, hf, _, _ := makeTestVars()
 	pg := newHeapPage(&td, 0, hf)
-	var expectedSlots = (PageSize - 8) / (StringLength + int(unsafe.Sizeof(int64(0))))
+	var expectedSlots = (PageSize - heapPageHeaderSize) * 8 / ((StringLength+int(unsafe.Sizeof(int64(0))))*8 + 1)
 	if pg.getNumSlots() != expectedSlots {
 		t.Fatalf("Incorrect number of slots, expected %d, got %d", expectedSlots, pg.getNumSlots())
 	}
@@ -218,3 +221,55 @@ func TestHeapPageSerialization(t *testing.T) {
 		}
 	}
 }
+
+// Serialize tuples into a page of a file in format version 0
+func encodePackedPage(desc *TupleDesc, tuples []*Tuple) []byte {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, int32((PageSize-packedPageHeaderSize)/calBytesPerTuple(desc)))
+	binary.Write(b, binary.LittleEndian, int32(len(tuples)))
+	for _, t := range tuples {
+		t.writeTo(b)
+	}
+	b.Write(make([]byte, PageSize-b.Len()))
+	return b.Bytes()
+}
+
+func TestPackedPages(t *testing.T) {
+	td, t1, t2, _, _, _ := makeTestVars()
+	var tuples []*Tuple
+	for i := 0; i < 20; i++ {
+		tuples = append(tuples, &t1, &t2)
+	}
+	decoded, err := decodePackedPage(encodePackedPage(&td, tuples), &td)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(decoded) != len(tuples) || !decoded[0].equals(&t1) || !decoded[1].equals(&t2) {
+		t.Errorf("expected the tuples of the page back, got %d tuples", len(decoded))
+	}
+	damaged := encodePackedPage(&td, tuples)
+	binary.LittleEndian.PutUint32(damaged[4:], 1000)
+	if _, err := decodePackedPage(damaged, &td); err == nil {
+		t.Errorf("expected a page with more used slots than slots to be rejected")
+	}
+
+	// a file in format version 0 is only opened to be upgraded, and fsck
+	// reports it without quarantining its pages
+	vfs := NewMemFS()
+	f, _ := vfs.OpenFile("older.dat", os.O_RDWR|os.O_CREATE, 0644)
+	f.Write(encodePackedPage(&td, tuples))
+	f.Write(encodePackedPage(&td, tuples))
+	f.Close()
+	_, err = NewHeapFile("older.dat", &td, NewBufferPoolWithVFS(10, vfs))
+	if e, ok := err.(GoDBError); !ok || e.code != UnsupportedFormatError {
+		t.Errorf("expected a file in format version 0 to be rejected, got %v", err)
+	}
+	report := &FsckReport{}
+	err = fsckHeapFile(vfs, "older", "older.dat", &td, PageSize, nil, true, report, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 1 || report.Quarantined != 0 {
+		t.Errorf("expected the file reported and nothing quarantined, got %s", report.String())
+	}
+}
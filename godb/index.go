@@ -0,0 +1,343 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Secondary indexes.  An index is recorded in the catalog by name, together
+// with the table and column it indexes and its kind, and is stored in its own
+// file next to the table's heap file.  [Catalog.GetTable] opens the indexes of a table
+// along with its heap file, and the heap file keeps them up to date as
+// tuples are inserted and deleted.
+
+// An index over a column of a HeapFile.  The heap file passes each tuple it
+// stores or removes to insertTuple or deleteTuple, with Rid set to the
+// tuple's record id.
+type indexFile interface {
+	DBFile
+	// remove every entry from the index
+	clear(tid TransactionID) error
+}
+
+// An index that can look up the entries for a range of keys, so that index
+// scans and index joins can read from it.  Each entry is a key and the record
+// id of the heap tuple it came from.
+type lookupIndex interface {
+	indexFile
+	// the name of the index in the catalog
+	indexName() string
+	// the position of the indexed column in the heap file's tuples
+	keyColumn() int
+	keyType() DBType
+	NumPages() int
+	// whether scanRange can scan r
+	supportsRange(r keyRange) bool
+	scanRange(r keyRange, tid TransactionID) (func() (*indexEntry, error), error)
+	lookup(key DBValue, tid TransactionID) ([]heapFileRID, error)
+}
+
+// The data structure of an index.  B+tree indexes support lookups of ranges
+// of keys, while hash indexes only support lookups of single keys.  Bloom
+// filter indexes can't look up keys at all, but let scans skip heap pages.
+// Full-text indexes look up the words of a string column, for MATCH
+// predicates.
+type IndexKind int
+
+const (
+	BTreeIndex    IndexKind = iota
+	HashIndex     IndexKind = iota
+	BloomIndex    IndexKind = iota
+	FullTextIndex IndexKind = iota
+)
+
+var indexKindNames = map[IndexKind]string{BTreeIndex: "btree", HashIndex: "hash", BloomIndex: "bloom", FullTextIndex: "fulltext"}
+
+// An index as recorded in the catalog
+type Index struct {
+	name   string
+	table  string
+	column string
+	kind   IndexKind
+}
+
+// Number of tuples added to an index being built between flushes of its
+// dirty pages, so that building an index over a large table doesn't fill the
+// buffer pool
+const indexBuildFlushInterval = 256
+
+func (c *Catalog) indexNameToFile(indexName string) string {
+	if idx := c.findIndex(indexName); idx != nil {
+		return c.indexFile(idx)
+	}
+	return c.rootPath + "/" + indexName + ".idx"
+}
+
+// Return the name of the file of an index, which is kept next to the file of
+// its table
+func (c *Catalog) indexFile(idx *Index) string {
+	return c.tableDir(idx.table) + "/" + idx.name + ".idx"
+}
+
+func (c *Catalog) findIndex(name string) *Index {
+	for _, idx := range c.indexes {
+		if idx.name == name {
+			return idx
+		}
+	}
+	return nil
+}
+
+// Open the file of an index on a table with the given TupleDesc
+func (c *Catalog) openIndex(idx *Index, desc *TupleDesc) (indexFile, error) {
+	keyIndex, err := findFieldInTd(FieldType{idx.column, "", UnknownType}, desc)
+	if err != nil {
+		return nil, GoDBError{NoSuchTableError, fmt.Sprintf("index %s is on unknown column %s of %s", idx.name, idx.column, idx.table)}
+	}
+	if idx.kind == FullTextIndex {
+		index, err := openTextFile(c.indexFile(idx), desc.Fields[keyIndex], keyIndex, c.cipher, c.bp)
+		if err != nil {
+			return nil, err
+		}
+		index.name = idx.name
+		return index, nil
+	}
+	if idx.kind == BloomIndex {
+		index, err := openBloomFile(c.indexFile(idx), desc.Fields[keyIndex], keyIndex, c.cipher, c.bp)
+		if err != nil {
+			return nil, err
+		}
+		index.name = idx.name
+		return index, nil
+	}
+	if idx.kind == HashIndex {
+		index, err := openHashFile(c.indexFile(idx), desc.Fields[keyIndex], keyIndex, c.cipher, c.bp)
+		if err != nil {
+			return nil, err
+		}
+		index.name = idx.name
+		return index, nil
+	}
+	index, err := openBTreeFile(c.indexFile(idx), desc.Fields[keyIndex], keyIndex, c.cipher, c.bp)
+	if err != nil {
+		return nil, err
+	}
+	index.name = idx.name
+	return index, nil
+}
+
+// Create an index of the given kind named name on column of table, and build
+// it from the tuples already in the table.  The index is built in its own transaction,
+// which read locks the whole table, and is only recorded in the catalog once
+// it is complete.  Indexes can't be created while a transaction is open.
+func (c *Catalog) CreateIndex(name string, table string, column string, kind IndexKind) error {
+	err := c.bp.checkNoTransactions("CREATE INDEX")
+	if err != nil {
+		return err
+	}
+	if c.findIndex(name) != nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("an index named '%s' already exists", name)}
+	}
+	file, err := c.GetTable(table)
+	if err != nil {
+		return err
+	}
+	hf, ok := file.(*HeapFile)
+	if !ok {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("table %s can't be indexed", table)}
+	}
+	idx := &Index{name, table, column, kind}
+	c.bp.vfs.Remove(c.indexFile(idx))
+	index, err := c.openIndex(idx, hf.desc)
+	if err != nil {
+		c.bp.vfs.Remove(c.indexFile(idx))
+		return err
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	err = hf.buildIndex(index, tid)
+	if err != nil {
+		c.bp.AbortTransaction(tid)
+		closeFiles(c.bp, index)
+		c.bp.vfs.Remove(c.indexFile(idx))
+		return err
+	}
+	err = c.bp.CommitTransaction(tid)
+	if err != nil {
+		closeFiles(c.bp, index)
+		c.bp.vfs.Remove(c.indexFile(idx))
+		return err
+	}
+	c.indexes = append(c.indexes, idx)
+	hf.indexes = append(hf.indexes, index)
+	return nil
+}
+
+// Remove the named index from the catalog and delete its file.  If table is
+// not empty, the index must be on that table.  Indexes can't be dropped while
+// a transaction is open, since it may have changed the index.
+func (c *Catalog) DropIndex(name string, table string) error {
+	err := c.bp.checkNoTransactions("DROP INDEX")
+	if err != nil {
+		return err
+	}
+	idx := c.findIndex(name)
+	if idx == nil {
+		return GoDBError{NoSuchTableError, fmt.Sprintf("no index '%s' found", name)}
+	}
+	if table != "" && idx.table != table {
+		return GoDBError{NoSuchTableError, fmt.Sprintf("index %s is not on table %s", name, table)}
+	}
+	c.removeIndex(idx)
+	return nil
+}
+
+// Remove an index from the catalog and delete its file
+func (c *Catalog) removeIndex(idx *Index) {
+	// the table's file is opened again without the index
+	c.closeTable(idx.table)
+	for i, other := range c.indexes {
+		if other == idx {
+			c.indexes = append(c.indexes[:i], c.indexes[i+1:]...)
+			break
+		}
+	}
+	c.bp.vfs.Remove(c.indexFile(idx))
+}
+
+// Return the indexes of the named table
+func (c *Catalog) tableIndexes(table string) []*Index {
+	var indexes []*Index
+	for _, idx := range c.indexes {
+		if idx.table == table {
+			indexes = append(indexes, idx)
+		}
+	}
+	return indexes
+}
+
+// The index entry for a tuple of an indexed heap file, whose key is the field
+// at keyIndex
+func entryForTuple(t *Tuple, keyIndex int) (indexEntry, error) {
+	rid, ok := t.Rid.(heapFileRID)
+	if !ok {
+		return indexEntry{}, GoDBError{TypeMismatchError, "index entries need a heap file record id"}
+	}
+	if keyIndex >= len(t.Fields) {
+		return indexEntry{}, GoDBError{MalformedDataError, "tuple doesn't have the indexed column"}
+	}
+	return indexEntry{t.Fields[keyIndex], rid}, nil
+}
+
+// Return the record ids of the entries of index with the given key
+func lookupRange(index lookupIndex, key DBValue, tid TransactionID) ([]heapFileRID, error) {
+	iter, err := index.scanRange(pointRange(key), tid)
+	if err != nil {
+		return nil, err
+	}
+	var rids []heapFileRID
+	for {
+		e, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			return rids, nil
+		}
+		rids = append(rids, e.rid)
+	}
+}
+
+// Add an entry for every tuple of the heap file to an empty index
+func (f *HeapFile) buildIndex(index indexFile, tid TransactionID) error {
+	iter, err := f.Iterator(tid)
+	if err != nil {
+		return err
+	}
+	for n := 1; ; n++ {
+		t, err := iter()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return nil
+		}
+		err = index.insertTuple(t, tid)
+		if err != nil {
+			return err
+		}
+		if n%indexBuildFlushInterval == 0 {
+			err = f.bufPool.flushPages(tid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Empty every index of the heap file and add the entries of its tuples
+// again, after they have moved to new record ids
+func (f *HeapFile) rebuildIndexes(tid TransactionID) error {
+	for _, index := range f.indexes {
+		err := index.clear(tid)
+		if err != nil {
+			return err
+		}
+		err = f.buildIndex(index, tid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse and run CREATE INDEX name ON table (column) [USING BTREE|HASH|BLOOM|FULLTEXT] or
+// DROP INDEX name ON table.  The SQL parser accepts both statements but reduces them to an
+// ALTER TABLE without the index name or columns, so they are parsed again
+// from the text of the query.
+func processIndexDDL(c *Catalog, query string) (QueryType, error) {
+	words := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ", ";", " ").Replace(strings.ToLower(query)))
+	if len(words) < 3 || words[1] != "index" {
+		return UnknownQueryType, GoDBError{ParseError, "unsupported alter table statement"}
+	}
+	switch words[0] {
+	case "create":
+		// create index name on table ( column ) [using kind]
+		if (len(words) != 8 && len(words) != 10) || words[3] != "on" || words[5] != "(" || words[7] != ")" || (len(words) == 10 && words[8] != "using") {
+			return UnknownQueryType, GoDBError{ParseError, "expected CREATE INDEX name ON table (column) [USING BTREE|HASH|BLOOM|FULLTEXT]"}
+		}
+		kind := BTreeIndex
+		if len(words) == 10 {
+			var err error
+			kind, err = parseIndexKind(words[9])
+			if err != nil {
+				return UnknownQueryType, err
+			}
+		}
+		err := c.CreateIndex(words[2], words[4], words[6], kind)
+		if err != nil {
+			return UnknownQueryType, err
+		}
+		return CreateIndexQueryType, nil
+	case "drop":
+		// drop index name on table
+		if len(words) != 5 || words[3] != "on" {
+			return UnknownQueryType, GoDBError{ParseError, "expected DROP INDEX name ON table"}
+		}
+		err := c.DropIndex(words[2], words[4])
+		if err != nil {
+			return UnknownQueryType, err
+		}
+		return DropIndexQueryType, nil
+	}
+	return UnknownQueryType, GoDBError{ParseError, "unsupported index statement"}
+}
+
+func parseIndexKind(name string) (IndexKind, error) {
+	for kind, kindName := range indexKindNames {
+		if kindName == name {
+			return kind, nil
+		}
+	}
+	return BTreeIndex, GoDBError{ParseError, fmt.Sprintf("unknown index kind %s", name)}
+}
@@ -0,0 +1,233 @@
+package godb
+
+import (
+	"fmt"
+	"math"
+)
+
+// IndexJoin is an index nested-loop join: for each tuple of the outer
+// operator it evaluates the outer join expression and looks the value up in
+// an index on the join column of the inner heap file, fetching just the
+// matching inner tuples instead of scanning the inner table.  The planner
+// uses it in place of an [EqualityJoin] when the outer side is small, or when
+// the index is a hash index, which serves as a ready-built hash table of the
+// inner side (see [planIndexJoin]).
+type IndexJoin struct {
+	outer      Operator
+	outerField Expr
+
+	inner     *HeapFile
+	index     lookupIndex
+	innerDesc *TupleDesc
+
+	// whether the inner table was the left side of the join in the query, in
+	// which case its fields come first in the joined tuples
+	innerFirst bool
+}
+
+// Outer sides estimated to have more rows than this are joined with an
+// EqualityJoin, which reads each side once, rather than probing a b+tree
+// index once per outer tuple
+const indexJoinMaxOuterRows = 1000
+
+// Fraction of its input that a predicate is assumed to keep when estimating
+// the size of a plan, for equality and other predicates
+const (
+	eqSelectivity    = 0.1
+	rangeSelectivity = 0.33
+)
+
+// Construct an IndexJoin of outer with inner on outerField = the key of
+// index, which must be an index of inner.  innerDesc is the TupleDesc of
+// inner's tuples in the query, with the table's alias.
+func NewIndexJoin(outer Operator, outerField Expr, inner *HeapFile, index lookupIndex, innerDesc *TupleDesc, innerFirst bool) (*IndexJoin, error) {
+	keyType := index.keyType()
+	if outerField.GetExprType().Ftype != keyType {
+		return nil, GoDBError{TypeMismatchError, "can't join fields of different types"}
+	}
+	return &IndexJoin{outer, outerField, inner, index, innerDesc, innerFirst}, nil
+}
+
+// The fields of the outer and inner operators, in the order of the query
+func (j *IndexJoin) Descriptor() *TupleDesc {
+	if j.innerFirst {
+		return j.innerDesc.merge(j.outer.Descriptor())
+	}
+	return j.outer.Descriptor().merge(j.innerDesc)
+}
+
+// Return an iterator over the joined tuples.  The inner tuples matching each
+// outer tuple are found through the index, in index order.
+func (j *IndexJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	outerIter, err := j.outer.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	var outerTuple *Tuple
+	var rids []heapFileRID
+	done := false
+	return func() (*Tuple, error) {
+		for len(rids) == 0 {
+			if done {
+				return nil, nil
+			}
+			outerTuple, err = outerIter()
+			if outerTuple == nil || err != nil {
+				done = outerTuple == nil && err == nil
+				return nil, err
+			}
+			key, err := j.outerField.EvalExpr(outerTuple)
+			if err != nil {
+				return nil, err
+			}
+			rids, err = j.index.lookup(key, tid)
+			if err != nil {
+				return nil, err
+			}
+		}
+		rid := rids[0]
+		rids = rids[1:]
+		page, err := j.inner.bufPool.GetPage(j.inner, rid.pageNum, tid, ReadPerm)
+		if err != nil {
+			return nil, err
+		}
+		hp := (*page).(*heapPage)
+		if rid.slotNum >= len(hp.slots) || hp.slots[rid.slotNum] == nil {
+			return nil, GoDBError{TupleNotFoundError, fmt.Sprintf("index %s has an entry for an empty slot %v", j.index.indexName(), rid)}
+		}
+		innerTuple := &Tuple{*j.innerDesc, hp.slots[rid.slotNum].Fields, rid}
+		if j.innerFirst {
+			return joinTuples(innerTuple, outerTuple), nil
+		}
+		return joinTuples(outerTuple, innerTuple), nil
+	}, nil
+}
+
+// Roughly estimate the number of tuples op returns, for choosing between
+// join methods.  Heap files are assumed to be full, and predicates to keep a
+// fixed fraction of their input.
+func estimateRows(op Operator) float64 {
+	switch op := op.(type) {
+	case *HeapFile:
+		return float64(op.NumPages() * calNumSlot(op.desc, op.pageSize))
+	case *IndexScan:
+		return estimateRows(op.heap) * op.keys.selectivity()
+	case *IndexOnlyScan:
+		return float64(op.index.NumPages()*btreeCapacity(btreeLeafPage, op.index.keyType())) * op.keys.selectivity()
+	case *ClusteredFile:
+		return float64(op.NumPages() * clusteredCapacity(btreeLeafPage, op.desc, op.keyIndex))
+	case *ClusteredScan:
+		if op.keys.isPoint() {
+			return 1 // clustering keys are unique
+		}
+		return estimateRows(op.file) * op.keys.selectivity()
+	case *Filter[int64]:
+		return estimateRows(op.child) * predSelectivity(op.op)
+	case *Filter[string]:
+		return estimateRows(op.child) * predSelectivity(op.op)
+	case *TextSearch:
+		return estimateRows(op.child) * eqSelectivity
+	case *Project:
+		return estimateRows(op.child)
+	case *LimitOp:
+		return estimateRows(op.child)
+	case *OrderBy:
+		return estimateRows(op.child)
+	case *Aggregator:
+		if len(op.groupByFields) == 0 {
+			return 1
+		}
+		return estimateRows(op.child)
+	case *EqualityJoin[int64]:
+		return math.Max(estimateRows(*op.left), estimateRows(*op.right))
+	case *EqualityJoin[string]:
+		return math.Max(estimateRows(*op.left), estimateRows(*op.right))
+	case *IndexJoin:
+		return estimateRows(op.outer)
+	}
+	return indexJoinMaxOuterRows + 1
+}
+
+func predSelectivity(pred BoolOp) float64 {
+	if pred == OpEq {
+		return eqSelectivity
+	}
+	return rangeSelectivity
+}
+
+// The fraction of an index's keys assumed to fall in the range
+func (r keyRange) selectivity() float64 {
+	if r.isPoint() {
+		return eqSelectivity
+	}
+	if r.lo != nil && r.hi != nil {
+		return rangeSelectivity * rangeSelectivity
+	}
+	if r.lo != nil || r.hi != nil {
+		return rangeSelectivity
+	}
+	return 1
+}
+
+// Return an index of op on the column that field refers to, if op is a heap
+// file with such an index.  Hash indexes are preferred, since a probe of a
+// hash index reads fewer pages.
+func joinIndex(op Operator, field Expr) (*HeapFile, lookupIndex) {
+	hf, ok := op.(*HeapFile)
+	if !ok {
+		return nil, nil
+	}
+	fieldExpr, ok := field.(*FieldExpr)
+	if !ok {
+		return nil, nil
+	}
+	var found lookupIndex
+	for _, index := range hf.indexes {
+		index, ok := index.(lookupIndex)
+		if !ok || hf.desc.Fields[index.keyColumn()].Fname != fieldExpr.selectField.Fname || index.keyType() != fieldExpr.selectField.Ftype {
+			continue
+		}
+		if _, isHash := index.(*HashFile); isHash || found == nil {
+			found = index
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return hf, found
+}
+
+// Whether to join by probing index once per outer tuple, given the estimated
+// sizes of the two sides.  A hash index is always worth probing, since it
+// saves building a hash table of the inner side;  a b+tree index only when the
+// outer side is small.
+func worthProbing(index lookupIndex, outerRows float64, innerRows float64) bool {
+	if index == nil {
+		return false
+	}
+	if _, isHash := index.(*HashFile); isHash {
+		return true
+	}
+	return outerRows <= indexJoinMaxOuterRows && outerRows < innerRows
+}
+
+// If one side of the join left.leftField = right.rightField is a heap file
+// with an index on its join column that is worth probing (see
+// [worthProbing]), return an IndexJoin that probes the index once per tuple
+// of the other side.  If both sides could be probed, the larger side is.
+// leftDesc and rightDesc are the TupleDescs of the sides in the query.
+// Returns nil if no index join applies.
+func planIndexJoin(left Operator, leftField Expr, leftDesc *TupleDesc, right Operator, rightField Expr, rightDesc *TupleDesc) (Operator, error) {
+	leftRows, rightRows := estimateRows(left), estimateRows(right)
+	rightHeap, rightIndex := joinIndex(right, rightField)
+	leftHeap, leftIndex := joinIndex(left, leftField)
+	probeRight := worthProbing(rightIndex, leftRows, rightRows)
+	probeLeft := worthProbing(leftIndex, rightRows, leftRows)
+	if probeRight && (!probeLeft || rightRows >= leftRows) {
+		return NewIndexJoin(left, leftField, rightHeap, rightIndex, rightDesc, false)
+	}
+	if probeLeft {
+		return NewIndexJoin(right, rightField, leftHeap, leftIndex, leftDesc, true)
+	}
+	return nil, nil
+}
@@ -0,0 +1,54 @@
+package godb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIndexJoinPlans(t *testing.T) {
+	c := makeIndexedCatalog(t)
+	queries := []struct {
+		sql       string
+		indexJoin bool
+		expected  []string
+	}{
+		{"select * from t2 join t on t2.name = t.name where t2.age = 25", true,
+			[]string{"[{sam} {25} {sam} {25}]", "[{sam} {25} {sam} {99}]"}},
+		{"select * from t join t2 on t.name = t2.name where t2.age = 25", true,
+			[]string{"[{sam} {25} {sam} {25}]", "[{sam} {99} {sam} {25}]"}},
+		{"select t.name, t2.age from t2 join t on t2.age = t.age where t2.name = 'riza'", true,
+			[]string{"[{ang} {22}]", "[{riza} {22}]", "[{riza} {43}]"}},
+		{"select t.name, t2.name from t2 join t on t2.age = t.age where t2.age > 40", true,
+			[]string{"[{bo} {bo}]", "[{bo} {sam}]", "[{kathy} {kathy}]", "[{mark} {mark}]", "[{riza} {riza}]", "[{sam} {bo}]", "[{sam} {sam}]", "[{sarah} {sarah}]"}},
+		{"select t2.name, count(*) from t2 join t on t2.age = t.age where t2.age < 40 group by t2.name", true,
+			[]string{"[{ang} {2}]", "[{bill} {1}]", "[{pat} {1}]", "[{riza} {2}]", "[{sam} {1}]"}},
+		{"select * from t join t2 on t.name = t2.name where t.age = 25", false,
+			[]string{"[{sam} {25} {sam} {25}]", "[{sam} {25} {sam} {99}]"}},
+	}
+	for _, q := range queries {
+		results, plan := runQuery(t, c, q.sql)
+		if fmt.Sprint(results) != fmt.Sprint(q.expected) {
+			t.Errorf("q=%s: expected %v, got %v", q.sql, q.expected, results)
+		}
+		indexJoin := false
+		for _, op := range planOperators(plan) {
+			if _, ok := op.(*IndexJoin); ok {
+				indexJoin = true
+			}
+		}
+		if indexJoin != q.indexJoin {
+			t.Errorf("q=%s: expected index join %v, got %v", q.sql, q.indexJoin, indexJoin)
+		}
+	}
+
+	// neither side of a join of two whole tables is small
+	_, plan, err := Parse(c, "select * from t join t2 on t.name = t2.name")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, op := range planOperators(plan) {
+		if _, ok := op.(*IndexJoin); ok {
+			t.Errorf("expected a join of two whole tables to be a hash join")
+		}
+	}
+}
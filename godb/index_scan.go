@@ -0,0 +1,201 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IndexScan returns the tuples of a heap file whose indexed column falls in
+// a range of keys, by looking up their record ids in an index and fetching
+// them from the heap file.  With a b+tree index, tuples are returned in key
+// order.  The planner uses it in place
+// of a Filter over a heap file scan (see [planIndexScan]).
+type IndexScan struct {
+	heap  *HeapFile
+	index lookupIndex
+	keys  keyRange
+}
+
+// Construct an IndexScan of the tuples of heap with keys in keys, using
+// index, which must be an index of heap that supports the range.
+func NewIndexScan(heap *HeapFile, index lookupIndex, keys keyRange) *IndexScan {
+	return &IndexScan{heap, index, keys}
+}
+
+// The tuples returned by an IndexScan are those of the heap file
+func (s *IndexScan) Descriptor() *TupleDesc {
+	return s.heap.Descriptor()
+}
+
+// Return an iterator over the tuples with keys in the range, with Rid set so
+// that they can be deleted.
+func (s *IndexScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := s.index.scanRange(s.keys, tid)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		e, err := iter()
+		if e == nil || err != nil {
+			return nil, err
+		}
+		page, err := s.heap.bufPool.GetPage(s.heap, e.rid.pageNum, tid, ReadPerm)
+		if err != nil {
+			return nil, err
+		}
+		hp := (*page).(*heapPage)
+		if e.rid.slotNum >= len(hp.slots) || hp.slots[e.rid.slotNum] == nil {
+			return nil, GoDBError{TupleNotFoundError, fmt.Sprintf("index %s has an entry for an empty slot %v", s.index.indexName(), e.rid)}
+		}
+		t := hp.slots[e.rid.slotNum]
+		t.Rid = e.rid
+		return t, nil
+	}, nil
+}
+
+// IndexOnlyScan is an IndexScan for queries that use no column of the table
+// other than the indexed one.  Tuples are built from the index entries alone,
+// without reading the heap file, and have just the indexed column.
+type IndexOnlyScan struct {
+	index lookupIndex
+	keys  keyRange
+	desc  *TupleDesc
+}
+
+// Construct an IndexOnlyScan of the keys of index in keys.  keyField is the
+// indexed column, as it appears in the heap file's TupleDesc.
+func NewIndexOnlyScan(index lookupIndex, keys keyRange, keyField FieldType) *IndexOnlyScan {
+	return &IndexOnlyScan{index, keys, &TupleDesc{Fields: []FieldType{keyField}}}
+}
+
+func (s *IndexOnlyScan) Descriptor() *TupleDesc {
+	return s.desc
+}
+
+// Return an iterator over the keys in the range, one tuple per entry.  The
+// Rid of each tuple is the record id of the heap tuple it came from.
+func (s *IndexOnlyScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := s.index.scanRange(s.keys, tid)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		e, err := iter()
+		if e == nil || err != nil {
+			return nil, err
+		}
+		return &Tuple{*s.desc, []DBValue{e.key}, e.rid}, nil
+	}, nil
+}
+
+// Narrow r to the keys k for which "k pred v" holds.  pred must be one of
+// =, <, <=, > or >=.
+func (r keyRange) restrict(pred BoolOp, v DBValue) keyRange {
+	switch pred {
+	case OpEq:
+		return r.restrict(OpGe, v).restrict(OpLe, v)
+	case OpGt, OpGe:
+		incl := pred == OpGe
+		if r.lo == nil || compareKeys(v, r.lo) > 0 || (compareKeys(v, r.lo) == 0 && !incl) {
+			r.lo, r.loIncl = v, incl
+		}
+	case OpLt, OpLe:
+		incl := pred == OpLe
+		if r.hi == nil || compareKeys(v, r.hi) < 0 || (compareKeys(v, r.hi) == 0 && !incl) {
+			r.hi, r.hiIncl = v, incl
+		}
+	}
+	return r
+}
+
+// Describe the range as a predicate on column
+func (r keyRange) String(column string) string {
+	if r.isPoint() {
+		return fmt.Sprintf("%s = %v", column, r.lo)
+	}
+	var preds []string
+	if r.lo != nil {
+		op := ">"
+		if r.loIncl {
+			op = ">="
+		}
+		preds = append(preds, fmt.Sprintf("%s %s %v", column, op, r.lo))
+	}
+	if r.hi != nil {
+		op := "<"
+		if r.hiIncl {
+			op = "<="
+		}
+		preds = append(preds, fmt.Sprintf("%s %s %v", column, op, r.hi))
+	}
+	return strings.Join(preds, " and ")
+}
+
+// If the predicate "field pred constExpr" can be answered by scanning a range
+// of one of the indexes of the heap file that op scans, return an index scan
+// over that range to use instead of a Filter over op.  Hash indexes only
+// answer equality predicates.  Likewise, a predicate on the clustering key of
+// a ClusteredFile becomes a [ClusteredScan] of a range of the file.  op may
+// also be an index scan built from an earlier predicate on the same column,
+// in which case its range is narrowed if the index supports the narrower
+// range.  onlyColumn reports whether the query uses no column of the table
+// other than the given one, so that an index-only scan will do.  Returns nil
+// if no index applies.
+func planIndexScan(op Operator, field Expr, pred BoolOp, constExpr Expr, onlyColumn func(string) bool) (Operator, error) {
+	switch pred {
+	case OpEq, OpLt, OpLe, OpGt, OpGe:
+	default:
+		return nil, nil
+	}
+	field, constExpr, err := coerceComparison(field, pred, constExpr)
+	if err != nil {
+		return nil, err
+	}
+	fieldExpr, ok := field.(*FieldExpr)
+	if !ok {
+		return nil, nil
+	}
+	constant, ok := constExpr.(*ConstExpr)
+	if !ok {
+		return nil, nil
+	}
+	key, ok := constant.val.(DBValue)
+	if !ok || !valueHasType(key, fieldExpr.selectField.Ftype) {
+		return nil, nil
+	}
+	column := fieldExpr.selectField.Fname
+
+	switch scan := op.(type) {
+	case *HeapFile:
+		keys := keyRange{}.restrict(pred, key)
+		for _, index := range scan.indexes {
+			index, ok := index.(lookupIndex)
+			if !ok || scan.desc.Fields[index.keyColumn()].Fname != column || !index.supportsRange(keys) {
+				continue
+			}
+			if onlyColumn(column) {
+				return NewIndexOnlyScan(index, keys, scan.desc.Fields[index.keyColumn()]), nil
+			}
+			return NewIndexScan(scan, index, keys), nil
+		}
+	case *IndexScan:
+		keys := scan.keys.restrict(pred, key)
+		if scan.heap.desc.Fields[scan.index.keyColumn()].Fname == column && scan.index.supportsRange(keys) {
+			return NewIndexScan(scan.heap, scan.index, keys), nil
+		}
+	case *IndexOnlyScan:
+		keys := scan.keys.restrict(pred, key)
+		if scan.desc.Fields[0].Fname == column && scan.index.supportsRange(keys) {
+			return NewIndexOnlyScan(scan.index, keys, scan.desc.Fields[0]), nil
+		}
+	case *ClusteredFile:
+		if scan.desc.Fields[scan.keyIndex].Fname == column {
+			return NewClusteredScan(scan, keyRange{}.restrict(pred, key)), nil
+		}
+	case *ClusteredScan:
+		if scan.file.desc.Fields[scan.file.keyIndex].Fname == column {
+			return NewClusteredScan(scan.file, scan.keys.restrict(pred, key)), nil
+		}
+	}
+	return nil, nil
+}
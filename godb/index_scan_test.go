@@ -0,0 +1,162 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Return the operators of a physical plan, from the top down
+func planOperators(op Operator) []Operator {
+	ops := []Operator{op}
+	switch op := op.(type) {
+	case *Project:
+		ops = append(ops, planOperators(op.child)...)
+	case *Filter[int64]:
+		ops = append(ops, planOperators(op.child)...)
+	case *Filter[string]:
+		ops = append(ops, planOperators(op.child)...)
+	case *Aggregator:
+		ops = append(ops, planOperators(op.child)...)
+	case *OrderBy:
+		ops = append(ops, planOperators(op.child)...)
+	case *LimitOp:
+		ops = append(ops, planOperators(op.child)...)
+	case *EqualityJoin[int64]:
+		ops = append(ops, planOperators(*op.left)...)
+		ops = append(ops, planOperators(*op.right)...)
+	case *EqualityJoin[string]:
+		ops = append(ops, planOperators(*op.left)...)
+		ops = append(ops, planOperators(*op.right)...)
+	case *IndexJoin:
+		ops = append(ops, planOperators(op.outer)...)
+	case *TextSearch:
+		ops = append(ops, planOperators(op.child)...)
+	}
+	return ops
+}
+
+// Run a query and return its results as sorted strings, along with its plan
+func runQuery(t *testing.T, c *Catalog, sql string) ([]string, Operator) {
+	_, op, err := Parse(c, sql)
+	if err != nil {
+		t.Fatalf("failed to parse, q=%s, %s", sql, err.Error())
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	defer c.bp.CommitTransaction(tid)
+	iter, err := op.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var results []string
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("q=%s, %s", sql, err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		results = append(results, fmt.Sprintf("%v", tup.Fields))
+	}
+	sort.Strings(results)
+	return results, op
+}
+
+// A test catalog where table t has indexes on both of its columns, and t2
+// has the same tuples but no indexes
+func makeIndexedCatalog(t *testing.T) *Catalog {
+	bp := NewBufferPool(50)
+	err := MakeTestDatabaseEasy(bp)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf("failed load catalog, %s", err.Error())
+	}
+	for _, sql := range []string{"create index t_age on t (age)", "create index t_name on t (name)"} {
+		os.Remove(c.indexNameToFile(sql[13:19]))
+		_, _, err := Parse(c, sql)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	return c
+}
+
+func TestIndexScanPlans(t *testing.T) {
+	c := makeIndexedCatalog(t)
+	queries := []struct {
+		where     string
+		indexOnly bool
+		scan      bool
+	}{
+		{"select name, age from t where age = 22", false, true},
+		{"select name, age from t where age < 40", false, true},
+		{"select name, age from t where age <= 40", false, true},
+		{"select name, age from t where age > 43", false, true},
+		{"select name, age from t where age >= 43", false, true},
+		{"select name, age from t where age > 22 and age <= 50", false, true},
+		{"select name, age from t where age > 22 and age < 60 and age >= 30", false, true},
+		{"select name, age from t where name = 'riza'", false, true},
+		{"select name, age from t where name >= 'p'", false, true},
+		{"select name, age from t where age > 20 and name < 'n'", false, true},
+		{"select name, age from t where age = '40'", false, true},
+		{"select * from t where age < 30", false, true},
+		{"select age from t where age >= 40", true, true},
+		{"select age from t where age = 99", true, true},
+		{"select name from t where name like 's%'", false, false},
+		{"select name from t where age <> 22", false, false},
+		{"select name, age from t where age > 50 and age < 30", false, true},
+		{"select name, age from t where name like 's%' and age > 30", false, true},
+		{"select name from t where age <> 22 and name = 'sam'", false, true},
+	}
+	for _, q := range queries {
+		results, plan := runQuery(t, c, q.where)
+		expected, _ := runQuery(t, c, strings.Replace(q.where, "from t where", "from t2 where", 1))
+		if fmt.Sprint(results) != fmt.Sprint(expected) {
+			t.Errorf("q=%s: expected %v, got %v", q.where, expected, results)
+		}
+		var scan, indexOnly bool
+		for _, op := range planOperators(plan) {
+			switch op.(type) {
+			case *IndexScan:
+				scan = true
+			case *IndexOnlyScan:
+				scan, indexOnly = true, true
+			}
+		}
+		if scan != q.scan || indexOnly != q.indexOnly {
+			t.Errorf("q=%s: expected index scan %v and index only %v, got %v and %v", q.where, q.scan, q.indexOnly, scan, indexOnly)
+		}
+	}
+}
+
+func TestIndexOnlyScanDescriptor(t *testing.T) {
+	c := makeIndexedCatalog(t)
+	_, op, err := Parse(c, "select age from t where age > 40")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, count, err := Parse(c, "select count(*) from t where age = 99")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := planOperators(count)[2].(*IndexOnlyScan); !ok {
+		t.Errorf("expected count(*) to aggregate an index only scan")
+	}
+	for _, op := range planOperators(op) {
+		if scan, ok := op.(*IndexOnlyScan); ok {
+			desc := scan.Descriptor()
+			if len(desc.Fields) != 1 || desc.Fields[0].Fname != "age" || desc.Fields[0].TableQualifier != "t" {
+				t.Errorf("expected index only scan to return t.age, got %v", desc.Fields)
+			}
+			return
+		}
+	}
+	t.Errorf("expected an index only scan")
+}
@@ -51,7 +51,9 @@ func (iop *InsertOp) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 				return rntTup, nil
 			}
 			cnt += 1
-			err = iop.dbFile.insertTuple(tuple, tid)
+			// stored tuples are returned by later scans of the file, so they
+			// must have its field names, not those of the child
+			err = iop.dbFile.insertTuple(&Tuple{*iop.dbFile.Descriptor(), tuple.Fields, nil}, tid)
 			if err != nil {
 				return nil, err
 			}
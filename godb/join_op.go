@@ -87,6 +87,7 @@ func (joinOp *EqualityJoin[T]) Iterator(tid TransactionID) (func() (*Tuple, erro
 			// rightTuple为nil的时候， 迭代maxBufferSize个leftTuple的值到mp中
 			if rightTuple == nil {
 				mp = make(map[T][]*Tuple, joinOp.maxBufferSize)
+				var keys []DBValue
 				for i := 0; i < joinOp.maxBufferSize; i++ {
 					leftTuple, _ = leftIter()
 					if leftTuple == nil {
@@ -97,11 +98,12 @@ func (joinOp *EqualityJoin[T]) Iterator(tid TransactionID) (func() (*Tuple, erro
 					leftValue := joinOp.getter(v)
 					if _, ok := mp[leftValue]; !ok {
 						mp[leftValue] = make([]*Tuple, 0)
+						keys = append(keys, v)
 					}
 					mp[leftValue] = append(mp[leftValue], leftTuple)
 				}
 				// 每次leftIter迭代之后， rightIter都要从头开始
-				rightIter, _ = (*joinOp.right).Iterator(tid)
+				rightIter, _ = joinOp.probeIterator(keys, tid)
 			}
 			for {
 				if idx == 0 {
@@ -127,3 +129,19 @@ func (joinOp *EqualityJoin[T]) Iterator(tid TransactionID) (func() (*Tuple, erro
 		}
 	}, nil
 }
+
+// Return an iterator over the right input of the join, to probe the hash
+// table built from the left tuples with the given keys.  If the right input
+// can apply runtime filters, it is passed a Bloom filter of the keys, so that
+// it drops most tuples that have no match.
+func (joinOp *EqualityJoin[T]) probeIterator(keys []DBValue, tid TransactionID) (func() (*Tuple, error), error) {
+	right, ok := (*joinOp.right).(runtimeFilterable)
+	if !ok {
+		return (*joinOp.right).Iterator(tid)
+	}
+	filter := newBloomFilter(len(keys))
+	for _, key := range keys {
+		filter.add(key)
+	}
+	return right.runtimeFilteredIterator([]runtimeFilter{{joinOp.rightField, filter}}, tid)
+}
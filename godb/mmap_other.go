@@ -0,0 +1,12 @@
+//go:build !unix
+
+package godb
+
+// Files are only memory mapped on unix systems
+func mmapFile(file File, size int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package godb
+
+import (
+	"os"
+	"syscall"
+)
+
+// Map the first size bytes of a file read-only into memory.  Only files of
+// the operating system can be mapped.
+func mmapFile(file File, size int) ([]byte, error) {
+	f, ok := file.(*os.File)
+	if !ok {
+		return nil, errMmapUnsupported
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
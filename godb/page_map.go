@@ -0,0 +1,243 @@
+package godb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A pageMap records where each page of a compressed heap file is stored.
+// Compressed pages are stored in extents of the heap file:  a page is
+// rewritten in place while it fits its extent, and is otherwise moved to a
+// new extent at the end of the file, leaving a hole behind.  Extents are
+// rounded up to pageExtentUnit bytes, so that a page can grow a little before
+// it has to move.
+//
+// The map is stored in a side file next to the heap file (fileName + ".pm")
+// that starts with the name of the codec the pages are compressed with,
+// padded to pageMapHeaderSize bytes, so that the file describes itself.  It
+// is followed by one entry per page:  the offset of the page's extent
+// (int64), the length of the compressed page (uint32) and the length of the
+// extent (uint32), all little endian.  The entry of a page is written after
+// the page itself.
+//
+// Pages of an encrypted file are compressed before they are encrypted.
+//
+// Entries are not cached, since several HeapFiles may be open on the same
+// file;  pageMapMu serializes the writers instead.
+type pageMap struct {
+	file      File
+	codec     *pageCodec
+	cipher    *pageCipher // encrypts the compressed pages, or nil
+	pageSize  int         // of the pages before compression
+	dataStart int64       // where the extents of the heap file start, after its header
+}
+
+const (
+	pageMapHeaderSize = 16
+	pageMapEntrySize  = 16
+	pageExtentUnit    = 256
+)
+
+var pageMapMu sync.Mutex
+
+func pageMapFileName(heapFileName string) string {
+	return heapFileName + ".pm"
+}
+
+// Where a page of a compressed heap file is stored
+type pageExtent struct {
+	offset   int64
+	length   int
+	capacity int
+}
+
+// Open the page map of a heap file.  If the map doesn't exist yet it is
+// created for the named codec, as long as the heap file is empty;  if codec
+// is "", the heap file isn't compressed, and nil is returned.  An existing
+// map must be for codec, unless codec is "".  Pages are pageSize bytes before
+// compression, and are encrypted with cipher, unless it is nil.
+func openPageMap(vfs VFS, heapFileName string, codec string, pageSize int, cipher *pageCipher) (*pageMap, error) {
+	file, err := vfs.OpenFile(pageMapFileName(heapFileName), os.O_RDWR, fs.ModePerm)
+	if os.IsNotExist(err) {
+		if codec == "" {
+			return nil, nil
+		}
+		return createPageMap(vfs, heapFileName, codec, pageSize, cipher)
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, pageMapHeaderSize)
+	_, err = file.ReadAt(header, 0)
+	if err != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("page map of %s has no header", heapFileName)}
+	}
+	name := strings.TrimRight(string(header), "\x00")
+	if codec != "" && name != codec {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("%s is compressed with %s, not %s", heapFileName, name, codec)}
+	}
+	c, err := findPageCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	return &pageMap{file, c, cipher, pageSize, 0}, nil
+}
+
+func createPageMap(vfs VFS, heapFileName string, codec string, pageSize int, cipher *pageCipher) (*pageMap, error) {
+	c, err := findPageCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := vfs.Stat(heapFileName)
+	if err == nil && stat.Size() > 0 {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("%s already has uncompressed pages", heapFileName)}
+	}
+	file, err := vfs.OpenFile(pageMapFileName(heapFileName), os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, pageMapHeaderSize)
+	copy(header, codec)
+	_, err = file.WriteAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &pageMap{file, c, cipher, pageSize, 0}, nil
+}
+
+// Return the number of pages in the map
+func (m *pageMap) numPages() int {
+	stat, err := m.file.Stat()
+	if err != nil || stat.Size() < pageMapHeaderSize {
+		return 0
+	}
+	return int(stat.Size()-pageMapHeaderSize) / pageMapEntrySize
+}
+
+func (m *pageMap) entry(pageNo int) (pageExtent, error) {
+	buf := make([]byte, pageMapEntrySize)
+	_, err := m.file.ReadAt(buf, int64(pageMapHeaderSize+pageNo*pageMapEntrySize))
+	if err != nil {
+		return pageExtent{}, err
+	}
+	return pageExtent{
+		offset:   int64(binary.LittleEndian.Uint64(buf)),
+		length:   int(binary.LittleEndian.Uint32(buf[8:])),
+		capacity: int(binary.LittleEndian.Uint32(buf[12:])),
+	}, nil
+}
+
+func (m *pageMap) setEntry(pageNo int, e pageExtent) error {
+	buf := make([]byte, pageMapEntrySize)
+	binary.LittleEndian.PutUint64(buf, uint64(e.offset))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(e.length))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(e.capacity))
+	_, err := m.file.WriteAt(buf, int64(pageMapHeaderSize+pageNo*pageMapEntrySize))
+	return err
+}
+
+// Read and decompress a page from data, the heap file.  Returns io.EOF if
+// the page is past the end of the file, like reading an uncompressed page.
+func (m *pageMap) readPage(data File, pageNo int) ([]byte, error) {
+	compressed, err := m.readCompressed(data, pageNo)
+	if err != nil {
+		return nil, err
+	}
+	return m.codec.decompress(compressed, m.pageSize)
+}
+
+// Read a page from data, the heap file, decrypting but not decompressing it
+func (m *pageMap) readCompressed(data File, pageNo int) ([]byte, error) {
+	if pageNo >= m.numPages() {
+		return nil, io.EOF
+	}
+	e, err := m.entry(pageNo)
+	if err != nil {
+		return nil, err
+	}
+	if e.length > e.capacity {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("page map entry has %d bytes in an extent of %d", e.length, e.capacity)}
+	}
+	buf := make([]byte, e.length)
+	_, err = data.ReadAt(buf, e.offset)
+	if err != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("can't read compressed page: %s", err.Error())}
+	}
+	return m.cipher.open(pageNo, buf)
+}
+
+// Compress a page and write it to data, the heap file, either in place or
+// in a new extent at the end of the file.  pageNo may be at most one past
+// the last page of the file.
+func (m *pageMap) writePage(data File, pageNo int, page []byte) error {
+	compressed, err := m.codec.compress(page)
+	if err != nil {
+		return err
+	}
+	compressed, err = m.cipher.seal(pageNo, compressed)
+	if err != nil {
+		return err
+	}
+	pageMapMu.Lock()
+	defer pageMapMu.Unlock()
+	numPages := m.numPages()
+	if pageNo > numPages {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("can't write page %d of a file with %d pages", pageNo, numPages)}
+	}
+	var e pageExtent
+	if pageNo < numPages {
+		e, err = m.entry(pageNo)
+		if err != nil {
+			return err
+		}
+	}
+	stat, err := data.Stat()
+	if err != nil {
+		return err
+	}
+	if pageNo == numPages || len(compressed) > e.capacity {
+		if pageNo == numPages || e.offset+int64(e.capacity) != stat.Size() {
+			// the page is not in the last extent, which could just grow
+			e.offset = stat.Size()
+		}
+		e.capacity = (len(compressed) + pageExtentUnit - 1) / pageExtentUnit * pageExtentUnit
+	}
+	e.length = len(compressed)
+	extent := make([]byte, e.capacity)
+	copy(extent, compressed)
+	_, err = data.WriteAt(extent, e.offset)
+	if err != nil {
+		return err
+	}
+	return m.setEntry(pageNo, e)
+}
+
+// Forget all pages at or after numPages, and cut the heap file down to the
+// end of the last extent still in use
+func (m *pageMap) truncate(data File, numPages int) error {
+	pageMapMu.Lock()
+	defer pageMapMu.Unlock()
+	if numPages > m.numPages() {
+		return nil
+	}
+	end := m.dataStart
+	for pageNo := 0; pageNo < numPages; pageNo++ {
+		e, err := m.entry(pageNo)
+		if err != nil {
+			return err
+		}
+		if e.offset+int64(e.capacity) > end {
+			end = e.offset + int64(e.capacity)
+		}
+	}
+	err := m.file.Truncate(int64(pageMapHeaderSize + numPages*pageMapEntrySize))
+	if err != nil {
+		return err
+	}
+	return data.Truncate(end)
+}
@@ -0,0 +1,121 @@
+package godb
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHeapFilePageSize(t *testing.T) {
+	td, t1, t2, _, _, _ := makeTestVars()
+	for _, size := range []int{1000, 2048, 5000, 131072} {
+		if _, err := NewHeapFileWithPageSize("big.dat", &td, size, NewBufferPoolWithVFS(10, NewMemFS())); err == nil {
+			t.Errorf("expected page size %d to be rejected", size)
+		}
+	}
+	for _, size := range []int{8192, MaxPageSize} {
+		vfs := NewMemFS()
+		bp := NewBufferPoolWithVFS(10, vfs)
+		hf, err := NewHeapFileWithPageSize("big.dat", &td, size, bp)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		slots := calNumSlot(&td, size)
+		if slots < calNumSlot(&td, PageSize)*(size/PageSize) {
+			t.Errorf("expected %d byte pages to hold about %d times as many tuples, got %d", size, size/PageSize, slots)
+		}
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		for i := 0; i < slots+1; i++ {
+			tup := t1
+			if i%2 == 0 {
+				tup = t2
+			}
+			err := hf.insertTuple(&tup, tid)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+		err = bp.CommitTransaction(tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		stat, err := vfs.Stat("big.dat")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if hf.NumPages() != 2 || stat.Size() != int64(3*size) {
+			t.Errorf("expected a header and 2 pages of %d bytes, got %d pages in %d bytes", size, hf.NumPages(), stat.Size())
+		}
+
+		bp = NewBufferPoolWithVFS(10, vfs)
+		hf, err = NewHeapFileWithPageSize("big.dat", &td, size, bp)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if n := len(collectTuples(t, hf, bp)); n != slots+1 {
+			t.Errorf("expected %d tuples after reopening, got %d", slots+1, n)
+		}
+	}
+}
+
+func TestCreateCatalogPageSize(t *testing.T) {
+	vfs := NewMemFS()
+	if _, err := CreateCatalog("catalog.txt", NewBufferPoolWithVFS(50, vfs), "db", 12288); err == nil {
+		t.Errorf("expected a page size that isn't a power of two to be rejected")
+	}
+	c, err := CreateCatalog("catalog.txt", NewBufferPoolWithVFS(50, vfs), "db", 16384)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := CreateCatalog("catalog.txt", NewBufferPoolWithVFS(50, vfs), "db", 16384); err == nil {
+		t.Errorf("expected creating an existing catalog to fail")
+	}
+	for _, sql := range []string{
+		"create table people (name text, age int)",
+		"create table packed (name text, age int) compression = 'lz'",
+	} {
+		if _, _, err := Parse(c, sql); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	runQuery(t, c, "insert into people values ('sam', 25), ('kathy', 45), ('bill', 30), ('ang', 22), ('joe', 40)")
+	runQuery(t, c, "insert into packed values ('sam', 25), ('kathy', 45), ('bill', 30)")
+	err = c.SaveToFile("catalog.txt", "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !strings.HasPrefix(c.CatalogString(), "page size 16384\n") {
+		t.Errorf("expected the catalog to start with its page size, got %s", c.CatalogString())
+	}
+	stat, err := vfs.Stat(c.tableNameToFile("people"))
+	if err != nil || stat.Size() != 2*16384 {
+		t.Errorf("expected people to have a header and one 16384 byte page")
+	}
+
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPoolWithVFS(50, vfs), "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	results, _ := runQuery(t, c, "select name from people where age > 30")
+	if strings.Join(results, ",") != "[{joe}],[{kathy}]" {
+		t.Errorf("expected joe and kathy, got %v", results)
+	}
+	if results, _ := runQuery(t, c, "select name from packed"); len(results) != 3 {
+		t.Errorf("expected 3 compressed tuples, got %v", results)
+	}
+	report, err := c.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 || report.Tuples != 8 {
+		t.Errorf("expected 8 tuples and no problems, got %s", report.String())
+	}
+
+	f, _ := vfs.OpenFile("db/catalog.txt", os.O_WRONLY|os.O_TRUNC, 0)
+	f.Write([]byte("page size 100\npeople (name string, age int)\n"))
+	f.Close()
+	if _, err := NewCatalogFromFile("catalog.txt", NewBufferPoolWithVFS(50, vfs), "db"); err == nil {
+		t.Errorf("expected a bad page size in the catalog to be rejected")
+	}
+}
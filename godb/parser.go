@@ -29,6 +29,7 @@ const (
 	ExprFunc  SelectExprType = iota
 	ExprStar  SelectExprType = iota
 	ExprAggr  SelectExprType = iota
+	ExprCast  SelectExprType = iota
 )
 
 type LogicalSelectNode struct {
@@ -83,6 +84,16 @@ func NewFuncSelectNode(op string, args []*LogicalSelectNode, alias string) Logic
 	return lsn
 }
 
+// The target type of a cast is stored in the value field
+func NewCastSelectNode(arg *LogicalSelectNode, castType string, alias string) LogicalSelectNode {
+	lsn := LogicalSelectNode{}
+	lsn.exprType = ExprCast
+	lsn.args = []*LogicalSelectNode{arg}
+	lsn.value = castType
+	lsn.alias = alias
+	return lsn
+}
+
 func checkNameInTablesOrSubqueries(table string, field string, c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode) (string, error) {
 	if table == "" && subqueries != nil {
 		for _, q := range subqueries {
@@ -120,7 +131,7 @@ func (lsn *LogicalSelectNode) getTableField(c *Catalog, subqueries []*LogicalPla
 	if lsn.exprType == ExprConst {
 		return "", "", nil
 	}
-	if lsn.exprType == ExprFunc || lsn.exprType == ExprAggr {
+	if lsn.exprType == ExprFunc || lsn.exprType == ExprAggr || lsn.exprType == ExprCast {
 		tabName := ""
 		fieldName := ""
 		for _, subLsn := range lsn.args {
@@ -194,6 +205,98 @@ func (p *LogicalPlan) getSubplanFields(c *Catalog) []*FieldType {
 	return nodes
 }
 
+// The columns of each table (by the name the plan uses for it) that a plan
+// refers to
+type columnRefs struct {
+	all  map[string]bool // tables all of whose columns are needed, e.g. by select *
+	cols map[string]map[string]bool
+}
+
+// Return whether the only column of table the plan refers to is column
+func (r *columnRefs) onlyUses(table string, column string) bool {
+	if r.all[table] {
+		return false
+	}
+	for col := range r.cols[table] {
+		if col != column {
+			return false
+		}
+	}
+	return true
+}
+
+// Return whether the plan refers to column of table
+func (r *columnRefs) uses(table string, column string) bool {
+	return r.all[table] || r.cols[table][column]
+}
+
+func (r *columnRefs) add(c *Catalog, p *LogicalPlan, s *LogicalSelectNode) error {
+	switch s.exprType {
+	case ExprConst:
+	case ExprStar:
+		for _, t := range p.tables {
+			name := t.tableName
+			if t.alias != "" {
+				name = t.alias
+			}
+			if s.table == "" || s.table == name {
+				r.all[name] = true
+			}
+		}
+	case ExprField:
+		if s.field == "*" {
+			return nil // count(*) needs no columns
+		}
+		table, _, err := s.getTableField(c, p.subqueries, p.tables)
+		if err != nil {
+			return err
+		}
+		if table == "" {
+			// can't tell which table the field comes from, so assume all
+			return r.add(c, p, &LogicalSelectNode{exprType: ExprStar})
+		}
+		if r.cols[table] == nil {
+			r.cols[table] = make(map[string]bool)
+		}
+		r.cols[table][s.field] = true
+	default:
+		for _, arg := range s.args {
+			err := r.add(c, p, arg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Find the columns of each table that the plan refers to anywhere, so that
+// the planner can tell when an index has all the columns a query needs.
+func (p *LogicalPlan) referencedColumns(c *Catalog) (*columnRefs, error) {
+	refs := &columnRefs{make(map[string]bool), make(map[string]map[string]bool)}
+	var nodes []*LogicalSelectNode
+	nodes = append(nodes, p.selects...)
+	for _, f := range p.filters {
+		nodes = append(nodes, &f.fieldExpr, &f.constExpr)
+	}
+	for _, j := range p.joins {
+		nodes = append(nodes, j.left, j.right)
+	}
+	for _, g := range p.groupByFields {
+		nodes = append(nodes, g.expr)
+	}
+	for _, o := range p.orderByFields {
+		nodes = append(nodes, o.expr)
+	}
+	for _, n := range nodes {
+		err := refs.add(c, p, n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
 func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, expr sqlparser.Expr) ([]*LogicalFilterNode, []*LogicalJoinNode, error) {
 	switch expr := expr.(type) {
 	case *sqlparser.AndExpr:
@@ -240,6 +343,24 @@ func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, e
 			lf[0] = &filter
 			return lf, nil, nil
 		}
+	case *sqlparser.MatchExpr:
+		if len(expr.Columns) != 1 || expr.Option != "" {
+			return nil, nil, GoDBError{ParseError, "MATCH takes one column and a string of terms"}
+		}
+		column, ok := expr.Columns[0].(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, nil, GoDBError{ParseError, "MATCH takes one column and a string of terms"}
+		}
+		left, err := parseExpr(c, column.Expr, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		right, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		filter := LogicalFilterNode{*left, *right, OpMatch}
+		return []*LogicalFilterNode{&filter}, nil, nil
 	default:
 		return nil, nil, GoDBError{ParseError, "where expression with non value or column on RHS (disjunctions and nested where expressions are not supported)"}
 	}
@@ -329,6 +450,16 @@ func isAgg(funcName string) bool {
 	return false
 }
 
+// Type names accepted in CAST(expr AS type); these are the types the
+// underlying MySQL grammar allows, mapped onto GoDB's types
+var castTypes = map[string]DBType{
+	"signed":   IntType,
+	"unsigned": IntType,
+	"char":     StringType,
+	"nchar":    StringType,
+	"binary":   StringType,
+}
+
 func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNode, error) {
 	switch expr := expr.(type) {
 	case *sqlparser.FuncExpr:
@@ -385,6 +516,17 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 		return &outer, nil
 	case *sqlparser.ParenExpr:
 		return parseExpr(c, expr.Expr, alias)
+	case *sqlparser.ConvertExpr:
+		arg, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		castType := strings.ToLower(expr.Type.Type)
+		if _, ok := castTypes[castType]; !ok {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported cast type %s", castType)}
+		}
+		cast := NewCastSelectNode(arg, castType, alias)
+		return &cast, nil
 	case *sqlparser.ColName:
 		field := NewFieldSelectNode(strings.ToLower(sqlparser.String(expr.Qualifier)), strings.ToLower(sqlparser.String(expr.Name)), alias)
 		if len(field.table) > 1 && (field.table[0] == '\'' || field.table[0] == '`') {
@@ -430,7 +572,7 @@ func extractAggs(s *LogicalSelectNode) []*LogicalSelectNode {
 	switch s.exprType {
 	case ExprAggr:
 		return []*LogicalSelectNode{s}
-	case ExprFunc:
+	case ExprFunc, ExprCast:
 		var aggs []*LogicalSelectNode
 		for _, subs := range s.args {
 			aggs = append(aggs, extractAggs(subs)...)
@@ -659,8 +801,21 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 			exprs[i] = &newExpr
 		}
 
+		err := coerceFuncArgs(*s.funcOp, exprs)
+		if err != nil {
+			return nil, "", err
+		}
 		fe := FuncExpr{*s.funcOp, exprs}
 		return &fe, fieldName, nil
+	case ExprCast:
+		argExpr, fieldName, err := s.args[0].generateExpr(c, inputDesc, tableMap)
+		if err != nil {
+			return nil, "", err
+		}
+		if s.alias != "" {
+			fieldName = s.alias
+		}
+		return &CastExpr{argExpr, castTypes[s.value]}, fieldName, nil
 	}
 	return nil, "", GoDBError{ParseError, "unhandled expression type in select list"}
 
@@ -684,6 +839,8 @@ func exprToStr(e Expr) string {
 			argStr += fmt.Sprintf("%s,", exprToStr(*arg))
 		}
 		return fmt.Sprintf("%s(%s)", ex.op, argStr)
+	case *CastExpr:
+		return fmt.Sprintf("cast(%s as %s)", exprToStr(ex.expr), typeNames[ex.castType])
 	default:
 		return fmt.Sprintf("%+v, ", e)
 	}
@@ -705,6 +862,8 @@ func opToStr(op BoolOp) string {
 		return "<"
 	case OpLike:
 		return " LIKE "
+	case OpMatch:
+		return " MATCH "
 
 	}
 	return "??"
@@ -750,6 +909,11 @@ func PrintPhysicalPlan(o Operator, indent string) {
 		PrintPhysicalPlan(*op.left, indent)
 		PrintPhysicalPlan(*op.right, indent)
 
+	case *IndexJoin:
+		fmt.Printf("%sIndex Nested Loop Join using %s, %+v == %+v\n", indent, op.index.indexName(), exprToStr(op.outerField), exprToStr(&FieldExpr{op.innerDesc.Fields[op.index.keyColumn()]}))
+		indent = indent + "\t"
+		PrintPhysicalPlan(op.outer, indent)
+
 	case *Project:
 		selectStr := ""
 		for _, ex := range op.selectFields {
@@ -768,6 +932,29 @@ func PrintPhysicalPlan(o Operator, indent string) {
 		PrintPhysicalPlan(op.child, indent)
 	case *HeapFile:
 		fmt.Printf("%sHeap Scan %v\n", indent, getStrFromObj(op))
+	case *IndexScan:
+		fmt.Printf("%sIndex Scan %v using %s, %s\n", indent, op.heap.fileName, op.index.indexName(), op.keys.String(exprToStr(&FieldExpr{op.heap.desc.Fields[op.index.keyColumn()]})))
+	case *TextSearch:
+		using := ""
+		if op.index != nil {
+			using = " using " + op.index.name
+		}
+		fmt.Printf("%sText Search for '%s' in %s%s\n", indent, op.query, exprToStr(op.field), using)
+		PrintPhysicalPlan(op.child, indent+"  ")
+	case *ColumnarScan:
+		var columns []string
+		for _, col := range op.columns {
+			columns = append(columns, op.file.desc.Fields[col].Fname)
+		}
+		fmt.Printf("%sColumnar Scan %v (%s)\n", indent, op.file.fileName, strings.Join(columns, ","))
+	case *ClusteredFile:
+		fmt.Printf("%sClustered Scan %v\n", indent, op.fileName)
+	case *PartitionedFile:
+		fmt.Printf("%sPartitioned Scan %v (%s)\n", indent, op.table, strings.Join(op.scannedPartitions(), ","))
+	case *ClusteredScan:
+		fmt.Printf("%sClustered Range Scan %v, %s\n", indent, op.file.fileName, op.keys.String(exprToStr(&FieldExpr{op.file.desc.Fields[op.file.keyIndex]})))
+	case *IndexOnlyScan:
+		fmt.Printf("%sIndex Only Scan using %s, %s\n", indent, op.index.indexName(), op.keys.String(exprToStr(&FieldExpr{op.desc.Fields[0]})))
 	case *OrderBy:
 		orderStr := ""
 		for _, ex := range op.orderBy {
@@ -803,6 +990,30 @@ func PrintPhysicalPlan(o Operator, indent string) {
 	}
 }
 
+// Coerce the operands of a filter predicate to a common type and build the
+// int or string [Filter] that evaluates it over child.
+func newCoercedFilter(field Expr, op BoolOp, constExpr Expr, child Operator) (Operator, error) {
+	field, constExpr, err := coerceComparison(field, op, constExpr)
+	if err != nil {
+		return nil, err
+	}
+	switch field.GetExprType().Ftype {
+	case IntType:
+		newOp, err := NewIntFilter(constExpr, op, field, child)
+		if err != nil {
+			return nil, err
+		}
+		return newOp, nil
+	case StringType:
+		newOp, err := NewStringFilter(constExpr, op, field, child)
+		if err != nil {
+			return nil, err
+		}
+		return newOp, nil
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot filter on %s", describeExpr(field))}
+}
+
 func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 	//build mapping from table names / aliases to operators
 
@@ -829,7 +1040,25 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 		tableMap[name] = &PlanNode{*t.file, td}
 	}
 
-	//now apply each filter to appropriate table
+	refs, err := plan.referencedColumns(c)
+	if err != nil {
+		return nil, err
+	}
+	//scan only the columns the plan refers to of columnar tables
+	for name, node := range tableMap {
+		if file, ok := node.op.(*ColumnarFile); ok {
+			var columns []int
+			for i, f := range file.desc.Fields {
+				if refs.uses(name, f.Fname) {
+					columns = append(columns, i)
+				}
+			}
+			node.op = NewColumnarScan(file, columns)
+		}
+	}
+
+	//scan only the partitions of partitioned tables that filters on their
+	//partition keys allow
 	for _, f := range plan.filters {
 		tabName, fieldName, err := f.fieldExpr.getTableField(c, plan.subqueries, plan.tables)
 		if err != nil {
@@ -839,6 +1068,10 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 		if err != nil {
 			return nil, err
 		}
+		file, ok := node.op.(*PartitionedFile)
+		if !ok {
+			continue
+		}
 		leftExpr, _, err := f.fieldExpr.generateExpr(c, node.desc, tableMap)
 		if err != nil {
 			return nil, err
@@ -847,24 +1080,77 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 		if err != nil {
 			return nil, err
 		}
+		node.op, err = file.prune(leftExpr, f.predOp, rightExpr)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		op := node.op
-		desc := *op.Descriptor()
-		desc.setTableAlias(tabName)
+	//now apply each filter to appropriate table.  An index scan can only take
+	//the place of a scan of the table itself, so the filters that one of the
+	//table's indexes covers are planned first, and the others are stacked on
+	//top of them
+	planFilter := func(f *LogicalFilterNode, useIndex bool) (bool, error) {
+		tabName, fieldName, err := f.fieldExpr.getTableField(c, plan.subqueries, plan.tables)
+		if err != nil {
+			return false, err
+		}
+		node, err := fieldToOp(tabName, fieldName, tableMap)
+		if err != nil {
+			return false, err
+		}
+		leftExpr, _, err := f.fieldExpr.generateExpr(c, node.desc, tableMap)
+		if err != nil {
+			return false, err
+		}
+		rightExpr, _, err := f.constExpr.generateExpr(c, node.desc, tableMap)
+		if err != nil {
+			return false, err
+		}
 
-		switch leftExpr.GetExprType().Ftype {
-		case IntType:
-			newOp, err := NewIntFilter(rightExpr, f.predOp, leftExpr, op)
+		op := node.op
+		var newOp Operator
+		if useIndex {
+			newOp, err = planIndexScan(op, leftExpr, f.predOp, rightExpr, func(column string) bool {
+				return refs.onlyUses(tabName, column)
+			})
 			if err != nil {
-				return nil, err
+				return false, err
+			}
+			if newOp == nil && f.predOp == OpMatch {
+				newOp, err = planTextSearch(op, leftExpr, rightExpr)
+				if err != nil {
+					return false, err
+				}
+			}
+			if newOp == nil {
+				return false, nil
 			}
-			tableMap[leftExpr.GetExprType().TableQualifier] = &PlanNode{newOp, &desc}
-		case StringType:
-			newOp, err := NewStringFilter(rightExpr, f.predOp, leftExpr, op)
+		} else {
+			newOp, err = newCoercedFilter(leftExpr, f.predOp, rightExpr, op)
 			if err != nil {
-				return nil, err
+				return false, err
 			}
-			tableMap[leftExpr.GetExprType().TableQualifier] = &PlanNode{newOp, &desc}
+		}
+		desc := *newOp.Descriptor()
+		desc.setTableAlias(tabName)
+		tableMap[leftExpr.GetExprType().TableQualifier] = &PlanNode{newOp, &desc}
+		return true, nil
+	}
+	var unindexed []*LogicalFilterNode
+	for _, f := range plan.filters {
+		planned, err := planFilter(f, true)
+		if err != nil {
+			return nil, err
+		}
+		if !planned {
+			unindexed = append(unindexed, f)
+		}
+	}
+	for _, f := range unindexed {
+		_, err := planFilter(f, false)
+		if err != nil {
+			return nil, err
 		}
 	}
 	//finally apply joins
@@ -910,18 +1196,26 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 			return nil, err
 		}
 
-		var (
-			newOp Operator
-		)
-		switch leftExpr.GetExprType().Ftype {
-		case IntType:
-			newOp, err = NewIntJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
-		case StringType:
-			newOp, err = NewStringJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+		leftExpr, rightExpr, err = coerceComparison(leftExpr, OpEq, rightExpr)
+		if err != nil {
+			return nil, err
 		}
+
+		newOp, err := planIndexJoin(op1, leftExpr, node1.desc, op2, rightExpr, node2.desc)
 		if err != nil {
 			return nil, err
 		}
+		if newOp == nil {
+			switch leftExpr.GetExprType().Ftype {
+			case IntType:
+				newOp, err = NewIntJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+			case StringType:
+				newOp, err = NewStringJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
 		newNode := &PlanNode{newOp, newOp.Descriptor()}
 		for key, node := range tableMap {
 			if node.op == op1 {
@@ -1094,10 +1388,15 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 			ascs = append(ascs, oby.ascending)
 
 		}
-		var err error
-		topOp, err = NewOrderBy(exprs, topOp, ascs)
-		if err != nil {
-			return nil, err
+		// clustering keys are unique, so tuples in key order are ordered by
+		// any list of fields that starts with the key
+		field, ok := exprs[0].(*FieldExpr)
+		if !ok || !ascs[0] || !keyOrdered(topOp, field.selectField) {
+			var err error
+			topOp, err = NewOrderBy(exprs, topOp, ascs)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 	}
@@ -1190,6 +1489,7 @@ func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
 	}
 	var newOp Operator
 	newOp = *tables[0].file
+	var filterOps []func(Operator) (Operator, error)
 	for _, f := range filters {
 		tabName, fieldName, err := f.fieldExpr.getTableField(c, subplans, tables)
 		if err != nil {
@@ -1211,19 +1511,23 @@ func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
 		//op := node.op
 		//dbField, _ := fieldNameToField(f.table, f.field, &PlanNode{op, &desc})
 
-		switch leftExpr.GetExprType().Ftype {
-		case IntType:
-			//newInt, _ := strconv.Atoi(f.constVal)
-			newOp, err = NewIntFilter(rightExpr, f.predOp, leftExpr, newOp)
-			if err != nil {
-				return nil, err
-			}
-		case StringType:
-			newOp, err = NewStringFilter(rightExpr, f.predOp, leftExpr, newOp)
+		//only delete from the partitions the filter allows
+		if file, ok := newOp.(*PartitionedFile); ok {
+			newOp, err = file.prune(leftExpr, f.predOp, rightExpr)
 			if err != nil {
 				return nil, err
 			}
 		}
+		predOp := f.predOp
+		filterOps = append(filterOps, func(child Operator) (Operator, error) {
+			return newCoercedFilter(leftExpr, predOp, rightExpr, child)
+		})
+	}
+	for _, filter := range filterOps {
+		newOp, err = filter(newOp)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return NewDeleteOp(*tables[0].file, newOp), nil
 
@@ -1238,10 +1542,69 @@ const (
 	AbortXactionType     QueryType = iota
 	CreateTableQueryType QueryType = iota
 	DropTableQueryType   QueryType = iota
+	VacuumQueryType      QueryType = iota
+	CreateIndexQueryType QueryType = iota
+	DropIndexQueryType   QueryType = iota
+	AlterTableQueryType  QueryType = iota
 	UnknownQueryType     QueryType = iota
 )
 
-func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
+// Return the column named by the PRIMARY KEY of a CREATE TABLE statement, if
+// it has one.  The table is clustered on its primary key, which must be a
+// single column.
+func parseClusterKey(spec *sqlparser.TableSpec) (string, error) {
+	var keys []string
+	for _, idx := range spec.Indexes {
+		if !idx.Info.Primary {
+			continue
+		}
+		if len(idx.Columns) != 1 {
+			return "", GoDBError{ParseError, "primary keys must be a single column"}
+		}
+		keys = append(keys, strings.ToLower(sqlparser.String(idx.Columns[0].Column)))
+	}
+	for _, col := range spec.Columns {
+		// the parser doesn't export its column key options, only their SQL
+		if strings.HasSuffix(sqlparser.String(&col.Type), " primary key") {
+			keys = append(keys, strings.ToLower(sqlparser.String(col.Name)))
+		}
+	}
+	if len(keys) > 1 {
+		return "", GoDBError{ParseError, "a table can only have one primary key"}
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+	return keys[0], nil
+}
+
+// Parse the storage options of a CREATE TABLE statement:  whether the table
+// is stored USING COLUMNAR, and the codec its pages are compressed with if it
+// has a COMPRESSION = 'codec' option.  The parser doesn't understand either
+// clause, but leaves them in the table options.
+func parseTableStorage(spec *sqlparser.TableSpec) (bool, string, error) {
+	words := strings.Fields(strings.NewReplacer("=", " ", "'", " ").Replace(strings.ToLower(spec.Options)))
+	columnar, compressed := false, ""
+	for len(words) > 0 {
+		if len(words) < 2 || (words[0] != "using" && words[0] != "compression") {
+			return false, "", GoDBError{ParseError, fmt.Sprintf("malformed table options %s", spec.Options)}
+		}
+		if words[0] == "compression" {
+			compressed = words[1]
+		} else if words[1] == "columnar" || words[1] == "heap" {
+			columnar = words[1] == "columnar"
+		} else {
+			return false, "", GoDBError{ParseError, fmt.Sprintf("unknown table storage %s", words[1])}
+		}
+		words = words[2:]
+	}
+	if compressed == "none" {
+		compressed = ""
+	}
+	return columnar, compressed, nil
+}
+
+func processDDL(c *Catalog, ddl *sqlparser.DDL, query string) (QueryType, error) {
 	switch ddl.Action {
 	case "create":
 		fields := make([]FieldType, len(ddl.TableSpec.Columns))
@@ -1268,8 +1631,26 @@ func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 			}
 			fields[i] = FieldType{colName, "", colType}
 		}
-
-		c.addTable(tabName, TupleDesc{fields})
+		clusterKey, err := parseClusterKey(ddl.TableSpec)
+		if err != nil {
+			return UnknownQueryType, err
+		}
+		columnar, compressed, err := parseTableStorage(ddl.TableSpec)
+		if err != nil {
+			return UnknownQueryType, err
+		}
+		var partitions *partitioning
+		if _, clause := splitPartitionClause(query); clause != "" {
+			partitions, err = parsePartitioning(clause, &TupleDesc{fields})
+			if err != nil {
+				return UnknownQueryType, err
+			}
+		}
+		_, temporary := splitTemporary(query)
+		err = c.addTable(&Table{tabName, TupleDesc{fields}, clusterKey, columnar, compressed, partitions, temporary})
+		if err != nil {
+			return UnknownQueryType, err
+		}
 		return CreateTableQueryType, nil
 
 	case "drop":
@@ -1279,13 +1660,25 @@ func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 			return UnknownQueryType, err
 		}
 		return DropTableQueryType, nil
+	case "alter":
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(query)), "alter") {
+			return processPartitionDDL(c, query)
+		}
+		// CREATE INDEX and DROP INDEX come back from the parser as ALTER TABLE
+		return processIndexDDL(c, query)
 	default:
 		return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("unsupported ddl statement %s", ddl.Action)}
 	}
 }
 
 func Parse(c *Catalog, query string) (QueryType, Operator, error) {
-	stmt, err := sqlparser.Parse(query)
+	if words := vacuumWords(query); len(words) > 0 && words[0] == "vacuum" {
+		qtype, err := processVacuum(c, query)
+		return qtype, nil, err
+	}
+	statement, _ := splitTemporary(query)
+	statement, _ = splitPartitionClause(statement)
+	stmt, err := sqlparser.Parse(rewriteMatchCalls(statement))
 	if err != nil {
 		return UnknownQueryType, nil, err
 	}
@@ -1321,7 +1714,7 @@ func Parse(c *Catalog, query string) (QueryType, Operator, error) {
 	case *sqlparser.Rollback:
 		return AbortXactionType, nil, nil
 	case *sqlparser.DDL:
-		qtype, err := processDDL(c, stmt)
+		qtype, err := processDDL(c, stmt, query)
 		if err != nil {
 			return UnknownQueryType, nil, err
 		} else {
@@ -0,0 +1,780 @@
+package godb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Partitioned tables.  A table created with a PARTITION BY clause stores its
+// tuples in one heap file per partition, chosen by the value of a column, the
+// partition key:
+//
+//	PARTITION BY RANGE (col) (PARTITION p0 VALUES LESS THAN (10), ...,
+//	    PARTITION pn VALUES LESS THAN MAXVALUE)
+//	PARTITION BY LIST (col) (PARTITION p0 VALUES IN ('a', 'b'), ...)
+//	PARTITION BY HASH (col) PARTITIONS n
+//
+// Range partitions hold the keys below their bound and at or above the bound
+// of the partition before them, and list partitions hold the keys they list.
+// Hash partitions are named p0 to pn-1, and hold the keys that hash to their
+// number.  The catalog records the clause after the table's other options.
+//
+// Queries only scan the partitions that filters on the partition key allow
+// (see [PartitionedFile.prune]).  ALTER TABLE t ADD PARTITION (PARTITION ...)
+// adds a range partition above the last bound or a list partition, and ALTER
+// TABLE t DROP PARTITION p removes a partition along with its tuples by
+// deleting its file, without reading it.
+
+// How the tuples of a partitioned table are assigned to its partitions
+type PartitionKind int
+
+const (
+	RangePartitioning PartitionKind = iota
+	ListPartitioning  PartitionKind = iota
+	HashPartitioning  PartitionKind = iota
+)
+
+var partitionKindNames = map[PartitionKind]string{RangePartitioning: "range", ListPartitioning: "list", HashPartitioning: "hash"}
+
+// A partition of a partitioned table
+type partition struct {
+	name   string
+	bound  DBValue   // upper bound of a range partition, or nil for MAXVALUE
+	values []DBValue // keys of a list partition
+}
+
+// The partitioning of a table, as declared by its PARTITION BY clause
+type partitioning struct {
+	kind       PartitionKind
+	column     string
+	partitions []*partition
+}
+
+// The record id of a tuple of a partitioned table:  the position of its
+// partition in the table's partitioning, and its record id in that
+// partition's heap file
+type partitionRID struct {
+	partition int
+	rid       heapFileRID
+}
+
+// PartitionedFile is the DBFile of a partitioned table.  It has no pages of
+// its own;  its tuples are stored in the heap files of its partitions.
+type PartitionedFile struct {
+	table      string
+	desc       *TupleDesc // shared with the heap files of the partitions
+	spec       *partitioning
+	keyIndex   int
+	partitions []*HeapFile // in the order of spec.partitions
+	keys       keyRange    // scans skip the partitions that can't hold keys in this range
+}
+
+// Open the partitions of a partitioned table of the catalog
+func (c *Catalog) openPartitionedFile(t *Table) (*PartitionedFile, error) {
+	desc := t.desc.copy()
+	keyIndex, err := findFieldInTd(FieldType{t.partitioning.column, "", UnknownType}, desc)
+	if err != nil {
+		return nil, err
+	}
+	f := &PartitionedFile{table: t.name, desc: desc, spec: t.partitioning, keyIndex: keyIndex}
+	for _, p := range t.partitioning.partitions {
+		hf, err := openHeapFile(c.partitionNameToFile(t.name, p.name), desc, c.pageSize, t.compressed, c.cipher, c.bp)
+		if err != nil {
+			return nil, err
+		}
+		hf.temporary = t.temporary
+		f.partitions = append(f.partitions, hf)
+	}
+	return f, nil
+}
+
+func (c *Catalog) partitionNameToFile(tableName string, partitionName string) string {
+	return c.tableDir(tableName) + "/" + tableName + "." + partitionName + ".dat"
+}
+
+func (f *PartitionedFile) Descriptor() *TupleDesc {
+	return f.desc
+}
+
+// Return the position of the partition that holds key
+func (s *partitioning) route(key DBValue) (int, error) {
+	switch s.kind {
+	case RangePartitioning:
+		for i, p := range s.partitions {
+			if p.bound == nil || compareKeys(key, p.bound) < 0 {
+				return i, nil
+			}
+		}
+	case ListPartitioning:
+		for i, p := range s.partitions {
+			for _, v := range p.values {
+				if compareKeys(key, v) == 0 {
+					return i, nil
+				}
+			}
+		}
+	case HashPartitioning:
+		return int(hashKey(key) % uint64(len(s.partitions))), nil
+	}
+	return -1, GoDBError{IllegalOperationError, fmt.Sprintf("no partition holds %s %s", s.column, formatPartitionValue(key))}
+}
+
+// Whether the partition at position i may hold keys in r
+func (s *partitioning) mayHold(i int, r keyRange) bool {
+	switch s.kind {
+	case RangePartitioning:
+		if bound := s.partitions[i].bound; r.lo != nil && bound != nil && compareKeys(r.lo, bound) >= 0 {
+			return false
+		}
+		if r.hi != nil && i > 0 {
+			cmp := compareKeys(r.hi, s.partitions[i-1].bound)
+			return cmp > 0 || (cmp == 0 && r.hiIncl)
+		}
+		return true
+	case ListPartitioning:
+		for _, v := range s.partitions[i].values {
+			if r.contains(v) {
+				return true
+			}
+		}
+		return false
+	case HashPartitioning:
+		return !r.isPoint() || int(hashKey(r.lo)%uint64(len(s.partitions))) == i
+	}
+	return true
+}
+
+// Whether v is in r
+func (r keyRange) contains(v DBValue) bool {
+	if r.lo != nil {
+		cmp := compareKeys(v, r.lo)
+		if cmp < 0 || (cmp == 0 && !r.loIncl) {
+			return false
+		}
+	}
+	if r.hi != nil {
+		cmp := compareKeys(v, r.hi)
+		if cmp > 0 || (cmp == 0 && !r.hiIncl) {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the positions of the partitions a scan of the file reads
+func (f *PartitionedFile) scanned() []int {
+	var scanned []int
+	for i := range f.partitions {
+		if f.spec.mayHold(i, f.keys) {
+			scanned = append(scanned, i)
+		}
+	}
+	return scanned
+}
+
+// Return the names of the partitions a scan of the file reads
+func (f *PartitionedFile) scannedPartitions() []string {
+	var names []string
+	for _, i := range f.scanned() {
+		names = append(names, f.spec.partitions[i].name)
+	}
+	return names
+}
+
+// If "field pred constExpr" is a predicate on the partition key, return a
+// copy of the file whose scans skip the partitions that hold no tuples
+// satisfying it, and otherwise the file itself.  The predicate must still be
+// applied to the tuples of the partitions that are scanned.
+func (f *PartitionedFile) prune(field Expr, pred BoolOp, constExpr Expr) (*PartitionedFile, error) {
+	switch pred {
+	case OpEq, OpLt, OpLe, OpGt, OpGe:
+	default:
+		return f, nil
+	}
+	field, constExpr, err := coerceComparison(field, pred, constExpr)
+	if err != nil {
+		return nil, err
+	}
+	fieldExpr, ok := field.(*FieldExpr)
+	if !ok || fieldExpr.selectField.Fname != f.desc.Fields[f.keyIndex].Fname {
+		return f, nil
+	}
+	constant, ok := constExpr.(*ConstExpr)
+	if !ok {
+		return f, nil
+	}
+	key, ok := constant.val.(DBValue)
+	if !ok || !valueHasType(key, f.desc.Fields[f.keyIndex].Ftype) {
+		return f, nil
+	}
+	pruned := *f
+	pruned.keys = f.keys.restrict(pred, key)
+	return &pruned, nil
+}
+
+// Insert t into the partition that holds its partition key
+func (f *PartitionedFile) insertTuple(t *Tuple, tid TransactionID) error {
+	if f.keyIndex >= len(t.Fields) {
+		return GoDBError{MalformedDataError, "tuple doesn't have the partition key"}
+	}
+	key := t.Fields[f.keyIndex]
+	if !valueHasType(key, f.desc.Fields[f.keyIndex].Ftype) {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("partition key of %s is a %s", f.table, typeNames[f.desc.Fields[f.keyIndex].Ftype])}
+	}
+	i, err := f.spec.route(key)
+	if err != nil {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("can't insert into %s: %s", f.table, err.(GoDBError).errString)}
+	}
+	return f.partitions[i].insertTuple(t, tid)
+}
+
+// Delete t, which must have been read from the file, from its partition
+func (f *PartitionedFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	rid, ok := t.Rid.(partitionRID)
+	if !ok || rid.partition >= len(f.partitions) {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("tuple was not read from partitioned table %s", f.table)}
+	}
+	return f.partitions[rid.partition].deleteTuple(&Tuple{t.Desc, t.Fields, rid.rid}, tid)
+}
+
+func (f *PartitionedFile) readPage(pageNo int) (*Page, error) {
+	return nil, GoDBError{IllegalOperationError, fmt.Sprintf("partitioned table %s has no pages of its own", f.table)}
+}
+
+func (f *PartitionedFile) flushPage(page *Page) error {
+	return GoDBError{IllegalOperationError, fmt.Sprintf("partitioned table %s has no pages of its own", f.table)}
+}
+
+func (f *PartitionedFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.table, PageNo: pgNo}
+}
+
+func (f *PartitionedFile) sync() error {
+	for _, hf := range f.partitions {
+		err := hf.sync()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return an iterator over the tuples of the partitions the file scans, one
+// partition after another
+func (f *PartitionedFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	scanned := f.scanned()
+	next := 0
+	var iter func() (*Tuple, error)
+	return func() (*Tuple, error) {
+		for {
+			if iter == nil {
+				if next == len(scanned) {
+					return nil, nil
+				}
+				var err error
+				iter, err = f.partitions[scanned[next]].Iterator(tid)
+				if err != nil {
+					return nil, err
+				}
+				next++
+			}
+			t, err := iter()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				iter = nil
+				continue
+			}
+			// the heap file's tuple is shared with its page, so it is copied
+			return &Tuple{*f.desc, t.Fields, partitionRID{scanned[next-1], t.Rid.(heapFileRID)}}, nil
+		}
+	}, nil
+}
+
+// Add a partition to the named table.  A range partition must go above the
+// bound of the last partition, and a list partition must not list a key of
+// another partition;  hash partitions can't be added.
+func (c *Catalog) addPartition(table string, p *partition) error {
+	t, err := c.partitionedTable(table)
+	if err != nil {
+		return err
+	}
+	if t.partitioning.kind == HashPartitioning {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("can't add a partition to %s, it is hash partitioned", table)}
+	}
+	spec := *t.partitioning
+	spec.partitions = append(append([]*partition{}, spec.partitions...), p)
+	err = spec.validate()
+	if err != nil {
+		return err
+	}
+	t.partitioning = &spec
+	if f, ok := c.files[table].(*PartitionedFile); ok {
+		hf, err := openHeapFile(c.partitionNameToFile(table, p.name), f.desc, c.pageSize, t.compressed, c.cipher, c.bp)
+		if err != nil {
+			c.closeTable(table)
+			return err
+		}
+		hf.temporary = t.temporary
+		f.spec = t.partitioning
+		f.partitions = append(f.partitions, hf)
+	}
+	return nil
+}
+
+// Drop a partition of a range or list partitioned table, and with it the
+// tuples it holds.  Only the partition's files are removed;  none of its
+// tuples are read.  Afterwards, a range partition's keys belong to the
+// partition above it, while a list partition's keys can't be inserted.
+func (c *Catalog) DropPartition(table string, name string) error {
+	t, err := c.partitionedTable(table)
+	if err != nil {
+		return err
+	}
+	spec := t.partitioning
+	if spec.kind == HashPartitioning {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("can't drop a partition of %s, it is hash partitioned", table)}
+	}
+	for i, p := range spec.partitions {
+		if p.name != name {
+			continue
+		}
+		if len(spec.partitions) == 1 {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("can't drop %s, the only partition of %s", name, table)}
+		}
+		fileName := c.partitionNameToFile(table, name)
+		t.partitioning = &partitioning{spec.kind, spec.column, append(append([]*partition{}, spec.partitions[:i]...), spec.partitions[i+1:]...)}
+		var hf *HeapFile
+		if f, ok := c.files[table].(*PartitionedFile); ok {
+			hf = f.partitions[i]
+			f.spec = t.partitioning
+			f.partitions = append(append([]*HeapFile{}, f.partitions[:i]...), f.partitions[i+1:]...)
+		} else {
+			hf, err = openHeapFile(fileName, t.desc.copy(), c.pageSize, t.compressed, c.cipher, c.bp)
+		}
+		if err == nil {
+			// a partition of the same name added later must not see its pages
+			closeFiles(c.bp, hf)
+		}
+		removeHeapFile(c.bp.vfs, fileName)
+		return nil
+	}
+	return GoDBError{NoSuchTableError, fmt.Sprintf("table %s has no partition '%s'", table, name)}
+}
+
+func (c *Catalog) partitionedTable(table string) (*Table, error) {
+	t := c.tableMap[table]
+	if t == nil {
+		return nil, GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", table)}
+	}
+	if t.partitioning == nil {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("table %s is not partitioned", table)}
+	}
+	return t, nil
+}
+
+// Return the heap files a table is stored in:  the partitions of a
+// PartitionedFile, or a single HeapFile.  Other files have none.
+func heapFiles(file DBFile) []*HeapFile {
+	switch file := file.(type) {
+	case *HeapFile:
+		return []*HeapFile{file}
+	case *PartitionedFile:
+		return file.partitions
+	}
+	return nil
+}
+
+// Return the names of the files that store the tuples of a table:  its own,
+// or those of its partitions if it is partitioned
+func (c *Catalog) tableFileNames(t *Table) []string {
+	if t.partitioning == nil {
+		return []string{c.tableNameToFile(t.name)}
+	}
+	var names []string
+	for _, p := range t.partitioning.partitions {
+		names = append(names, c.partitionNameToFile(t.name, p.name))
+	}
+	return names
+}
+
+// Check that the partitions have distinct names and don't overlap, and that
+// only the last range partition is unbounded
+func (s *partitioning) validate() error {
+	if len(s.partitions) == 0 {
+		return GoDBError{ParseError, "a partitioned table needs at least one partition"}
+	}
+	names := make(map[string]bool)
+	keys := make(map[any]string)
+	for i, p := range s.partitions {
+		if names[p.name] {
+			return GoDBError{ParseError, fmt.Sprintf("duplicate partition %s", p.name)}
+		}
+		names[p.name] = true
+		switch s.kind {
+		case RangePartitioning:
+			if i == 0 {
+				break
+			}
+			prev := s.partitions[i-1].bound
+			if prev == nil {
+				return GoDBError{ParseError, fmt.Sprintf("partition %s follows a partition with no upper bound", p.name)}
+			}
+			if p.bound != nil && compareKeys(p.bound, prev) <= 0 {
+				return GoDBError{ParseError, fmt.Sprintf("bound of partition %s must be above %s", p.name, formatPartitionValue(prev))}
+			}
+		case ListPartitioning:
+			for _, v := range p.values {
+				if other, ok := keys[v]; ok {
+					return GoDBError{ParseError, fmt.Sprintf("%s is listed by both %s and %s", formatPartitionValue(v), other, p.name)}
+				}
+				keys[v] = p.name
+			}
+		}
+	}
+	return nil
+}
+
+// Return the PARTITION BY clause that declares the partitioning
+func (s *partitioning) String() string {
+	clause := fmt.Sprintf("partition by %s (%s)", partitionKindNames[s.kind], s.column)
+	if s.kind == HashPartitioning {
+		return clause + fmt.Sprintf(" partitions %d", len(s.partitions))
+	}
+	var defs []string
+	for _, p := range s.partitions {
+		defs = append(defs, p.String(s.kind))
+	}
+	return clause + " (" + strings.Join(defs, ", ") + ")"
+}
+
+// Return the definition of the partition in a PARTITION BY clause
+func (p *partition) String(kind PartitionKind) string {
+	if kind == ListPartitioning {
+		var values []string
+		for _, v := range p.values {
+			values = append(values, formatPartitionValue(v))
+		}
+		return fmt.Sprintf("partition %s values in (%s)", p.name, strings.Join(values, ", "))
+	}
+	if p.bound == nil {
+		return fmt.Sprintf("partition %s values less than maxvalue", p.name)
+	}
+	return fmt.Sprintf("partition %s values less than (%s)", p.name, formatPartitionValue(p.bound))
+}
+
+func formatPartitionValue(v DBValue) string {
+	if s, ok := v.(StringField); ok {
+		return "'" + strings.ReplaceAll(s.Value, "'", "''") + "'"
+	}
+	return fmt.Sprintf("%v", v.(IntField).Value)
+}
+
+var partitionClause = regexp.MustCompile(`(?i)\spartition\s+by\s`)
+
+// Split the PARTITION BY clause off a table definition, returning the
+// definition without it and the clause, or "" if it has none
+func cutPartitionClause(s string) (string, string) {
+	loc := partitionClause.FindStringIndex(s)
+	if loc == nil {
+		return s, ""
+	}
+	return s[:loc[0]], strings.TrimSpace(s[loc[0]:])
+}
+
+// Split the PARTITION BY clause, which the SQL parser doesn't understand, off
+// a CREATE TABLE statement
+func splitPartitionClause(query string) (string, string) {
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(query)), "create") {
+		return query, ""
+	}
+	return cutPartitionClause(query)
+}
+
+// Parse the PARTITION BY clause of a table with fields desc
+func parsePartitioning(clause string, desc *TupleDesc) (*partitioning, error) {
+	p, err := newPartitionParser(clause)
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect("partition", "by")
+	if err != nil {
+		return nil, err
+	}
+	spec := &partitioning{}
+	kindName := p.word()
+	kind := -1
+	for k, name := range partitionKindNames {
+		if name == kindName {
+			kind = int(k)
+		}
+	}
+	if kind < 0 {
+		return nil, GoDBError{ParseError, fmt.Sprintf("unknown partitioning %s", kindName)}
+	}
+	spec.kind = PartitionKind(kind)
+	err = p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+	spec.column = p.word()
+	err = p.expect(")")
+	if err != nil {
+		return nil, err
+	}
+	keyIndex, err := findFieldInTd(FieldType{spec.column, "", UnknownType}, desc)
+	if err != nil {
+		return nil, GoDBError{ParseError, fmt.Sprintf("partition key %s is not a column of the table", spec.column)}
+	}
+	keyType := desc.Fields[keyIndex].Ftype
+	if spec.kind == HashPartitioning {
+		err = p.expect("partitions")
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(p.word())
+		if err != nil || n < 1 {
+			return nil, GoDBError{ParseError, "expected the number of hash partitions"}
+		}
+		for i := 0; i < n; i++ {
+			spec.partitions = append(spec.partitions, &partition{name: fmt.Sprintf("p%d", i)})
+		}
+	} else {
+		err = p.expect("(")
+		if err != nil {
+			return nil, err
+		}
+		for {
+			def, err := p.partition(spec.kind, keyType)
+			if err != nil {
+				return nil, err
+			}
+			spec.partitions = append(spec.partitions, def)
+			if p.peek() != "," {
+				break
+			}
+			p.pos++
+		}
+		err = p.expect(")")
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = p.end()
+	if err != nil {
+		return nil, err
+	}
+	return spec, spec.validate()
+}
+
+// Parse and run an ALTER TABLE t ADD PARTITION (PARTITION ...) or ALTER TABLE
+// t DROP PARTITION p statement
+func processPartitionDDL(c *Catalog, query string) (QueryType, error) {
+	p, err := newPartitionParser(query)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	err = p.expect("alter", "table")
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	table := p.word()
+	action := p.word()
+	if action != "add" && action != "drop" || p.peek() != "partition" {
+		return UnknownQueryType, GoDBError{ParseError, "unsupported alter table statement"}
+	}
+	p.pos++
+	if action == "drop" {
+		name := p.word()
+		err = p.end()
+		if err != nil {
+			return UnknownQueryType, err
+		}
+		err = c.DropPartition(table, name)
+		if err != nil {
+			return UnknownQueryType, err
+		}
+		return AlterTableQueryType, nil
+	}
+	t, err := c.partitionedTable(table)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	keyIndex, err := findFieldInTd(FieldType{t.partitioning.column, "", UnknownType}, &t.desc)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	err = p.expect("(")
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	def, err := p.partition(t.partitioning.kind, t.desc.Fields[keyIndex].Ftype)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	err = p.expect(")")
+	if err == nil {
+		err = p.end()
+	}
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	err = c.addPartition(table, def)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	return AlterTableQueryType, nil
+}
+
+// A parser of the tokens of partitioning clauses:  words, which are compared
+// in lower case, quoted strings, parentheses and commas
+type partitionParser struct {
+	tokens []string
+	pos    int
+}
+
+func newPartitionParser(s string) (*partitionParser, error) {
+	p := &partitionParser{}
+	runes := []rune(strings.TrimSuffix(strings.TrimSpace(s), ";"))
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			p.tokens = append(p.tokens, string(r))
+			i++
+		case r == '\'' || r == '"':
+			// quoted strings keep their opening quote, to tell them from words
+			token := []rune{r}
+			for i++; ; i++ {
+				if i == len(runes) {
+					return nil, GoDBError{ParseError, "unterminated string in partitioning"}
+				}
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						i++
+					} else {
+						i++
+						break
+					}
+				}
+				token = append(token, runes[i])
+			}
+			p.tokens = append(p.tokens, string(token))
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("(),'\"", runes[i]) {
+				i++
+			}
+			p.tokens = append(p.tokens, strings.ToLower(string(runes[start:i])))
+		}
+	}
+	return p, nil
+}
+
+// Return the next token without consuming it, or "" at the end
+func (p *partitionParser) peek() string {
+	if p.pos == len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// Consume and return the next token
+func (p *partitionParser) word() string {
+	w := p.peek()
+	if w != "" {
+		p.pos++
+	}
+	return w
+}
+
+// Consume the given tokens
+func (p *partitionParser) expect(words ...string) error {
+	for _, w := range words {
+		if got := p.word(); got != w {
+			return GoDBError{ParseError, fmt.Sprintf("expected '%s' in partitioning, got '%s'", w, got)}
+		}
+	}
+	return nil
+}
+
+func (p *partitionParser) end() error {
+	if p.pos != len(p.tokens) {
+		return GoDBError{ParseError, fmt.Sprintf("unexpected '%s' in partitioning", strings.Join(p.tokens[p.pos:], " "))}
+	}
+	return nil
+}
+
+// Parse a constant of type ftype
+func (p *partitionParser) value(ftype DBType) (DBValue, error) {
+	token := p.word()
+	quoted := strings.HasPrefix(token, "'") || strings.HasPrefix(token, "\"")
+	if ftype == StringType && quoted {
+		return StringField{token[1:]}, nil
+	}
+	if ftype == IntType && !quoted {
+		v, err := strconv.ParseInt(token, 10, 64)
+		if err == nil {
+			return IntField{v}, nil
+		}
+	}
+	return nil, GoDBError{ParseError, fmt.Sprintf("expected a %s partition key, got %s", typeNames[ftype], token)}
+}
+
+// Parse the definition of a partition, PARTITION name VALUES LESS THAN
+// (value) or MAXVALUE for range partitions, or PARTITION name VALUES IN
+// (value, ...) for list partitions
+func (p *partitionParser) partition(kind PartitionKind, keyType DBType) (*partition, error) {
+	err := p.expect("partition")
+	if err != nil {
+		return nil, err
+	}
+	def := &partition{name: p.word()}
+	if def.name == "" || def.name == "(" || def.name == ")" || def.name == "," {
+		return nil, GoDBError{ParseError, "expected a partition name"}
+	}
+	err = p.expect("values")
+	if err != nil {
+		return nil, err
+	}
+	if kind == RangePartitioning {
+		err = p.expect("less", "than")
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() == "maxvalue" {
+			p.pos++
+			return def, nil
+		}
+		err = p.expect("(")
+		if err != nil {
+			return nil, err
+		}
+		def.bound, err = p.value(keyType)
+		if err != nil {
+			return nil, err
+		}
+		return def, p.expect(")")
+	}
+	err = p.expect("in", "(")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		v, err := p.value(keyType)
+		if err != nil {
+			return nil, err
+		}
+		def.values = append(def.values, v)
+		if p.peek() != "," {
+			break
+		}
+		p.pos++
+	}
+	return def, p.expect(")")
+}
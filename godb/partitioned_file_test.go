@@ -0,0 +1,201 @@
+package godb
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// Create a catalog in memory with the given tables
+func makePartitionCatalog(t *testing.T, vfs VFS, sqls ...string) *Catalog {
+	f, _ := vfs.OpenFile("db/catalog.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	f.Close()
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPoolWithVFS(100, vfs), "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, sql := range sqls {
+		if _, _, err := Parse(c, sql); err != nil {
+			t.Fatalf("%s: %s", sql, err.Error())
+		}
+	}
+	return c
+}
+
+// Return the partitions scanned by the plan of a query
+func scannedPartitions(t *testing.T, op Operator) string {
+	for _, op := range planOperators(op) {
+		if file, ok := op.(*PartitionedFile); ok {
+			return strings.Join(file.scannedPartitions(), ",")
+		}
+	}
+	t.Fatalf("expected a partitioned scan")
+	return ""
+}
+
+// Count the tuples stored in each partition of a table
+func partitionSizes(t *testing.T, c *Catalog, table string) []int {
+	file, err := c.GetTable(table)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var sizes []int
+	for _, hf := range file.(*PartitionedFile).partitions {
+		sizes = append(sizes, len(collectTuples(t, hf, c.bp)))
+	}
+	return sizes
+}
+
+func TestRangePartitioning(t *testing.T) {
+	vfs := NewMemFS()
+	c := makePartitionCatalog(t, vfs, "create table people (name text, age int) partition by range (age) (partition kids values less than (18), partition adults values less than (65), partition seniors values less than maxvalue)")
+	runQuery(t, c, "insert into people values ('sam', 25), ('kathy', 45), ('bill', 70), ('ang', 12), ('joe', 40), ('tim', 18)")
+	if sizes := partitionSizes(t, c, "people"); len(sizes) != 3 || sizes[0] != 1 || sizes[1] != 4 || sizes[2] != 1 {
+		t.Errorf("expected 1, 4 and 1 tuples in the partitions, got %v", sizes)
+	}
+
+	for _, test := range []struct {
+		sql, scanned, results string
+	}{
+		{"select name from people where age < 18", "kids", "[{ang}]"},
+		{"select name from people where age >= 18 and age < 30", "adults", "[{sam}],[{tim}]"},
+		{"select name from people where age > 64", "adults,seniors", "[{bill}]"},
+		{"select name from people where age = 65", "seniors", ""},
+		{"select name from people where name = 'joe'", "kids,adults,seniors", "[{joe}]"},
+	} {
+		results, op := runQuery(t, c, test.sql)
+		if scanned := scannedPartitions(t, op); scanned != test.scanned {
+			t.Errorf("%s: expected to scan %s, scanned %s", test.sql, test.scanned, scanned)
+		}
+		if strings.Join(results, ",") != test.results {
+			t.Errorf("%s: expected %s, got %v", test.sql, test.results, results)
+		}
+	}
+
+	runQuery(t, c, "delete from people where age >= 40 and age < 50")
+	if sizes := partitionSizes(t, c, "people"); sizes[1] != 2 {
+		t.Errorf("expected 2 adults after the delete, got %d", sizes[1])
+	}
+	if results, _ := runQuery(t, c, "select name from people"); len(results) != 4 {
+		t.Errorf("expected 4 people after the delete, got %v", results)
+	}
+}
+
+func TestListPartitioning(t *testing.T) {
+	vfs := NewMemFS()
+	c := makePartitionCatalog(t, vfs, "create table stores (city text, sales int) partition by list (city) (partition west values in ('SF', 'LA'), partition east values in ('NYC', 'Boston'))")
+	runQuery(t, c, "insert into stores values ('SF', 10), ('NYC', 20), ('LA', 30), ('Boston', 40)")
+	if sizes := partitionSizes(t, c, "stores"); sizes[0] != 2 || sizes[1] != 2 {
+		t.Errorf("expected 2 tuples in each partition, got %v", sizes)
+	}
+	results, op := runQuery(t, c, "select sales from stores where city = 'LA'")
+	if scanned := scannedPartitions(t, op); scanned != "west" || strings.Join(results, ",") != "[{30}]" {
+		t.Errorf("expected to find 30 in west, found %v in %s", results, scanned)
+	}
+	if _, _, err := Parse(c, "create table bad (city text) partition by list (city) (partition a values in ('x'), partition b values in ('x'))"); err == nil {
+		t.Errorf("expected a key listed by two partitions to be rejected")
+	}
+
+	// keys that no partition lists can't be inserted
+	_, op, err := Parse(c, "insert into stores values ('Austin', 50)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	iter, _ := op.Iterator(tid)
+	if _, err := iter(); err == nil {
+		t.Errorf("expected a key without a partition to be rejected")
+	}
+	c.bp.AbortTransaction(tid)
+}
+
+func TestHashPartitioning(t *testing.T) {
+	vfs := NewMemFS()
+	c := makePartitionCatalog(t, vfs, "create table people (name text, age int) compression = 'lz' partition by hash (age) partitions 4")
+	runQuery(t, c, "insert into people values ('sam', 25), ('kathy', 45), ('bill', 70), ('ang', 12), ('joe', 40), ('tim', 18), ('amy', 33), ('bob', 51)")
+	total, used := 0, 0
+	for _, n := range partitionSizes(t, c, "people") {
+		total += n
+		if n > 0 {
+			used++
+		}
+	}
+	if total != 8 || used < 2 {
+		t.Errorf("expected 8 tuples spread over the partitions, got %d in %d partitions", total, used)
+	}
+	results, op := runQuery(t, c, "select name from people where age = 45")
+	if scanned := scannedPartitions(t, op); strings.Contains(scanned, ",") || strings.Join(results, ",") != "[{kathy}]" {
+		t.Errorf("expected to find kathy in a single partition, found %v in %s", results, scanned)
+	}
+	if _, op := runQuery(t, c, "select name from people where age > 45"); scannedPartitions(t, op) != "p0,p1,p2,p3" {
+		t.Errorf("expected a range to scan every hash partition")
+	}
+	if _, err := processPartitionDDL(c, "alter table people drop partition p0"); err == nil {
+		t.Errorf("expected dropping a hash partition to fail")
+	}
+}
+
+func TestDropPartition(t *testing.T) {
+	vfs := NewMemFS()
+	c := makePartitionCatalog(t, vfs, "create table events (name text, day int) partition by range (day) (partition d1 values less than (100), partition d2 values less than (200))")
+	runQuery(t, c, "insert into events values ('a', 10), ('b', 20), ('c', 150)")
+	err := c.SaveToFile("catalog.txt", "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !strings.Contains(c.CatalogString(), "partition by range (day) (partition d1 values less than (100), partition d2 values less than (200))") {
+		t.Errorf("expected the catalog to record the partitioning, got %s", c.CatalogString())
+	}
+
+	// add a partition for new keys, and drop the oldest one
+	for _, sql := range []string{
+		"alter table events add partition (partition d3 values less than (300))",
+		"alter table events drop partition d1",
+	} {
+		if qtype, _, err := Parse(c, sql); err != nil || qtype != AlterTableQueryType {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+	if _, err := vfs.Stat(c.partitionNameToFile("events", "d1")); err == nil {
+		t.Errorf("expected the dropped partition's file to be removed")
+	}
+	if _, _, err := Parse(c, "alter table events add partition (partition d0 values less than (50))"); err == nil {
+		t.Errorf("expected a partition below the last bound to be rejected")
+	}
+	if _, _, err := Parse(c, "alter table events drop partition d9"); err == nil {
+		t.Errorf("expected dropping a missing partition to fail")
+	}
+	runQuery(t, c, "insert into events values ('d', 250), ('e', 50)")
+	err = c.SaveToFile("catalog.txt", "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPoolWithVFS(100, vfs), "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	results, _ := runQuery(t, c, "select name from events")
+	if strings.Join(results, ",") != "[{c}],[{d}],[{e}]" {
+		t.Errorf("expected only the tuples of the remaining partitions, got %v", results)
+	}
+	if sizes := partitionSizes(t, c, "events"); sizes[0] != 2 || sizes[1] != 1 {
+		t.Errorf("expected 2 and 1 tuples in d2 and d3, got %v", sizes)
+	}
+	report, err := c.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 || report.Tuples != 3 || report.Tables != 1 {
+		t.Errorf("expected a table of 3 tuples and no problems, got %s", report.String())
+	}
+
+	_, _, err = Parse(c, "drop table events")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if names := vfs.Names(); len(names) != 1 {
+		t.Errorf("expected only the catalog to be left, got %v", names)
+	}
+}
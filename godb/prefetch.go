@@ -0,0 +1,126 @@
+package godb
+
+// Read-ahead.  A sequential scan of a heap file fetches one page at a time
+// with [BufferPool.GetPage], so without help it waits for every read in
+// turn.  With [BufferPool.SetReadAhead], each scan prefetches the pages it is
+// about to reach:  reads of the next pages are issued on background
+// goroutines, which put the pages into the buffer pool, so that by the time
+// the scan asks for them they are usually cached.  [BufferPool.GetPages]
+// fetches a batch of pages the same way.
+//
+// Prefetching doesn't lock pages;  a page is only locked when a transaction
+// gets it with GetPage, as always.  A prefetched page is only cached if the
+// pool doesn't hold it already, and if there is room or a page that isn't
+// dirty to evict, so a prefetch never replaces a page a transaction has
+// changed.  GetPage waits for a page being prefetched rather than reading it
+// again.  Errors are not reported by prefetches:  the page is simply not
+// cached, and the transaction that needs it reads it and gets the error.
+//
+// A prefetch reads its page without holding the pool's lock, so the page may
+// change on disk before the read is cached:  a transaction may commit a new
+// version of it, and that version may be evicted again, or the file may be
+// truncated.  The pool counts the times each page is written or dropped in
+// its generations, and a prefetched page is only cached if the generation of
+// its page is the one it was when the read was issued.
+
+// Set the number of pages that scans of heap files prefetch ahead of the
+// page they are on.  The default is 0, which turns read-ahead off.
+func (bp *BufferPool) SetReadAhead(pages int) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.readAhead = pages
+}
+
+// The distance set with [BufferPool.SetReadAhead]
+func (bp *BufferPool) readAheadPages() int {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.readAhead
+}
+
+// Issue reads of the given pages of file in the background, caching the
+// pages once they are read.  Pages that are cached or already being read are
+// skipped.
+func (bp *BufferPool) prefetch(file DBFile, pageNos []int) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	for _, pageNo := range pageNos {
+		key := file.pageKey(pageNo)
+		if _, ok := bp.mapPage[key]; ok {
+			continue
+		}
+		if _, ok := bp.inFlight[key]; ok {
+			continue
+		}
+		done := make(chan struct{})
+		bp.inFlight[key] = done
+		go bp.readInBackground(file, pageNo, key, done, bp.generations[key])
+	}
+}
+
+// Read a page for prefetch, and cache it unless the page may have changed
+// since the read was issued, i.e., it is no longer of the given generation
+func (bp *BufferPool) readInBackground(file DBFile, pageNo int, key any, done chan struct{}, generation int) {
+	page, err := file.readPage(pageNo)
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	delete(bp.inFlight, key)
+	close(done)
+	if err != nil || bp.generations[key] != generation {
+		return
+	}
+	if _, ok := bp.mapPage[key]; ok {
+		return
+	}
+	if bp.makeRoom() != nil {
+		return
+	}
+	bp.mapPage[key] = page
+}
+
+// Retrieve several pages of file on behalf of tid, as [BufferPool.GetPage]
+// does, in the order given.  The pages that aren't cached are all read
+// concurrently, rather than one after the other.
+func (bp *BufferPool) GetPages(file DBFile, pageNos []int, tid TransactionID, perm RWPerm) ([]*Page, error) {
+	bp.prefetch(file, pageNos)
+	pages := make([]*Page, len(pageNos))
+	for i, pageNo := range pageNos {
+		page, err := bp.GetPage(file, pageNo, tid, perm)
+		if err != nil {
+			return nil, err
+		}
+		pages[i] = page
+	}
+	return pages, nil
+}
+
+// Prefetch the pages a scan of the heap file will read after page pageNo,
+// up to the read-ahead distance of the buffer pool.  next is the first page
+// that hasn't been prefetched yet, which is advanced.  Pages that the zone
+// map shows can't hold tuples satisfying preds are left out, since the scan
+// will skip them.
+func (f *HeapFile) readAhead(pageNo int, numPages int, preds []zonePredicate, next *int) {
+	distance := f.bufPool.readAheadPages()
+	if distance == 0 {
+		return
+	}
+	if *next <= pageNo {
+		*next = pageNo + 1
+	}
+	end := pageNo + distance + 1
+	if end > numPages {
+		end = numPages
+	}
+	var pageNos []int
+	for ; *next < end; *next++ {
+		if len(preds) > 0 {
+			if z := f.zones.read(*next); z != nil && !z.mayMatch(preds) {
+				continue
+			}
+		}
+		pageNos = append(pageNos, *next)
+	}
+	if len(pageNos) > 0 {
+		f.bufPool.prefetch(f, pageNos)
+	}
+}
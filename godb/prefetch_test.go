@@ -0,0 +1,176 @@
+package godb
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Wait for the prefetches issued so far to finish
+func waitForPrefetches(bp *BufferPool) {
+	bp.mu.Lock()
+	var pending []chan struct{}
+	for _, done := range bp.inFlight {
+		pending = append(pending, done)
+	}
+	bp.mu.Unlock()
+	for _, done := range pending {
+		<-done
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars()
+	fileName := filepath.Join(t.TempDir(), "readahead.dat")
+	n := makeScanTestFile(t, fileName, &td, 20)
+
+	bp := NewBufferPool(50)
+	bp.SetReadAhead(4)
+	hf, err := NewHeapFile(fileName, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := hf.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tup, err := iter(); err != nil || tup == nil {
+		t.Fatalf("expected a tuple, got %v", err)
+	}
+	waitForPrefetches(bp)
+	for pageNo := 1; pageNo <= 5; pageNo++ {
+		if cached := bp.isCached(hf, pageNo); cached != (pageNo <= 4) {
+			t.Errorf("expected page %d cached=%v while the scan is on page 0", pageNo, pageNo <= 4)
+		}
+	}
+	count := 1
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		count++
+	}
+	bp.CommitTransaction(tid)
+	if count != n {
+		t.Errorf("expected %d tuples, got %d", n, count)
+	}
+
+	// read-ahead further than the pool holds evicts prefetched pages, but
+	// doesn't break the scan
+	bp = NewBufferPool(3)
+	bp.SetReadAhead(8)
+	hf.bufPool = bp
+	if got := len(collectTuples(t, hf, bp)); got != n {
+		t.Errorf("expected %d tuples with a small pool, got %d", n, got)
+	}
+	waitForPrefetches(bp)
+	hf.close()
+}
+
+func TestGetPages(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars()
+	fileName := filepath.Join(t.TempDir(), "getpages.dat")
+	makeScanTestFile(t, fileName, &td, 10)
+
+	bp := NewBufferPool(20)
+	hf, err := NewHeapFile(fileName, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	pageNos := []int{7, 2, 5, 2}
+	pages, err := bp.GetPages(hf, pageNos, tid, ReadPerm)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i, page := range pages {
+		if hp := (*page).(*heapPage); hp.pageNo != pageNos[i] || hp.usedSlots != hp.numSlots {
+			t.Errorf("expected full page %d, got page %d", pageNos[i], hp.pageNo)
+		}
+	}
+	if pages[1] != pages[3] {
+		t.Errorf("expected page 2 to be read once")
+	}
+	if _, err := bp.GetPages(hf, []int{1, 10}, tid, ReadPerm); err == nil {
+		t.Errorf("expected getting a page past the end of the file to fail")
+	}
+	bp.CommitTransaction(tid)
+	waitForPrefetches(bp)
+	hf.close()
+}
+
+// A heap file whose reads return a page read earlier, as a prefetch that was
+// slow to finish would
+type staleHeapFile struct {
+	*HeapFile
+	page *Page
+}
+
+func (f staleHeapFile) readPage(pageNo int) (*Page, error) {
+	return f.page, nil
+}
+
+func TestPrefetchStalePage(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars()
+	fileName := filepath.Join(t.TempDir(), "stale.dat")
+	n := makeScanTestFile(t, fileName, &td, 3)
+
+	bp := NewBufferPool(2)
+	hf, err := NewHeapFile(fileName, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// a prefetch of page 0 is issued and reads it...
+	stale, err := hf.readPage(0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	key := hf.pageKey(0)
+	bp.mu.Lock()
+	generation := bp.generations[key]
+	bp.mu.Unlock()
+
+	// ...then a transaction changes the page and commits, and the new version
+	// is evicted...
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := hf.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tup, err := iter()
+	if err != nil || tup == nil {
+		t.Fatalf("expected a tuple, got %v", err)
+	}
+	if err := hf.deleteTuple(tup, tid); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := bp.CommitTransaction(tid); err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if _, err := bp.GetPages(hf, []int{1, 2}, tid, ReadPerm); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.CommitTransaction(tid)
+	if bp.isCached(hf, 0) {
+		t.Fatalf("expected page 0 to be evicted")
+	}
+
+	// ...before the prefetch finishes, which must not cache what it read
+	bp.readInBackground(staleHeapFile{hf, stale}, 0, key, make(chan struct{}), generation)
+	if bp.isCached(hf, 0) {
+		t.Errorf("expected the stale page not to be cached")
+	}
+	if got := len(collectTuples(t, hf, bp)); got != n-1 {
+		t.Errorf("expected %d tuples after the delete, got %d", n-1, got)
+	}
+	hf.close()
+}
@@ -0,0 +1,94 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync/atomic"
+)
+
+// A temporary table, made with CREATE TEMPORARY TABLE (or CREATE TEMP
+// TABLE), lasts only as long as the session that created it:  the Catalog it
+// was created in, which the shell keeps open until it exits, and which a
+// program using GoDB closes with [Catalog.Close].  Its files, and those of its
+// indexes, live in a scratch directory of the session under the catalog's
+// root, which is made when the first temporary table is created.
+//
+// Temporary tables are never written to the catalog file, and since nothing
+// about them has to survive a crash, their files are never synced.  A session
+// that crashes leaves its scratch directory behind, which can be deleted.
+
+var temporaryKeyword = regexp.MustCompile(`(?i)^(\s*create\s+)temp(orary)?\s+(table\s)`)
+
+// Number of sessions opened by this process so far, to name their scratch
+// directories
+var sessionCount int64
+
+// Remove the TEMPORARY keyword, which the SQL parser doesn't understand, from
+// a CREATE TEMPORARY TABLE statement.  Reports whether it was there.
+func splitTemporary(query string) (string, bool) {
+	if !temporaryKeyword.MatchString(query) {
+		return query, false
+	}
+	return temporaryKeyword.ReplaceAllString(query, "$1$3"), true
+}
+
+// Return the directory that holds the files of the named table:  the scratch
+// directory of the session for a temporary table, and the catalog's root
+// otherwise
+func (c *Catalog) tableDir(tableName string) string {
+	if t := c.tableMap[tableName]; t != nil && t.temporary {
+		return c.tempDir
+	}
+	return c.rootPath
+}
+
+// Make the scratch directory of the session, if it doesn't exist yet
+func (c *Catalog) makeTempDir() error {
+	if c.tempDir != "" {
+		return nil
+	}
+	dir := fmt.Sprintf("%s/session%d.%d.tmp", c.rootPath, os.Getpid(), atomic.AddInt64(&sessionCount, 1))
+	err := c.bp.vfs.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	c.tempDir = dir
+	return nil
+}
+
+// End the session of the catalog:  drop its temporary tables, close the
+// files of its other tables, dropping their cached pages from the buffer
+// pool, and remove its scratch directory.  The catalog's other tables are
+// left alone, and it may still be used afterwards, opening their files again.
+// The catalog can't be closed while a transaction is open.
+func (c *Catalog) Close() error {
+	err := c.bp.checkNoTransactions("closing the catalog")
+	if err != nil {
+		return err
+	}
+	var temporary []string
+	for _, t := range c.tables {
+		if t.temporary {
+			temporary = append(temporary, t.name)
+		}
+	}
+	for _, name := range temporary {
+		err = c.dropTable(name)
+		if err != nil {
+			return err
+		}
+	}
+	for name := range c.files {
+		c.closeTable(name)
+	}
+	if c.tempDir == "" {
+		return nil
+	}
+	err = c.bp.vfs.Remove(c.tempDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.tempDir = ""
+	return nil
+}
@@ -0,0 +1,84 @@
+package godb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemporaryTable(t *testing.T) {
+	vfs := NewMemFS()
+	c := makePartitionCatalog(t, vfs,
+		"create table people (name text, age int)",
+		"create temporary table scratch (name text, age int)",
+		"create temp table pets (name text, age int) partition by hash (age) partitions 2",
+		"create index scratch_age on scratch (age)")
+	runQuery(t, c, "insert into scratch values ('sam', 25), ('kathy', 45), ('bill', 30)")
+	runQuery(t, c, "insert into pets values ('rex', 3), ('tom', 4)")
+	results, _ := runQuery(t, c, "select name from scratch where age > 26")
+	if strings.Join(results, ",") != "[{bill}],[{kathy}]" {
+		t.Errorf("expected bill and kathy, got %v", results)
+	}
+	if c.tempDir == "" || !strings.HasPrefix(c.tableNameToFile("scratch"), c.tempDir+"/") ||
+		!strings.HasPrefix(c.indexNameToFile("scratch_age"), c.tempDir+"/") ||
+		!strings.HasPrefix(c.partitionNameToFile("pets", "p0"), c.tempDir+"/") {
+		t.Errorf("expected the files of temporary tables in the scratch directory %s", c.tempDir)
+	}
+	if !strings.HasPrefix(c.tableNameToFile("people"), "db/people") {
+		t.Errorf("expected the files of other tables in the catalog's root")
+	}
+	if !strings.Contains(c.CatalogString(), "temporary scratch (name string, age int)") {
+		t.Errorf("expected the catalog to show the temporary table, got %s", c.CatalogString())
+	}
+
+	err := c.SaveToFile("catalog.txt", "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	saved, _ := readVFSFile(vfs, "db/catalog.txt")
+	if string(saved) != "people (name string, age int)\n" {
+		t.Errorf("expected only people in the catalog file, got %s", saved)
+	}
+
+	// the files of temporary tables are never synced
+	runQuery(t, c, "insert into people values ('ang', 22)")
+	for table, synced := range map[string]bool{"people": true, "scratch": false} {
+		if f := vfs.files[c.tableNameToFile(table)]; (f.synced != nil) != synced {
+			t.Errorf("expected the file of %s synced=%v", table, synced)
+		}
+	}
+
+	tempDir := c.tempDir
+	err = c.Close()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := c.GetTable("scratch"); err == nil {
+		t.Errorf("expected scratch to be dropped when the session ends")
+	}
+	if c.findIndex("scratch_age") != nil {
+		t.Errorf("expected the index of scratch to be dropped")
+	}
+	for _, name := range vfs.Names() {
+		if strings.HasPrefix(name, tempDir) {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	if results, _ := runQuery(t, c, "select name from people"); len(results) != 1 {
+		t.Errorf("expected people to be left alone, got %v", results)
+	}
+
+	// a new session has a scratch directory of its own
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPoolWithVFS(100, vfs), "db")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if c.NumTables() != 1 {
+		t.Errorf("expected only people after reopening, got %s", c.CatalogString())
+	}
+	if _, _, err := Parse(c, "create temporary table scratch (name text)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if c.tempDir == tempDir {
+		t.Errorf("expected another scratch directory than %s", tempDir)
+	}
+}
@@ -0,0 +1,385 @@
+package godb
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/exp/slices"
+)
+
+// Full-text search.  MATCH(column, 'terms') is true of the tuples whose
+// column contains at least one of the terms, where text is compared term by
+// term after splitting it into lowercase words (see [tokenize]).  The planner
+// answers MATCH predicates with a [TextSearch], which returns the matching
+// tuples most relevant first, using a [TextFile] index on the column if there
+// is one.
+
+// Split text into its terms:  maximal runs of letters and digits, in lower
+// case
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Return the distinct terms of text, in the order they first appear
+func distinctTerms(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, term := range tokenize(text) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Whether text contains any of the terms of query
+func matchesTerms(text string, query string) bool {
+	terms := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		terms[term] = true
+	}
+	for _, term := range tokenize(text) {
+		if terms[term] {
+			return true
+		}
+	}
+	return false
+}
+
+// The SQL parser only knows MySQL's MATCH (column) AGAINST ('terms') form,
+// so the shorter MATCH(column, 'terms') is rewritten to it before parsing
+var matchCallRegexp = regexp.MustCompile(`(?i)\bmatch\s*\(\s*([\w.]+)\s*,\s*('(?:[^']|'')*')\s*\)`)
+
+func rewriteMatchCalls(query string) string {
+	return matchCallRegexp.ReplaceAllString(query, "match($1) against ($2)")
+}
+
+// TextFile is a full-text index over a string column of a HeapFile:  an
+// inverted index that maps each term to the record ids of the tuples whose
+// column contains it.  The postings are kept in a [HashFile] keyed by term,
+// with one entry per distinct term of each tuple, and kept up to date as the
+// heap file inserts and deletes tuples.
+//
+// As an Operator, a TextFile returns one tuple per posting, with the term
+// followed by the page and slot of the tuple's record id.
+type TextFile struct {
+	name     string // name of the index in the catalog
+	keyIndex int    // position of the indexed column in the heap file's tuples
+	terms    *HashFile
+}
+
+// Open a TextFile, creating an empty index if the file doesn't exist yet.
+// Parameters
+// - fromFile: backing file for the index
+// - keyField: the indexed column of the heap file, which must be a string
+// - keyIndex: the position of keyField in the heap file's TupleDesc
+// - bp: the BufferPool that is used to store pages read from the index
+func NewTextFile(fromFile string, keyField FieldType, keyIndex int, bp *BufferPool) (*TextFile, error) {
+	return openTextFile(fromFile, keyField, keyIndex, nil, bp)
+}
+
+// Open a TextFile whose pages are encrypted with cipher, unless it is nil
+func openTextFile(fromFile string, keyField FieldType, keyIndex int, cipher *pageCipher, bp *BufferPool) (*TextFile, error) {
+	if keyField.Ftype != StringType {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("can't build a full-text index on %s, which is not a string column", keyField.Fname)}
+	}
+	terms, err := openHashFile(fromFile, FieldType{Fname: "term", Ftype: StringType}, 0, cipher, bp)
+	if err != nil {
+		return nil, err
+	}
+	return &TextFile{name: fromFile, keyIndex: keyIndex, terms: terms}, nil
+}
+
+func (f *TextFile) NumPages() int {
+	return f.terms.NumPages()
+}
+
+func (f *TextFile) readPage(pageNo int) (*Page, error) {
+	return f.terms.readPage(pageNo)
+}
+
+func (f *TextFile) flushPage(p *Page) error {
+	return f.terms.flushPage(p)
+}
+
+func (f *TextFile) pageKey(pgNo int) any {
+	return f.terms.pageKey(pgNo)
+}
+
+func (f *TextFile) sync() error {
+	return f.terms.sync()
+}
+
+func (f *TextFile) close() error {
+	return f.terms.close()
+}
+
+func (f *TextFile) Descriptor() *TupleDesc {
+	return f.terms.Descriptor()
+}
+
+func (f *TextFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return f.terms.Iterator(tid)
+}
+
+func (f *TextFile) clear(tid TransactionID) error {
+	return f.terms.clear(tid)
+}
+
+// Return the postings of the terms of t, a tuple of the indexed heap file
+func (f *TextFile) postingsForTuple(t *Tuple) ([]indexEntry, error) {
+	e, err := entryForTuple(t, f.keyIndex)
+	if err != nil {
+		return nil, err
+	}
+	text, ok := e.key.(StringField)
+	if !ok {
+		return nil, GoDBError{TypeMismatchError, "full-text indexes need a string column"}
+	}
+	var postings []indexEntry
+	for _, term := range distinctTerms(text.Value) {
+		postings = append(postings, indexEntry{StringField{term}, e.rid})
+	}
+	return postings, nil
+}
+
+// Add a posting for each distinct term of t to the index
+func (f *TextFile) insertTuple(t *Tuple, tid TransactionID) error {
+	postings, err := f.postingsForTuple(t)
+	if err != nil {
+		return err
+	}
+	for _, e := range postings {
+		err := f.terms.insertEntry(e, tid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove the postings of the terms of t from the index
+func (f *TextFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	postings, err := f.postingsForTuple(t)
+	if err != nil {
+		return err
+	}
+	for _, e := range postings {
+		err := f.terms.deleteEntry(e, tid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the record ids of the tuples that contain term, in record id order
+func (f *TextFile) postings(term string, tid TransactionID) ([]heapFileRID, error) {
+	return f.terms.lookup(StringField{term}, tid)
+}
+
+// TextSearch returns the tuples of its child that match a MATCH predicate,
+// most relevant first.  Each tuple is scored by tf-idf:  the sum over the
+// query terms of the number of times the term occurs in the tuple's text,
+// weighted by log(1 + N/df), where N is the number of tuples and df the
+// number of them that contain the term, so that rare terms count for more.
+// Tuples with equal scores are returned in the order of the child.
+//
+// If index is not nil, the child must be its heap file, and only the tuples
+// listed in the postings of the query terms are read;  N is then the
+// estimated size of the heap file.  Otherwise every tuple of the child is
+// read.  Either way, the search has to see every matching tuple before it
+// returns the first.
+type TextSearch struct {
+	child Operator
+	field Expr
+	query string
+	index *TextFile
+}
+
+// Construct a TextSearch for the tuples of child whose field matches the
+// terms of query, using index if it is not nil.
+func NewTextSearch(child Operator, field Expr, query string, index *TextFile) (*TextSearch, error) {
+	if field.GetExprType().Ftype != StringType {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("can't MATCH against %s, which is not a string", describeExpr(field))}
+	}
+	if index != nil {
+		if _, ok := child.(*HeapFile); !ok {
+			return nil, GoDBError{IllegalOperationError, "a full-text index can only search its heap file"}
+		}
+	}
+	return &TextSearch{child, field, query, index}, nil
+}
+
+func (s *TextSearch) Descriptor() *TupleDesc {
+	return s.child.Descriptor()
+}
+
+// A tuple matched by a TextSearch, with the number of times each query term
+// occurs in its text
+type textMatch struct {
+	tuple *Tuple
+	tf    map[string]int
+	score float64
+}
+
+// Count the occurrences of the query terms in the text of t.  Returns nil if
+// t contains none of them.
+func (s *TextSearch) termCounts(t *Tuple, terms []string) (map[string]int, error) {
+	v, err := s.field.EvalExpr(t)
+	if err != nil {
+		return nil, err
+	}
+	text, ok := v.(StringField)
+	if !ok {
+		return nil, GoDBError{TypeMismatchError, "MATCH needs a string"}
+	}
+	var tf map[string]int
+	for _, term := range tokenize(text.Value) {
+		if slices.Contains(terms, term) {
+			if tf == nil {
+				tf = make(map[string]int)
+			}
+			tf[term]++
+		}
+	}
+	return tf, nil
+}
+
+// Find the matching tuples, and the number of tuples searched
+func (s *TextSearch) matches(terms []string, tid TransactionID) ([]*textMatch, float64, error) {
+	var matches []*textMatch
+	add := func(t *Tuple) error {
+		tf, err := s.termCounts(t, terms)
+		if err != nil || tf == nil {
+			return err
+		}
+		matches = append(matches, &textMatch{tuple: t, tf: tf})
+		return nil
+	}
+	if s.index == nil {
+		iter, err := s.child.Iterator(tid)
+		if err != nil {
+			return nil, 0, err
+		}
+		n := 0
+		for {
+			t, err := iter()
+			if err != nil {
+				return nil, 0, err
+			}
+			if t == nil {
+				return matches, float64(n), nil
+			}
+			n++
+			err = add(t)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	heap := s.child.(*HeapFile)
+	var rids []heapFileRID
+	seen := make(map[heapFileRID]bool)
+	for _, term := range terms {
+		postings, err := s.index.postings(term, tid)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, rid := range postings {
+			if !seen[rid] {
+				seen[rid] = true
+				rids = append(rids, rid)
+			}
+		}
+	}
+	sort.Slice(rids, func(i, j int) bool {
+		return rids[i].pageNum < rids[j].pageNum || (rids[i].pageNum == rids[j].pageNum && rids[i].slotNum < rids[j].slotNum)
+	})
+	for _, rid := range rids {
+		page, err := heap.bufPool.GetPage(heap, rid.pageNum, tid, ReadPerm)
+		if err != nil {
+			return nil, 0, err
+		}
+		hp := (*page).(*heapPage)
+		if rid.slotNum >= len(hp.slots) || hp.slots[rid.slotNum] == nil {
+			return nil, 0, GoDBError{TupleNotFoundError, fmt.Sprintf("full-text index %s has a posting for an empty slot %v", s.index.name, rid)}
+		}
+		t := hp.slots[rid.slotNum]
+		t.Rid = rid
+		err = add(t)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	n := estimateRows(heap)
+	if n < float64(len(rids)) {
+		n = float64(len(rids))
+	}
+	return matches, n, nil
+}
+
+// Return an iterator over the matching tuples, most relevant first
+func (s *TextSearch) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	terms := distinctTerms(s.query)
+	matches, n, err := s.matches(terms, tid)
+	if err != nil {
+		return nil, err
+	}
+	df := make(map[string]int)
+	for _, m := range matches {
+		for term := range m.tf {
+			df[term]++
+		}
+	}
+	for _, m := range matches {
+		for term, count := range m.tf {
+			m.score += float64(count) * math.Log(1+n/float64(df[term]))
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	return func() (*Tuple, error) {
+		if len(matches) == 0 {
+			return nil, nil
+		}
+		t := matches[0].tuple
+		matches = matches[1:]
+		return t, nil
+	}, nil
+}
+
+// Plan MATCH(field, constExpr) over op as a TextSearch, using a full-text
+// index on the column if op is a heap file that has one
+func planTextSearch(op Operator, field Expr, constExpr Expr) (Operator, error) {
+	field, constExpr, err := coerceComparison(field, OpMatch, constExpr)
+	if err != nil {
+		return nil, err
+	}
+	constant, ok := constExpr.(*ConstExpr)
+	if !ok {
+		return nil, GoDBError{ParseError, "MATCH needs a constant string of terms"}
+	}
+	query, ok := constant.val.(StringField)
+	if !ok {
+		return nil, GoDBError{TypeMismatchError, "MATCH needs a constant string of terms"}
+	}
+	if hf, ok := op.(*HeapFile); ok {
+		if fieldExpr, ok := field.(*FieldExpr); ok {
+			for _, index := range hf.indexes {
+				index, ok := index.(*TextFile)
+				if ok && hf.desc.Fields[index.keyIndex].Fname == fieldExpr.selectField.Fname {
+					return NewTextSearch(hf, field, query.Value, index)
+				}
+			}
+		}
+	}
+	return NewTextSearch(op, field, query.Value, nil)
+}
@@ -0,0 +1,86 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	terms := tokenize("The quick, brown FOX -- isn't 42nd!")
+	expected := []string{"the", "quick", "brown", "fox", "isn", "t", "42nd"}
+	if strings.Join(terms, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v, got %v", expected, terms)
+	}
+	if terms := distinctTerms("dog Dog cat dog"); strings.Join(terms, " ") != "dog cat" {
+		t.Errorf("expected [dog cat], got %v", terms)
+	}
+	if !matchesTerms("A lazy dog", "cat DOG") || matchesTerms("A lazy dog", "dogs") {
+		t.Errorf("matchesTerms should compare whole terms, ignoring case")
+	}
+
+	rewritten := rewriteMatchCalls("select id from docs where MATCH(docs.body, 'it''s here') and match (x) against ('y')")
+	if rewritten != "select id from docs where match(docs.body) against ('it''s here') and match (x) against ('y')" {
+		t.Errorf("unexpected rewrite %s", rewritten)
+	}
+}
+
+func TestTextSearchRanking(t *testing.T) {
+	bp := NewBufferPool(100)
+	hf, file := makeIndexTestVars(t, bp, FullTextIndex)
+	idx := file.(*TextFile)
+	docs := []string{
+		"the quick brown fox",
+		"a lazy dog",
+		"fox fox FOX",
+		"the fox and the dog",
+		"fox in a box",
+		"nothing to see here",
+		"another fox",
+	}
+	// unrelated tuples, so that fox is a common term but not in most tuples
+	for len(docs) < 40 {
+		docs = append(docs, "filler text")
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i, doc := range docs {
+		tup := Tuple{*hf.Descriptor(), []DBValue{StringField{doc}, IntField{int64(i + 1)}}, nil}
+		err := hf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.CommitTransaction(tid)
+
+	// most relevant first:  a term that occurs more often counts for more
+	// than one that occurs once, and a rare term for more than a common one.
+	// Tuples with equal scores keep the order of the heap file.
+	queries := []struct {
+		query    string
+		expected string
+	}{
+		{"fox", "3,1,4,5,7"},
+		{"dog", "2,4"},
+		{"dog fox", "3,4,2,1,5,7"},
+		{"DOG Fox fox", "3,4,2,1,5,7"},
+		{"lazy", "2"},
+		{"cat", ""},
+	}
+	name := &FieldExpr{hf.Descriptor().Fields[0]}
+	for _, index := range []*TextFile{nil, idx} {
+		for _, q := range queries {
+			search, err := NewTextSearch(hf, name, q.query, index)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			var ids []string
+			for _, tup := range collectTuples(t, search, bp) {
+				ids = append(ids, fmt.Sprint(tup.Fields[1].(IntField).Value))
+			}
+			if strings.Join(ids, ",") != q.expected {
+				t.Errorf("query=%s, indexed=%v: expected %s, got %s", q.query, index != nil, q.expected, strings.Join(ids, ","))
+			}
+		}
+	}
+}
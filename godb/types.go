@@ -25,6 +25,9 @@ const (
 	DeadlockError           GoDBErrorCode = iota
 	IllegalTransactionError GoDBErrorCode = iota
 	IllegalIdxError         GoDBErrorCode = iota
+	CorruptPageError        GoDBErrorCode = iota
+	TransactionFailedError  GoDBErrorCode = iota
+	UnsupportedFormatError  GoDBErrorCode = iota
 )
 
 type GoDBError struct {
@@ -41,6 +44,13 @@ const (
 	StringLength int = 32
 )
 
+// The range of page sizes a catalog's heap tables may use instead of
+// PageSize (see [CreateCatalog]).  Every other file uses PageSize pages.
+const (
+	MinPageSize int = 4096
+	MaxPageSize int = 65536
+)
+
 type Page interface {
 	//these methods are used by buffer pool to
 	//manage pages
@@ -57,6 +67,8 @@ type DBFile interface {
 	readPage(pageNo int) (*Page, error)
 	flushPage(page *Page) error
 	pageKey(pgNo int) any //uint64
+	// make every page flushed so far durable
+	sync() error
 
 	Operator
 }
@@ -76,6 +88,9 @@ const (
 	OpEq   BoolOp = iota
 	OpNeq  BoolOp = iota
 	OpLike BoolOp = iota
+	// MATCH(field, 'terms'):  whether the text of field contains any of the
+	// terms (see text_index.go)
+	OpMatch BoolOp = iota
 )
 
 var BoolOpMap = map[string]BoolOp{
@@ -116,6 +131,16 @@ func evalPred[T constraints.Ordered](i1 T, i2 T, op BoolOp) bool {
 		regex = strings.Replace(regex, "%", ".*?", -1)
 		match, _ := regexp.MatchString(regex, s1)
 		return match
+	case OpMatch:
+		text, ok := any(i1).(string)
+		if !ok {
+			return false
+		}
+		query, ok := any(i2).(string)
+		if !ok {
+			return false
+		}
+		return matchesTerms(text, query)
 	}
 	return false
 
@@ -0,0 +1,343 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VACUUM reclaims the space left behind by deleted tuples.  Tuples keep their
+// slot on disk (see [heapPage.toBuffer]), so a deleted tuple leaves a hole in
+// its page that later inserts fill;  what VACUUM adds is moving tuples off
+// sparse pages at the end of the file so that the file itself can shrink.
+// The index entries of moved tuples are updated to their new record ids.
+// Tuples are moved in the buffer pool like any other change, so the moves
+// are undone if the transaction aborts;  the pages they leave empty are cut
+// off in a second transaction, once the first has committed.
+//
+// VACUUM FULL instead writes every tuple of the table densely into a new
+// file, while holding write locks on all of its pages, puts the new file in
+// place of the old one with [replaceHeapFile], and then rebuilds the table's
+// indexes.
+
+// Move tuples from the trailing pages of the heap file into free slots on
+// earlier pages.  Returns the number of pages left empty, which
+// [HeapFile.truncateEmptyPages] can remove once tid has committed.
+func (f *HeapFile) vacuum(tid TransactionID) (int, error) {
+	numPages := f.NumPages()
+	newNumPages := numPages
+	for newNumPages > 0 {
+		last := newNumPages - 1
+		page, err := f.bufPool.GetPage(f, last, tid, WritePerm)
+		if err != nil {
+			return 0, err
+		}
+		hp := (*page).(*heapPage)
+		for slot, t := range hp.slots {
+			if t == nil {
+				continue
+			}
+			moved, err := f.insertIntoFreePage(t, last, tid)
+			if err != nil {
+				return 0, err
+			}
+			if !moved {
+				break
+			}
+			f.Lock()
+			err = hp.deleteTuple(heapFileRID{last, slot})
+			f.Unlock()
+			if err != nil {
+				return 0, err
+			}
+			hp.setDirty(true)
+			err = f.removeFromIndexes(t, heapFileRID{last, slot}, tid)
+			if err != nil {
+				return 0, err
+			}
+			err = f.addToIndexes(t, tid)
+			if err != nil {
+				return 0, err
+			}
+		}
+		f.fsm.update(last, hp.numSlots-hp.usedSlots)
+		if hp.usedSlots > 0 {
+			break
+		}
+		newNumPages--
+	}
+	return numPages - newNumPages, nil
+}
+
+// Truncate the empty pages at the end of the heap file, after write locking
+// them.  Only committed pages are empty on disk, so this must not run in the
+// transaction that emptied them.  Returns the number of pages removed.
+func (f *HeapFile) truncateEmptyPages(tid TransactionID) (int, error) {
+	numPages := f.NumPages()
+	newNumPages := numPages
+	for newNumPages > 0 {
+		page, err := f.bufPool.GetPage(f, newNumPages-1, tid, WritePerm)
+		if err != nil {
+			return 0, err
+		}
+		if (*page).(*heapPage).usedSlots > 0 {
+			break
+		}
+		newNumPages--
+	}
+	if newNumPages == numPages {
+		return 0, nil
+	}
+	err := f.shrink(newNumPages, numPages)
+	if err != nil {
+		return 0, err
+	}
+	return numPages - newNumPages, nil
+}
+
+// Rewrite the heap file so that its tuples are packed into as few pages as
+// possible.  Every page is write locked before anything is moved, so no other
+// transaction can see the table while it is being rewritten.  Returns the
+// number of pages removed.
+func (f *HeapFile) vacuumFull(tid TransactionID) (int, error) {
+	numPages := f.NumPages()
+	for pageNo := 0; pageNo < numPages; pageNo++ {
+		_, err := f.bufPool.GetPage(f, pageNo, tid, WritePerm)
+		if err != nil {
+			return 0, err
+		}
+	}
+	codec := ""
+	if f.pages != nil {
+		codec = f.pages.codec.name
+	}
+
+	newNumPages := 0
+	err := replaceHeapFile(f.bufPool.vfs, f.fileName, func(newName string) error {
+		packed, err := openHeapFile(newName, f.desc, f.pageSize, codec, f.cipher, f.bufPool)
+		if err != nil {
+			return err
+		}
+		defer packed.close()
+		packer := newHeapFilePacker(packed)
+		for pageNo := 0; pageNo < numPages; pageNo++ {
+			page, err := f.bufPool.GetPage(f, pageNo, tid, WritePerm)
+			if err != nil {
+				return err
+			}
+			for _, t := range (*page).(*heapPage).slots {
+				if t == nil {
+					continue
+				}
+				err = packer.add(t)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		newNumPages, err = packer.finish()
+		if err != nil {
+			return err
+		}
+		if f.NumPages() != numPages {
+			// a page appended since the pages were locked may hold tuples
+			// that the new file doesn't
+			return GoDBError{TransactionFailedError, fmt.Sprintf("%s grew during VACUUM FULL", f.fileName)}
+		}
+		return packed.sync()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// every cached page of the file is now stale, and the files are reopened
+	// to read the new ones
+	f.bufPool.discardFilePages(f, 0, numPages)
+	err = f.reopen(codec)
+	if err != nil {
+		return 0, err
+	}
+	err = f.rebuildIndexes(tid)
+	if err != nil {
+		return 0, err
+	}
+	return numPages - newNumPages, nil
+}
+
+// Writes tuples to the pages of a new heap file in order, filling each page
+// before starting the next, without going through the buffer pool
+type heapFilePacker struct {
+	file *HeapFile
+	page *heapPage
+}
+
+func newHeapFilePacker(file *HeapFile) *heapFilePacker {
+	return &heapFilePacker{file, newHeapPage(file.desc, 0, file)}
+}
+
+// Add a copy of t to the file
+func (p *heapFilePacker) add(t *Tuple) error {
+	if p.page.usedSlots == p.page.numSlots {
+		page := Page(p.page)
+		err := p.file.flushPage(&page)
+		if err != nil {
+			return err
+		}
+		p.page = newHeapPage(p.file.desc, p.page.pageNo+1, p.file)
+	}
+	_, err := p.page.insertTuple(&Tuple{t.Desc, t.Fields, nil})
+	return err
+}
+
+// Write the last page, and return the number of pages written
+func (p *heapFilePacker) finish() (int, error) {
+	if p.page.usedSlots == 0 {
+		return p.page.pageNo, nil
+	}
+	page := Page(p.page)
+	return p.page.pageNo + 1, p.file.flushPage(&page)
+}
+
+// Close the files of the heap file and open them again, after they were
+// replaced
+func (f *HeapFile) reopen(codec string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.close()
+	g, err := openHeapFile(f.fileName, f.desc, f.pageSize, codec, f.cipher, f.bufPool)
+	if err != nil {
+		return err
+	}
+	f.file, f.fsm, f.zones, f.pages, f.version, f.mapping = g.file, g.fsm, g.zones, g.pages, g.version, g.mapping
+	return nil
+}
+
+// Remove pages [newNumPages, oldNumPages) from the end of the heap file.  The
+// caller must hold write locks on those pages.  If the file grew in the
+// meantime (another transaction appended a page), the pages can't be cut off,
+// so they are overwritten with empty pages instead.
+func (f *HeapFile) shrink(newNumPages int, oldNumPages int) error {
+	f.bufPool.discardFilePages(f, newNumPages, oldNumPages)
+	if f.NumPages() != oldNumPages {
+		for pageNo := newNumPages; pageNo < oldNumPages; pageNo++ {
+			p := Page(newHeapPage(f.desc, pageNo, f))
+			err := f.flushPage(&p)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// reads of the mapping past the new end of the file would fault
+	f.mapping.release()
+	var err error
+	if f.pages != nil {
+		err = f.pages.truncate(f.file, newNumPages)
+	} else {
+		err = truncateFilePages(f.file, f.cipher, f.pageSize, newNumPages+f.firstSlot())
+	}
+	if err != nil {
+		return err
+	}
+	err = f.zones.truncate(newNumPages)
+	if err != nil {
+		return err
+	}
+	return f.fsm.truncate(newNumPages)
+}
+
+// Run fn in a transaction of its own, which is committed if fn succeeds and
+// aborted otherwise
+func (bp *BufferPool) runTransaction(fn func(tid TransactionID) (int, error)) (int, error) {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	n, err := fn(tid)
+	if err != nil {
+		bp.AbortTransaction(tid)
+		return 0, err
+	}
+	err = bp.CommitTransaction(tid)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Vacuum the heap file, in transactions of its own.  Returns the number of
+// pages removed.
+func (f *HeapFile) vacuumFile(full bool) (int, error) {
+	if full {
+		return f.bufPool.runTransaction(f.vacuumFull)
+	}
+	emptied, err := f.bufPool.runTransaction(f.vacuum)
+	if err != nil || emptied == 0 {
+		return 0, err
+	}
+	return f.bufPool.runTransaction(f.truncateEmptyPages)
+}
+
+// Vacuum the named table, or every table in the catalog if table is empty.
+// Each heap file, i.e., each table or each partition of a partitioned table,
+// is vacuumed on its own.  Returns the total number of pages removed.  VACUUM
+// can't run while a transaction is open.
+func (c *Catalog) Vacuum(table string, full bool) (int, error) {
+	err := c.bp.checkNoTransactions("VACUUM")
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	if table == "" {
+		for _, t := range c.tables {
+			names = append(names, t.name)
+		}
+	} else {
+		names = []string{table}
+	}
+	removed := 0
+	for _, name := range names {
+		file, err := c.GetTable(name)
+		if err != nil {
+			return removed, err
+		}
+		for _, hf := range heapFiles(file) {
+			n, err := hf.vacuumFile(full)
+			if err != nil {
+				return removed, err
+			}
+			removed += n
+		}
+	}
+	return removed, nil
+}
+
+// Split a statement into lower case words, dropping semicolons
+func vacuumWords(query string) []string {
+	return strings.Fields(strings.ToLower(strings.ReplaceAll(query, ";", " ")))
+}
+
+// Parse and run a VACUUM [FULL] [table] statement, which the SQL parser
+// doesn't understand.
+func processVacuum(c *Catalog, query string) (QueryType, error) {
+	words := vacuumWords(query)
+	if len(words) == 0 || words[0] != "vacuum" {
+		return UnknownQueryType, GoDBError{ParseError, "expected VACUUM statement"}
+	}
+	words = words[1:]
+	full := false
+	if len(words) > 0 && words[0] == "full" {
+		full = true
+		words = words[1:]
+	}
+	table := ""
+	if len(words) > 0 {
+		table = words[0]
+		words = words[1:]
+	}
+	if len(words) > 0 {
+		return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("unexpected '%s' in VACUUM statement", strings.Join(words, " "))}
+	}
+	_, err := c.Vacuum(table, full)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	return VacuumQueryType, nil
+}
@@ -0,0 +1,178 @@
+package godb
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// delete the tuples of hf for which del returns true, in a committed transaction
+func deleteWhere(t *testing.T, hf *HeapFile, bp *BufferPool, del func(n int, rid heapFileRID) bool) int {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ := hf.Iterator(tid)
+	var victims []*Tuple
+	for n := 0; ; n++ {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		if del(n, tup.Rid.(heapFileRID)) {
+			victims = append(victims, tup)
+		}
+	}
+	for _, tup := range victims {
+		err := hf.deleteTuple(tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.CommitTransaction(tid)
+	return len(victims)
+}
+
+func countTuples(t *testing.T, hf *HeapFile, bp *BufferPool) int {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	defer bp.CommitTransaction(tid)
+	iter, _ := hf.Iterator(tid)
+	cnt := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			return cnt
+		}
+		cnt++
+	}
+}
+
+func TestVacuumTruncatesTrailingPages(t *testing.T) {
+	_, t1, _, hf, bp, _ := makeTestVars()
+	fillThreePages(t, hf, bp, &t1)
+	total := countTuples(t, hf, bp)
+
+	// leave a few tuples on the last two pages, and make room for them on the first
+	deleted := deleteWhere(t, hf, bp, func(n int, rid heapFileRID) bool {
+		return rid.slotNum >= 3
+	})
+
+	// the moves are undone by an abort
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	emptied, err := hf.vacuum(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if emptied != 2 {
+		t.Errorf("expected vacuum to empty 2 pages, emptied %d", emptied)
+	}
+	bp.AbortTransaction(tid)
+	if cnt := countTuples(t, hf, bp); hf.NumPages() != 3 || cnt != total-deleted {
+		t.Errorf("expected the aborted vacuum to leave 3 pages and %d tuples, got %d pages and %d tuples", total-deleted, hf.NumPages(), cnt)
+	}
+
+	removed, err := hf.vacuumFile(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if removed != 2 || hf.NumPages() != 1 {
+		t.Errorf("expected vacuum to remove 2 pages, removed %d, %d pages left", removed, hf.NumPages())
+	}
+	if cnt := countTuples(t, hf, bp); cnt != total-deleted {
+		t.Errorf("expected %d tuples after vacuum, got %d", total-deleted, cnt)
+	}
+}
+
+func TestVacuumFull(t *testing.T) {
+	td, t1, _, hf, bp, _ := makeTestVars()
+	fillThreePages(t, hf, bp, &t1)
+	total := countTuples(t, hf, bp)
+	deleted := deleteWhere(t, hf, bp, func(n int, rid heapFileRID) bool {
+		return n%2 == 0
+	})
+
+	removed, err := hf.vacuumFile(true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, name := range []string{replacementFileName(hf.fileName), partialReplacementFileName(hf.fileName)} {
+		if _, err := os.Stat(name); err == nil {
+			t.Errorf("expected %s to be gone after vacuum full", name)
+		}
+	}
+
+	slots := calNumSlot(&td, PageSize)
+	expectedPages := (total - deleted + slots - 1) / slots
+	if hf.NumPages() != expectedPages || removed != 3-expectedPages {
+		t.Errorf("expected %d pages after vacuum full, got %d (removed %d)", expectedPages, hf.NumPages(), removed)
+	}
+	if cnt := countTuples(t, hf, bp); cnt != total-deleted {
+		t.Errorf("expected %d tuples after vacuum full, got %d", total-deleted, cnt)
+	}
+}
+
+func TestParseVacuum(t *testing.T) {
+	bp := NewBufferPool(10)
+	err := MakeTestDatabaseEasy(bp)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+	if err != nil {
+		t.Fatalf("failed load catalog, %s", err.Error())
+	}
+	for _, sql := range []string{"vacuum", "VACUUM t", "vacuum full t2;"} {
+		qType, _, err := Parse(c, sql)
+		if err != nil {
+			t.Fatalf("failed to parse, q=%s, %s", sql, err.Error())
+		}
+		if qType != VacuumQueryType {
+			t.Errorf("q=%s, expected VacuumQueryType", sql)
+		}
+	}
+	for _, sql := range []string{"vacuum nosuchtable", "vacuum full t t2", "vacuumx t"} {
+		_, _, err := Parse(c, sql)
+		if err == nil {
+			t.Errorf("expected error, q=%s", sql)
+		}
+	}
+
+	// inside an open transaction, which holds locks on the table, VACUUM is
+	// rejected rather than waiting for them
+	_, op, err := Parse(c, "insert into t values ('vera', 31)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := op.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := iter(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	done := make(chan error)
+	go func() {
+		_, _, err := Parse(c, "vacuum t")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if e, ok := err.(GoDBError); !ok || e.code != IllegalOperationError {
+			t.Errorf("expected VACUUM inside a transaction to be rejected, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("VACUUM inside a transaction is waiting for the transaction's locks")
+	}
+	bp.CommitTransaction(tid)
+	if _, _, err := Parse(c, "vacuum t"); err != nil {
+		t.Errorf("expected VACUUM after the commit to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,474 @@
+package godb
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The virtual file system.  All storage code reaches the disk through a VFS
+// rather than calling the os package directly, so that a database can be kept
+// entirely in memory ([MemFS]) or run on top of a file system that fails in
+// controlled ways ([FaultFS]).  A BufferPool carries the VFS that the files
+// opened with it use (see [NewBufferPoolWithVFS]);  by default that is the
+// operating system's ([OSFS]).
+
+// A VFS opens, removes and describes files by name.  Names are paths, as
+// they would be for the os package.  Errors for missing files must satisfy
+// [os.IsNotExist].  Directories are only created, with MkdirAll, and removed,
+// with Remove once they are empty;  a VFS may have no directories at all.
+type VFS interface {
+	// Open a file, with the same flags and permissions as [os.OpenFile]
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Remove(name string) error
+	// Rename a file, replacing newpath if it exists, as [os.Rename] does
+	Rename(oldpath, newpath string) error
+	Stat(name string) (fs.FileInfo, error)
+	// Create a directory and any missing parents, as [os.MkdirAll] does
+	MkdirAll(path string, perm fs.FileMode) error
+}
+
+// A File is an open file of a VFS.  Read and Write use and advance the
+// file's offset, while ReadAt and WriteAt don't, as for [os.File].
+type File interface {
+	io.Reader
+	io.Writer
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Stat() (fs.FileInfo, error)
+	Truncate(size int64) error
+	// Make everything written to the file so far durable
+	Sync() error
+}
+
+// Read the whole of the named file
+func readVFSFile(vfs VFS, name string) ([]byte, error) {
+	f, err := vfs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// OSFS is the VFS of the operating system
+type OSFS struct{}
+
+func (OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// a nil *os.File must not become a non-nil File
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// MemFS is a VFS that keeps its files in memory, for fast tests.  Files are
+// shared by everyone who opens them, as on disk.
+//
+// MemFS also remembers what each file held when it was last synced, so that
+// tests can simulate a crash with [MemFS.Crash].
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	mu      sync.Mutex
+	name    string
+	data    []byte
+	synced  []byte // the contents as of the last Sync
+	modTime time.Time
+}
+
+// Create an empty MemFS
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memFile)}
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	name = path.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		f = &memFile{name: name, modTime: time.Now()}
+		m.files[name] = f
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	if flag&os.O_TRUNC != 0 {
+		f.mu.Lock()
+		f.data = nil
+		f.mu.Unlock()
+	}
+	return &memHandle{file: f, flag: flag}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = path.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = path.Clean(oldpath), path.Clean(newpath)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldpath)
+	f.mu.Lock()
+	f.name = newpath
+	f.mu.Unlock()
+	m.files[newpath] = f
+	return nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	name = path.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.info(), nil
+}
+
+// MemFS keys files by their whole path, so directories don't need to exist
+// for files to be created in them
+func (m *MemFS) MkdirAll(path string, perm fs.FileMode) error {
+	return nil
+}
+
+// Return the names of the files in the file system, in order
+func (m *MemFS) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Simulate a crash:  every file loses whatever was written to it since it was
+// last synced.  Files are still open afterwards, but should not be used.
+func (m *MemFS) Crash() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.files {
+		f.mu.Lock()
+		f.data = append([]byte(nil), f.synced...)
+		f.mu.Unlock()
+	}
+}
+
+func (f *memFile) info() fs.FileInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return memFileInfo{path.Base(f.name), int64(len(f.data)), f.modTime}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() fs.FileMode  { return 0644 }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return false }
+func (i memFileInfo) Sys() any           { return nil }
+
+// An open file of a MemFS
+type memHandle struct {
+	file   *memFile
+	flag   int
+	offset int64
+	closed bool
+}
+
+var errFileClosed = errors.New("file already closed")
+
+func (h *memHandle) ReadAt(p []byte, off int64) (int, error) {
+	if h.closed {
+		return 0, errFileClosed
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f := h.file
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (h *memHandle) WriteAt(p []byte, off int64) (int, error) {
+	if h.closed {
+		return 0, errFileClosed
+	}
+	if h.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &fs.PathError{Op: "write", Path: h.file.name, Err: fs.ErrPermission}
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f := h.file
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	copy(f.data[off:], p)
+	f.modTime = time.Now()
+	return len(p), nil
+}
+
+func (h *memHandle) Read(p []byte) (int, error) {
+	n, err := h.ReadAt(p, h.offset)
+	h.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (h *memHandle) Write(p []byte) (int, error) {
+	if h.flag&os.O_APPEND != 0 {
+		stat, err := h.Stat()
+		if err != nil {
+			return 0, err
+		}
+		h.offset = stat.Size()
+	}
+	n, err := h.WriteAt(p, h.offset)
+	h.offset += int64(n)
+	return n, err
+}
+
+func (h *memHandle) Stat() (fs.FileInfo, error) {
+	if h.closed {
+		return nil, errFileClosed
+	}
+	return h.file.info(), nil
+}
+
+func (h *memHandle) Truncate(size int64) error {
+	if h.closed {
+		return errFileClosed
+	}
+	f := h.file
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size < int64(len(f.data)) {
+		f.data = f.data[:size]
+	} else {
+		f.data = append(f.data, make([]byte, size-int64(len(f.data)))...)
+	}
+	f.modTime = time.Now()
+	return nil
+}
+
+func (h *memHandle) Sync() error {
+	if h.closed {
+		return errFileClosed
+	}
+	f := h.file
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.synced = append(f.synced[:0], f.data...)
+	return nil
+}
+
+func (h *memHandle) Close() error {
+	if h.closed {
+		return errFileClosed
+	}
+	h.closed = true
+	return nil
+}
+
+// The kinds of faults a FaultFS can inject
+type FaultKind int
+
+const (
+	// A write stores only part of its data, then fails
+	TornWrite FaultKind = iota
+	// A sync fails, leaving the file's durability unknown
+	SyncFailure
+	// A read returns fewer bytes than were asked for, with an error
+	ShortRead
+)
+
+// The error returned by operations that a FaultFS made fail
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultFS wraps another VFS and makes chosen operations on its files fail,
+// for crash testing.  Faults are armed with [FaultFS.InjectFault] and fire
+// once each.  Combined with [MemFS.Crash], a torn write or a failed sync
+// can be followed by a crash to see what survives.
+type FaultFS struct {
+	VFS
+	mu     sync.Mutex
+	faults []*fault
+}
+
+type fault struct {
+	kind FaultKind
+	name string
+	skip int
+}
+
+// Wrap a VFS with one that can inject faults
+func NewFaultFS(base VFS) *FaultFS {
+	return &FaultFS{VFS: base}
+}
+
+// Arm a fault of the given kind that fires on the operation of that kind
+// after the next skip ones on the named file, or on any file if name is "".
+// A torn write writes the first half of its data, rounded down to a 512
+// byte sector;  a short read reads half of what was asked for.
+func (v *FaultFS) InjectFault(kind FaultKind, name string, skip int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if name != "" {
+		name = path.Clean(name)
+	}
+	v.faults = append(v.faults, &fault{kind, name, skip})
+}
+
+// Disarm all faults that haven't fired yet
+func (v *FaultFS) ClearFaults() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.faults = nil
+}
+
+// Report whether an operation of the given kind on the named file should
+// fail, disarming the fault that makes it fail
+func (v *FaultFS) fire(kind FaultKind, name string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for i, f := range v.faults {
+		if f.kind != kind || (f.name != "" && f.name != name) {
+			continue
+		}
+		if f.skip > 0 {
+			f.skip--
+			continue
+		}
+		v.faults = append(v.faults[:i], v.faults[i+1:]...)
+		return true
+	}
+	return false
+}
+
+func (v *FaultFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := v.VFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{f, v, path.Clean(name)}, nil
+}
+
+// An open file of a FaultFS
+type faultFile struct {
+	File
+	vfs  *FaultFS
+	name string
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.vfs.fire(ShortRead, f.name) {
+		n, err := f.File.ReadAt(p[:len(p)/2], off)
+		if err == nil {
+			err = ErrInjectedFault
+		}
+		return n, err
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	if f.vfs.fire(ShortRead, f.name) {
+		n, err := f.File.Read(p[:len(p)/2])
+		if err == nil {
+			err = ErrInjectedFault
+		}
+		return n, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *faultFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.vfs.fire(TornWrite, f.name) {
+		n, err := f.File.WriteAt(p[:len(p)/2/512*512], off)
+		if err == nil {
+			err = ErrInjectedFault
+		}
+		return n, err
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	if f.vfs.fire(TornWrite, f.name) {
+		n, err := f.File.Write(p[:len(p)/2/512*512])
+		if err == nil {
+			err = ErrInjectedFault
+		}
+		return n, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultFile) Sync() error {
+	if f.vfs.fire(SyncFailure, f.name) {
+		return ErrInjectedFault
+	}
+	return f.File.Sync()
+}
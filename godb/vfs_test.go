@@ -0,0 +1,171 @@
+package godb
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	vfs := NewMemFS()
+	if _, err := vfs.OpenFile("a.dat", os.O_RDWR, 0644); !os.IsNotExist(err) {
+		t.Fatalf("expected opening a missing file to fail with not exist, got %v", err)
+	}
+	f, err := vfs.OpenFile("a.dat", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	f.WriteAt([]byte("world"), 6)
+	f.Write([]byte("hello "))
+	buf := make([]byte, 11)
+	if n, err := f.ReadAt(buf, 0); n != 11 || err != nil || string(buf) != "hello world" {
+		t.Fatalf("expected hello world, got %q", buf[:n])
+	}
+	if n, err := f.ReadAt(buf, 6); n != 5 || err != io.EOF {
+		t.Errorf("expected a read past the end to return 5 bytes and EOF, got %d, %v", n, err)
+	}
+	f.Sync()
+	f.Truncate(5)
+	if stat, _ := vfs.Stat("./a.dat"); stat.Size() != 5 {
+		t.Errorf("expected the file to be truncated to 5 bytes")
+	}
+
+	// the same file, through another handle
+	g, err := vfs.OpenFile("a.dat", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	data, _ := io.ReadAll(g)
+	if string(data) != "hello" {
+		t.Errorf("expected hello, got %q", data)
+	}
+	if _, err := g.WriteAt([]byte("x"), 0); err == nil {
+		t.Errorf("expected a write to a read only file to fail")
+	}
+
+	// a crash reverts the file to what was last synced
+	vfs.Crash()
+	data, _ = readVFSFile(vfs, "a.dat")
+	if string(data) != "hello world" {
+		t.Errorf("expected the synced contents after a crash, got %q", data)
+	}
+	if err := vfs.Rename("a.dat", "b.dat"); err != nil || vfs.Names()[0] != "b.dat" {
+		t.Errorf("expected the file to be renamed, got %v", vfs.Names())
+	}
+	if err := vfs.Rename("a.dat", "b.dat"); !os.IsNotExist(err) {
+		t.Errorf("expected renaming a missing file to fail with not exist, got %v", err)
+	}
+	if err := vfs.Remove("b.dat"); err != nil || len(vfs.Names()) != 0 {
+		t.Errorf("expected the file to be removed")
+	}
+}
+
+func TestMemFSCatalog(t *testing.T) {
+	vfs := NewMemFS()
+	bp := NewBufferPoolWithVFS(50, vfs)
+	f, _ := vfs.OpenFile("mem/catalog.txt", os.O_RDWR|os.O_CREATE, 0644)
+	f.Write([]byte("people (name string, age int)\n"))
+	f.Close()
+	c, err := NewCatalogFromFile("catalog.txt", bp, "mem")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, _, err = Parse(c, "create index people_age on people (age)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	runQuery(t, c, "insert into people values ('sam', 25), ('kathy', 45), ('bill', 30), ('ang', 22), ('joe', 40)")
+	err = c.SaveToFile("catalog.txt", "mem")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := os.Stat("mem"); err == nil {
+		t.Errorf("expected nothing to be written to disk")
+	}
+
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPoolWithVFS(50, vfs), "mem")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	results, _ := runQuery(t, c, "select name from people where age > 30")
+	if strings.Join(results, ",") != "[{joe}],[{kathy}]" {
+		t.Errorf("expected joe and kathy, got %v", results)
+	}
+	report, err := c.Fsck(false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Problems) != 0 || report.Tuples != 5 || report.Indexes != 1 {
+		t.Errorf("expected 5 tuples, an index and no problems, got %s", report.String())
+	}
+	_, _, err = Parse(c, "drop table people")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if names := vfs.Names(); len(names) != 1 || names[0] != "mem/catalog.txt" {
+		t.Errorf("expected only the catalog to be left, got %v", names)
+	}
+}
+
+func TestFaultFS(t *testing.T) {
+	td, t1, t2, _, _, _ := makeTestVars()
+	vfs := NewFaultFS(NewMemFS())
+	bp := NewBufferPoolWithVFS(10, vfs)
+	hf, err := NewHeapFile("faults.dat", &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	hf.insertTuple(&t1, tid)
+	bp.CommitTransaction(tid)
+
+	// a torn write leaves a page that fails its checksum
+	page, err := hf.readPage(0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hp := (*page).(*heapPage)
+	for hp.usedSlots < hp.numSlots {
+		hp.insertTuple(&Tuple{t2.Desc, t2.Fields, nil})
+	}
+	vfs.InjectFault(TornWrite, "faults.dat", 0)
+	if err := hf.flushPage(page); err != ErrInjectedFault {
+		t.Fatalf("expected the torn write to fail, got %v", err)
+	}
+	_, err = hf.readPage(0)
+	if e, ok := err.(GoDBError); !ok || e.code != CorruptPageError {
+		t.Errorf("expected a torn page to be corrupt, got %v", err)
+	}
+
+	// faults fire once, after skipping the given number of operations
+	vfs.InjectFault(ShortRead, "faults.dat", 1)
+	if _, err := hf.readPage(0); err == ErrInjectedFault {
+		t.Errorf("expected the first read to succeed")
+	}
+	if _, err := hf.readPage(0); err != ErrInjectedFault {
+		t.Errorf("expected the second read to be short, got %v", err)
+	}
+	if _, err := hf.readPage(0); err == ErrInjectedFault {
+		t.Errorf("expected the fault to fire only once")
+	}
+
+	f, err := vfs.OpenFile("faults.dat", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	vfs.InjectFault(SyncFailure, "", 0)
+	if err := f.Sync(); err != ErrInjectedFault {
+		t.Errorf("expected the sync to fail, got %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Errorf("expected the next sync to succeed, got %v", err)
+	}
+	vfs.InjectFault(TornWrite, "", 0)
+	vfs.ClearFaults()
+	if _, err := f.WriteAt(bytes.Repeat([]byte{1}, 1024), 0); err != nil {
+		t.Errorf("expected cleared faults not to fire, got %v", err)
+	}
+}
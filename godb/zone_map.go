@@ -0,0 +1,193 @@
+package godb
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+)
+
+// A zoneMap records, for every page of a heap file, the smallest and largest
+// value of each column on the page, so that a filtered scan can skip pages
+// that can't contain a matching tuple without reading them (see
+// [HeapFile.Iterator] and [Filter]).
+//
+// Like the [freeSpaceMap], the map is stored in a side file next to the heap
+// file (fileName + ".zm") and its entries are written when a page is flushed,
+// so an entry describes the page as it is on disk.  Each entry is a flag byte
+// followed by the minimum and maximum of every column, in tuple layout.  A
+// page that is cached in the buffer pool may have changed since it was
+// written, so its entry must not be used.  The entries of an encrypted heap
+// file are encrypted like its pages, since they hold values of its tuples.
+type zoneMap struct {
+	file   File
+	desc   *TupleDesc
+	cipher *pageCipher // encrypts each entry, or nil
+}
+
+// Values of the flag byte of a zone map entry.  Entries that were never
+// written (e.g., because the map was lost) read as zoneUnknown.
+const (
+	zoneUnknown = iota
+	zoneEmpty
+	zoneValid
+)
+
+// The range of values on a page, as recorded by its zone map entry
+type zone struct {
+	empty bool
+	min   []DBValue
+	max   []DBValue
+}
+
+// A predicate "field op value" on the tuples of a heap file, where field is
+// the index of a column of the file
+type zonePredicate struct {
+	field int
+	op    BoolOp
+	value DBValue
+}
+
+func zoneMapFileName(heapFileName string) string {
+	return heapFileName + ".zm"
+}
+
+// Open (or create) the zone map for a heap file with numPages pages.  Entries
+// past the end of the heap file are discarded.
+func openZoneMap(vfs VFS, heapFileName string, desc *TupleDesc, numPages int, cipher *pageCipher) (*zoneMap, error) {
+	file, err := vfs.OpenFile(zoneMapFileName(heapFileName), os.O_RDWR|os.O_CREATE, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	m := &zoneMap{file: file, desc: desc, cipher: cipher}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if int(stat.Size()) > numPages*m.storedEntrySize() {
+		err = m.truncate(numPages)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *zoneMap) entrySize() int {
+	return 1 + 2*calBytesPerTuple(m.desc)
+}
+
+// Size of an entry in the map file, once it is encrypted
+func (m *zoneMap) storedEntrySize() int {
+	return m.cipher.storedSize(m.entrySize())
+}
+
+// Compute the zone map entry of a page from its tuples, and write it to the
+// map file.  Called when the page itself is written to disk.
+func (m *zoneMap) persist(page *heapPage) error {
+	var min, max []DBValue
+	for _, t := range page.slots {
+		if t == nil {
+			continue
+		}
+		if min == nil {
+			min = append([]DBValue{}, t.Fields...)
+			max = append([]DBValue{}, t.Fields...)
+			continue
+		}
+		for i, v := range t.Fields {
+			if compareKeys(v, min[i]) < 0 {
+				min[i] = v
+			}
+			if compareKeys(v, max[i]) > 0 {
+				max[i] = v
+			}
+		}
+	}
+	b := new(bytes.Buffer)
+	if min == nil {
+		b.WriteByte(zoneEmpty)
+		b.Write(make([]byte, m.entrySize()-1))
+	} else {
+		b.WriteByte(zoneValid)
+		for _, values := range [][]DBValue{min, max} {
+			err := (&Tuple{Fields: values}).writeTo(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	entry, err := m.cipher.seal(page.pageNo, b.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = m.file.WriteAt(entry, int64(page.pageNo*m.storedEntrySize()))
+	return err
+}
+
+// Read the zone map entry of a page.  Returns nil if the entry is unknown or
+// can't be read.
+func (m *zoneMap) read(pageNo int) *zone {
+	buf := make([]byte, m.storedEntrySize())
+	_, err := m.file.ReadAt(buf, int64(pageNo*m.storedEntrySize()))
+	if err != nil {
+		return nil
+	}
+	buf, err = m.cipher.open(pageNo, buf)
+	if err != nil {
+		return nil
+	}
+	switch buf[0] {
+	case zoneEmpty:
+		return &zone{empty: true}
+	case zoneValid:
+		b := bytes.NewBuffer(buf[1:])
+		min, err := readTupleFrom(b, m.desc)
+		if err != nil {
+			return nil
+		}
+		max, err := readTupleFrom(b, m.desc)
+		if err != nil {
+			return nil
+		}
+		return &zone{min: min.Fields, max: max.Fields}
+	}
+	return nil
+}
+
+// Forget all pages at or after numPages, e.g., after the heap file has been
+// truncated
+func (m *zoneMap) truncate(numPages int) error {
+	return m.file.Truncate(int64(numPages * m.storedEntrySize()))
+}
+
+// Whether a tuple in the zone may satisfy all of the predicates
+func (z *zone) mayMatch(preds []zonePredicate) bool {
+	if z.empty {
+		return false
+	}
+	for _, p := range preds {
+		lo := compareKeys(z.min[p.field], p.value)
+		hi := compareKeys(z.max[p.field], p.value)
+		var ok bool
+		switch p.op {
+		case OpEq:
+			ok = lo <= 0 && hi >= 0
+		case OpNeq:
+			ok = lo != 0 || hi != 0
+		case OpLt:
+			ok = lo < 0
+		case OpLe:
+			ok = lo <= 0
+		case OpGt:
+			ok = hi > 0
+		case OpGe:
+			ok = hi >= 0
+		default:
+			ok = true
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
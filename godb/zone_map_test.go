@@ -0,0 +1,190 @@
+package godb
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Return the number of pages of hf in the buffer pool
+func cachedPages(bp *BufferPool, hf *HeapFile) int {
+	cnt := 0
+	for pageNo := 0; pageNo < hf.NumPages(); pageNo++ {
+		if bp.isCached(hf, pageNo) {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func TestZoneMapSkipsPages(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars()
+	bp := NewBufferPool(500)
+	hf, err := NewHeapFile(TestingFile, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	const n = 2000
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < n; i++ {
+		tup := Tuple{td, []DBValue{StringField{fmt.Sprintf("name%d", i)}, IntField{int64(i)}}, nil}
+		err := hf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.CommitTransaction(tid)
+	if hf.NumPages() < 10 {
+		t.Fatalf("expected many pages, got %d", hf.NumPages())
+	}
+
+	// reopen the file, so none of its pages are cached
+	bp2 := NewBufferPool(100)
+	hf2, err := NewHeapFile(TestingFile, &td, bp2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	age := &FieldExpr{td.Fields[1]}
+	cases := []struct {
+		op       BoolOp
+		value    int64
+		expected int
+	}{
+		{OpGe, n - 10, 10},
+		{OpLt, 5, 5},
+		{OpEq, n / 2, 1},
+		{OpEq, -1, 0},
+	}
+	for _, c := range cases {
+		bp2.discardFilePages(hf2, 0, hf2.NumPages())
+		tid := NewTID()
+		bp2.BeginTransaction(tid)
+		filt, err := NewIntFilter(&ConstExpr{IntField{c.value}, IntType}, c.op, age, hf2)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		iter, _ := filt.Iterator(tid)
+		cnt := 0
+		for {
+			tup, err := iter()
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if tup == nil {
+				break
+			}
+			cnt++
+		}
+		if cnt != c.expected {
+			t.Errorf("age %v %d: expected %d tuples, got %d", c.op, c.value, c.expected, cnt)
+		}
+		if read := cachedPages(bp2, hf2); read > 1 {
+			t.Errorf("age %v %d: expected at most one page to be read, read %d", c.op, c.value, read)
+		}
+		bp2.CommitTransaction(tid)
+	}
+
+	// a page changed in the buffer pool must not be skipped
+	tid = NewTID()
+	bp2.BeginTransaction(tid)
+	defer bp2.CommitTransaction(tid)
+	tup := Tuple{td, []DBValue{StringField{"new"}, IntField{-5}}, nil}
+	err = hf2.deleteTuple(&Tuple{td, []DBValue{StringField{"name0"}, IntField{0}}, heapFileRID{0, 0}}, tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = hf2.insertTuple(&tup, tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	filt, _ := NewIntFilter(&ConstExpr{IntField{-5}, IntType}, OpEq, age, hf2)
+	iter, _ := filt.Iterator(tid)
+	found, err := iter()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if found == nil || found.Fields[0] != (StringField{"new"}) {
+		t.Errorf("expected to find the inserted tuple, got %v", found)
+	}
+}
+
+func TestZoneMapSkippedPagesLocked(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars()
+	fileName := filepath.Join(t.TempDir(), "skipped.dat")
+	bp := NewBufferPool(100)
+	hf, err := NewHeapFile(fileName, &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 1000; i++ {
+		tup := Tuple{td, []DBValue{StringField{fmt.Sprintf("name%d", i)}, IntField{int64(i)}}, nil}
+		err := hf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.CommitTransaction(tid)
+	bp.discardFilePages(hf, 0, hf.NumPages())
+
+	age := &FieldExpr{td.Fields[1]}
+	count := func(tid TransactionID) int {
+		filt, err := NewIntFilter(&ConstExpr{IntField{-7}, IntType}, OpEq, age, hf)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		iter, _ := filt.Iterator(tid)
+		cnt := 0
+		for {
+			tup, err := iter()
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if tup == nil {
+				return cnt
+			}
+			cnt++
+		}
+	}
+	reader := NewTID()
+	bp.BeginTransaction(reader)
+	if cnt := count(reader); cnt != 0 || cachedPages(bp, hf) != 0 {
+		t.Fatalf("expected no tuples and every page skipped, got %d tuples and %d pages read", cnt, cachedPages(bp, hf))
+	}
+
+	// a writer can't add a matching tuple to a skipped page until the reader
+	// completes, so a second scan by the reader still finds nothing
+	done := make(chan error)
+	go func() {
+		writer := NewTID()
+		bp.BeginTransaction(writer)
+		tup := Tuple{td, []DBValue{StringField{"new"}, IntField{-7}}, nil}
+		err := hf.insertTuple(&tup, writer)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- bp.CommitTransaction(writer)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected the writer to wait for the reader, got %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+	if cnt := count(reader); cnt != 0 {
+		t.Errorf("expected the reader to find no tuples again, got %d", cnt)
+	}
+	bp.CommitTransaction(reader)
+	if err := <-done; err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if cnt := count(tid); cnt != 1 {
+		t.Errorf("expected the writer's tuple once the reader completed, got %d", cnt)
+	}
+	bp.CommitTransaction(tid)
+}
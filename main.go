@@ -28,6 +28,8 @@ Available shell commands:
 	\c path/to/catalog : Change the current database to a specified catalog file
 	\d : List tables and fields in the current database
 	\f : List available functions for use in queries
+	\fsck [quarantine] : Check the heap files of the current database for corruption;  with quarantine, move bad pages aside
+	\u : Upgrade the heap files of the current database to the current file format
 	\a : Toggle aligned vs csv output
 	\l table path/to/file [sep] [hasHeader]: Append csv file to end of table.  Default to sep = ',', hasHeader = 'true'`
 
@@ -54,7 +56,49 @@ func printCatalog(c *godb.Catalog) {
 	fmt.Printf("\033[34m%s\n\033[0m", s)
 }
 
+// Check the database named by the catalog file and print a report.  With
+// quarantine, bad pages are moved to quarantine files.  Returns false if any
+// problems were found.
+func fsck(c *godb.Catalog, quarantine bool) bool {
+	report, err := c.Fsck(quarantine)
+	if err != nil {
+		fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+		return false
+	}
+	fmt.Print(report.String())
+	return len(report.Problems) == 0
+}
+
+// Run as "godb fsck [path/to/catalog] [quarantine]" to check a database
+// without starting the shell
+func fsckMain(args []string) {
+	catName := "catalog.txt"
+	catPath := "godb"
+	quarantine := false
+	for _, arg := range args {
+		if arg == "quarantine" || arg == "--quarantine" {
+			quarantine = true
+			continue
+		}
+		pathAr := strings.Split(arg, "/")
+		catName = pathAr[len(pathAr)-1]
+		catPath = strings.Join(pathAr[0:len(pathAr)-1], "/")
+	}
+	c, err := godb.NewCatalogFromFile(catName, godb.NewBufferPool(10), catPath)
+	if err != nil {
+		fmt.Printf("failed load catalog, %s\n", err.Error())
+		os.Exit(2)
+	}
+	if !fsck(c, quarantine) {
+		os.Exit(1)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		fsckMain(os.Args[2:])
+		return
+	}
 	alarm := make(chan int, 1)
 
 	go func() {
@@ -90,6 +134,8 @@ func main() {
 		fmt.Printf("failed load catalog, %s", err.Error())
 		return
 	}
+	// temporary tables are dropped when the session ends
+	defer func() { c.Close() }()
 	rl, err := readline.New("> ")
 	if err != nil {
 		panic(err)
@@ -148,11 +194,17 @@ func main() {
 					pathAr := strings.Split(rest, "/")
 					catName = pathAr[len(pathAr)-1]
 					catPath = strings.Join(pathAr[0:len(pathAr)-1], "/")
-					c, err = godb.NewCatalogFromFile(catName, bp, catPath)
+					newCatalog, err := godb.NewCatalogFromFile(catName, bp, catPath)
 					if err != nil {
 						fmt.Printf("failed load catalog, %s\n", err.Error())
 						continue
 					}
+					err = c.Close()
+					if err != nil {
+						fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+						continue
+					}
+					c = newCatalog
 					fmt.Printf("Loaded %s/%s\n", catPath, catName)
 					//	printCatalog(catPath + "/" + catName)
 					printCatalog(c)
@@ -161,8 +213,19 @@ func main() {
 					fmt.Printf("Expected catalog file name after /c")
 				}
 			case 'f':
+				if strings.HasPrefix(text, "\\fsck") {
+					fsck(c, strings.Contains(text, "quarantine"))
+					break
+				}
 				fmt.Println("Available functions:")
 				fmt.Printf(godb.ListOfFunctions())
+			case 'u':
+				n, err := c.Upgrade()
+				if err != nil {
+					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+					break
+				}
+				fmt.Printf("Upgraded %d tables\n", n)
 			case 'a':
 				aligned = !aligned
 				if aligned {
@@ -273,6 +336,9 @@ func main() {
 			iter, err := plan.Iterator(tid)
 			if err != nil {
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+				if autocommit {
+					bp.AbortTransaction(tid)
+				}
 				continue
 			}
 
@@ -293,13 +359,19 @@ func main() {
 				select {
 				case <-alarm:
 					fmt.Println("Aborting")
+					if autocommit {
+						bp.AbortTransaction(tid)
+					}
 					goto outer
 				default:
 
 				}
 			}
 			if autocommit {
-				bp.CommitTransaction(tid)
+				err = bp.CommitTransaction(tid)
+				if err != nil {
+					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+				}
 			}
 		outer:
 			fmt.Printf("\033[32;1m(%d results)\033[0m\n", nresults)
@@ -328,8 +400,12 @@ func main() {
 			if autocommit {
 				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot commit transaction unless in transaction")
 			} else {
-				bp.CommitTransaction(tid)
+				err := bp.CommitTransaction(tid)
 				autocommit = true
+				if err != nil {
+					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+					break
+				}
 				fmt.Printf("\033[32;1mCOMMIT\033[0m\n\n")
 			}
 		case godb.CreateTableQueryType:
@@ -344,6 +420,26 @@ func main() {
 			if err != nil {
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
 			}
+		case godb.VacuumQueryType:
+			fmt.Printf("\033[32;1mVACUUM\033[0m\n\n")
+		case godb.CreateIndexQueryType:
+			fmt.Printf("\033[32;1mCREATE INDEX\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.DropIndexQueryType:
+			fmt.Printf("\033[32;1mDROP INDEX\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.AlterTableQueryType:
+			fmt.Printf("\033[32;1mALTER TABLE\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
 		}
 
 	}